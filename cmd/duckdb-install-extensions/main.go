@@ -0,0 +1,55 @@
+// Command duckdb-install-extensions installs the DuckDB extensions the DuckDB
+// record processor loads into a directory, such as the extension directory
+// of its image. It links the same DuckDB library as the processor, so the
+// extensions installed are built for the version and platform it loads.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+
+	_ "github.com/marcboeker/go-duckdb"
+)
+
+// extensions are the extensions the processor loads.
+var extensions = []string{"httpfs"}
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintf(os.Stderr, "usage: %s <extension directory>\n", os.Args[0])
+		os.Exit(2)
+	}
+
+	if err := run(context.Background(), os.Args[1]); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, dir string) error {
+	db, err := sql.Open("duckdb", "")
+	if err != nil {
+		return fmt.Errorf("failed to open duckdb: %w", err)
+	}
+	defer db.Close()
+
+	if _, err := db.ExecContext(ctx, "SET extension_directory = '"+strings.ReplaceAll(dir, "'", "''")+"';"); err != nil {
+		return fmt.Errorf("failed to set extension directory: %w", err)
+	}
+
+	for _, name := range extensions {
+		if _, err := db.ExecContext(ctx, "INSTALL "+name+";"); err != nil {
+			return fmt.Errorf("failed to install extension %s: %w", name, err)
+		}
+
+		// Loading checks the installed extension can be loaded
+		if _, err := db.ExecContext(ctx, "LOAD "+name+";"); err != nil {
+			return fmt.Errorf("failed to load extension %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
@@ -1,16 +1,82 @@
 package main
 
+import (
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/envelope"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/publisher"
+)
+
 // config is the configuration for the program.
 type config struct {
+	// Config is the configuration of the sink records are published to
+	publisher.Config
+
 	// Env is the environment we're executing in
 	Env string `env:"ENV"`
 
-	// SQSBatchSize is the number of records to publish in a single SQS message
-	SQSBatchSize int `env:"SQS_BATCH_SIZE"`
+	// SQSBatchSize is the number of records to publish in a single message.
+	// Fewer records are packed when they would exceed the sink message size limit
+	SQSBatchSize int `env:"SQS_BATCH_SIZE" envDefault:"100"`
+
+	// RowsPerBatch is the number of rows a worker scans before packing and
+	// publishing them, all of its rows at once if it is zero
+	RowsPerBatch int `env:"ROWS_PER_BATCH"`
 
 	// RowsPerWorker is the number of rows to process per worker
 	RowsPerWorker int `env:"ROWS_PER_WORKER"`
 
-	// S3EndpointOverride is the endpoint to use for S3
+	// MaxWorkers is the number of workers reading partitions at once, shared
+	// by every file of a request published at once
+	MaxWorkers int `env:"MAX_WORKERS" envDefault:"8"`
+
+	// FileConcurrency is the number of files of a request published at once.
+	// Files are published one after another to FIFO queues and topics
+	FileConcurrency int `env:"FILE_CONCURRENCY" envDefault:"1"`
+
+	// S3EndpointOverride is the endpoint to use for S3, by both the S3 client
+	// and DuckDB
 	S3EndpointOverride string `env:"S3_ENDPOINT_OVERRIDE"`
+
+	// ReadMode is how DuckDB reads files, one of "download" to download them
+	// to a temporary directory first or "s3" to query them straight from S3
+	// through the httpfs extension
+	ReadMode string `env:"READ_MODE" envDefault:"download"`
+
+	// ExtensionDirectory is where DuckDB loads the httpfs extension from, the
+	// .duckdb directory of the home directory if it is empty. The extension
+	// is installed there with duckdb-install-extensions, which the image does
+	// when it is built
+	ExtensionDirectory string `env:"DUCKDB_EXTENSION_DIRECTORY"`
+
+	// SchemaRegistryBucket is the bucket schema versions are loaded from, the
+	// versions registered in the repository are used if it is empty
+	SchemaRegistryBucket string `env:"SCHEMA_REGISTRY_BUCKET"`
+
+	// SchemaRegistryPrefix is the key prefix schema versions are loaded from
+	SchemaRegistryPrefix string `env:"SCHEMA_REGISTRY_PREFIX" envDefault:"schemas/"`
+
+	// DatasetTargets are the targets of datasets published somewhere other
+	// than the configured queue, topic or stream, formatted as
+	// dataset=target,dataset=target
+	DatasetTargets map[string]string `env:"DATASET_TARGETS" envKeyValSeparator:"="`
+
+	// Transforms are the transform chains applied to the records of datasets
+	// before they are published, as YAML or JSON keyed by dataset name, see
+	// package transform
+	Transforms string `env:"TRANSFORMS"`
+
+	// TransformHashKey is the HMAC key of hash transforms
+	TransformHashKey string `env:"TRANSFORM_HASH_KEY"`
+
+	// RedactionPolicies are the redaction policies of the destinations records
+	// are published to, as YAML or JSON keyed by policy name, see package
+	// redact. Records are published as they are to destinations without one
+	RedactionPolicies string `env:"REDACTION_POLICIES"`
+
+	// RedactionKey is the key of hash and tokenize redactions
+	RedactionKey string `env:"REDACTION_KEY"`
+
+	// MessageAttributes are the record fields published as message attributes
+	// so subscribers can filter without decoding messages, see
+	// envelope.AttributeColumns for the format
+	MessageAttributes envelope.AttributeColumns `env:"MESSAGE_ATTRIBUTES"`
 }
@@ -2,13 +2,23 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"database/sql/driver"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/marcboeker/go-duckdb"
 )
 
 // newS3DuckDBConnector creates a new DuckDB connector with configuration
-// for S3.
+// for S3. Every connection loads the httpfs extension from
+// ExtensionDirectory, where it is installed ahead of time by
+// duckdb-install-extensions, so nothing is downloaded at runtime. S3
+// requests are signed with the secret kept up to date by s3Credentials,
+// which DuckDB shares between every connection of the database.
 //
 // # NOTE
 //
@@ -17,17 +27,11 @@ import (
 // default connector.
 func newS3DuckDBConnector(ctx context.Context, cfg config) (*duckdb.Connector, error) {
 	return duckdb.NewConnector("", func(execer driver.ExecerContext) error {
-		bootQueries := make([]string, 0)
-
-		// For local development we need to set S3 configuration variables
-		if cfg.Env == "local" {
-			bootQueries = append(bootQueries, []string{
-				`SET s3_endpoint='s3.localhost.localstack.cloud:4566'`,
-				`SET s3_access_key_id='test';`,
-				`SET s3_secret_access_key='test';`,
-				`SET s3_region='us-east-1';`,
-			}...)
+		var bootQueries []string
+		if cfg.ExtensionDirectory != "" {
+			bootQueries = append(bootQueries, "SET extension_directory = "+quoteLiteral(cfg.ExtensionDirectory)+";")
 		}
+		bootQueries = append(bootQueries, `LOAD httpfs;`)
 
 		for _, query := range bootQueries {
 			if _, err := execer.ExecContext(ctx, query, nil); err != nil {
@@ -37,3 +41,90 @@ func newS3DuckDBConnector(ctx context.Context, cfg config) (*duckdb.Connector, e
 		return nil
 	})
 }
+
+// execer runs statements on a database, such as a *sql.DB.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// s3Credentials keeps the DuckDB secret S3 requests are signed with in step
+// with the credentials of the AWS SDK default chain. DuckDB does not refresh
+// the credentials of a secret itself, so they are retrieved again before
+// every query reading S3, and the secret is replaced when they have changed.
+// A nil s3Credentials refreshes nothing, for files downloaded before they are
+// read.
+type s3Credentials struct {
+	db     execer
+	cfg    config
+	awscfg aws.Config
+
+	// mu serializes refreshes, so the secret is only replaced once when it
+	// changes.
+	mu sync.Mutex
+
+	// current is the statement the secret was last created with.
+	current string
+}
+
+// refresh creates the secret, or replaces it if the credentials have changed
+// since it was created.
+func (c *s3Credentials) refresh(ctx context.Context) error {
+	if c == nil {
+		return nil
+	}
+
+	secret, err := s3Secret(ctx, c.cfg, c.awscfg)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if secret == c.current {
+		return nil
+	}
+	if _, err := c.db.ExecContext(ctx, secret); err != nil {
+		return fmt.Errorf("failed to create s3 secret: %w", err)
+	}
+
+	c.current = secret
+	return nil
+}
+
+// s3Secret returns the statement creating the DuckDB secret S3 requests are
+// signed with, from the credentials the AWS SDK currently holds.
+func s3Secret(ctx context.Context, cfg config, awscfg aws.Config) (string, error) {
+	creds, err := awscfg.Credentials.Retrieve(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve aws credentials: %w", err)
+	}
+
+	options := []string{
+		"TYPE S3",
+		"KEY_ID " + quoteLiteral(creds.AccessKeyID),
+		"SECRET " + quoteLiteral(creds.SecretAccessKey),
+		"REGION " + quoteLiteral(awscfg.Region),
+	}
+	if creds.SessionToken != "" {
+		options = append(options, "SESSION_TOKEN "+quoteLiteral(creds.SessionToken))
+	}
+
+	// DuckDB takes the endpoint without a scheme, and whether to use TLS
+	// separately. Endpoints other than AWS, such as localstack, are addressed
+	// by path rather than by virtual host
+	if cfg.S3EndpointOverride != "" {
+		endpoint, err := url.Parse(cfg.S3EndpointOverride)
+		if err != nil || endpoint.Host == "" {
+			return "", fmt.Errorf("invalid s3 endpoint override %q, expected a URL such as http://localhost:4566", cfg.S3EndpointOverride)
+		}
+
+		options = append(options,
+			"ENDPOINT "+quoteLiteral(endpoint.Host),
+			"URL_STYLE 'path'",
+			fmt.Sprintf("USE_SSL %t", endpoint.Scheme == "https"),
+		)
+	}
+
+	return "CREATE OR REPLACE SECRET s3 (" + strings.Join(options, ", ") + ");", nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+func TestS3Secret(t *testing.T) {
+	tests := []struct {
+		name     string
+		creds    aws.Credentials
+		endpoint string
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:  "static credentials",
+			creds: aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"},
+			want:  "CREATE OR REPLACE SECRET s3 (TYPE S3, KEY_ID 'AKID', SECRET 'secret', REGION 'us-east-1');",
+		},
+		{
+			name:  "quoted session token",
+			creds: aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "sec'ret", SessionToken: "tok'); DROP TABLE t; --"},
+			want:  "CREATE OR REPLACE SECRET s3 (TYPE S3, KEY_ID 'AKID', SECRET 'sec''ret', REGION 'us-east-1', SESSION_TOKEN 'tok''); DROP TABLE t; --');",
+		},
+		{
+			name:     "endpoint",
+			creds:    aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"},
+			endpoint: "http://localhost:4566",
+			want:     "CREATE OR REPLACE SECRET s3 (TYPE S3, KEY_ID 'AKID', SECRET 'secret', REGION 'us-east-1', ENDPOINT 'localhost:4566', URL_STYLE 'path', USE_SSL false);",
+		},
+		{
+			name:     "quoted endpoint",
+			creds:    aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"},
+			endpoint: "https://s3.local'host",
+			want:     "CREATE OR REPLACE SECRET s3 (TYPE S3, KEY_ID 'AKID', SECRET 'secret', REGION 'us-east-1', ENDPOINT 's3.local''host', URL_STYLE 'path', USE_SSL true);",
+		},
+		{
+			name:     "endpoint without a scheme",
+			creds:    aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"},
+			endpoint: "localhost:4566",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			awscfg := aws.Config{
+				Region:      "us-east-1",
+				Credentials: credentials.NewStaticCredentialsProvider(tt.creds.AccessKeyID, tt.creds.SecretAccessKey, tt.creds.SessionToken),
+			}
+
+			got, err := s3Secret(context.Background(), config{S3EndpointOverride: tt.endpoint}, awscfg)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got secret %s, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("secret =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestS3CredentialsRefresh(t *testing.T) {
+	ctx := context.Background()
+
+	// The credentials are rotated by changing token
+	var (
+		token   = "first"
+		retrErr error
+	)
+	awscfg := aws.Config{
+		Region: "us-east-1",
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret", SessionToken: token}, retrErr
+		}),
+	}
+
+	db := &execRecorder{}
+	creds := &s3Credentials{db: db, awscfg: awscfg}
+
+	steps := []struct {
+		name    string
+		token   string
+		execErr error
+		retrErr error
+		wantErr bool
+
+		// wantExecs is the number of secrets created after the step
+		wantExecs int
+	}{
+		{name: "created", token: "first", wantExecs: 1},
+		{name: "unchanged", token: "first", wantExecs: 1},
+		{name: "rotated", token: "second", wantExecs: 2},
+		{name: "failed to create", token: "third", execErr: errors.New("extension not loaded"), wantErr: true, wantExecs: 3},
+		{name: "created after a failure", token: "third", wantExecs: 4},
+		{name: "failed to retrieve", token: "fourth", retrErr: errors.New("expired"), wantErr: true, wantExecs: 4},
+	}
+
+	for _, step := range steps {
+		token, retrErr, db.err = step.token, step.retrErr, step.execErr
+
+		err := creds.refresh(ctx)
+		if (err != nil) != step.wantErr {
+			t.Fatalf("%s: err = %v, want error %v", step.name, err, step.wantErr)
+		}
+		if len(db.stmts) != step.wantExecs {
+			t.Fatalf("%s: created %d secrets, want %d", step.name, len(db.stmts), step.wantExecs)
+		}
+	}
+
+	if !slices.ContainsFunc(db.stmts, func(stmt string) bool { return stmt == creds.current }) {
+		t.Fatalf("current secret %s was never created", creds.current)
+	}
+
+	// Files read after being downloaded need no secret
+	var none *s3Credentials
+	if err := none.refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQuoteLiteralRoundTrips(t *testing.T) {
+	db, err := sql.Open("duckdb", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	for _, s := range []string{"", "plain", "it's", "''", `back\slash`, "new\nline", "'); DROP TABLE t; --", "ünïcode"} {
+		var got string
+		if err := db.QueryRow("SELECT " + quoteLiteral(s)).Scan(&got); err != nil {
+			t.Fatalf("%q: %v", s, err)
+		}
+		if got != s {
+			t.Fatalf("quoted %q reads back as %q", s, got)
+		}
+	}
+}
+
+func TestEscapeGlob(t *testing.T) {
+	db, err := sql.Open("duckdb", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	// Every name is also matched by the others as a glob pattern
+	dir := t.TempDir()
+	names := []string{"a*b", "axb", "q?", "qz", "r[1]", "r1", "s[[]", "s["}
+	for _, name := range names {
+		path := filepath.Join(dir, name+".parquet")
+		if _, err := db.Exec("COPY (SELECT " + quoteLiteral(name) + " AS name) TO " + quoteLiteral(path) + " (FORMAT parquet)"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range names {
+		path := escapeGlob(filepath.Join(dir, name+".parquet"))
+
+		rows, err := db.Query("SELECT name FROM read_parquet(?)", path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		var got []string
+		for rows.Next() {
+			var s string
+			if err := rows.Scan(&s); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, s)
+		}
+		if err := rows.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if !slices.Equal(got, []string{name}) {
+			t.Fatalf("read %v from %s, want only %s", got, path, name)
+		}
+	}
+}
+
+// execRecorder records the statements it runs, failing them with err.
+type execRecorder struct {
+	stmts []string
+	err   error
+}
+
+func (r *execRecorder) ExecContext(_ context.Context, query string, _ ...any) (sql.Result, error) {
+	r.stmts = append(r.stmts, query)
+	return nil, r.err
+}
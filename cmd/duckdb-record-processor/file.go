@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/dataset"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/envelope"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/publisher"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/s3file"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/schema"
+)
+
+// parquetFile is a parquet file from S3 as DuckDB reads it.
+type parquetFile struct {
+	// path is where DuckDB reads the file from, a local file or an s3:// URL.
+	// Neither is ever expanded as a glob pattern, so DuckDB reads the
+	// version of the object whose ETag identifies its envelopes and nothing
+	// else.
+	path string
+
+	// etag is the ETag of the version of the object that was opened.
+	etag string
+
+	// bytes is the number of bytes of the object fetched from S3 to open it.
+	bytes int64
+
+	columns []schema.Column
+}
+
+// globStripper replaces the glob characters of a file name.
+var globStripper = strings.NewReplacer("*", "_", "?", "_", "[", "_")
+
+// downloadFile downloads an object from S3 into dir and reads its schema.
+// Every object gets its own file, so objects with the same name under
+// different prefixes can be downloaded at once. The name of the file leaves
+// out the glob characters of the name of the object.
+func downloadFile(ctx context.Context, s3Client *s3.Client, bucket, key, dir string) (_ parquetFile, err error) {
+	// Create the file, removing it again if the download fails
+	file, err := os.CreateTemp(dir, "*-"+globStripper.Replace(filepath.Base(key)))
+	if err != nil {
+		return parquetFile{}, fmt.Errorf("failed to create file: %w", err)
+	}
+	defer func() {
+		file.Close()
+		if err != nil {
+			os.Remove(file.Name())
+		}
+	}()
+
+	// Download the file from S3
+	getObjectOutput, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return parquetFile{}, fmt.Errorf("failed to download file: %w", err)
+	}
+	defer getObjectOutput.Body.Close()
+
+	// Write the downloaded file to the local file
+	size, err := io.Copy(file, getObjectOutput.Body)
+	if err != nil {
+		return parquetFile{}, fmt.Errorf("failed to write file: %w", err)
+	}
+
+	columns, err := fileColumns(file, size)
+	if err != nil {
+		return parquetFile{}, err
+	}
+
+	return parquetFile{
+		path:    file.Name(),
+		etag:    aws.ToString(getObjectOutput.ETag),
+		bytes:   size,
+		columns: columns,
+	}, nil
+}
+
+// remoteFile opens an object DuckDB reads straight from S3. Only its footer is
+// fetched, to read its schema.
+func remoteFile(ctx context.Context, s3Client *s3.Client, bucket, key string) (parquetFile, error) {
+	fr, err := s3file.Open(ctx, s3Client, bucket, key, s3file.DefaultBlockSize)
+	if err != nil {
+		return parquetFile{}, err
+	}
+	defer fr.Close()
+
+	columns, err := fileColumns(fr.Reader(ctx), fr.Size())
+	if err != nil {
+		return parquetFile{}, err
+	}
+
+	return parquetFile{
+		path:    "s3://" + bucket + "/" + escapeGlob(key),
+		etag:    fr.ETag(),
+		bytes:   fr.BytesRead(),
+		columns: columns,
+	}, nil
+}
+
+// The outcomes of publishing a file.
+const (
+	// filePublished files have had every row published.
+	filePublished = "published"
+
+	// fileFailed files could not be published. Rows published before the
+	// failure stay published.
+	fileFailed = "failed"
+)
+
+// fileReport reports how publishing a single file went.
+type fileReport struct {
+	Path string `json:"path"`
+
+	// Status is the outcome, "published" or "failed".
+	Status string `json:"status"`
+
+	// RowsRead is the number of rows of the file read by workers.
+	RowsRead int64 `json:"rows_read"`
+
+	// RowsPublished is the number of rows of the file published.
+	RowsPublished int64 `json:"rows_published"`
+
+	// Bytes is the number of bytes of the file fetched from S3. When DuckDB
+	// reads the file straight from S3 only the footer read for its schema is
+	// counted, as DuckDB fetches the rows itself.
+	Bytes int64 `json:"bytes"`
+
+	// DurationMS is how long the file took in milliseconds.
+	DurationMS int64 `json:"duration_ms"`
+
+	// Error is why the file failed.
+	Error string `json:"error,omitempty"`
+}
+
+// fileJob publishes the files of a request.
+type fileJob struct {
+	logger   *slog.Logger
+	db       *sql.DB
+	creds    *s3Credentials
+	s3Client *s3.Client
+	dest     publisher.Destination
+	registry *schema.Registry
+	dataset  dataset.Dataset
+	cfg      config
+	bucket   string
+
+	// dir is the directory files are downloaded to, unless DuckDB reads them
+	// straight from S3.
+	dir string
+
+	// workers holds a token for every worker running, so no more than
+	// MaxWorkers run at once however many files are published at once.
+	workers chan struct{}
+
+	// stats counts the publishing outcomes of every file of the request.
+	stats *publisher.Stats
+}
+
+// run publishes a file. Everything the file opened or downloaded is released
+// before it returns.
+func (j *fileJob) run(ctx context.Context, path string) fileReport {
+	start := time.Now()
+	report := fileReport{Path: path, Status: filePublished}
+
+	if err := j.publish(ctx, path, &report); err != nil {
+		report.Status = fileFailed
+		report.Error = err.Error()
+	}
+
+	report.DurationMS = time.Since(start).Milliseconds()
+	return report
+}
+
+// publish publishes the rows of a file, filling in the report as it goes.
+func (j *fileJob) publish(ctx context.Context, path string, report *fileReport) error {
+	logger, cfg := j.logger, j.cfg
+
+	var (
+		file parquetFile
+		err  error
+	)
+	if cfg.ReadMode == "s3" {
+		file, err = remoteFile(ctx, j.s3Client, j.bucket, path)
+	} else {
+		file, err = downloadFile(ctx, j.s3Client, j.bucket, path, j.dir)
+	}
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to open file", "path", path, "error", err)
+		return fmt.Errorf("failed to open file %s: %w", path, err)
+	}
+	if cfg.ReadMode != "s3" {
+		defer os.Remove(file.path)
+	}
+
+	report.Bytes = file.bytes
+
+	// Reject files whose schema is not a registered version, or a
+	// compatible evolution of one, before anything is published
+	fingerprint := schema.Fingerprint(file.columns)
+
+	version, exact, err := j.registry.Resolve(j.dataset.Schema, file.columns)
+	if err != nil {
+		logger.ErrorContext(ctx, "file schema is not compatible with a registered version", "path", path, "schema", fingerprint, "error", err)
+		return fmt.Errorf("file %s has an incompatible schema: %w", path, err)
+	}
+	if !exact {
+		logger.WarnContext(ctx, "file schema is an unregistered evolution of a registered version",
+			"path", path,
+			"schema", fingerprint,
+			"schema_version", version.ID())
+	}
+
+	pub := &publication{
+		logger: logger,
+		sender: j.dest.Sender,
+		cfg:    cfg,
+		header: envelope.Header{
+			Source:        "s3://" + j.bucket + "/" + path,
+			ETag:          file.etag,
+			Schema:        fingerprint,
+			SchemaVersion: version.ID(),
+			Config:        j.dataset.Fingerprint(j.dest.Policy),
+		},
+		dataset: j.dataset,
+		stats:   j.stats,
+		policy:  j.dest.Policy,
+	}
+
+	// Count the rows however the file ends
+	defer func() {
+		report.RowsRead = pub.read.Load()
+		report.RowsPublished = pub.published.Load()
+	}()
+
+	// Plan the partitions read by workers from the row groups of the file,
+	// so every worker reads its own row groups and nothing else
+	if err := j.creds.refresh(ctx); err != nil {
+		logger.ErrorContext(ctx, "failed to refresh s3 credentials", "error", err)
+		return fmt.Errorf("failed to refresh s3 credentials: %w", err)
+	}
+
+	rowGroups, err := readRowGroups(ctx, j.db, file.path)
+	if err != nil {
+		return fmt.Errorf("failed to read row groups of file %s: %w", path, err)
+	}
+
+	var totalRows int64
+	for _, rg := range rowGroups {
+		totalRows += rg.rows
+	}
+
+	parts := planPartitions(rowGroups, cfg.RowsPerWorker)
+
+	// Launch workers. FIFO queues only preserve order within a message group
+	// if batches are sent one after another, so workers take turns on them
+	g, gctx := errgroup.WithContext(ctx)
+	if cfg.FIFOQueue {
+		g.SetLimit(1)
+	}
+
+	// A worker is only started once it has a token, so files published at
+	// once share the workers rather than each starting their own
+launch:
+	for _, part := range parts {
+		select {
+		case j.workers <- struct{}{}:
+		case <-gctx.Done():
+			// A worker failed or the invocation is ending
+			break launch
+		}
+
+		g.Go(func() error {
+			defer func() { <-j.workers }()
+
+			if err := j.creds.refresh(gctx); err != nil {
+				logger.ErrorContext(gctx, "failed to refresh s3 credentials", "error", err)
+				return fmt.Errorf("failed to refresh s3 credentials: %w", err)
+			}
+			return worker(gctx, j.db, pub, logger)(file.path, part)
+		})
+	}
+
+	// Return on first error
+	if err := g.Wait(); err != nil {
+		return fmt.Errorf("worker error: %w", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("worker error: %w", err)
+	}
+
+	logger.InfoContext(ctx, "processed file",
+		"path", path,
+		"count", totalRows,
+		"row_groups", len(rowGroups),
+		"workers", len(parts))
+
+	return nil
+}
@@ -3,113 +3,171 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"io"
 	"log/slog"
 	"os"
-	"path/filepath"
-	"sync"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/dataset"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/publisher"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/s3paths"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/schema"
 )
 
 // request is the request for the handler function.
 type request struct {
-	Bucket string   `json:"bucket"`
-	Paths  []string `json:"paths"`
+	Bucket string `json:"bucket"`
+
+	// Paths are the keys of the files to publish. Glob patterns such as
+	// exports/2024-10-*/part-*.parquet and prefixes ending in a slash are
+	// expanded to the keys of the objects they match, see package s3paths.
+	Paths []string `json:"paths"`
+
+	// Objects optionally limits the objects patterns and prefixes in Paths
+	// expand to by last modified time and size.
+	Objects *s3paths.Filter `json:"objects,omitempty"`
+
+	// Dataset is the name of the registered dataset the files, or the result
+	// rows of Query, belong to, which decides how their rows are decoded,
+	// checked, keyed, transformed and where they are published. Defaults to
+	// records.
+	Dataset string `json:"dataset,omitempty"`
+
+	// Query is a SQL defined publish job whose result rows are published
+	// instead of the rows of Paths.
+	Query *query `json:"query,omitempty"`
 }
 
 // response is the response for the handler function.
 type response struct {
+	// Paths are the keys the requested paths expanded to, whether they were
+	// published or not.
 	Paths []string `json:"paths"`
+
+	// Files reports how publishing each of the keys went, in the order of
+	// Paths.
+	Files []fileReport `json:"files,omitempty"`
+
+	// Rows is the number of result rows published by a query.
+	Rows int64 `json:"rows,omitempty"`
+
+	// Retried is the number of messages that were sent again after a
+	// transient failure.
+	Retried int64 `json:"retried"`
+
+	// Failed is the number of messages that could not be published and were
+	// routed to the dead letter sink.
+	Failed int64 `json:"failed"`
+
+	// ClaimChecked is the number of messages whose body was too large to
+	// publish and was stored in S3 behind a pointer envelope.
+	ClaimChecked int64 `json:"claim_checked"`
 }
 
-// handler processes records from a set of parquet files from S3
-func handler(logger *slog.Logger, db *sql.DB, s3Client *s3.Client, cfg config) func(context.Context, request) (response, error) {
-	return func(ctx context.Context, req request) (response, error) {
-		tempDir, err := os.MkdirTemp("", "record-publisher-*")
+// handler publishes records from a set of parquet files from S3
+func handler(logger *slog.Logger, db *sql.DB, creds *s3Credentials, s3Client *s3.Client, dests publisher.Destinations, datasets *dataset.Registry, registry *schema.Registry, cfg config) func(context.Context, request) (response, error) {
+	return func(ctx context.Context, req request) (res response, err error) {
+		if req.Dataset == "" {
+			req.Dataset = dataset.Records
+		}
+
+		ds, err := datasets.Lookup(req.Dataset)
 		if err != nil {
-			return response{}, fmt.Errorf("failed to create temp directory: %w", err)
+			logger.ErrorContext(ctx, "invalid dataset", "dataset", req.Dataset, "error", err)
+			return response{}, fmt.Errorf("invalid dataset: %w", err)
 		}
 
-		defer os.RemoveAll(tempDir)
+		// Report publishing outcomes however the invocation ends
+		var stats publisher.Stats
+		defer func() {
+			res.Retried = stats.Retried.Load()
+			res.Failed = stats.Failed.Load()
+			res.ClaimChecked = stats.ClaimChecked.Load()
+
+			logger.InfoContext(ctx, "publish summary",
+				"retried", res.Retried,
+				"failed", res.Failed,
+				"claim_checked", res.ClaimChecked)
+		}()
+
+		if req.Query != nil {
+			if len(req.Paths) > 0 {
+				return response{}, errors.New("a request has either paths or a query, not both")
+			}
+			return publishQuery(ctx, logger, db, creds, dests.Destination(ds.Name), ds, cfg, req, &stats)
+		}
 
-		for _, path := range req.Paths {
-			localFilePath := filepath.Join(tempDir, filepath.Base(path))
+		// Expand patterns and prefixes into the keys of the files they match
+		var filter s3paths.Filter
+		if req.Objects != nil {
+			filter = *req.Objects
+		}
 
-			// Create the file
-			file, err := os.Create(localFilePath)
-			if err != nil {
-				return response{}, fmt.Errorf("failed to create file: %w", err)
-			}
-			defer file.Close()
+		paths, err := s3paths.Expand(ctx, s3Client, req.Bucket, req.Paths, filter)
+		if err != nil {
+			logger.ErrorContext(ctx, "failed to expand paths", "paths", req.Paths, "error", err)
+			return response{}, fmt.Errorf("failed to expand paths: %w", err)
+		}
 
-			// Download the file from S3
-			getObjectOutput, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
-				Bucket: aws.String(req.Bucket),
-				Key:    aws.String(path),
-			})
-			if err != nil {
-				return response{}, fmt.Errorf("failed to download file: %w", err)
-			}
+		logger.InfoContext(ctx, "expanded paths", "paths", req.Paths, "keys", paths)
 
-			// Write the downloaded file to the local file
-			if _, err = io.Copy(file, getObjectOutput.Body); err != nil {
-				return response{}, fmt.Errorf("failed to write file: %w", err)
+		// Files are downloaded to a temporary directory unless DuckDB reads
+		// them straight from S3
+		var tempDir string
+		if cfg.ReadMode != "s3" {
+			if tempDir, err = os.MkdirTemp("", "record-publisher-*"); err != nil {
+				return response{}, fmt.Errorf("failed to create temp directory: %w", err)
 			}
 
-			var totalRows int
+			defer os.RemoveAll(tempDir)
+		}
 
-			// Get total row count so we can batch rows across workers
-			countResult := db.QueryRowContext(ctx, fmt.Sprintf("SELECT count(*) FROM '%s';", localFilePath))
-			if countResult.Err() != nil {
-				return response{}, fmt.Errorf("failed to count rows: %w", err)
-			}
+		// Publish the files, up to FileConcurrency at once. FIFO queues only
+		// preserve order within a message group if files are published one
+		// after another. A file that fails is reported without stopping the
+		// others
+		job := &fileJob{
+			logger:   logger,
+			db:       db,
+			creds:    creds,
+			s3Client: s3Client,
+			dest:     dests.Destination(ds.Name),
+			registry: registry,
+			dataset:  ds,
+			cfg:      cfg,
+			bucket:   req.Bucket,
+			dir:      tempDir,
+			workers:  make(chan struct{}, max(cfg.MaxWorkers, 1)),
+			stats:    &stats,
+		}
 
-			if err := countResult.Scan(&totalRows); err != nil {
-				return response{}, fmt.Errorf("failed to scan row count: %w", err)
-			}
+		files := max(cfg.FileConcurrency, 1)
+		if cfg.FIFOQueue {
+			files = 1
+		}
 
-			// Calculate the number of workers needed
-			numWorkers := (totalRows + cfg.RowsPerWorker - 1) / cfg.RowsPerWorker // Ceiling division
-
-			// Create a wait group to synchronize workers
-			var wg sync.WaitGroup
-			wg.Add(numWorkers)
-
-			// Create a channel to capture errors from workers
-			errChan := make(chan error, numWorkers)
-
-			// Launch workers
-			for i := 0; i < numWorkers; i++ {
-				start := i * cfg.RowsPerWorker
-				end := start + cfg.RowsPerWorker
-				if end > totalRows {
-					end = totalRows
-				}
-				go func(start, end int) {
-					defer wg.Done()
-					worker(ctx, errChan, db, logger)(localFilePath, start, end)
-				}(start, end)
-			}
+		var g errgroup.Group
+		g.SetLimit(files)
 
-			// Wait for workers or errors
-			go func() {
-				wg.Wait()
-				close(errChan)
-			}()
+		res.Paths = paths
+		res.Files = make([]fileReport, len(paths))
+		for i, path := range paths {
+			g.Go(func() error {
+				res.Files[i] = job.run(ctx, path)
+				return nil
+			})
+		}
+		g.Wait()
 
-			// Return on first error
-			if err := <-errChan; err != nil {
-				return response{}, fmt.Errorf("worker error: %w", err)
+		for _, report := range res.Files {
+			if report.Status != filePublished {
+				logger.ErrorContext(ctx, "failed to publish file", "path", report.Path, "error", report.Error)
 			}
-
-			logger.InfoContext(ctx, "processed file", "path", path, "count", totalRows)
 		}
 
-		return response{
-			Paths: req.Paths,
-		}, nil
+		return res, nil
 	}
 }
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"io"
+	"log/slog"
+	"math/rand"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/dataset"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/envelope"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/publisher"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/recordgen"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/s3test"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/schema"
+)
+
+func TestHandlerReportsExpandedPaths(t *testing.T) {
+	srv := s3test.NewServer(t)
+	srv.Put("bucket", "exports/a.parquet", testRecordsFile(t, 10))
+	srv.Put("bucket", "exports/b.parquet", []byte("not a parquet file"))
+	srv.Put("bucket", "other/c.parquet", testRecordsFile(t, 10))
+
+	h := testHandler(t, srv, &recorder{})
+	res, err := h(context.Background(), request{Bucket: "bucket", Paths: []string{"exports/"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every key the prefix expanded to is reported, whether it was published
+	// or not
+	if want := []string{"exports/a.parquet", "exports/b.parquet"}; !slices.Equal(res.Paths, want) {
+		t.Fatalf("paths = %v, want %v", res.Paths, want)
+	}
+
+	statuses := make([]string, len(res.Files))
+	for i, report := range res.Files {
+		statuses[i] = report.Status
+	}
+	if want := []string{filePublished, fileFailed}; !slices.Equal(statuses, want) {
+		t.Fatalf("files = %+v, want statuses %v", res.Files, want)
+	}
+}
+
+func TestHandlerReportsPublishSummary(t *testing.T) {
+	srv := s3test.NewServer(t)
+	srv.Put("bucket", "a.parquet", testRecordsFile(t, 10))
+	srv.Put("bucket", "b.parquet", testRecordsFile(t, 10))
+
+	// Every message is rejected and routed to the dead letter sink
+	rej := &rejecter{}
+	h := testHandler(t, srv, rej)
+	res, err := h(context.Background(), request{Bucket: "bucket", Paths: []string{"a.parquet", "b.parquet"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rej.mu.Lock()
+	rejected := int64(len(rej.messages))
+	rej.mu.Unlock()
+
+	if rejected == 0 || res.Failed != rejected || res.Retried != 0 || res.ClaimChecked != 0 {
+		t.Fatalf("retried %d, failed %d, claim checked %d, want %d failed", res.Retried, res.Failed, res.ClaimChecked, rejected)
+	}
+}
+
+// testRecordsFile returns a parquet file of n generated records.
+func testRecordsFile(t *testing.T, n int) []byte {
+	t.Helper()
+
+	gen := recordgen.New(rand.New(rand.NewSource(1)), func() time.Time { return time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC) })
+
+	var buf bytes.Buffer
+	w := recordgen.NewWriter(&buf)
+	for range n {
+		if err := w.Write(gen.Record()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// testHandler returns a handler publishing files from srv to pub.
+func testHandler(t *testing.T, srv *s3test.Server, pub publisher.Publisher) func(context.Context, request) (response, error) {
+	t.Helper()
+
+	db, err := sql.Open("duckdb", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	registry, err := schema.Embedded()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := testConfig()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	dests, err := publisher.NewDestinations(cfg.Config, nil, dataset.Default, nil, func(c publisher.Config) (*publisher.Sender, error) {
+		return publisher.NewSender(logger, pub, publisher.NewLogDeadLetterSink(logger), nil, c), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return handler(logger, db, nil, srv.Client(), dests, dataset.Default, registry, cfg)
+}
+
+// rejecter is a publisher rejecting every message it is sent.
+type rejecter struct {
+	recorder
+}
+
+func (r *rejecter) PublishBatch(ctx context.Context, messages []envelope.Message) ([]publisher.Failure, error) {
+	r.recorder.PublishBatch(ctx, messages)
+
+	failures := make([]publisher.Failure, len(messages))
+	for i, msg := range messages {
+		failures[i] = publisher.Failure{ID: msg.ID, Code: "InvalidMessageContents", SenderFault: true}
+	}
+	return failures, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// rowGroup is a row group of a parquet file.
+type rowGroup struct {
+	// start is the row number of the first row of the row group in the file.
+	start int64
+
+	rows int64
+}
+
+// partition is a range of whole, consecutive row groups of a parquet file
+// read by a single worker.
+type partition struct {
+	rowGroups []rowGroup
+}
+
+// start returns the row number of the first row of the partition.
+func (p partition) start() int64 {
+	return p.rowGroups[0].start
+}
+
+// end returns the row number after the last row of the partition.
+func (p partition) end() int64 {
+	last := p.rowGroups[len(p.rowGroups)-1]
+	return last.start + last.rows
+}
+
+// readRowGroups reads the row groups of a parquet file from its metadata,
+// without reading any of its rows.
+func readRowGroups(ctx context.Context, db *sql.DB, path string) ([]rowGroup, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT row_group_id, any_value(row_group_num_rows)
+		FROM parquet_metadata(?)
+		GROUP BY row_group_id
+		ORDER BY row_group_id`, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query parquet metadata: %w", err)
+	}
+	defer rows.Close()
+
+	var (
+		groups []rowGroup
+		start  int64
+	)
+	for rows.Next() {
+		var id, n int64
+		if err := rows.Scan(&id, &n); err != nil {
+			return nil, fmt.Errorf("failed to scan row group: %w", err)
+		}
+		if id != int64(len(groups)) {
+			return nil, fmt.Errorf("row group %d is missing from parquet metadata", len(groups))
+		}
+
+		groups = append(groups, rowGroup{start: start, rows: n})
+		start += n
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read parquet metadata: %w", err)
+	}
+
+	return groups, nil
+}
+
+// planPartitions splits the row groups of a file into partitions of about
+// rowsPerWorker rows. The number of partitions is that of rowsPerWorker sized
+// slices of the file, and row groups are spread over them as evenly as their
+// sizes allow. Every partition holds at least one row group, so there are
+// fewer when row groups are larger than rowsPerWorker. Every row group is its
+// own partition if rowsPerWorker is not set. Empty row groups are skipped.
+func planPartitions(groups []rowGroup, rowsPerWorker int) []partition {
+	var total int64
+	for _, g := range groups {
+		total += g.rows
+	}
+
+	if total == 0 {
+		return nil
+	}
+
+	// Ceiling division
+	target := int64(1)
+	if rowsPerWorker > 0 {
+		numWorkers := (total + int64(rowsPerWorker) - 1) / int64(rowsPerWorker)
+		target = (total + numWorkers - 1) / numWorkers
+	}
+
+	var (
+		parts   []partition
+		current partition
+
+		// rows is the number of rows up to the end of the current partition
+		rows int64
+	)
+	for _, g := range groups {
+		if g.rows == 0 {
+			continue
+		}
+
+		current.rowGroups = append(current.rowGroups, g)
+		rows += g.rows
+
+		// Close the partition once it holds its share of the rows, so later
+		// partitions are not left with more than theirs
+		if rows >= target*int64(len(parts)+1) {
+			parts = append(parts, current)
+			current = partition{}
+		}
+	}
+
+	if len(current.rowGroups) > 0 {
+		parts = append(parts, current)
+	}
+
+	return parts
+}
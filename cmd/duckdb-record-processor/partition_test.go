@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/recordgen"
+)
+
+func TestReadRowGroups(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sql.Open("duckdb", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	tests := []struct {
+		name  string
+		sizes []int
+		want  []rowGroup
+	}{
+		{name: "empty file", sizes: nil, want: nil},
+		{name: "single row group", sizes: []int{25}, want: []rowGroup{{start: 0, rows: 25}}},
+		{name: "offsets carry across row groups", sizes: []int{10, 25, 5}, want: []rowGroup{
+			{start: 0, rows: 10},
+			{start: 10, rows: 25},
+			{start: 35, rows: 5},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeRowGroups(t, tt.sizes)
+
+			got, err := readRowGroups(ctx, db, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("row groups = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanPartitions(t *testing.T) {
+	// groups returns row groups of the given sizes, numbered from row 0
+	groups := func(sizes ...int64) []rowGroup {
+		var (
+			out   []rowGroup
+			start int64
+		)
+		for _, n := range sizes {
+			out = append(out, rowGroup{start: start, rows: n})
+			start += n
+		}
+		return out
+	}
+
+	tests := []struct {
+		name          string
+		groups        []rowGroup
+		rowsPerWorker int
+
+		// want holds the start of every row group of every partition
+		want [][]int64
+	}{
+		{name: "no row groups", groups: nil, rowsPerWorker: 100, want: nil},
+		{name: "only empty row groups", groups: groups(0, 0), rowsPerWorker: 100, want: nil},
+		{name: "row group per partition", groups: groups(100, 50, 100), rowsPerWorker: 0, want: [][]int64{{0}, {100}, {150}}},
+		{name: "row group larger than the target", groups: groups(1_000), rowsPerWorker: 100, want: [][]int64{{0}}},
+		{name: "row groups larger than the target", groups: groups(300, 300), rowsPerWorker: 100, want: [][]int64{{0}, {300}}},
+		{name: "exact boundaries", groups: groups(100, 100, 100, 100), rowsPerWorker: 200, want: [][]int64{{0, 100}, {200, 300}}},
+		{name: "row group per target", groups: groups(100, 100, 100), rowsPerWorker: 100, want: [][]int64{{0}, {100}, {200}}},
+		{name: "one row past a boundary", groups: groups(100, 100, 1), rowsPerWorker: 200, want: [][]int64{{0, 100}, {200}}},
+		{name: "large row group first", groups: groups(300, 100, 100, 100), rowsPerWorker: 300, want: [][]int64{{0}, {300, 400, 500}}},
+		{name: "empty row groups skipped", groups: groups(100, 0, 100, 0), rowsPerWorker: 100, want: [][]int64{{0}, {100}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts := planPartitions(tt.groups, tt.rowsPerWorker)
+
+			got := make([][]int64, len(parts))
+			for i, part := range parts {
+				for _, g := range part.rowGroups {
+					got[i] = append(got[i], g.start)
+				}
+			}
+			if !slices.EqualFunc(got, tt.want, slices.Equal) {
+				t.Fatalf("partitions = %v, want %v", got, tt.want)
+			}
+
+			// Partitions cover the rows of the file without gaps or overlaps
+			var next int64
+			for i, part := range parts {
+				if part.start() != next {
+					t.Fatalf("partition %d starts at row %d, want %d", i, part.start(), next)
+				}
+				next = part.end()
+			}
+			if total := sumRows(tt.groups); next != total {
+				t.Fatalf("partitions end at row %d, want %d", next, total)
+			}
+		})
+	}
+}
+
+// sumRows returns the number of rows of row groups.
+func sumRows(groups []rowGroup) int64 {
+	var n int64
+	for _, g := range groups {
+		n += g.rows
+	}
+	return n
+}
+
+// writeRowGroups writes a parquet file of generated records with row groups
+// of the given sizes and returns its path.
+func writeRowGroups(t *testing.T, sizes []int) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "records.parquet")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	gen := recordgen.New(rand.New(rand.NewSource(1)), func() time.Time { return time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC) })
+	w := recordgen.NewWriter(f)
+	for _, n := range sizes {
+		for range n {
+			if err := w.Write(gen.Record()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/dataset"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/envelope"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/publisher"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/redact"
+)
+
+// publication publishes the records of a single parquet file through the same
+// transforms, redaction, envelopes and batches as the parquetgo processor, so
+// both publish a file as the same messages. DuckDB reads timestamps with
+// microsecond precision, so nanosecond timestamps are published truncated to
+// microseconds.
+type publication struct {
+	logger  *slog.Logger
+	sender  *publisher.Sender
+	cfg     config
+	header  envelope.Header
+	dataset dataset.Dataset
+	stats   *publisher.Stats
+
+	// policy redacts records before they are published, nil if they are
+	// published as they are.
+	policy *redact.Policy
+
+	// read and published are the number of rows read and published by every
+	// worker.
+	read      atomic.Int64
+	published atomic.Int64
+}
+
+// prepare transforms a record the way its dataset publishes it and redacts it
+// for the destination. Redacting last means fields created by transforms are
+// checked by the policy too.
+func (p *publication) prepare(ctx context.Context, row int64, record any) (any, error) {
+	record, err := p.dataset.Transform(record)
+	if err != nil {
+		p.logger.ErrorContext(ctx, "failed to transform record", "row", row, slog.Any("error", err))
+		return nil, fmt.Errorf("failed to transform row %d: %w", row, err)
+	}
+
+	if p.policy != nil {
+		if record, err = p.policy.Redact(record, p.dataset.Classes); err != nil {
+			p.logger.ErrorContext(ctx, "failed to redact record", "row", row, "policy", p.policy.Name, slog.Any("error", err))
+			return nil, fmt.Errorf("failed to redact row %d: %w", row, err)
+		}
+	}
+
+	return record, nil
+}
+
+// publish packs records read from rows of the file into envelopes and sends
+// them in as few batches as the sink limits allow. rows holds the row number
+// of every record.
+func (p *publication) publish(ctx context.Context, rows []int64, records []any) error {
+	encoded, err := envelope.EncodeRecords(records)
+	if err != nil {
+		p.logger.ErrorContext(ctx, "failed to encode records", "file", p.header.Source, "error", err)
+		return fmt.Errorf("failed to encode records from file %s: %w", p.header.Source, err)
+	}
+
+	limits := p.sender.Limits()
+	messages, err := envelope.Pack(p.header, rows[0], encoded, envelope.PackOptions{
+		MaxRecords:   p.cfg.SQSBatchSize,
+		MaxBytes:     limits.MaxEntryBytes,
+		Rows:         rows,
+		Oversize:     p.sender.ClaimChecks(),
+		Keys:         p.cfg.GroupIDs(p.header.Source, records, p.dataset.KeyField),
+		Attributes:   p.cfg.MessageAttributes.AttributesOf(records),
+		NoAttributes: limits.MaxAttributes == 0,
+	})
+	if err != nil {
+		p.logger.ErrorContext(ctx, "failed to pack records", "file", p.header.Source, "error", err)
+		return fmt.Errorf("failed to pack records from file %s: %w", p.header.Source, err)
+	}
+
+	for i, messages := range publisher.Batch(messages, limits) {
+		req := publisher.Request{
+			BatchIndex: i,
+			Source:     p.header.Source,
+			Messages:   messages,
+			Stats:      p.stats,
+		}
+		if err := p.sender.Send(ctx, req); err != nil {
+			return err
+		}
+	}
+
+	p.published.Add(int64(len(records)))
+	return nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"math/rand"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/dataset"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/envelope"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/models"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/publisher"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/recordgen"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/redact"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/s3test"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/schema"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/transform"
+)
+
+// TestPublishParity publishes a generated file with transforms, a redaction
+// policy and message attributes, and checks every record and message is
+// published as the parquetgo processor publishes it: transformed, then
+// redacted, then packed with the attributes of its records.
+func TestPublishParity(t *testing.T) {
+	ctx := context.Background()
+
+	// DuckDB reads timestamps with microsecond precision, so the generated
+	// ones have no nanoseconds
+	now := time.Date(2024, 10, 1, 12, 30, 15, 123456000, time.UTC)
+	gen := recordgen.New(rand.New(rand.NewSource(1)), func() time.Time { return now })
+
+	var (
+		buf     bytes.Buffer
+		records = make([]models.Record, 1_200)
+	)
+	w := recordgen.NewWriter(&buf)
+	for i := range records {
+		records[i] = gen.Record()
+		if err := w.Write(records[i]); err != nil {
+			t.Fatal(err)
+		}
+		if (i+1)%500 == 0 {
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := s3test.NewServer(t)
+	srv.Put("bucket", "test_data.parquet", buf.Bytes())
+
+	chains, err := transform.Parse([]byte(`records: [{op: derive, field: greeting, template: "Hello {{.first_name}}"}]`), transform.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	datasets := dataset.NewRegistry()
+	dataset.Register[models.Record](datasets, dataset.Records, dataset.Options{Schema: "record"})
+	if err := datasets.AddTransforms(dataset.Records, chains[dataset.Records].Fingerprint(), chains[dataset.Records].Classify, chains[dataset.Records].Apply); err != nil {
+		t.Fatal(err)
+	}
+	ds, err := datasets.Lookup(dataset.Records)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policies, err := redact.ParsePolicies([]byte(`
+internal:
+  actions:
+    name: drop
+    email: hash
+`), []byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := policies["internal"]
+
+	cfg := testConfig()
+	if err := cfg.MessageAttributes.UnmarshalText([]byte("account_type,country=address.country")); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := &recorder{}
+	job := testFileJob(t, srv, rec, cfg)
+	job.dataset = ds
+	job.dest.Policy = policy
+
+	report := job.run(ctx, "test_data.parquet")
+	if report.Status != filePublished || report.RowsPublished != int64(len(records)) {
+		t.Fatalf("report = %+v", report)
+	}
+
+	// Every record is the transformed and redacted generated record
+	want := make([]json.RawMessage, len(records))
+	for i, r := range records {
+		record, err := ds.Transform(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record, err = policy.Redact(record, ds.Classes); err != nil {
+			t.Fatal(err)
+		}
+		if want[i], err = json.Marshal(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	messages := rec.sorted()
+	var published int
+	for _, msg := range messages {
+		env, err := envelope.Decode(msg.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if wantID := envelope.ID(envelope.Header{Source: env.Source, ETag: env.ETag, Config: ds.Fingerprint(policy)}, env.RowStart, env.RowEnd); env.ID != wantID {
+			t.Fatalf("envelope of rows %d-%d has ID %s, want %s", env.RowStart, env.RowEnd, env.ID, wantID)
+		}
+
+		for i, raw := range env.Records {
+			row := env.RowStart + int64(i)
+			if !bytes.Equal(raw, want[row]) {
+				t.Fatalf("row %d published as\n%s\nwant\n%s", row, raw, want[row])
+			}
+
+			attrs := cfg.MessageAttributes.Attributes(records[row])
+			for name, attr := range attrs {
+				if msg.Attributes[name] != attr {
+					t.Fatalf("row %d published with attribute %s = %v, want %v", row, name, msg.Attributes[name], attr)
+				}
+			}
+		}
+		published += len(env.Records)
+	}
+	if published != len(records) {
+		t.Fatalf("published %d records, want %d", published, len(records))
+	}
+}
+
+// testConfig returns the configuration of tests, publishing in small batches
+// so files span many messages.
+func testConfig() config {
+	return config{
+		Config: publisher.Config{
+			PublishConcurrency: 4,
+			PublishMaxAttempts: 1,
+		},
+		SQSBatchSize:    50,
+		RowsPerBatch:    100,
+		RowsPerWorker:   300,
+		MaxWorkers:      2,
+		FileConcurrency: 1,
+		ReadMode:        "download",
+	}
+}
+
+// testFileJob returns a job publishing records files from srv to rec, reading
+// them with an in-memory DuckDB database.
+func testFileJob(t *testing.T, srv *s3test.Server, rec *recorder, cfg config) *fileJob {
+	t.Helper()
+
+	db, err := sql.Open("duckdb", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	ds, err := dataset.Default.Lookup(dataset.Records)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registry, err := schema.Embedded()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	return &fileJob{
+		logger:   logger,
+		db:       db,
+		s3Client: srv.Client(),
+		dest:     publisher.Destination{Sender: publisher.NewSender(logger, rec, publisher.NewLogDeadLetterSink(logger), nil, cfg.Config)},
+		registry: registry,
+		dataset:  ds,
+		cfg:      cfg,
+		bucket:   "bucket",
+		dir:      t.TempDir(),
+		workers:  make(chan struct{}, max(cfg.MaxWorkers, 1)),
+		stats:    &publisher.Stats{},
+	}
+}
+
+// recorder is a publisher recording the messages it is sent.
+type recorder struct {
+	mu       sync.Mutex
+	messages []envelope.Message
+}
+
+// Limits returns the limits of the NDJSON sink, but allows attributes as they
+// are recorded.
+func (r *recorder) Limits() publisher.Limits {
+	limits := publisher.NewNDJSON(io.Discard).Limits()
+	limits.MaxAttributes = 10
+	return limits
+}
+
+func (r *recorder) PublishBatch(_ context.Context, messages []envelope.Message) ([]publisher.Failure, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.messages = append(r.messages, messages...)
+	return nil, nil
+}
+
+// sorted returns the messages published, ordered by first row.
+func (r *recorder) sorted() []envelope.Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	messages := slices.Clone(r.messages)
+	slices.SortFunc(messages, func(a, b envelope.Message) int {
+		return int(a.RowStart - b.RowStart)
+	})
+	return messages
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/dataset"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/envelope"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/publisher"
+)
+
+// defaultQueryBatchRows is the number of result rows published at once when
+// RowsPerBatch is not set.
+const defaultQueryBatchRows = 1000
+
+// query is a SQL defined publish job. Every row of its result is published as
+// a record of the dataset of the request, keyed by column name, transformed
+// and redacted the same way as the rows of its files.
+//
+// The query must be a single read only SELECT statement that reads nothing but
+// its sources. Statements that write or change settings, table functions such
+// as read_csv and glob, and tables other than the sources are refused before
+// it is run, so a job cannot reach files or state the function can beyond
+// the sources it names.
+type query struct {
+	// SQL is the query, such as a join, aggregate or window function over its
+	// sources. It refers to its sources by name and to its parameters with
+	// placeholders such as $1. Results should be ordered, so a job run again
+	// publishes the same rows in the same envelopes.
+	SQL string `json:"sql"`
+
+	// Sources are the parquet files the query reads, keyed by the name the
+	// query refers to them by. Every source is a list of keys in the bucket of
+	// the request or s3:// URLs, which can be globs such as 2024/*.parquet.
+	Sources map[string][]string `json:"sources"`
+
+	// Params are the values of the parameters of the query.
+	Params []any `json:"params,omitempty"`
+}
+
+// validate checks the job can be run.
+func (q query) validate(cfg config) error {
+	if strings.TrimSpace(q.SQL) == "" {
+		return errors.New("sql is required")
+	}
+
+	if cfg.ReadMode != "s3" {
+		return errors.New("queries read their sources from S3 and need the s3 read mode")
+	}
+
+	for name, keys := range q.Sources {
+		if name == "" {
+			return errors.New("source names must not be empty")
+		}
+		if len(keys) == 0 {
+			return fmt.Errorf("source %s has no keys", name)
+		}
+	}
+
+	return nil
+}
+
+// id identifies the job by its query, sources and parameters, so the records
+// of a job run again are published in envelopes with the same IDs.
+func (q query) id() (string, error) {
+	data, err := json.Marshal(q)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode query: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+// runQuery publishes the result rows of a SQL defined publish job, and returns
+// the number of rows published.
+func runQuery(ctx context.Context, db *sql.DB, pub *publication, logger *slog.Logger, bucket string, q query) (int64, error) {
+	// Sources are views on a connection of their own, so they are visible to
+	// the query and to nothing else
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	for _, name := range slices.Sorted(maps.Keys(q.Sources)) {
+		paths := make([]string, len(q.Sources[name]))
+		for i, key := range q.Sources[name] {
+			if !strings.HasPrefix(key, "s3://") {
+				key = "s3://" + bucket + "/" + key
+			}
+			paths[i] = quoteLiteral(key)
+		}
+
+		// Views cannot take parameters, so the names and paths are quoted
+		view := quoteIdentifier(name)
+		stmt := "CREATE OR REPLACE TEMP VIEW " + view + " AS SELECT * FROM read_parquet([" + strings.Join(paths, ", ") + "])"
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			logger.ErrorContext(ctx, "failed to create source view", "source", name, slog.Any("error", err))
+			return 0, fmt.Errorf("failed to create view of source %s: %w", name, err)
+		}
+		defer conn.ExecContext(context.WithoutCancel(ctx), "DROP VIEW IF EXISTS "+view)
+	}
+
+	if err := checkReadOnly(ctx, conn, q); err != nil {
+		logger.ErrorContext(ctx, "refused query", slog.Any("error", err))
+		return 0, fmt.Errorf("refused query: %w", err)
+	}
+
+	rows, err := conn.QueryContext(ctx, q.SQL, q.Params...)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to run query", slog.Any("error", err))
+		return 0, fmt.Errorf("failed to run query: %w", err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to get columns", slog.Any("error", err))
+		return 0, fmt.Errorf("failed to get columns: %w", err)
+	}
+
+	batchSize := pub.cfg.RowsPerBatch
+	if batchSize <= 0 {
+		batchSize = defaultQueryBatchRows
+	}
+
+	var (
+		batch     = make([]any, 0, batchSize)
+		batchRows = make([]int64, 0, batchSize)
+		batchRow  int64
+	)
+
+	// Publish the result rows read so far and start the next batch after them
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := pub.publish(ctx, batchRows, batch); err != nil {
+			return err
+		}
+		batchRow += int64(len(batch))
+		batch, batchRows = batch[:0], batchRows[:0]
+		return nil
+	}
+
+	for rows.Next() {
+		row, err := scanRow(rows, columns)
+		if err != nil {
+			logger.ErrorContext(ctx, "failed to scan row", slog.Any("error", err))
+			return 0, fmt.Errorf("failed to scan row: %w", err)
+		}
+
+		// Result rows hold whatever columns the query selects, so they are
+		// published as they are read rather than decoded as records of the
+		// dataset, but transformed and redacted like them
+		rowNum := batchRow + int64(len(batch))
+		record, err := pub.prepare(ctx, rowNum, row)
+		if err != nil {
+			return 0, err
+		}
+
+		batch = append(batch, record)
+		batchRows = append(batchRows, rowNum)
+		if len(batch) == batchSize {
+			if err := flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.ErrorContext(ctx, "failed to read query results", slog.Any("error", err))
+		return 0, fmt.Errorf("failed to read query results: %w", err)
+	}
+
+	if err := flush(); err != nil {
+		return 0, err
+	}
+
+	return batchRow, nil
+}
+
+// publishQuery runs the query of a request and publishes its result rows as
+// records of a dataset to its destination, counting the outcomes in stats.
+func publishQuery(ctx context.Context, logger *slog.Logger, db *sql.DB, creds *s3Credentials, dest publisher.Destination, ds dataset.Dataset, cfg config, req request, stats *publisher.Stats) (response, error) {
+	q := *req.Query
+	if err := q.validate(cfg); err != nil {
+		return response{}, fmt.Errorf("invalid query: %w", err)
+	}
+
+	id, err := q.id()
+	if err != nil {
+		return response{}, err
+	}
+
+	// Result rows are not rows of a file, so envelopes identify the job
+	// instead and number rows by their position in the result
+	pub := &publication{
+		logger: logger,
+		sender: dest.Sender,
+		cfg:    cfg,
+		header: envelope.Header{
+			Source: "query:" + id,
+			Config: ds.Fingerprint(dest.Policy),
+		},
+		dataset: ds,
+		stats:   stats,
+		policy:  dest.Policy,
+	}
+
+	if err := creds.refresh(ctx); err != nil {
+		logger.ErrorContext(ctx, "failed to refresh s3 credentials", "error", err)
+		return response{}, fmt.Errorf("failed to refresh s3 credentials: %w", err)
+	}
+
+	count, err := runQuery(ctx, db, pub, logger, req.Bucket, q)
+	if err != nil {
+		return response{}, fmt.Errorf("failed to publish query %s: %w", id, err)
+	}
+
+	logger.InfoContext(ctx, "published query", "query", id, "count", count)
+
+	return response{Rows: count}, nil
+}
+
+// checkReadOnly checks a query is a single SELECT statement reading nothing
+// but its sources and the common table expressions it defines. DuckDB parses
+// the query into its syntax tree, which it only does for SELECT statements,
+// and every table the tree reads from is checked.
+func checkReadOnly(ctx context.Context, conn *sql.Conn, q query) error {
+	var tree string
+	if err := conn.QueryRowContext(ctx, "SELECT json_serialize_sql(?::VARCHAR)", q.SQL).Scan(&tree); err != nil {
+		return fmt.Errorf("failed to parse query: %w", err)
+	}
+
+	var parsed struct {
+		Error        bool              `json:"error"`
+		ErrorMessage string            `json:"error_message"`
+		Statements   []json.RawMessage `json:"statements"`
+	}
+	if err := json.Unmarshal([]byte(tree), &parsed); err != nil {
+		return fmt.Errorf("failed to decode parsed query: %w", err)
+	}
+
+	switch {
+	case parsed.Error:
+		return fmt.Errorf("only SELECT statements are allowed: %s", parsed.ErrorMessage)
+	case len(parsed.Statements) != 1:
+		return fmt.Errorf("expected a single statement, got %d", len(parsed.Statements))
+	}
+
+	var node any
+	if err := json.Unmarshal(parsed.Statements[0], &node); err != nil {
+		return fmt.Errorf("failed to decode parsed query: %w", err)
+	}
+
+	// Tables are read by name from the sources and from the common table
+	// expressions of the query
+	tables := make(map[string]bool, len(q.Sources))
+	for name := range q.Sources {
+		tables[strings.ToLower(name)] = true
+	}
+	walkTree(node, func(m map[string]any) error {
+		if ctes, ok := m["cte_map"].(map[string]any); ok {
+			entries, _ := ctes["map"].([]any)
+			for _, entry := range entries {
+				if entry, ok := entry.(map[string]any); ok {
+					if name, ok := entry["key"].(string); ok {
+						tables[strings.ToLower(name)] = true
+					}
+				}
+			}
+		}
+		return nil
+	})
+
+	return walkTree(node, func(m map[string]any) error {
+		switch m["type"] {
+		case "TABLE_FUNCTION":
+			function, _ := m["function"].(map[string]any)
+			return fmt.Errorf("table function %v is not allowed, queries only read their sources", function["function_name"])
+		case "BASE_TABLE":
+			name, _ := m["table_name"].(string)
+			if m["schema_name"] != "" || m["catalog_name"] != "" || !tables[strings.ToLower(name)] {
+				return fmt.Errorf("table %q is not a source of the query", name)
+			}
+		}
+		return nil
+	})
+}
+
+// walkTree calls fn with every object of a decoded JSON tree, stopping at the
+// first error.
+func walkTree(v any, fn func(map[string]any) error) error {
+	switch v := v.(type) {
+	case map[string]any:
+		if err := fn(v); err != nil {
+			return err
+		}
+		for _, k := range slices.Sorted(maps.Keys(v)) {
+			if err := walkTree(v[k], fn); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, e := range v {
+			if err := walkTree(e, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
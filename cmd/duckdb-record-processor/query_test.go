@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/dataset"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/envelope"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/fields"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/models"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/publisher"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/redact"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/transform"
+)
+
+func TestCheckReadOnly(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sql.Open("duckdb", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sources := map[string][]string{"accounts": {"accounts.parquet"}, "Logins": {"logins.parquet"}}
+
+	tests := []struct {
+		name    string
+		sql     string
+		allowed bool
+	}{
+		{name: "select from a source", sql: "SELECT id, email FROM accounts ORDER BY id", allowed: true},
+		{name: "join of sources", sql: "SELECT a.id, count(*) AS n FROM accounts a JOIN logins l ON a.id = l.id GROUP BY a.id", allowed: true},
+		{name: "common table expression", sql: "WITH recent AS (SELECT * FROM logins WHERE day > $1) SELECT * FROM recent", allowed: true},
+		{name: "subquery of a source", sql: "SELECT * FROM (SELECT id FROM accounts) WHERE id IN (SELECT id FROM logins)", allowed: true},
+		{name: "values", sql: "SELECT * FROM (VALUES (1, 'a')) t(id, name)", allowed: true},
+		{name: "copy", sql: "COPY (SELECT * FROM accounts) TO 's3://elsewhere/accounts.csv'"},
+		{name: "settings", sql: "SET s3_region = 'us-west-2'"},
+		{name: "extension", sql: "INSTALL spatial"},
+		{name: "secret", sql: "CREATE SECRET other (TYPE S3)"},
+		{name: "several statements", sql: "SELECT * FROM accounts; SELECT * FROM logins"},
+		{name: "table function", sql: "SELECT * FROM read_csv('s3://elsewhere/secrets.csv')"},
+		{name: "table function in a subquery", sql: "SELECT * FROM accounts WHERE id IN (SELECT file FROM glob('s3://elsewhere/*'))"},
+		{name: "table function in a common table expression", sql: "WITH f AS (SELECT * FROM read_parquet('s3://elsewhere/x.parquet')) SELECT * FROM f"},
+		{name: "file path", sql: "SELECT * FROM 's3://elsewhere/x.parquet'"},
+		{name: "table other than a source", sql: "SELECT * FROM duckdb_settings"},
+		{name: "qualified table", sql: "SELECT * FROM information_schema.tables"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkReadOnly(ctx, conn, query{SQL: tt.sql, Sources: sources})
+			if allowed := err == nil; allowed != tt.allowed {
+				t.Fatalf("allowed = %t, want %t: %v", allowed, tt.allowed, err)
+			}
+		})
+	}
+}
+
+func TestPublishQueryTransformsAndRedacts(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sql.Open("duckdb", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	chains, err := transform.Parse([]byte(`records: [{op: derive, field: greeting, template: "Hello {{.first_name}}"}]`), transform.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	datasets := dataset.NewRegistry()
+	dataset.Register[models.Record](datasets, dataset.Records, dataset.Options{Schema: "record"})
+	if err := datasets.AddTransforms(dataset.Records, chains[dataset.Records].Fingerprint(), chains[dataset.Records].Classify, chains[dataset.Records].Apply); err != nil {
+		t.Fatal(err)
+	}
+	ds, err := datasets.Lookup(dataset.Records)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policies, err := redact.ParsePolicies([]byte(`
+internal:
+  actions:
+    name: drop
+external:
+  external: true
+  actions:
+    name: allow
+`), []byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := testConfig()
+	cfg.ReadMode = "s3"
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	req := request{Query: &query{
+		SQL: "SELECT * FROM (VALUES ('id-1', 'Ada'), ('id-2', 'Grace')) t(id, first_name) ORDER BY id",
+	}}
+
+	t.Run("redacted after transforms", func(t *testing.T) {
+		rec := &recorder{}
+		dest := publisher.Destination{
+			Sender: publisher.NewSender(logger, rec, publisher.NewLogDeadLetterSink(logger), nil, cfg.Config),
+			Policy: policies["internal"],
+		}
+
+		res, err := publishQuery(ctx, logger, db, nil, dest, ds, cfg, req, &publisher.Stats{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Rows != 2 {
+			t.Fatalf("published %d rows, want 2", res.Rows)
+		}
+
+		var records []map[string]any
+		for _, msg := range rec.sorted() {
+			env, err := envelope.Decode(msg.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := envelope.ID(envelope.Header{Source: env.Source, Config: ds.Fingerprint(dest.Policy)}, env.RowStart, env.RowEnd); env.ID != want {
+				t.Fatalf("envelope ID = %s, want %s", env.ID, want)
+			}
+			for _, raw := range env.Records {
+				var record map[string]any
+				if err := json.Unmarshal(raw, &record); err != nil {
+					t.Fatal(err)
+				}
+				records = append(records, record)
+			}
+		}
+
+		if len(records) != 2 {
+			t.Fatalf("published %d records, want 2", len(records))
+		}
+		for i, name := range []string{"Ada", "Grace"} {
+			_, first := records[i]["first_name"]
+			_, greeting := records[i]["greeting"]
+			if first || greeting || records[i]["id"] != []string{"id-1", "id-2"}[i] {
+				t.Errorf("record %d of %s = %v, want first_name and the greeting derived from it dropped", i, name, records[i])
+			}
+		}
+	})
+
+	t.Run("derived field to an external destination", func(t *testing.T) {
+		rec := &recorder{}
+		dest := publisher.Destination{
+			Sender: publisher.NewSender(logger, rec, publisher.NewLogDeadLetterSink(logger), nil, cfg.Config),
+			Policy: policies["external"],
+		}
+
+		// greeting is derived from a name, which the policy allows
+		if _, err := publishQuery(ctx, logger, db, nil, dest, ds, cfg, req, &publisher.Stats{}); err != nil {
+			t.Fatal(err)
+		}
+
+		var greetings []any
+		for _, msg := range rec.sorted() {
+			env, err := envelope.Decode(msg.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, raw := range env.Records {
+				var record map[string]any
+				if err := json.Unmarshal(raw, &record); err != nil {
+					t.Fatal(err)
+				}
+				greetings = append(greetings, record["greeting"])
+			}
+		}
+		if len(greetings) != 2 || greetings[0] != "Hello Ada" || greetings[1] != "Hello Grace" {
+			t.Fatalf("greetings = %v, want both published", greetings)
+		}
+	})
+
+	t.Run("unclassified field to an external destination", func(t *testing.T) {
+		// A transform registered in code adds a field nobody classified
+		unclassified := dataset.NewRegistry()
+		dataset.Register[models.Record](unclassified, dataset.Records, dataset.Options{Schema: "record"})
+		if err := unclassified.AddTransforms(dataset.Records, "note", nil, func(record any) (any, error) {
+			m, err := fields.Map(record)
+			if err != nil {
+				return nil, err
+			}
+			m["note"] = "vip"
+			return m, nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		ds, err := unclassified.Lookup(dataset.Records)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := &recorder{}
+		dest := publisher.Destination{
+			Sender: publisher.NewSender(logger, rec, publisher.NewLogDeadLetterSink(logger), nil, cfg.Config),
+			Policy: policies["external"],
+		}
+
+		if _, err := publishQuery(ctx, logger, db, nil, dest, ds, cfg, req, &publisher.Stats{}); !errors.Is(err, redact.ErrUnclassified) {
+			t.Fatalf("err = %v, want %v", err, redact.ErrUnclassified)
+		}
+		if n := len(rec.sorted()); n != 0 {
+			t.Fatalf("published %d messages, want none", n)
+		}
+	})
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/schema"
+)
+
+// fileColumns returns the columns of a parquet file as described by the
+// schema registry, read from the file footer. The columns are described the
+// way the parquetgo processor describes them, so both fingerprint a file the
+// same.
+func fileColumns(r io.ReaderAt, size int64) ([]schema.Column, error) {
+	pf, err := parquet.OpenFile(r, size, parquet.SkipPageIndex(true), parquet.SkipBloomFilters(true))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read parquet footer: %w", err)
+	}
+
+	return schema.Describe(pf.Schema()), nil
+}
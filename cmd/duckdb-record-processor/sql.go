@@ -0,0 +1,101 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/marcboeker/go-duckdb"
+)
+
+// quoteLiteral quotes a string as a SQL string literal. Statements that
+// cannot take parameters, such as CREATE VIEW and CREATE SECRET, embed values
+// with it so they are never interpreted as SQL.
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// globEscaper escapes the characters DuckDB expands in file paths, see
+// escapeGlob.
+var globEscaper = strings.NewReplacer("*", "[*]", "?", "[?]", "[", "[[]")
+
+// escapeGlob escapes a path so DuckDB reads the file at the path and nothing
+// else, rather than every file the path matches as a glob pattern.
+func escapeGlob(path string) string {
+	return globEscaper.Replace(path)
+}
+
+// quoteIdentifier quotes a string as a SQL identifier, such as the name of a
+// view or column.
+func quoteIdentifier(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// scanRow scans the current row of a result into a map keyed by column name.
+// Values DuckDB returns as types without a JSON encoding of their own are
+// converted, so the row is published the way it reads.
+func scanRow(rows *sql.Rows, columns []string) (map[string]any, error) {
+	values := make([]any, len(columns))
+	ptrs := make([]any, len(columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+
+	if err := rows.Scan(ptrs...); err != nil {
+		return nil, err
+	}
+
+	row := make(map[string]any, len(columns))
+	for i, name := range columns {
+		row[name] = jsonValue(values[i])
+	}
+
+	return row, nil
+}
+
+// jsonValue converts a scanned value to one that encodes to JSON. Decimals
+// become exact JSON numbers and maps become JSON objects.
+func jsonValue(v any) any {
+	switch v := v.(type) {
+	case duckdb.Decimal:
+		return decimalNumber(v)
+	case duckdb.Map:
+		m := make(map[string]any, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = jsonValue(e)
+		}
+		return m
+	case map[string]any:
+		for k, e := range v {
+			v[k] = jsonValue(e)
+		}
+		return v
+	case []any:
+		for i, e := range v {
+			v[i] = jsonValue(e)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// decimalNumber formats a decimal as a JSON number without losing precision.
+func decimalNumber(d duckdb.Decimal) json.Number {
+	digits := new(big.Int).Abs(d.Value).String()
+
+	scale := int(d.Scale)
+	if scale > 0 {
+		if len(digits) <= scale {
+			digits = strings.Repeat("0", scale-len(digits)+1) + digits
+		}
+		digits = digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+	}
+
+	if d.Value.Sign() < 0 {
+		digits = "-" + digits
+	}
+	return json.Number(digits)
+}
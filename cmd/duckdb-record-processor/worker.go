@@ -7,24 +7,116 @@ import (
 	"log/slog"
 )
 
-// worker returns a closure that processes a range of rows from the parquet file
-func worker(ctx context.Context, errChan chan<- error, db *sql.DB, logger *slog.Logger) func(localFilePath string, start, end int) {
-	return func(localFilePath string, start, end int) {
-		// Query and process rows from start to end
-		query := fmt.Sprintf("SELECT * FROM '%s' LIMIT %d OFFSET %d", localFilePath, end-start, start)
-		rows, err := db.QueryContext(ctx, query)
+// worker returns a closure that publishes the row groups of a partition of the
+// parquet file
+func worker(ctx context.Context, db *sql.DB, pub *publication, logger *slog.Logger) func(filePath string, part partition) error {
+	return func(filePath string, part partition) error {
+		// Query the rows of the partition by their row number in the file.
+		// The filter is pushed into the scan, so row groups outside the
+		// partition are skipped rather than read and discarded. DuckDB only
+		// returns rows in file order when asked to. The path is bound as a
+		// parameter, so it is never interpreted as SQL
+		rows, err := db.QueryContext(ctx, `
+			SELECT *
+			FROM read_parquet(?, file_row_number = true)
+			WHERE file_row_number >= ? AND file_row_number < ?
+			ORDER BY file_row_number`, filePath, part.start(), part.end())
 		if err != nil {
 			logger.ErrorContext(ctx, "failed to query rows", slog.Any("error", err))
-			errChan <- err
-			return
+			return fmt.Errorf("failed to query rows: %w", err)
 		}
 		defer rows.Close()
 
+		columns, err := rows.Columns()
+		if err != nil {
+			logger.ErrorContext(ctx, "failed to get columns", slog.Any("error", err))
+			return fmt.Errorf("failed to get columns: %w", err)
+		}
+
+		var (
+			// batch holds the records of consecutive rows starting at
+			// batchRow, and batchRows their row numbers. Batches never span
+			// row groups, matching the read batches of the parquetgo
+			// processor
+			batch     []any
+			batchRows []int64
+			batchRow  = part.start()
+
+			// rowGroup is the index of the row group being read
+			rowGroup int
+		)
+
+		// Publish the scanned rows and start the next batch after them
+		flush := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+			if err := pub.publish(ctx, batchRows, batch); err != nil {
+				return err
+			}
+			batchRow += int64(len(batch))
+			batch, batchRows = batch[:0], batchRows[:0]
+			return nil
+		}
+
 		// Process the rows
 		for rows.Next() {
-			// Process data...
+			row, err := scanRow(rows, columns)
+			if err != nil {
+				logger.ErrorContext(ctx, "failed to scan row", slog.Any("error", err))
+				return fmt.Errorf("failed to scan row: %w", err)
+			}
+
+			rowNum, _ := row["file_row_number"].(int64)
+			delete(row, "file_row_number")
+			pub.read.Add(1)
+
+			// Rows are published with their position in the file, so they
+			// must arrive in order
+			if next := batchRow + int64(len(batch)); rowNum != next {
+				logger.ErrorContext(ctx, "rows read out of order", "row", rowNum, "expected_row", next)
+				return fmt.Errorf("read row %d where row %d was expected", rowNum, next)
+			}
+
+			// Decode the record the way the dataset reads it, then transform
+			// and redact it the way it is published
+			record, err := pub.dataset.Decode(row)
+			if err != nil {
+				logger.ErrorContext(ctx, "failed to decode record", "row", rowNum, slog.Any("error", err))
+				return fmt.Errorf("failed to decode row %d: %w", rowNum, err)
+			}
+
+			if record, err = pub.prepare(ctx, rowNum, record); err != nil {
+				return err
+			}
+
+			batch = append(batch, record)
+			batchRows = append(batchRows, rowNum)
+
+			// Publish at the end of every row group and every RowsPerBatch
+			// rows within it
+			rg := part.rowGroups[rowGroup]
+			if end := rg.start + rg.rows; rowNum+1 == end || len(batch) == pub.cfg.RowsPerBatch {
+				if err := flush(); err != nil {
+					return err
+				}
+				if rowNum+1 == end {
+					rowGroup++
+				}
+			}
+		}
+
+		if err := rows.Err(); err != nil {
+			logger.ErrorContext(ctx, "failed to read rows", slog.Any("error", err))
+			return fmt.Errorf("failed to read rows: %w", err)
+		}
+
+		if next := batchRow + int64(len(batch)); next != part.end() {
+			logger.ErrorContext(ctx, "partition ended early", "row", next, "end", part.end())
+			return fmt.Errorf("partition ended early at row %d, expected %d rows", next, part.end())
 		}
 
-		logger.InfoContext(ctx, "processed rows", "start", start, "end", end)
+		logger.InfoContext(ctx, "published rows", "start", part.start(), "end", part.end(), "row_groups", len(part.rowGroups))
+		return nil
 	}
 }
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/dataset"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/publisher"
+)
+
+// checkpoint records how far publishing of a single file has progressed so a
+// later invocation can resume where the previous one stopped.
+type checkpoint struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+
+	// ETag is the ETag of the object the checkpoint was taken against. A
+	// checkpoint is only honoured if the object has not changed since.
+	ETag string `json:"etag"`
+
+	// Config identifies the dataset, selection, target and redaction policy
+	// the file is published with, see checkpointConfig. Publishing the file
+	// differently keeps a checkpoint of its own.
+	Config string `json:"config"`
+
+	// RowGroup is the index of the row group containing the next row to read.
+	RowGroup int `json:"row_group"`
+
+	// RowOffset is the number of rows of RowGroup that have been published.
+	RowOffset int64 `json:"row_offset"`
+
+	// Published is the total number of rows of the file that have been
+	// published.
+	Published int64 `json:"published"`
+
+	// Filtered is the total number of rows of the file that have been read
+	// and left out because they did not match the request filter.
+	Filtered int64 `json:"filtered,omitempty"`
+
+	// Complete is true once every row of the file has been published.
+	Complete bool `json:"complete"`
+
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// seek moves the checkpoint to a row number within the file.
+func (c *checkpoint) seek(row int64, rowGroupRows []int64) {
+	c.RowGroup, c.RowOffset = 0, row
+	for c.RowGroup < len(rowGroupRows) && c.RowOffset >= rowGroupRows[c.RowGroup] {
+		c.RowOffset -= rowGroupRows[c.RowGroup]
+		c.RowGroup++
+	}
+}
+
+// row returns the row number within the file of the next row to read.
+func (c *checkpoint) row(rowGroupRows []int64) int64 {
+	var row int64
+	for _, n := range rowGroupRows[:min(c.RowGroup, len(rowGroupRows))] {
+		row += n
+	}
+	return row + c.RowOffset
+}
+
+// checkpointStore persists checkpoints between invocations.
+type checkpointStore interface {
+	// Load returns the checkpoint for a file published with config. The
+	// boolean is false if no checkpoint has been saved.
+	Load(ctx context.Context, bucket, key, config string) (checkpoint, bool, error)
+
+	// Save persists a checkpoint, replacing any previous one for the file.
+	Save(ctx context.Context, cp checkpoint) error
+}
+
+// newCheckpointStore creates the checkpoint store selected by the
+// configuration.
+func newCheckpointStore(cfg config, s3Client checkpointObjectAPI) (checkpointStore, error) {
+	switch cfg.CheckpointStore {
+	case "":
+		return nopCheckpointStore{}, nil
+	case "s3":
+		if cfg.CheckpointBucket == "" {
+			return nil, errors.New("CHECKPOINT_BUCKET is required for the s3 checkpoint store")
+		}
+		return &s3CheckpointStore{
+			client: s3Client,
+			bucket: cfg.CheckpointBucket,
+			prefix: cfg.CheckpointPrefix,
+		}, nil
+	case "file":
+		if cfg.CheckpointDir == "" {
+			return nil, errors.New("CHECKPOINT_DIR is required for the file checkpoint store")
+		}
+		return &fileCheckpointStore{dir: cfg.CheckpointDir}, nil
+	default:
+		return nil, fmt.Errorf("unknown checkpoint store %q", cfg.CheckpointStore)
+	}
+}
+
+// maxCheckpointName is the longest name checkpointName returns. Most
+// filesystems limit a file name to 255 bytes, which leaves room for the
+// ".tmp" suffix the file store writes under first.
+const maxCheckpointName = 250
+
+// checkpointName returns a flat, filesystem and S3 safe name for the
+// checkpoint of a file published with config. Names that would be too long
+// are truncated and told apart by a hash of the bucket and key.
+func checkpointName(bucket, key, config string) string {
+	name, suffix := url.PathEscape(bucket+"/"+key), "."+config+".json"
+	if len(name)+len(suffix) <= maxCheckpointName {
+		return name + suffix
+	}
+
+	sum := sha256.Sum256([]byte(bucket + "/" + key))
+	suffix = "." + hex.EncodeToString(sum[:16]) + suffix
+	name = name[:maxCheckpointName-len(suffix)]
+	// Do not cut an escape sequence in half.
+	if i := strings.LastIndexByte(name, '%'); i >= len(name)-2 {
+		name = name[:i]
+	}
+	return name + suffix
+}
+
+// checkpointConfig returns the config of the checkpoints of files of a
+// dataset published with a selection to a destination. The rows a checkpoint
+// has counted as published depend on all of them.
+func checkpointConfig(ds dataset.Dataset, sel selection, dest publisher.Destination) string {
+	sum := sha256.Sum256([]byte(ds.Fingerprint(dest.Policy) + "\x00" + sel.id() + "\x00" + dest.Target))
+	return hex.EncodeToString(sum[:8])
+}
+
+// nopCheckpointStore is used when checkpointing is disabled. Every file starts
+// from the first row.
+type nopCheckpointStore struct{}
+
+// Load always reports that no checkpoint exists.
+func (nopCheckpointStore) Load(context.Context, string, string, string) (checkpoint, bool, error) {
+	return checkpoint{}, false, nil
+}
+
+// Save discards the checkpoint.
+func (nopCheckpointStore) Save(context.Context, checkpoint) error {
+	return nil
+}
+
+// checkpointObjectAPI is the subset of the S3 client used by the S3 checkpoint
+// store.
+type checkpointObjectAPI interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+// s3CheckpointStore stores checkpoints as JSON objects under a prefix of an S3
+// bucket.
+type s3CheckpointStore struct {
+	client checkpointObjectAPI
+	bucket string
+	prefix string
+}
+
+// Load reads the checkpoint object for a file.
+func (s *s3CheckpointStore) Load(ctx context.Context, bucket, key, config string) (checkpoint, bool, error) {
+	result, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(path.Join(s.prefix, checkpointName(bucket, key, config))),
+	})
+	if err != nil {
+		var notFound *types.NoSuchKey
+		if errors.As(err, &notFound) {
+			return checkpoint{}, false, nil
+		}
+		return checkpoint{}, false, fmt.Errorf("failed to get checkpoint object: %w", err)
+	}
+	defer result.Body.Close()
+
+	var cp checkpoint
+	if err := json.NewDecoder(result.Body).Decode(&cp); err != nil {
+		return checkpoint{}, false, fmt.Errorf("failed to decode checkpoint object: %w", err)
+	}
+
+	return cp, true, nil
+}
+
+// Save writes the checkpoint object for a file.
+func (s *s3CheckpointStore) Save(ctx context.Context, cp checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return fmt.Errorf("failed to marshal checkpoint: %w", err)
+	}
+
+	if _, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(path.Join(s.prefix, checkpointName(cp.Bucket, cp.Key, cp.Config))),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	}); err != nil {
+		return fmt.Errorf("failed to put checkpoint object: %w", err)
+	}
+
+	return nil
+}
+
+// fileCheckpointStore stores checkpoints as JSON files in a local directory.
+// It is intended for local development and tests.
+type fileCheckpointStore struct {
+	dir string
+}
+
+// Load reads the checkpoint file for a file.
+func (s *fileCheckpointStore) Load(_ context.Context, bucket, key, config string) (checkpoint, bool, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, checkpointName(bucket, key, config)))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return checkpoint{}, false, nil
+		}
+		return checkpoint{}, false, fmt.Errorf("failed to read checkpoint file: %w", err)
+	}
+
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return checkpoint{}, false, fmt.Errorf("failed to decode checkpoint file: %w", err)
+	}
+
+	return cp, true, nil
+}
+
+// Save writes the checkpoint file for a file. The file is written to a
+// temporary name first and renamed so a crash never leaves a partial
+// checkpoint behind.
+func (s *fileCheckpointStore) Save(_ context.Context, cp checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return fmt.Errorf("failed to marshal checkpoint: %w", err)
+	}
+
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create checkpoint directory: %w", err)
+	}
+
+	name := filepath.Join(s.dir, checkpointName(cp.Bucket, cp.Key, cp.Config))
+	if err := os.WriteFile(name+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write checkpoint file: %w", err)
+	}
+
+	if err := os.Rename(name+".tmp", name); err != nil {
+		return fmt.Errorf("failed to rename checkpoint file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCheckpointNameFitsLongKeys(t *testing.T) {
+	ctx := context.Background()
+	store := &fileCheckpointStore{dir: t.TempDir()}
+
+	keys := []string{
+		"records.parquet",
+		strings.Repeat("a", 1024) + "/1.parquet",
+		strings.Repeat("a", 1024) + "/2.parquet",
+		strings.Repeat("ü", 300) + ".parquet",
+	}
+
+	names := map[string]bool{}
+	for i, key := range keys {
+		name := checkpointName("bucket", key, "0123456789abcdef")
+		if len(name) > maxCheckpointName {
+			t.Fatalf("name of %.20q is %d bytes long, want at most %d", key, len(name), maxCheckpointName)
+		}
+		if names[name] {
+			t.Fatalf("name of %.20q = %s, which is taken by another key", key, name)
+		}
+		names[name] = true
+
+		if err := store.Save(ctx, checkpoint{Bucket: "bucket", Key: key, Config: "0123456789abcdef", Published: int64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, key := range keys {
+		cp, ok, err := store.Load(ctx, "bucket", key, "0123456789abcdef")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok || cp.Key != key || cp.Published != int64(i) {
+			t.Fatalf("checkpoint of %.20q = %+v, %v, want the one saved for it", key, cp, ok)
+		}
+	}
+}
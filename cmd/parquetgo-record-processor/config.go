@@ -1,15 +1,49 @@
 package main
 
+import (
+	"time"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/envelope"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/publisher"
+)
+
 // config is the configuration for the program.
 type config struct {
+	// Config is the configuration of the sink records are published to
+	publisher.Config
+
 	// Env is the environment we're executing in
 	Env string `env:"ENV"`
 
-	// QueueURL is the URL of the SQS queue to publish messages to
-	QueueURL string `env:"QUEUE_URL"`
+	// SQSBatchSize is the number of records to publish in a single message.
+	// Fewer records are packed when they would exceed the sink message size limit
+	SQSBatchSize int `env:"SQS_BATCH_SIZE" envDefault:"100"`
+
+	// DatasetTargets are the targets of datasets published somewhere other
+	// than the configured queue, topic or stream, formatted as
+	// dataset=target,dataset=target
+	DatasetTargets map[string]string `env:"DATASET_TARGETS" envKeyValSeparator:"="`
+
+	// Transforms are the transform chains applied to the records of datasets
+	// before they are published, as YAML or JSON keyed by dataset name, see
+	// package transform
+	Transforms string `env:"TRANSFORMS"`
+
+	// TransformHashKey is the HMAC key of hash transforms
+	TransformHashKey string `env:"TRANSFORM_HASH_KEY"`
 
-	// SQSBatchSize is the number of records to publish in a single SQS message
-	SQSBatchSize int `env:"SQS_BATCH_SIZE"`
+	// RedactionPolicies are the redaction policies of the destinations records
+	// are published to, as YAML or JSON keyed by policy name, see package
+	// redact. Records are published as they are to destinations without one
+	RedactionPolicies string `env:"REDACTION_POLICIES"`
+
+	// RedactionKey is the key of hash and tokenize redactions
+	RedactionKey string `env:"REDACTION_KEY"`
+
+	// MessageAttributes are the record fields published as message attributes
+	// so subscribers can filter without decoding messages, see
+	// envelope.AttributeColumns for the format
+	MessageAttributes envelope.AttributeColumns `env:"MESSAGE_ATTRIBUTES"`
 
 	// RowsPerBatch is the number of rows to read from parquet file in a single batch
 	RowsPerBatch int `env:"ROWS_PER_BATCH"`
@@ -19,4 +53,56 @@ type config struct {
 
 	// S3EndpointOverride is the endpoint to use for S3
 	S3EndpointOverride string `env:"S3_ENDPOINT_OVERRIDE"`
+
+	// S3ReadBlockSize is the minimum number of bytes fetched by a single ranged
+	// S3 read
+	S3ReadBlockSize int64 `env:"S3_READ_BLOCK_SIZE" envDefault:"1048576"`
+
+	// CheckpointStore is where publishing progress is persisted, one of "s3",
+	// "file" or empty to disable checkpointing
+	CheckpointStore string `env:"CHECKPOINT_STORE"`
+
+	// CheckpointBucket is the bucket checkpoints are written to by the s3 store
+	CheckpointBucket string `env:"CHECKPOINT_BUCKET"`
+
+	// CheckpointPrefix is the key prefix checkpoints are written under by the
+	// s3 store
+	CheckpointPrefix string `env:"CHECKPOINT_PREFIX" envDefault:"checkpoints/"`
+
+	// CheckpointDir is the directory checkpoints are written to by the file store
+	CheckpointDir string `env:"CHECKPOINT_DIR"`
+
+	// SchemaRegistryBucket is the bucket schema versions are loaded from, the
+	// versions registered in the repository are used if it is empty
+	SchemaRegistryBucket string `env:"SCHEMA_REGISTRY_BUCKET"`
+
+	// SchemaRegistryPrefix is the key prefix schema versions are loaded from
+	SchemaRegistryPrefix string `env:"SCHEMA_REGISTRY_PREFIX" envDefault:"schemas/"`
+
+	// DeadlineBuffer is how long before the invocation deadline the handler
+	// stops reading new rows and saves its checkpoint
+	DeadlineBuffer time.Duration `env:"DEADLINE_BUFFER" envDefault:"30s"`
+
+	// ContinuationMode is how remaining work is handed off when the deadline
+	// approaches, one of "lambda", "queue" or empty to only report it
+	ContinuationMode string `env:"CONTINUATION_MODE"`
+
+	// ContinuationQueueURL is the control queue continuation requests are sent
+	// to in queue mode
+	ContinuationQueueURL string `env:"CONTINUATION_QUEUE_URL"`
+
+	// MaxContinuations is the maximum number of times a single request is
+	// continued before giving up
+	MaxContinuations int `env:"MAX_CONTINUATIONS" envDefault:"100"`
+
+	// PipelineBuffer is the number of read batches buffered between the reader
+	// and the encoder, bounding how far reading runs ahead of publishing
+	PipelineBuffer int `env:"PIPELINE_BUFFER" envDefault:"2"`
+
+	// FileConcurrency is the number of files of a request published at once.
+	// Files are published one after another to FIFO queues and topics
+	FileConcurrency int `env:"FILE_CONCURRENCY" envDefault:"1"`
+
+	// FunctionName is the name of this function, invoked again in lambda mode
+	FunctionName string `env:"AWS_LAMBDA_FUNCTION_NAME"`
 }
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	lambdatypes "github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// continuer hands the remaining work of a request to a new invocation when the
+// current one runs out of time.
+type continuer interface {
+	Continue(ctx context.Context, req request) error
+}
+
+// newContinuer creates the continuer selected by the configuration.
+func newContinuer(cfg config, lambdaClient lambdaInvokeAPI, sqsClient sqsSendAPI) (continuer, error) {
+	switch cfg.ContinuationMode {
+	case "":
+		return nopContinuer{}, nil
+	case "lambda":
+		if cfg.FunctionName == "" {
+			return nil, errors.New("AWS_LAMBDA_FUNCTION_NAME is required for lambda continuation")
+		}
+		return &lambdaContinuer{client: lambdaClient, functionName: cfg.FunctionName}, nil
+	case "queue":
+		if cfg.ContinuationQueueURL == "" {
+			return nil, errors.New("CONTINUATION_QUEUE_URL is required for queue continuation")
+		}
+		return &queueContinuer{client: sqsClient, queueURL: cfg.ContinuationQueueURL}, nil
+	default:
+		return nil, fmt.Errorf("unknown continuation mode %q", cfg.ContinuationMode)
+	}
+}
+
+// nopContinuer is used when continuation is disabled. The remaining work is
+// only reported in the response.
+type nopContinuer struct{}
+
+// Continue does nothing.
+func (nopContinuer) Continue(context.Context, request) error {
+	return nil
+}
+
+// lambdaInvokeAPI is the subset of the Lambda client used to continue a
+// request.
+type lambdaInvokeAPI interface {
+	Invoke(ctx context.Context, params *lambda.InvokeInput, optFns ...func(*lambda.Options)) (*lambda.InvokeOutput, error)
+}
+
+// lambdaContinuer continues a request by asynchronously invoking the function
+// again with the continuation request as its payload.
+type lambdaContinuer struct {
+	client       lambdaInvokeAPI
+	functionName string
+}
+
+// Continue invokes the function with the continuation request.
+func (c *lambdaContinuer) Continue(ctx context.Context, req request) error {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal continuation request: %w", err)
+	}
+
+	if _, err := c.client.Invoke(ctx, &lambda.InvokeInput{
+		FunctionName:   aws.String(c.functionName),
+		InvocationType: lambdatypes.InvocationTypeEvent,
+		Payload:        payload,
+	}); err != nil {
+		return fmt.Errorf("failed to invoke function %s: %w", c.functionName, err)
+	}
+
+	return nil
+}
+
+// sqsSendAPI is the subset of the SQS client used to continue a request.
+type sqsSendAPI interface {
+	SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error)
+}
+
+// queueContinuer continues a request by sending it to a control queue. The
+// control queue is expected to be an event source of this function, see
+// withControlQueue.
+type queueContinuer struct {
+	client   sqsSendAPI
+	queueURL string
+}
+
+// Continue sends the continuation request to the control queue.
+func (c *queueContinuer) Continue(ctx context.Context, req request) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal continuation request: %w", err)
+	}
+
+	if _, err := c.client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(c.queueURL),
+		MessageBody: aws.String(string(body)),
+	}); err != nil {
+		return fmt.Errorf("failed to send continuation request to %s: %w", c.queueURL, err)
+	}
+
+	return nil
+}
+
+// withControlQueue adapts a handler so it accepts either a request or an SQS
+// event from the control queue whose message bodies are requests. The
+// responses to the requests of an event are merged into one.
+func withControlQueue(h func(context.Context, request) (response, error)) func(context.Context, json.RawMessage) (response, error) {
+	return func(ctx context.Context, payload json.RawMessage) (response, error) {
+		var event events.SQSEvent
+		if err := json.Unmarshal(payload, &event); err == nil && len(event.Records) > 0 && event.Records[0].EventSource == "aws:sqs" {
+			res := response{Complete: true}
+			for _, record := range event.Records {
+				var req request
+				if err := json.Unmarshal([]byte(record.Body), &req); err != nil {
+					return response{}, fmt.Errorf("failed to decode continuation request %s: %w", record.MessageId, err)
+				}
+
+				r, err := h(ctx, req)
+				if err != nil {
+					return response{}, err
+				}
+				res.merge(r)
+			}
+
+			return res, nil
+		}
+
+		var req request
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return response{}, fmt.Errorf("failed to decode request: %w", err)
+		}
+
+		return h(ctx, req)
+	}
+}
+
+// merge adds the outcome of another request to the response. The merged
+// response is complete only if both were.
+func (r *response) merge(o response) {
+	r.Paths = append(r.Paths, o.Paths...)
+	r.Files = append(r.Files, o.Files...)
+	r.Complete = r.Complete && o.Complete
+	r.Pending = append(r.Pending, o.Pending...)
+	r.Continued = r.Continued || o.Continued
+	r.Retried += o.Retried
+	r.Failed += o.Failed
+	r.ClaimChecked += o.ClaimChecked
+	r.SkippedRowGroups += o.SkippedRowGroups
+	r.SkippedBytes += o.SkippedBytes
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	lambdatypes "github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/s3test"
+)
+
+// TestContinuationResumesMidRowGroup stops an invocation partway through the
+// only row group of a file and checks the continuation it hands over resumes
+// the file from there, publishing every row exactly once.
+func TestContinuationResumesMidRowGroup(t *testing.T) {
+	tests := []struct {
+		name string
+		cont func() (continuer, func(t *testing.T) json.RawMessage)
+	}{
+		{
+			name: "lambda",
+			cont: func() (continuer, func(t *testing.T) json.RawMessage) {
+				client := &fakeLambda{}
+				return &lambdaContinuer{client: client, functionName: "processor"}, func(t *testing.T) json.RawMessage {
+					if len(client.inputs) != 1 {
+						t.Fatalf("invoked %d times, want once", len(client.inputs))
+					}
+					in := client.inputs[0]
+					if aws.ToString(in.FunctionName) != "processor" || in.InvocationType != lambdatypes.InvocationTypeEvent {
+						t.Fatalf("invoked %s with %s, want an event invocation of processor", aws.ToString(in.FunctionName), in.InvocationType)
+					}
+					return in.Payload
+				}
+			},
+		},
+		{
+			name: "queue",
+			cont: func() (continuer, func(t *testing.T) json.RawMessage) {
+				client := &fakeSQS{}
+				return &queueContinuer{client: client, queueURL: "control"}, func(t *testing.T) json.RawMessage {
+					if len(client.inputs) != 1 {
+						t.Fatalf("sent %d messages, want one", len(client.inputs))
+					}
+					in := client.inputs[0]
+					if aws.ToString(in.QueueUrl) != "control" {
+						t.Fatalf("sent to %s, want control", aws.ToString(in.QueueUrl))
+					}
+					return sqsEvent(t, aws.ToString(in.MessageBody))
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := s3test.NewServer(t)
+			srv.Put("bucket", "records.parquet", testParquet(t, 3_000, 3_000))
+
+			ctx := &stoppingContext{Context: context.Background()}
+			rec := &stopAfter{recorder: &recorder{}, ctx: ctx, source: "s3://bucket/records.parquet", rows: 100}
+
+			cfg := testConfig()
+			cfg.ContinuationMode = tt.name
+			cfg.MaxContinuations = 1
+
+			cont, continued := tt.cont()
+			h := withControlQueue(testHandler(t, srv, rec, nopCheckpointStore{}, cont, cfg))
+
+			payload, err := json.Marshal(request{Bucket: "bucket", Paths: []string{"records.parquet"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := h(ctx, payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Complete || !res.Continued || !slices.Equal(res.Pending, []string{"records.parquet"}) {
+				t.Fatalf("first response = %+v", res)
+			}
+
+			// The continuation resumes partway through the row group
+			payload = continued(t)
+			next := decodeContinuation(t, payload)
+			if next.Continuation != 1 || !next.Expanded || len(next.Cursors) != 1 {
+				t.Fatalf("continuation = %+v", next)
+			}
+			if cursor := next.Cursors[0]; cursor.RowGroup != 0 || cursor.RowOffset <= 0 || cursor.RowOffset >= 3_000 || cursor.Published != cursor.RowOffset {
+				t.Fatalf("cursor = %+v, want partway through row group 0", cursor)
+			}
+
+			res, err = h(context.Background(), payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !res.Complete || len(res.Files) != 1 || res.Files[0].RowsPublished != 3_000-next.Cursors[0].Published {
+				t.Fatalf("second response = %+v", res)
+			}
+
+			ids := rec.ids(t)
+			if len(ids) != 3_000 {
+				t.Fatalf("published %d records, want 3000", len(ids))
+			}
+			for i, id := range ids {
+				if want := fmt.Sprintf("id-%d", i); id != want {
+					t.Fatalf("record %d is %s, want %s", i, id, want)
+				}
+			}
+		})
+	}
+}
+
+func TestControlQueueMergesResponses(t *testing.T) {
+	srv := s3test.NewServer(t)
+	srv.Put("bucket", "first.parquet", testParquet(t, 100, 100))
+	srv.Put("bucket", "second.parquet", testParquet(t, 200, 100))
+
+	rec := &recorder{}
+	h := withControlQueue(testHandler(t, srv, rec, nopCheckpointStore{}, nopContinuer{}, testConfig()))
+
+	var event events.SQSEvent
+	for i, path := range []string{"first.parquet", "second.parquet", "missing.parquet"} {
+		body, err := json.Marshal(request{Bucket: "bucket", Paths: []string{path}, Expanded: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		event.Records = append(event.Records, events.SQSMessage{MessageId: fmt.Sprint(i), EventSource: "aws:sqs", Body: string(body)})
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := h(context.Background(), payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res.Files) != 3 || res.Files[0].RowsPublished != 100 || res.Files[1].RowsPublished != 200 || res.Files[2].Status != fileFailed {
+		t.Fatalf("files = %+v", res.Files)
+	}
+	if res.Complete || !slices.Contains(res.Paths, "first.parquet") || !slices.Contains(res.Paths, "second.parquet") {
+		t.Fatalf("response = %+v", res)
+	}
+}
+
+// sqsEvent returns the payload of a control queue event delivering body.
+func sqsEvent(t *testing.T, body string) json.RawMessage {
+	t.Helper()
+
+	payload, err := json.Marshal(events.SQSEvent{Records: []events.SQSMessage{{MessageId: "1", EventSource: "aws:sqs", Body: body}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+// decodeContinuation decodes the request in a continuation payload, either a
+// request or a control queue event.
+func decodeContinuation(t *testing.T, payload json.RawMessage) request {
+	t.Helper()
+
+	var event events.SQSEvent
+	if err := json.Unmarshal(payload, &event); err == nil && len(event.Records) == 1 {
+		payload = json.RawMessage(event.Records[0].Body)
+	}
+
+	var req request
+	if err := json.Unmarshal(payload, &req); err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+// fakeLambda records the invocations it is asked for.
+type fakeLambda struct {
+	inputs []*lambda.InvokeInput
+}
+
+func (f *fakeLambda) Invoke(_ context.Context, in *lambda.InvokeInput, _ ...func(*lambda.Options)) (*lambda.InvokeOutput, error) {
+	f.inputs = append(f.inputs, in)
+	return &lambda.InvokeOutput{StatusCode: 202}, nil
+}
+
+// fakeSQS records the messages it is sent.
+type fakeSQS struct {
+	inputs []*sqs.SendMessageInput
+}
+
+func (f *fakeSQS) SendMessage(_ context.Context, in *sqs.SendMessageInput, _ ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	f.inputs = append(f.inputs, in)
+	return &sqs.SendMessageOutput{}, nil
+}
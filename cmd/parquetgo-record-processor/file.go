@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/dataset"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/publisher"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/s3file"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/schema"
+)
+
+// The outcomes of publishing a file.
+const (
+	// filePublished files have had every row published.
+	filePublished = "published"
+
+	// fileSkipped files were published completely by an earlier invocation.
+	fileSkipped = "skipped"
+
+	// filePending files were stopped or never started because the deadline
+	// approached, and are handed to a continuation invocation.
+	filePending = "pending"
+
+	// fileFailed files could not be published. Rows published before the
+	// failure stay published.
+	fileFailed = "failed"
+)
+
+// fileReport reports how publishing a single file went.
+type fileReport struct {
+	Path string `json:"path"`
+
+	// Status is the outcome, one of "published", "skipped", "pending" or
+	// "failed".
+	Status string `json:"status"`
+
+	// RowsRead is the number of rows read by this invocation.
+	RowsRead int64 `json:"rows_read"`
+
+	// RowsPublished is the number of rows read by this invocation that
+	// matched the filter and were published.
+	RowsPublished int64 `json:"rows_published"`
+
+	// Bytes is the number of bytes of the file fetched from S3.
+	Bytes int64 `json:"bytes"`
+
+	// DurationMS is how long the file took in milliseconds.
+	DurationMS int64 `json:"duration_ms"`
+
+	// Error is why the file failed.
+	Error string `json:"error,omitempty"`
+
+	skippedRowGroups int
+	skippedBytes     int64
+}
+
+// fileJob publishes the files of a request, each with the same dataset,
+// selection and destination.
+type fileJob struct {
+	logger   *slog.Logger
+	s3Client s3file.ObjectAPI
+	store    checkpointStore
+	registry *schema.Registry
+	cfg      config
+	bucket   string
+	dataset  dataset.Dataset
+	sel      selection
+	dest     publisher.Destination
+	pool     *publishPool
+	stats    *publisher.Stats
+}
+
+// run publishes a file, resuming from cursor if it is set or from its last
+// checkpoint otherwise. When the file is stopped because the deadline is
+// approaching, it also returns the checkpoint to resume from, nil if the file
+// was never started. Everything the file opened is released before it
+// returns.
+func (j *fileJob) run(ctx context.Context, path string, cursor *checkpoint) (report fileReport, resume *checkpoint) {
+	start := time.Now()
+	report = fileReport{Path: path}
+
+	resume, err := j.publish(ctx, path, cursor, &report)
+	if err != nil {
+		report.Status = fileFailed
+		report.Error = err.Error()
+	}
+
+	report.DurationMS = time.Since(start).Milliseconds()
+	return report, resume
+}
+
+// publish publishes a file, filling in the report as it goes.
+func (j *fileJob) publish(ctx context.Context, path string, cursor *checkpoint, report *fileReport) (*checkpoint, error) {
+	logger, cfg := j.logger, j.cfg
+
+	// Stop before opening another file if there is no time left
+	if deadlineNear(ctx, cfg.DeadlineBuffer) {
+		logger.WarnContext(ctx, "Deadline approaching, stopping before file", "path", path)
+		report.Status = filePending
+		return nil, nil
+	}
+
+	// Open the S3 object for ranged reads. Only the footer is fetched
+	// up front, column chunks are fetched as the reader needs them.
+	fr, err := s3file.Open(ctx, j.s3Client, j.bucket, path, cfg.S3ReadBlockSize)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to open S3 object", "bucket", j.bucket, "path", path, "error", err)
+		return nil, fmt.Errorf("failed to open S3 object %s/%s: %w", j.bucket, path, err)
+	}
+	defer func() {
+		report.Bytes = fr.BytesRead()
+		fr.Close()
+	}()
+
+	logger.InfoContext(ctx, "Opened S3 object for ranged reads", "bucket", j.bucket, "path", path, "size", fr.Size(), "etag", fr.ETag())
+
+	// Resume from the request cursor, or from the last checkpoint if the
+	// object is unchanged and published the same way
+	var (
+		cp     checkpoint
+		found  bool
+		config = checkpointConfig(j.dataset, j.sel, j.dest)
+	)
+	if cursor != nil {
+		cp, found = *cursor, true
+	} else if cp, found, err = j.store.Load(ctx, j.bucket, path, config); err != nil {
+		logger.ErrorContext(ctx, "Failed to load checkpoint", "path", path, "error", err)
+		return nil, fmt.Errorf("failed to load checkpoint for file %s: %w", path, err)
+	}
+
+	switch {
+	case !found:
+		cp = checkpoint{Bucket: j.bucket, Key: path, ETag: fr.ETag(), Config: config}
+	case cp.ETag != fr.ETag():
+		logger.WarnContext(ctx, "Object changed since checkpoint, starting over",
+			"path", path,
+			"checkpoint_etag", cp.ETag,
+			"etag", fr.ETag())
+		cp = checkpoint{Bucket: j.bucket, Key: path, ETag: fr.ETag(), Config: config}
+	case cp.Config != config:
+		logger.WarnContext(ctx, "File published differently since checkpoint, starting over",
+			"path", path,
+			"checkpoint_config", cp.Config,
+			"config", config)
+		cp = checkpoint{Bucket: j.bucket, Key: path, ETag: fr.ETag(), Config: config}
+	case cp.Complete:
+		logger.InfoContext(ctx, "File already published, skipping", "path", path, "published", cp.Published)
+		report.Status = fileSkipped
+		return nil, nil
+	default:
+		logger.InfoContext(ctx, "Resuming file from checkpoint",
+			"path", path,
+			"row_group", cp.RowGroup,
+			"row_offset", cp.RowOffset,
+			"published", cp.Published)
+	}
+
+	pf, err := openParquetFile(ctx, fr, cfg.S3ReadBlockSize)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to open parquet file", "path", path, "error", err)
+		return nil, fmt.Errorf("failed to open parquet file %s: %w", path, err)
+	}
+
+	// Reject files whose schema is not a registered version, or a
+	// compatible evolution of one, before anything is published
+	columns := schema.Describe(pf.Schema())
+	fingerprint := schema.Fingerprint(columns)
+
+	version, exact, err := j.registry.Resolve(j.dataset.Schema, columns)
+	if err != nil {
+		logger.ErrorContext(ctx, "File schema is not compatible with a registered version", "path", path, "schema", fingerprint, "error", err)
+		return nil, fmt.Errorf("file %s has an incompatible schema: %w", path, err)
+	}
+	if !exact {
+		logger.WarnContext(ctx, "File schema is an unregistered evolution of a registered version",
+			"path", path,
+			"schema", fingerprint,
+			"schema_version", version.ID())
+	}
+
+	src, plan, err := newRowSource(pf, j.dataset, cp.RowGroup, cp.RowOffset, j.sel)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to create row source for file", "path", path, "error", err)
+		return nil, fmt.Errorf("failed to create row source for file %s: %w", path, err)
+	}
+	defer src.Close()
+
+	logger.InfoContext(
+		ctx,
+		"Created row source for file",
+		"path", path,
+		"schema", fingerprint,
+		"schema_version", version.ID(),
+		"row_groups", len(plan.rowGroups),
+		"skipped_row_groups", len(plan.skipped),
+		"skipped_bytes", plan.skippedBytes)
+
+	report.skippedRowGroups = len(plan.skipped)
+	report.skippedBytes = plan.skippedBytes
+
+	var (
+		totalRows int64
+		source    = "s3://" + j.bucket + "/" + path
+	)
+	for _, n := range plan.rowGroupRows {
+		totalRows += n
+	}
+
+	pl := &pipeline{
+		logger:    logger,
+		sender:    j.dest.Sender,
+		pool:      j.pool,
+		policy:    j.dest.Policy,
+		store:     j.store,
+		cfg:       cfg,
+		path:      path,
+		source:    source,
+		schema:    fingerprint,
+		version:   version.ID(),
+		dataset:   j.dataset,
+		sel:       j.sel,
+		src:       src,
+		plan:      plan,
+		totalRows: totalRows,
+		stats:     j.stats,
+		cp:        &cp,
+	}
+
+	// Count the rows this invocation reads from where the checkpoint was
+	published, filtered := cp.Published, cp.Filtered
+	stopped, err := pl.run(ctx)
+	report.RowsPublished = cp.Published - published
+	report.RowsRead = report.RowsPublished + cp.Filtered - filtered
+	if err != nil {
+		return nil, err
+	}
+
+	// Hand back before the invocation is killed
+	if stopped {
+		logger.WarnContext(
+			ctx,
+			"Deadline approaching, stopping at checkpoint",
+			slog.String("file", path),
+			slog.Int("row_group", cp.RowGroup),
+			slog.Int64("row_offset", cp.RowOffset),
+			slog.Int64("total_published_rows", cp.Published),
+			slog.Int64("total_rows", totalRows))
+
+		report.Status = filePending
+		return &cp, nil
+	}
+
+	cp.Complete = true
+	cp.UpdatedAt = time.Now()
+	if err := j.store.Save(ctx, cp); err != nil {
+		logger.ErrorContext(ctx, "Failed to save checkpoint", "path", path, "error", err)
+		return nil, fmt.Errorf("failed to save checkpoint for file %s: %w", path, err)
+	}
+
+	logger.InfoContext(
+		ctx,
+		"Processed file",
+		"s3_path", path,
+		"num_rows", totalRows)
+
+	report.Status = filePublished
+	return nil, nil
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/dataset"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/envelope"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/models"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/publisher"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/s3test"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/schema"
+)
+
+func TestFileJobReadsFromS3(t *testing.T) {
+	ctx := context.Background()
+
+	srv := s3test.NewServer(t)
+	srv.Put("bucket", "records.parquet", testParquet(t, 2_000, 500))
+
+	tests := []struct {
+		name    string
+		columns []string
+	}{
+		{name: "every column"},
+		{name: "projected", columns: []string{"id", "account_type"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sel, err := newSelection(tt.columns, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rec := &recorder{}
+			job := testFileJob(t, srv, rec)
+			job.sel = sel
+
+			report, resume := job.run(ctx, "records.parquet", nil)
+			if report.Status != filePublished || resume != nil {
+				t.Fatalf("report = %+v, resume = %v", report, resume)
+			}
+			if report.RowsRead != 2_000 || report.RowsPublished != 2_000 {
+				t.Fatalf("read %d and published %d rows, want 2000", report.RowsRead, report.RowsPublished)
+			}
+			if got := rec.ids(t); len(got) != 2_000 || got[0] != "id-0" {
+				t.Fatalf("published %d records starting with %v", len(got), got[:min(1, len(got))])
+			}
+
+			// Only ranges are fetched, never the whole object
+			for _, r := range srv.Requests() {
+				if r.Method == "GET" && r.Range == "" {
+					t.Fatalf("fetched the whole object: %+v", r)
+				}
+			}
+
+			size := int64(len(mustObject(t, srv, "bucket", "records.parquet")))
+			if report.Bytes <= 0 || report.Bytes > size {
+				t.Fatalf("fetched %d bytes of a %d byte object", report.Bytes, size)
+			}
+			if tt.columns != nil && report.Bytes >= size/2 {
+				t.Fatalf("fetched %d bytes of a %d byte object for two columns", report.Bytes, size)
+			}
+		})
+	}
+}
+
+func TestFileJobResumesFromCursor(t *testing.T) {
+	ctx := context.Background()
+
+	srv := s3test.NewServer(t)
+	srv.Put("bucket", "records.parquet", testParquet(t, 1_000, 250))
+
+	rec := &recorder{}
+	job := testFileJob(t, srv, rec)
+
+	cursor := &checkpoint{
+		Bucket:    "bucket",
+		Key:       "records.parquet",
+		ETag:      etagOf(t, srv, "records.parquet"),
+		Config:    checkpointConfig(job.dataset, job.sel, job.dest),
+		RowGroup:  2,
+		RowOffset: 100,
+		Published: 600,
+	}
+
+	report, _ := job.run(ctx, "records.parquet", cursor)
+	if report.Status != filePublished {
+		t.Fatalf("report = %+v", report)
+	}
+
+	ids := rec.ids(t)
+	if len(ids) != 400 || ids[0] != "id-600" {
+		t.Fatalf("published %d records starting with %v, want 400 from id-600", len(ids), ids[:min(1, len(ids))])
+	}
+}
+
+// testParquet writes n records into a parquet file with row groups of
+// rowGroupRows rows.
+func testParquet(t *testing.T, n, rowGroupRows int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := parquet.NewGenericWriter[models.Record](&buf, parquet.MaxRowsPerRowGroup(int64(rowGroupRows)))
+
+	base := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+	for i := range n {
+		r := models.Record{
+			ID:                       fmt.Sprintf("id-%d", i),
+			CreatedAt:                base.Add(time.Duration(i) * time.Minute),
+			UpdatedAt:                base,
+			FirstName:                "Ada",
+			LastName:                 "Lovelace",
+			Email:                    fmt.Sprintf("ada%d@example.com", i),
+			PhoneNumber:              "555-0100",
+			DateOfBirth:              "1990-01-01",
+			AccountType:              []string{"free", "premium"}[i%2],
+			AccountStatus:            "active",
+			LastLoginDate:            base,
+			AccountBalance:           float64(i) + 0.5,
+			Language:                 "en",
+			CommunicationPreferences: []string{"email"},
+			NewsletterSubscribed:     i%3 == 0,
+			Tags:                     []string{"x"},
+			Body:                     strings.Repeat("b", 2_000),
+		}
+		r.Address.City = "Denver"
+		r.Address.Country = "USA"
+
+		if _, err := w.Write([]models.Record{r}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// testConfig returns the configuration of tests, publishing in small batches
+// so files span many messages.
+func testConfig() config {
+	return config{
+		Config: publisher.Config{
+			PublishConcurrency: 4,
+			PublishMaxAttempts: 1,
+		},
+		SQSBatchSize:    50,
+		RowsPerBatch:    100,
+		PipelineBuffer:  2,
+		S3ReadBlockSize: 32 << 10,
+		FileConcurrency: 1,
+	}
+}
+
+// testFileJob returns a job publishing records files from srv to rec.
+func testFileJob(t *testing.T, srv *s3test.Server, rec *recorder) *fileJob {
+	t.Helper()
+
+	ds, err := dataset.Default.Lookup(dataset.Records)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := testConfig()
+	pool := newPublishPool(cfg.PublishConcurrency)
+	t.Cleanup(pool.close)
+
+	return &fileJob{
+		logger:   testLogger(),
+		s3Client: srv.Client(),
+		store:    nopCheckpointStore{},
+		registry: testRegistry(t),
+		cfg:      cfg,
+		bucket:   "bucket",
+		dataset:  ds,
+		dest:     publisher.Destination{Sender: testSender(rec, cfg)},
+		pool:     pool,
+		stats:    &publisher.Stats{},
+	}
+}
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func testRegistry(t *testing.T) *schema.Registry {
+	t.Helper()
+
+	registry, err := schema.Embedded()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return registry
+}
+
+func testSender(pub publisher.Publisher, cfg config) *publisher.Sender {
+	logger := testLogger()
+	return publisher.NewSender(logger, pub, publisher.NewLogDeadLetterSink(logger), nil, cfg.Config)
+}
+
+func mustObject(t *testing.T, srv *s3test.Server, bucket, key string) []byte {
+	t.Helper()
+
+	data, ok := srv.Object(bucket, key)
+	if !ok {
+		t.Fatalf("object %s/%s does not exist", bucket, key)
+	}
+	return data
+}
+
+// etagOf returns the ETag the server reports for an object.
+func etagOf(t *testing.T, srv *s3test.Server, key string) string {
+	t.Helper()
+
+	head, err := srv.Client().HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return aws.ToString(head.ETag)
+}
+
+// recorder is a publisher recording the messages it is sent.
+type recorder struct {
+	mu       sync.Mutex
+	messages []envelope.Message
+}
+
+// Limits returns the limits of the NDJSON sink, but allows attributes as they
+// are recorded.
+func (r *recorder) Limits() publisher.Limits {
+	limits := publisher.NewNDJSON(io.Discard).Limits()
+	limits.MaxAttributes = 10
+	return limits
+}
+
+func (r *recorder) PublishBatch(_ context.Context, messages []envelope.Message) ([]publisher.Failure, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.messages = append(r.messages, messages...)
+	return nil, nil
+}
+
+// envelopes returns the envelopes published, ordered by source and row.
+func (r *recorder) envelopes(t *testing.T) []envelope.Envelope {
+	t.Helper()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	envs := make([]envelope.Envelope, 0, len(r.messages))
+	for _, msg := range r.messages {
+		env, err := envelope.Decode(msg.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		envs = append(envs, env)
+	}
+
+	slices.SortFunc(envs, func(a, b envelope.Envelope) int {
+		if c := strings.Compare(a.Source, b.Source); c != 0 {
+			return c
+		}
+		return int(a.RowStart - b.RowStart)
+	})
+	return envs
+}
+
+// ids returns the ids of the records published, in row order.
+func (r *recorder) ids(t *testing.T) []string {
+	t.Helper()
+
+	var ids []string
+	for _, env := range r.envelopes(t) {
+		for _, raw := range env.Records {
+			var rec struct {
+				ID string `json:"id"`
+			}
+			if err := json.Unmarshal(raw, &rec); err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, rec.ID)
+		}
+	}
+	return ids
+}
@@ -2,251 +2,277 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"io"
 	"log/slog"
-	"os"
-	"path/filepath"
-	"strconv"
+	"time"
 
-	"golang.org/x/sync/errgroup"
-
-	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/aws-sdk-go-v2/service/sqs"
-	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
-	"github.com/xitongsys/parquet-go-source/local"
-	"github.com/xitongsys/parquet-go/reader"
-)
+	"golang.org/x/sync/errgroup"
 
-const (
-	// Maximum number of SQS messages to send in a batch (hard limit from AWS)
-	sqsBatchSize = 10
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/dataset"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/publisher"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/s3paths"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/schema"
 )
 
 // request is the request for the handler function.
 type request struct {
-	Bucket string   `json:"bucket"`
-	Paths  []string `json:"paths"`
+	Bucket string `json:"bucket"`
+
+	// Paths are the keys of the files to publish. Glob patterns such as
+	// exports/2024-10-*/part-*.parquet and prefixes ending in a slash are
+	// expanded to the keys of the objects they match, see package s3paths.
+	Paths []string `json:"paths"`
+
+	// Objects optionally limits the objects patterns and prefixes in Paths
+	// expand to by last modified time and size.
+	Objects *s3paths.Filter `json:"objects,omitempty"`
+
+	// Expanded is set on continuation requests, whose paths are keys already
+	// expanded from the original request.
+	Expanded bool `json:"expanded,omitempty"`
+
+	// Dataset is the name of the registered dataset the files belong to,
+	// which decides how their rows are read, checked, keyed, transformed and
+	// where they are published. Defaults to records.
+	Dataset string `json:"dataset,omitempty"`
+
+	// Cursors are where paths stopped by an earlier invocation resume from.
+	// They are set on continuation requests and take precedence over the
+	// checkpoint store.
+	Cursors []checkpoint `json:"cursors,omitempty"`
+
+	// Columns optionally limits the published fields to these paths, such as
+	// address.city. Every field is published if it is empty.
+	Columns []string `json:"columns,omitempty"`
+
+	// Filter optionally limits the published rows to those matching an
+	// expression such as account_status = 'active' AND account_balance > 100.
+	// Row groups whose statistics rule out a match are never read.
+	Filter string `json:"filter,omitempty"`
+
+	// Continuation is the number of invocations that came before this one
+	// for the same original request.
+	Continuation int `json:"continuation,omitempty"`
 }
 
 // response is the response for the handler function.
 type response struct {
+	// Paths are the keys the requested paths expanded to, whether they were
+	// published or not.
 	Paths []string `json:"paths"`
-}
-
-// publishRequest is the request for the publishBatch function.
-type publishRequest struct {
-	batchIndex int
-	records    []interface{}
-	queueURL   string
-	localPath  string
-}
 
-// publishBatch sends a batch of records to SQS
-func publishBatch(ctx context.Context, sqsClient *sqs.Client, logger *slog.Logger, req publishRequest) func() error {
-	return func() error {
-		// Prepare batch entries
-		var entries []types.SendMessageBatchRequestEntry
-		for j, record := range req.records {
-			// Convert individual record to JSON
-			jsonData, err := json.Marshal(record)
-			if err != nil {
-				logger.ErrorContext(ctx, "Failed to marshal record to JSON",
-					"file", req.localPath,
-					"batch_index", req.batchIndex,
-					"record_index", j,
-					"error", err,
-				)
-				return fmt.Errorf("failed to marshal record to JSON: %w", err)
-			}
+	// Files reports how publishing each of the keys went, in the order of
+	// Paths.
+	Files []fileReport `json:"files"`
 
-			// Create batch entry
-			entries = append(entries, types.SendMessageBatchRequestEntry{
-				Id:          aws.String(strconv.Itoa(req.batchIndex*sqsBatchSize + j)), // Unique ID within the batch
-				MessageBody: aws.String(string(jsonData)),
-			})
-		}
+	// Complete is true when every requested path has been published. When it
+	// is false a file failed, or the invocation stopped early and Pending must
+	// be processed by a continuation invocation.
+	Complete bool `json:"complete"`
 
-		// Send batch to SQS
-		result, err := sqsClient.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{
-			QueueUrl: aws.String(req.queueURL),
-			Entries:  entries,
-		})
-		if err != nil {
-			logger.ErrorContext(ctx, "Failed to send message batch to SQS",
-				"file", req.localPath,
-				"batch_index", req.batchIndex,
-				"batch_size", len(req.records),
-				"error", err,
-				"queue_url", req.queueURL,
-			)
-			return fmt.Errorf("failed to send message batch to SQS: %w", err)
-		}
+	// Pending are the paths that still have rows to publish when the
+	// invocation stopped.
+	Pending []string `json:"pending,omitempty"`
 
-		// Log any failed messages
-		if len(result.Failed) > 0 {
-			logger.ErrorContext(ctx, "Some messages failed to send",
-				"file", req.localPath,
-				"batch_index", req.batchIndex,
-				"failed_count", len(result.Failed),
-				"failed_messages", result.Failed,
-			)
-			return fmt.Errorf("failed to send %d messages in batch", len(result.Failed))
-		}
+	// Continued is true when the pending paths were handed to a continuation
+	// invocation.
+	Continued bool `json:"continued"`
 
-		return nil
-	}
-}
+	// Retried is the number of messages that were sent again after a
+	// transient failure.
+	Retried int64 `json:"retried"`
 
-// handler processes records from a set of parquet files from S3
-func handler(logger *slog.Logger, s3Client *s3.Client, sqsClient *sqs.Client, cfg config) func(context.Context, request) (response, error) {
-	return func(ctx context.Context, req request) (response, error) {
-		logger.InfoContext(ctx, "Received request", "bucket", req.Bucket, "paths", req.Paths)
+	// Failed is the number of messages that could not be published and were
+	// routed to the dead letter sink.
+	Failed int64 `json:"failed"`
 
-		// Create temporary directory for all files
-		tempDir, err := os.MkdirTemp("", "*")
-		if err != nil {
-			return response{}, fmt.Errorf("failed to create temp directory: %w", err)
-		}
+	// ClaimChecked is the number of messages whose body was too large to
+	// publish and was stored in S3 behind a pointer envelope.
+	ClaimChecked int64 `json:"claim_checked"`
 
-		defer os.RemoveAll(tempDir)
+	// SkippedRowGroups is the number of row groups that were never read
+	// because their statistics or bloom filters ruled out the filter.
+	SkippedRowGroups int `json:"skipped_row_groups"`
 
-		logger.InfoContext(ctx, "Created temporary directory", "path", tempDir)
+	// SkippedBytes is the compressed size of the skipped row groups.
+	SkippedBytes int64 `json:"skipped_bytes"`
+}
 
-		for _, path := range req.Paths {
-			// Create local file path
-			localPath := filepath.Join(tempDir, filepath.Base(path))
+// deadlineNear reports whether the invocation deadline is closer than buffer.
+// Contexts without a deadline are never near.
+func deadlineNear(ctx context.Context, buffer time.Duration) bool {
+	deadline, ok := ctx.Deadline()
+	return ok && time.Until(deadline) < buffer
+}
 
-			// Create local file
-			file, err := os.Create(localPath)
-			if err != nil {
-				logger.ErrorContext(ctx, "Failed to create local file", "path", localPath, "error", err)
-				return response{}, fmt.Errorf("failed to create local file %s: %w", localPath, err)
+// handler processes records from a set of parquet files from S3
+func handler(logger *slog.Logger, s3Client *s3.Client, dests publisher.Destinations, store checkpointStore, cont continuer, datasets *dataset.Registry, registry *schema.Registry, cfg config) func(context.Context, request) (res response, err error) {
+	return func(ctx context.Context, req request) (res response, err error) {
+		logger.InfoContext(ctx, "Received request", "bucket", req.Bucket, "paths", req.Paths, "dataset", req.Dataset, "continuation", req.Continuation)
+
+		// Expand patterns and prefixes into the keys of the files they match,
+		// which are reported in the response in order
+		if !req.Expanded {
+			var filter s3paths.Filter
+			if req.Objects != nil {
+				filter = *req.Objects
 			}
-			defer file.Close()
 
-			// Download file from S3
-			result, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
-				Bucket: aws.String(req.Bucket),
-				Key:    aws.String(path),
-			})
+			paths, err := s3paths.Expand(ctx, s3Client, req.Bucket, req.Paths, filter)
 			if err != nil {
-				logger.ErrorContext(ctx, "Failed to get object from S3", "bucket", req.Bucket, "path", path, "error", err)
-				return response{}, fmt.Errorf("failed to get object from S3 %s/%s: %w", req.Bucket, path, err)
+				logger.ErrorContext(ctx, "Failed to expand paths", "paths", req.Paths, "error", err)
+				return response{}, fmt.Errorf("failed to expand paths: %w", err)
 			}
-			defer result.Body.Close()
 
-			// Copy S3 object to local file
-			_, err = io.Copy(file, result.Body)
-			if err != nil {
-				logger.ErrorContext(ctx, "Failed to copy S3 object to local file", "local_path", localPath, "error", err)
-				return response{}, fmt.Errorf("failed to copy S3 object to local file %s: %w", localPath, err)
-			}
+			logger.InfoContext(ctx, "Expanded paths", "paths", req.Paths, "keys", paths)
+			req.Paths = paths
+		}
 
-			logger.InfoContext(ctx, "Copied S3 object to local file", "s3_path", path, "local_path", localPath)
+		res = response{Paths: req.Paths}
 
-			fr, err := local.NewLocalFileReader(localPath)
-			if err != nil {
-				logger.ErrorContext(ctx, "Failed to create local file reader for file", "local_path", localPath, "error", err)
-				return response{}, fmt.Errorf("failed to create local file reader for file %s: %w", localPath, err)
-			}
+		if req.Dataset == "" {
+			req.Dataset = dataset.Records
+		}
 
-			logger.InfoContext(ctx, "Created local file reader for file", "local_path", localPath)
+		ds, err := datasets.Lookup(req.Dataset)
+		if err != nil {
+			logger.ErrorContext(ctx, "Invalid dataset", "dataset", req.Dataset, "error", err)
+			return response{}, fmt.Errorf("invalid dataset: %w", err)
+		}
 
-			// TODO: move into a cleanup closure
-			defer fr.Close()
+		sel, err := newSelection(req.Columns, req.Filter)
+		if err != nil {
+			logger.ErrorContext(ctx, "Invalid selection", "columns", req.Columns, "filter", req.Filter, "error", err)
+			return response{}, fmt.Errorf("invalid selection: %w", err)
+		}
 
-			pr, err := reader.NewParquetReader(fr, nil, 4)
-			if err != nil {
-				logger.ErrorContext(ctx, "Failed to create parquet reader for file", "local_path", localPath, "error", err)
-				return response{}, fmt.Errorf("failed to create parquet reader for file %s: %w", localPath, err)
-			}
+		// Report publishing outcomes however the invocation ends
+		var stats publisher.Stats
+		defer func() {
+			res.Retried = stats.Retried.Load()
+			res.Failed = stats.Failed.Load()
+			res.ClaimChecked = stats.ClaimChecked.Load()
+
+			logger.InfoContext(ctx, "Publish summary",
+				"retried", res.Retried,
+				"failed", res.Failed,
+				"claim_checked", res.ClaimChecked)
+		}()
+
+		// Publish the files, up to FileConcurrency at once. FIFO queues only
+		// preserve order within a message group if files are published one
+		// after another, by a single publisher. A file that fails is reported
+		// without stopping the others
+		files, publishers := max(cfg.FileConcurrency, 1), max(cfg.PublishConcurrency, 1)
+		if cfg.FIFOQueue {
+			files, publishers = 1, 1
+		}
 
-			logger.InfoContext(
-				ctx,
-				"Created parquet reader for file",
-				"local_path", localPath)
-
-			var (
-				totalRows     = pr.GetNumRows()
-				publishedRows = 0
-			)
-
-			for {
-				rows, err := pr.ReadByNumber(cfg.RowsPerBatch)
-				if err != nil {
-					logger.ErrorContext(
-						ctx,
-						"Failed to read batch from parquet file",
-						slog.String("file", localPath),
-						slog.Any("error", err))
-
-					return response{}, fmt.Errorf("failed to read batch from parquet file %s: %w", localPath, err)
-				}
+		pool := newPublishPool(publishers)
+		defer pool.close()
+
+		job := &fileJob{
+			logger:   logger,
+			s3Client: s3Client,
+			store:    store,
+			registry: registry,
+			cfg:      cfg,
+			bucket:   req.Bucket,
+			dataset:  ds,
+			sel:      sel,
+			dest:     dests.Destination(ds.Name),
+			pool:     pool,
+			stats:    &stats,
+		}
 
-				// If no more rows, we're done
-				if len(rows) == 0 {
-					break
-				}
+		resumeFrom := make(map[string]*checkpoint, len(req.Cursors))
+		for i := range req.Cursors {
+			resumeFrom[req.Cursors[i].Key] = &req.Cursors[i]
+		}
 
-				// Create error group for concurrent processing
-				g, gctx := errgroup.WithContext(ctx)
-
-				// Prepare all batches first
-				var batches [][]interface{}
-				for i := 0; i < len(rows); i += sqsBatchSize {
-					end := i + sqsBatchSize
-					if end > len(rows) {
-						end = len(rows)
-					}
-					batches = append(batches, rows[i:end])
+		var (
+			g       errgroup.Group
+			resumes = make([]*checkpoint, len(req.Paths))
+		)
+		res.Files = make([]fileReport, len(req.Paths))
+		g.SetLimit(files)
+
+		for i, path := range req.Paths {
+			g.Go(func() error {
+				res.Files[i], resumes[i] = job.run(ctx, path, resumeFrom[path])
+				return nil
+			})
+		}
+		g.Wait()
+
+		var (
+			cursors   []checkpoint
+			published int
+		)
+		for i, report := range res.Files {
+			switch report.Status {
+			case filePublished, fileSkipped:
+				published++
+			case filePending:
+				res.Pending = append(res.Pending, report.Path)
+
+				// A file stopped before it was opened resumes from the
+				// cursor it was given, if any
+				resume := resumes[i]
+				if resume == nil {
+					resume = resumeFrom[report.Path]
 				}
-
-				// Process batches concurrently
-				for i, batch := range batches {
-					req := publishRequest{
-						batchIndex: i,
-						records:    batch,
-						queueURL:   cfg.QueueURL,
-						localPath:  localPath,
-					}
-					g.Go(publishBatch(gctx, sqsClient, logger, req))
+				if resume != nil {
+					cursors = append(cursors, *resume)
 				}
+			case fileFailed:
+				logger.ErrorContext(ctx, "Failed to publish file", "path", report.Path, "error", report.Error)
+			}
 
-				// Wait for all goroutines to complete
-				if err := g.Wait(); err != nil {
-					logger.ErrorContext(
-						ctx,
-						"Error processing batch",
-						slog.Any("error", err),
-						slog.Int("rows_in_batch", len(rows)),
-						slog.Int("total_published_rows", publishedRows),
-						slog.Int64("total_rows", totalRows))
-
-					return response{}, err
-				}
+			res.SkippedRowGroups += report.skippedRowGroups
+			res.SkippedBytes += report.skippedBytes
+		}
 
-				publishedRows += len(rows)
+		res.Complete = published == len(req.Paths)
+		if len(res.Pending) == 0 {
+			return res, nil
+		}
 
-				logger.InfoContext(
-					ctx,
-					"Published batch from parquet file",
-					slog.Int("rows_in_batch", len(rows)),
-					slog.Int("total_published_rows", publishedRows),
-					slog.Int64("total_rows", totalRows),
-				)
-			}
+		// Hand the pending files to a continuation invocation that resumes
+		// them from their checkpoints
+		if req.Continuation >= cfg.MaxContinuations {
+			logger.ErrorContext(ctx, "Maximum continuations reached, not continuing",
+				"continuation", req.Continuation,
+				"pending", res.Pending)
+			return res, nil
+		}
 
-			logger.InfoContext(
-				ctx,
-				"Processed file",
-				"s3_path", path,
-				"local_path", localPath,
-				"num_rows", totalRows)
+		next := request{
+			Bucket:       req.Bucket,
+			Paths:        res.Pending,
+			Dataset:      req.Dataset,
+			Cursors:      cursors,
+			Columns:      req.Columns,
+			Filter:       req.Filter,
+			Expanded:     true,
+			Continuation: req.Continuation + 1,
 		}
 
-		return response{}, nil
+		// The continuation must be sent even though our own deadline is
+		// close, so it is not bound to the invocation context.
+		if err := cont.Continue(context.WithoutCancel(ctx), next); err != nil {
+			logger.ErrorContext(ctx, "Failed to continue request", "pending", res.Pending, "error", err)
+			return response{}, fmt.Errorf("failed to continue request: %w", err)
+		}
+
+		res.Continued = cfg.ContinuationMode != ""
+
+		logger.InfoContext(ctx, "Continued request",
+			"pending", res.Pending,
+			"continuation", next.Continuation,
+			"continued", res.Continued)
+
+		return res, nil
 	}
 }
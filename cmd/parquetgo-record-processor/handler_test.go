@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/dataset"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/envelope"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/models"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/publisher"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/s3test"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/schema"
+)
+
+func TestHandlerReportsFilesPastAFailure(t *testing.T) {
+	srv := s3test.NewServer(t)
+	srv.Put("bucket", "first.parquet", testParquet(t, 100, 100))
+	srv.Put("bucket", "last.parquet", testParquet(t, 3_000, 500))
+
+	// The deadline becomes near once the first file has been published, which
+	// stops the last file while the missing one has already failed
+	ctx := &stoppingContext{Context: context.Background()}
+	rec := &stopAfter{recorder: &recorder{}, ctx: ctx, source: "s3://bucket/first.parquet", rows: 100}
+
+	cfg := testConfig()
+	cfg.FileConcurrency = 3
+	cfg.MaxContinuations = 1
+
+	cont := &recordingContinuer{}
+	h := testHandler(t, srv, rec, nopCheckpointStore{}, cont, cfg)
+
+	ds, err := dataset.Default.Lookup(dataset.Records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cursor := checkpoint{
+		Bucket:    "bucket",
+		Key:       "last.parquet",
+		ETag:      etagOf(t, srv, "last.parquet"),
+		Config:    checkpointConfig(ds, selection{}, publisher.Destination{}),
+		RowGroup:  1,
+		Published: 500,
+	}
+	res, err := h(ctx, request{
+		Bucket:   "bucket",
+		Paths:    []string{"first.parquet", "missing.parquet", "last.parquet"},
+		Expanded: true,
+		Cursors:  []checkpoint{cursor},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statuses := make([]string, len(res.Files))
+	for i, report := range res.Files {
+		statuses[i] = report.Status
+	}
+	if want := []string{filePublished, fileFailed, filePending}; !slices.Equal(statuses, want) {
+		t.Fatalf("files = %+v, want statuses %v", res.Files, want)
+	}
+	if res.Files[0].RowsPublished != 100 || res.Files[1].Error == "" {
+		t.Fatalf("files = %+v", res.Files)
+	}
+	if res.Complete || !slices.Equal(res.Paths, []string{"first.parquet", "missing.parquet", "last.parquet"}) || !slices.Equal(res.Pending, []string{"last.parquet"}) {
+		t.Fatalf("response = %+v", res)
+	}
+
+	// The pending file is continued from where it stopped
+	if len(cont.next) != 1 {
+		t.Fatalf("continued %d times, want once", len(cont.next))
+	}
+	next := cont.next[0]
+	if !slices.Equal(next.Paths, []string{"last.parquet"}) || len(next.Cursors) != 1 {
+		t.Fatalf("continuation = %+v", next)
+	}
+	if got := next.Cursors[0]; got.Key != "last.parquet" || got.Published < cursor.Published || got.Complete {
+		t.Fatalf("continuation cursor = %+v, want last.parquet resumed from at least %d rows", got, cursor.Published)
+	}
+}
+
+func TestHandlerRepublishesFilesWithADifferentFilter(t *testing.T) {
+	ctx := context.Background()
+
+	srv := s3test.NewServer(t)
+	srv.Put("bucket", "records.parquet", testParquet(t, 100, 25))
+
+	rec := &recorder{}
+	store := &fileCheckpointStore{dir: t.TempDir()}
+	h := testHandler(t, srv, rec, store, &recordingContinuer{}, testConfig())
+
+	tests := []struct {
+		filter string
+		status string
+		rows   int64
+	}{
+		{filter: "account_type = 'free'", status: filePublished, rows: 50},
+		{filter: "account_balance < 10", status: filePublished, rows: 10},
+		{filter: "account_type = 'free'", status: fileSkipped, rows: 0},
+	}
+
+	for _, tt := range tests {
+		res, err := h(ctx, request{Bucket: "bucket", Paths: []string{"records.parquet"}, Filter: tt.filter, Expanded: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Complete || res.Files[0].Status != tt.status || res.Files[0].RowsPublished != tt.rows {
+			t.Fatalf("filter %s: files = %+v, want %s with %d rows", tt.filter, res.Files, tt.status, tt.rows)
+		}
+	}
+
+	if ids := rec.ids(t); len(ids) != 60 {
+		t.Fatalf("published %d records, want 60", len(ids))
+	}
+}
+
+// event is a row of the dynamic events dataset.
+type event struct {
+	ID    string `parquet:"id"`
+	Kind  string `parquet:"kind"`
+	Value int64  `parquet:"value"`
+}
+
+func TestHandlerRoutesDatasets(t *testing.T) {
+	ctx := context.Background()
+
+	srv := s3test.NewServer(t)
+	srv.Put("bucket", "records.parquet", testParquet(t, 10, 10))
+
+	var buf bytes.Buffer
+	w := parquet.NewGenericWriter[event](&buf)
+	for i := range 6 {
+		if _, err := w.Write([]event{{ID: fmt.Sprintf("event-%d", i), Kind: []string{"click", "view", "buy"}[i%3], Value: int64(i)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	srv.Put("bucket", "events.parquet", buf.Bytes())
+
+	// describe returns the columns of a stored file
+	describe := func(key string) []schema.Column {
+		data, _ := srv.Object("bucket", key)
+		pf, err := parquet.OpenFile(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return schema.Describe(pf.Schema())
+	}
+	registry, err := schema.NewRegistry(
+		schema.Version{Name: "record", Version: 1, Columns: describe("records.parquet")},
+		schema.Version{Name: "events", Version: 1, Columns: describe("events.parquet")},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A typed and a dynamic dataset, each with a key field and transforms of
+	// its own
+	datasets := dataset.NewRegistry()
+	dataset.Register[models.Record](datasets, "records", dataset.Options{
+		Schema:   "record",
+		KeyField: "account_type",
+		Transforms: []dataset.Transform{func(record any) (any, error) {
+			r := record.(models.Record)
+			r.Language = "transformed"
+			return r, nil
+		}},
+	})
+	dataset.RegisterDynamic(datasets, "events", dataset.Options{
+		KeyField: "kind",
+		Transforms: []dataset.Transform{func(record any) (any, error) {
+			row := record.(map[string]any)
+			row["transformed"] = true
+			return row, nil
+		}},
+	})
+	for name, fingerprint := range map[string]string{"records": "records-chain", "events": "events-chain"} {
+		if err := datasets.AddTransforms(name, fingerprint, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Every queue is recorded separately
+	cfg := testConfig()
+	cfg.QueueURL = "default-queue"
+	cfg.FIFOQueue = true
+	queues := map[string]*recorder{"default-queue": {}, "records-queue": {}, "events-queue": {}}
+
+	dests, err := publisher.NewDestinations(cfg.Config, map[string]string{"records": "records-queue", "events": "events-queue"}, datasets, nil, func(c publisher.Config) (*publisher.Sender, error) {
+		rec, ok := queues[c.QueueURL]
+		if !ok {
+			t.Fatalf("sender created for unknown queue %s", c.QueueURL)
+		}
+		return testSender(rec, config{Config: c}), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := handler(testLogger(), srv.Client(), dests, nopCheckpointStore{}, &recordingContinuer{}, datasets, registry, cfg)
+	for name, key := range map[string]string{"records": "records.parquet", "events": "events.parquet"} {
+		res, err := h(ctx, request{Bucket: "bucket", Paths: []string{key}, Dataset: name, Expanded: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Complete || len(res.Files) != 1 || res.Files[0].Status != filePublished {
+			t.Fatalf("%s response = %+v", name, res)
+		}
+	}
+
+	if n := len(queues["default-queue"].messages); n != 0 {
+		t.Fatalf("published %d messages to the default queue, want every dataset on its own", n)
+	}
+
+	fingerprints := make(map[string]string)
+	tests := []struct {
+		dataset string
+		queue   string
+		source  string
+		rows    int
+		keys    []string
+
+		// transformed reports whether a record was transformed by its
+		// dataset
+		transformed func(record map[string]any) bool
+	}{
+		{
+			dataset:     "records",
+			queue:       "records-queue",
+			source:      "s3://bucket/records.parquet",
+			rows:        10,
+			keys:        []string{"free", "premium"},
+			transformed: func(record map[string]any) bool { return record["language"] == "transformed" },
+		},
+		{
+			dataset:     "events",
+			queue:       "events-queue",
+			source:      "s3://bucket/events.parquet",
+			rows:        6,
+			keys:        []string{"buy", "click", "view"},
+			transformed: func(record map[string]any) bool { return record["transformed"] == true },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dataset, func(t *testing.T) {
+			ds, err := datasets.Lookup(tt.dataset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fingerprints[tt.dataset] = ds.Fingerprint(nil)
+
+			rec := queues[tt.queue]
+
+			// Messages are grouped by the key field of the dataset
+			var keys []string
+			for _, msg := range rec.messages {
+				if !slices.Contains(keys, msg.Key) {
+					keys = append(keys, msg.Key)
+				}
+			}
+			slices.Sort(keys)
+			if !slices.Equal(keys, tt.keys) {
+				t.Fatalf("message groups = %v, want %v", keys, tt.keys)
+			}
+
+			rows := 0
+			for _, env := range rec.envelopes(t) {
+				if env.Source != tt.source {
+					t.Fatalf("published %s to the %s queue", env.Source, tt.queue)
+				}
+
+				// Envelopes are identified by the fingerprint of the dataset
+				header := envelope.Header{Source: env.Source, ETag: env.ETag, Config: ds.Fingerprint(nil)}
+				if env.ID != envelope.ID(header, env.RowStart, env.RowEnd) {
+					t.Fatalf("envelope %s not identified by the fingerprint of %s", env.ID, tt.dataset)
+				}
+
+				for _, raw := range env.Records {
+					var record map[string]any
+					if err := json.Unmarshal(raw, &record); err != nil {
+						t.Fatal(err)
+					}
+					if !tt.transformed(record) {
+						t.Fatalf("record %s not transformed by %s", raw, tt.dataset)
+					}
+					rows++
+				}
+			}
+			if rows != tt.rows {
+				t.Fatalf("published %d records, want %d", rows, tt.rows)
+			}
+		})
+	}
+
+	if fingerprints["records"] == fingerprints["events"] {
+		t.Fatal("datasets share a fingerprint")
+	}
+}
+
+// testHandler returns the handler of the records dataset publishing files
+// from srv to pub.
+func testHandler(t *testing.T, srv *s3test.Server, pub publisher.Publisher, store checkpointStore, cont continuer, cfg config) func(context.Context, request) (response, error) {
+	t.Helper()
+
+	dests, err := publisher.NewDestinations(cfg.Config, nil, dataset.Default, nil, func(c publisher.Config) (*publisher.Sender, error) {
+		logger := testLogger()
+		return publisher.NewSender(logger, pub, publisher.NewLogDeadLetterSink(logger), nil, c), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return handler(testLogger(), srv.Client(), dests, store, cont, dataset.Default, testRegistry(t), cfg)
+}
+
+// stoppingContext is a context whose deadline is an hour away until stop is
+// called, and has passed afterwards.
+type stoppingContext struct {
+	context.Context
+	stopped atomic.Bool
+}
+
+func (c *stoppingContext) Deadline() (time.Time, bool) {
+	if c.stopped.Load() {
+		return time.Now(), true
+	}
+	return time.Now().Add(time.Hour), true
+}
+
+func (c *stoppingContext) stop() {
+	c.stopped.Store(true)
+}
+
+// stopAfter is a recorder that stops its context once rows records of source
+// have been published.
+type stopAfter struct {
+	*recorder
+	ctx    *stoppingContext
+	source string
+	rows   int
+
+	mu        sync.Mutex
+	published int
+}
+
+func (s *stopAfter) PublishBatch(ctx context.Context, messages []envelope.Message) ([]publisher.Failure, error) {
+	failures, err := s.recorder.PublishBatch(ctx, messages)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, msg := range messages {
+		if env, err := envelope.Decode(msg.Body); err == nil && env.Source == s.source {
+			s.published += len(env.Records)
+		}
+	}
+	if s.published >= s.rows {
+		s.ctx.stop()
+	}
+
+	return failures, err
+}
+
+// recordingContinuer records the continuation requests it is handed.
+type recordingContinuer struct {
+	next []request
+}
+
+func (c *recordingContinuer) Continue(_ context.Context, req request) error {
+	c.next = append(c.next, req)
+	return nil
+}
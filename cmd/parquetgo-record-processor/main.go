@@ -7,31 +7,45 @@ import (
 	"log/slog"
 	"os"
 
-	"github.com/aws/aws-lambda-go/lambda"
+	lambdaruntime "github.com/aws/aws-lambda-go/lambda"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/caarlos0/env/v11"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/dataset"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/envelope"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/publisher"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/redact"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/schema"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/transform"
 )
 
 func main() {
-	if err := run(context.Background(), os.Stdout); err != nil {
+	if err := run(context.Background(), os.Stdout, os.Stderr); err != nil {
 		fmt.Fprintf(os.Stderr, "run failed: %v", err)
 		os.Exit(1)
 	}
 }
 
 // run executes the main logic of the program.
-func run(ctx context.Context, stdout io.Writer) error {
-	// Create structured logger using JSON format
-	logger := slog.New(slog.NewJSONHandler(stdout, nil))
-
+func run(ctx context.Context, stdout, stderr io.Writer) error {
 	// Load env config
 	var cfg config
 	if err := env.Parse(&cfg); err != nil {
 		return fmt.Errorf("failed to parse config: %w", err)
 	}
 
+	// Create structured logger using JSON format. The stdout sink publishes
+	// records to stdout, so logs are written to stderr instead of being
+	// interleaved with them
+	logs := stdout
+	if cfg.Sink == "stdout" {
+		logs = stderr
+	}
+	logger := slog.New(slog.NewJSONHandler(logs, nil))
+
 	// Load aws config
 	awscfg, err := awsconfig.LoadDefaultConfig(ctx)
 	if err != nil {
@@ -44,8 +58,77 @@ func run(ctx context.Context, stdout io.Writer) error {
 	// Create a new SQS client using default config
 	sqsClient := sqs.NewFromConfig(awscfg)
 
+	// Create the store used to checkpoint publishing progress
+	store, err := newCheckpointStore(cfg, s3Client)
+	if err != nil {
+		return fmt.Errorf("failed to create checkpoint store: %w", err)
+	}
+
+	// Add the configured transform chains to their datasets
+	chains, err := transform.Parse([]byte(cfg.Transforms), transform.Options{HashKey: []byte(cfg.TransformHashKey)})
+	if err != nil {
+		return fmt.Errorf("failed to parse transforms: %w", err)
+	}
+	for name, chain := range chains {
+		if err := dataset.Default.AddTransforms(name, chain.Fingerprint(), chain.Classify, chain.Apply); err != nil {
+			return fmt.Errorf("failed to add transforms: %w", err)
+		}
+	}
+
+	// Load the schema versions files are checked against
+	registry, err := schema.FromConfig(ctx, s3Client, cfg.SchemaRegistryBucket, cfg.SchemaRegistryPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to load schema registry: %w", err)
+	}
+
+	// Create the continuer used to hand remaining work to a new invocation
+	cont, err := newContinuer(cfg, lambda.NewFromConfig(awscfg), sqsClient)
+	if err != nil {
+		return fmt.Errorf("failed to create continuer: %w", err)
+	}
+
+	// Create the sink for messages that cannot be published
+	deadLetters, err := publisher.NewDeadLetterSink(cfg.Config, logger, s3Client)
+	if err != nil {
+		return fmt.Errorf("failed to create dead letter sink: %w", err)
+	}
+
+	// newSender creates a sender publishing to the target of a sink
+	// configuration, shared by every file of its datasets published by an
+	// invocation
+	newSender := func(pcfg publisher.Config) (*publisher.Sender, error) {
+		pub, err := publisher.New(pcfg, awscfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create publisher: %w", err)
+		}
+
+		// Every message carries the envelope header attributes as well, make
+		// sure the configured ones fit alongside them
+		if limit := pub.Limits().MaxAttributes; limit > 0 && len(cfg.MessageAttributes)+envelope.HeaderAttributes > limit {
+			return nil, fmt.Errorf("too many message attributes for the %s sink: %d configured, at most %d allowed", pcfg.Sink, len(cfg.MessageAttributes), limit-envelope.HeaderAttributes)
+		}
+
+		// Create the claim checker for records too large to publish, nil when
+		// claim checking is disabled
+		claims := publisher.NewClaimChecker(pcfg, s3Client, pub.Limits())
+
+		return publisher.NewSender(logger, pub, deadLetters, claims, pcfg), nil
+	}
+
+	// Load the redaction policies of the destinations
+	policies, err := redact.ParsePolicies([]byte(cfg.RedactionPolicies), []byte(cfg.RedactionKey))
+	if err != nil {
+		return fmt.Errorf("failed to parse redaction policies: %w", err)
+	}
+
+	// Create the destinations of every dataset
+	dests, err := publisher.NewDestinations(cfg.Config, cfg.DatasetTargets, dataset.Default, policies, newSender)
+	if err != nil {
+		return fmt.Errorf("failed to create destinations: %w", err)
+	}
+
 	// Start lambda function
-	lambda.StartWithOptions(handler(logger, s3Client, sqsClient, cfg))
+	lambdaruntime.StartWithOptions(withControlQueue(handler(logger, s3Client, dests, store, cont, dataset.Default, registry, cfg)))
 
 	return nil
 }
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/format"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/dataset"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/rowsource"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/s3file"
+)
+
+// readPlan describes the row groups of a file and which of them are read.
+type readPlan struct {
+	// rowGroupRows is the number of rows in every row group of the file,
+	// indexed by row group.
+	rowGroupRows []int64
+
+	// rowGroups are the indexes of the row groups that are read, in order.
+	rowGroups []int
+
+	// skipped are the indexes of the row groups that were pruned because none
+	// of their rows can match the selection.
+	skipped []int
+
+	// skippedBytes is the compressed size of the skipped row groups.
+	skippedBytes int64
+
+	// columns are the leaf columns of the file keyed by dotted path.
+	columns map[string]column
+
+	// names are the paths the selection refers to as named in the file, see
+	// selection.resolve.
+	names map[string]string
+}
+
+// start returns the row number within the file of the first row of a row
+// group.
+func (p readPlan) start(rowGroup int) int64 {
+	var row int64
+	for _, n := range p.rowGroupRows[:rowGroup] {
+		row += n
+	}
+	return row
+}
+
+// openParquetFile opens a parquet file read from S3 with ctx. Column chunks
+// are read through buffers of blockSize bytes, so every column is fetched in
+// ranges of at least that size. Page indexes and bloom filters are not read
+// up front, bloom filters are only read when a filter needs them.
+func openParquetFile(ctx context.Context, fr *s3file.File, blockSize int64) (*parquet.File, error) {
+	if blockSize <= 0 {
+		blockSize = s3file.DefaultBlockSize
+	}
+
+	return parquet.OpenFile(fr.Reader(ctx), fr.Size(),
+		parquet.SkipPageIndex(true),
+		parquet.SkipBloomFilters(true),
+		parquet.ReadBufferSize(int(blockSize)),
+	)
+}
+
+// newRowSource creates a source of the records of a file of a dataset starting
+// at a row within a row group. Row groups before rowGroup, and those whose
+// statistics or bloom filters show none of their rows can match the
+// selection, are never fetched, and rowOffset rows are skipped within
+// rowGroup. Columns the selection does not need are never read.
+//
+// It also returns the plan of row groups the source reads, so callers can
+// track their position within the file.
+func newRowSource(f *parquet.File, ds dataset.Dataset, rowGroup int, rowOffset int64, sel selection) (rowsource.RowSource[any], readPlan, error) {
+	plan := readPlan{columns: fileColumns(f)}
+
+	if err := sel.validate(plan.columns); err != nil {
+		return nil, readPlan{}, err
+	}
+	plan.names = sel.resolve(plan.columns)
+
+	rowGroups := f.Metadata().RowGroups
+	if rowGroup > len(rowGroups) {
+		return nil, readPlan{}, fmt.Errorf("row group %d out of range, file has %d row groups", rowGroup, len(rowGroups))
+	}
+
+	plan.rowGroupRows = make([]int64, len(rowGroups))
+	plan.rowGroups = make([]int, 0, len(rowGroups))
+	for i := range rowGroups {
+		rg := &rowGroups[i]
+		plan.rowGroupRows[i] = rg.NumRows
+		if i < rowGroup {
+			continue
+		}
+
+		if sel.mayMatch(f, plan.columns, rg) {
+			plan.rowGroups = append(plan.rowGroups, i)
+		} else {
+			plan.skipped = append(plan.skipped, i)
+			plan.skippedBytes += rowGroupBytes(rg)
+		}
+	}
+
+	// The offset only applies if the row group it is within is read
+	if len(plan.rowGroups) == 0 || plan.rowGroups[0] != rowGroup {
+		rowOffset = 0
+	}
+
+	src, err := ds.Open(f, rowsource.ParquetOptions{
+		RowGroups: plan.rowGroups,
+		Offset:    rowOffset,
+		Columns:   sel.paths(),
+	})
+	if err != nil {
+		return nil, readPlan{}, err
+	}
+
+	return src, plan, nil
+}
+
+// rowGroupBytes returns the compressed size of a row group.
+func rowGroupBytes(rg *format.RowGroup) int64 {
+	if rg.TotalCompressedSize > 0 {
+		return rg.TotalCompressedSize
+	}
+
+	var size int64
+	for _, chunk := range rg.Columns {
+		size += chunk.MetaData.TotalCompressedSize
+	}
+	return size
+}
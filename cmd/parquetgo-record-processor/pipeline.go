@@ -0,0 +1,446 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/dataset"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/envelope"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/publisher"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/redact"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/rowsource"
+)
+
+// readBatch is a batch of rows read from the parquet file.
+type readBatch struct {
+	// seq orders batches in the order they were read.
+	seq int
+
+	// row is the row number of the first row in the file.
+	row int64
+
+	rows []any
+}
+
+// publishJob is a single SendMessageBatch worth of envelopes encoded from a
+// read batch.
+type publishJob struct {
+	seq        int
+	batchIndex int
+	messages   []envelope.Message
+}
+
+// pendingBatch tracks the publish jobs of a read batch that are yet to finish.
+type pendingBatch struct {
+	// rows is the number of rows read.
+	rows int
+
+	// matched is the number of rows that matched the filter and are
+	// published.
+	matched int
+
+	// next is the row number of the row after the batch.
+	next int64
+
+	messages  int
+	remaining int
+}
+
+// pipeline publishes the rows of a single parquet file. Rows flow through a
+// reader, an encoder and the shared pool of publishers joined by bounded
+// channels, so the next batch is read and encoded while earlier ones are being
+// published.
+//
+// Batches can finish publishing out of order, so the checkpoint is only
+// advanced past a batch once every batch read before it has been published.
+// Checkpoints are saved by a single saver outside the lock, so publishers
+// never wait on a save and saves land in the order they were committed.
+type pipeline struct {
+	logger *slog.Logger
+	sender *publisher.Sender
+	pool   *publishPool
+	store  checkpointStore
+	cfg    config
+
+	// policy redacts records before they are published, nil if they are
+	// published as they are.
+	policy *redact.Policy
+
+	path      string
+	source    string
+	schema    string
+	version   string
+	dataset   dataset.Dataset
+	sel       selection
+	src       rowsource.RowSource[any]
+	plan      readPlan
+	totalRows int64
+	stats     *publisher.Stats
+
+	// mu guards the checkpoint and the batches that are yet to be committed to
+	// it.
+	mu      sync.Mutex
+	cp      *checkpoint
+	pending map[int]*pendingBatch
+	next    int
+
+	// unsaved is a copy of the checkpoint committed since the last save, nil
+	// if it has been saved. saveCh signals the saver that it is set.
+	unsaved *checkpoint
+	saveCh  chan struct{}
+}
+
+// run publishes the file from the checkpoint onward. It returns true if it
+// stopped early because the invocation deadline is approaching, in which case
+// the checkpoint points at the first row that has not been published.
+func (p *pipeline) run(ctx context.Context) (bool, error) {
+	p.pending = make(map[int]*pendingBatch)
+	p.saveCh = make(chan struct{}, 1)
+
+	var (
+		stopped bool
+		g, gctx = errgroup.WithContext(ctx)
+		readCh  = make(chan readBatch, p.cfg.PipelineBuffer)
+		jobCh   = make(chan publishJob, p.cfg.PublishConcurrency)
+	)
+
+	// Reader stage. Batches never span row groups, so the row numbers of a
+	// batch stay contiguous when row groups in between were pruned.
+	g.Go(func() error {
+		defer close(readCh)
+
+		var (
+			seq int
+			row = p.cp.row(p.plan.rowGroupRows)
+		)
+		for _, rowGroup := range p.plan.rowGroups {
+			start := p.plan.start(rowGroup)
+			end := start + p.plan.rowGroupRows[rowGroup]
+			row = max(row, start)
+
+			for row < end {
+				// Stop reading before the invocation is killed, batches
+				// already read are still published
+				if deadlineNear(ctx, p.cfg.DeadlineBuffer) {
+					stopped = true
+					return nil
+				}
+
+				n := end - row
+				if p.cfg.RowsPerBatch > 0 {
+					n = min(n, int64(p.cfg.RowsPerBatch))
+				}
+
+				rows := make([]any, n)
+				read, err := p.src.Read(rows)
+				if err != nil && !errors.Is(err, io.EOF) {
+					p.logger.ErrorContext(
+						ctx,
+						"Failed to read batch from parquet file",
+						slog.String("file", p.path),
+						slog.Any("error", err))
+
+					return fmt.Errorf("failed to read batch from parquet file %s: %w", p.path, err)
+				}
+
+				if read == 0 {
+					p.logger.ErrorContext(ctx, "Row group ended early", "file", p.path, "row_group", rowGroup, "row", row)
+					return fmt.Errorf("row group %d of file %s ended early at row %d", rowGroup, p.path, row)
+				}
+
+				select {
+				case readCh <- readBatch{seq: seq, row: row, rows: rows[:read]}:
+				case <-gctx.Done():
+					return gctx.Err()
+				}
+
+				row += int64(read)
+				seq++
+			}
+		}
+
+		return nil
+	})
+
+	// Encoder stage
+	limits := p.sender.Limits()
+	g.Go(func() error {
+		defer close(jobCh)
+
+		for batch := range readCh {
+			// Keep the selected columns of the rows that match the filter,
+			// transformed the way the dataset publishes them and redacted
+			// for the destination. Redacting last means fields created by
+			// transforms are checked by the policy too.
+			var (
+				matched    = make([]interface{}, 0, len(batch.rows))
+				rowNumbers = make([]int64, 0, len(batch.rows))
+			)
+			for i, row := range batch.rows {
+				if !p.sel.match(p.plan, row) {
+					continue
+				}
+
+				record, err := p.dataset.Transform(p.sel.apply(p.plan, row))
+				if err != nil {
+					p.logger.ErrorContext(ctx, "Failed to transform record", "file", p.path, "row", batch.row+int64(i), "error", err)
+					return fmt.Errorf("failed to transform row %d of file %s: %w", batch.row+int64(i), p.path, err)
+				}
+
+				if p.policy != nil {
+					if record, err = p.policy.Redact(record, p.dataset.Classes); err != nil {
+						p.logger.ErrorContext(ctx, "Failed to redact record", "file", p.path, "row", batch.row+int64(i), "policy", p.policy.Name, "error", err)
+						return fmt.Errorf("failed to redact row %d of file %s: %w", batch.row+int64(i), p.path, err)
+					}
+				}
+
+				matched = append(matched, record)
+				rowNumbers = append(rowNumbers, batch.row+int64(i))
+			}
+
+			// Pack the rows into as few envelopes as the sink limits allow
+			records, err := envelope.EncodeRecords(matched)
+			if err != nil {
+				p.logger.ErrorContext(ctx, "Failed to encode records", "file", p.path, "error", err)
+				return fmt.Errorf("failed to encode records from file %s: %w", p.path, err)
+			}
+
+			messages, err := envelope.Pack(p.header(), batch.row, records, envelope.PackOptions{
+				MaxRecords:   p.cfg.SQSBatchSize,
+				MaxBytes:     limits.MaxEntryBytes,
+				Rows:         rowNumbers,
+				Oversize:     p.sender.ClaimChecks(),
+				Keys:         p.cfg.GroupIDs(p.source, matched, p.dataset.KeyField),
+				Attributes:   p.cfg.MessageAttributes.AttributesOf(matched),
+				NoAttributes: limits.MaxAttributes == 0,
+			})
+			if err != nil {
+				p.logger.ErrorContext(ctx, "Failed to pack records", "file", p.path, "error", err)
+				return fmt.Errorf("failed to pack records from file %s: %w", p.path, err)
+			}
+
+			batches := publisher.Batch(messages, limits)
+			pending := &pendingBatch{
+				rows:      len(batch.rows),
+				matched:   len(matched),
+				next:      batch.row + int64(len(batch.rows)),
+				messages:  len(messages),
+				remaining: len(batches),
+			}
+			p.expect(ctx, batch.seq, pending)
+
+			for i, messages := range batches {
+				select {
+				case jobCh <- publishJob{seq: batch.seq, batchIndex: i, messages: messages}:
+				case <-gctx.Done():
+					return gctx.Err()
+				}
+			}
+		}
+
+		return nil
+	})
+
+	// Publisher stage. Jobs are sent by the publishers of the pool shared by
+	// every file of the invocation, in the order they are handed to it. A
+	// failed send stops handing over jobs and cancels the sends in flight,
+	// and published is closed once every job handed over has finished.
+	published := make(chan struct{})
+	g.Go(func() error {
+		var (
+			publishing           sync.WaitGroup
+			once                 sync.Once
+			failed               = make(chan struct{})
+			sendErr              error
+			sendCtx, cancelSends = context.WithCancel(gctx)
+		)
+		defer cancelSends()
+
+		fail := func(err error) {
+			once.Do(func() {
+				sendErr = err
+				close(failed)
+				cancelSends()
+			})
+		}
+
+		handOver := func() error {
+			for job := range jobCh {
+				select {
+				case <-failed:
+					return nil
+				default:
+				}
+
+				publishing.Add(1)
+				err := p.pool.submit(sendCtx, poolJob{
+					ctx:    sendCtx,
+					sender: p.sender,
+					req: publisher.Request{
+						BatchIndex: job.batchIndex,
+						Source:     p.source,
+						Messages:   job.messages,
+						Stats:      p.stats,
+					},
+					done: func(err error) {
+						defer publishing.Done()
+
+						if err != nil {
+							fail(err)
+							return
+						}
+						p.done(ctx, job.seq)
+					},
+				})
+				if err != nil {
+					publishing.Done()
+					return err
+				}
+			}
+
+			return nil
+		}
+
+		err := handOver()
+		publishing.Wait()
+		close(published)
+
+		// A failed send is the cause of the jobs canceled after it
+		select {
+		case <-failed:
+			err = sendErr
+		default:
+		}
+		return err
+	})
+
+	// Saver stage. A save covers every batch committed since the previous
+	// one, and the last commit is saved once the publishers stop, including
+	// when the pipeline failed, so progress is never lost.
+	g.Go(func() error {
+		for {
+			select {
+			case <-p.saveCh:
+			case <-published:
+				return p.save(ctx)
+			}
+
+			if err := p.save(ctx); err != nil {
+				return err
+			}
+		}
+	})
+
+	if err := g.Wait(); err != nil {
+		p.logger.ErrorContext(
+			ctx,
+			"Error processing batch",
+			slog.Any("error", err),
+			slog.Int64("total_published_rows", p.cp.Published),
+			slog.Int64("total_rows", p.totalRows))
+
+		return false, err
+	}
+
+	return stopped, nil
+}
+
+// header returns the envelope header for the file.
+func (p *pipeline) header() envelope.Header {
+	return envelope.Header{
+		Source:        p.source,
+		ETag:          p.cp.ETag,
+		Schema:        p.schema,
+		SchemaVersion: p.version,
+		Selection:     p.sel.id(),
+		Config:        p.dataset.Fingerprint(p.policy),
+	}
+}
+
+// expect registers the publish jobs of a read batch. A batch without any jobs
+// is committed straight away.
+func (p *pipeline) expect(ctx context.Context, seq int, batch *pendingBatch) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.pending[seq] = batch
+	p.commit(ctx)
+}
+
+// done records that a publish job of a read batch has finished.
+func (p *pipeline) done(ctx context.Context, seq int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.pending[seq].remaining--
+	p.commit(ctx)
+}
+
+// commit advances the checkpoint over every finished batch that directly
+// follows the last committed one and hands a copy of it to the saver. It must
+// be called with mu held.
+func (p *pipeline) commit(ctx context.Context) {
+	var committed bool
+	for {
+		batch, ok := p.pending[p.next]
+		if !ok || batch.remaining > 0 {
+			break
+		}
+
+		p.cp.Published += int64(batch.matched)
+		p.cp.Filtered += int64(batch.rows - batch.matched)
+		p.cp.seek(batch.next, p.plan.rowGroupRows)
+		delete(p.pending, p.next)
+		p.next++
+		committed = true
+
+		p.logger.InfoContext(
+			ctx,
+			"Published batch from parquet file",
+			slog.Int("rows_in_batch", batch.rows),
+			slog.Int("matched_rows_in_batch", batch.matched),
+			slog.Int("messages_in_batch", batch.messages),
+			slog.Int64("total_published_rows", p.cp.Published),
+			slog.Int64("total_rows", p.totalRows),
+		)
+	}
+
+	if !committed {
+		return
+	}
+
+	p.cp.UpdatedAt = time.Now()
+	cp := *p.cp
+	p.unsaved = &cp
+
+	select {
+	case p.saveCh <- struct{}{}:
+	default:
+	}
+}
+
+// save saves the last committed checkpoint if it has not been saved yet.
+func (p *pipeline) save(ctx context.Context) error {
+	p.mu.Lock()
+	cp := p.unsaved
+	p.unsaved = nil
+	p.mu.Unlock()
+
+	if cp == nil {
+		return nil
+	}
+
+	if err := p.store.Save(ctx, *cp); err != nil {
+		p.logger.ErrorContext(ctx, "Failed to save checkpoint", "path", p.path, "error", err)
+		return fmt.Errorf("failed to save checkpoint for file %s: %w", p.path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/dataset"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/envelope"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/models"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/publisher"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/redact"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/s3test"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/transform"
+)
+
+// slowStore is a checkpoint store whose first save blocks until it is
+// released, recording every checkpoint saved.
+type slowStore struct {
+	release chan struct{}
+	started chan struct{}
+	once    sync.Once
+
+	mu    sync.Mutex
+	saves []checkpoint
+}
+
+func newSlowStore() *slowStore {
+	return &slowStore{release: make(chan struct{}), started: make(chan struct{})}
+}
+
+func (s *slowStore) Load(context.Context, string, string, string) (checkpoint, bool, error) {
+	return checkpoint{}, false, nil
+}
+
+func (s *slowStore) Save(ctx context.Context, cp checkpoint) error {
+	s.once.Do(func() {
+		close(s.started)
+		<-s.release
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.saves = append(s.saves, cp)
+	return nil
+}
+
+func TestPipelineSavesOutsideLock(t *testing.T) {
+	ctx := context.Background()
+
+	srv := s3test.NewServer(t)
+	srv.Put("bucket", "records.parquet", testParquet(t, 2_000, 500))
+
+	store := newSlowStore()
+	rec := &recorder{}
+	job := testFileJob(t, srv, rec)
+	job.store = store
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		job.run(ctx, "records.parquet", nil)
+	}()
+
+	// Every row is published while the first checkpoint save is stuck
+	<-store.started
+	deadline := time.After(10 * time.Second)
+	for len(rec.ids(t)) < 2_000 {
+		select {
+		case <-deadline:
+			t.Fatalf("published %d of 2000 rows while a checkpoint save was blocked", len(rec.ids(t)))
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	close(store.release)
+	<-done
+
+	// Saves land in commit order, the last one marking the file complete
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for i := 1; i < len(store.saves); i++ {
+		if store.saves[i].Published < store.saves[i-1].Published {
+			t.Fatalf("save %d published %d rows after save %d published %d", i, store.saves[i].Published, i-1, store.saves[i-1].Published)
+		}
+	}
+	last := store.saves[len(store.saves)-1]
+	if !last.Complete || last.Published != 2_000 {
+		t.Fatalf("last save = %+v, want 2000 rows complete", last)
+	}
+}
+
+// funcPublisher is a publisher sending every batch with a function.
+type funcPublisher struct {
+	recorder
+	publish func(ctx context.Context, messages []envelope.Message) ([]publisher.Failure, error)
+}
+
+func (p *funcPublisher) PublishBatch(ctx context.Context, messages []envelope.Message) ([]publisher.Failure, error) {
+	p.recorder.PublishBatch(ctx, messages)
+	return p.publish(ctx, messages)
+}
+
+// runFile publishes a records file with job, failing the test if it does not
+// finish in time.
+func runFile(t *testing.T, job *fileJob, path string) fileReport {
+	t.Helper()
+
+	done := make(chan fileReport, 1)
+	go func() {
+		report, _ := job.run(context.Background(), path, nil)
+		done <- report
+	}()
+
+	select {
+	case report := <-done:
+		return report
+	case <-time.After(10 * time.Second):
+		t.Fatalf("publishing %s did not finish", path)
+		return fileReport{}
+	}
+}
+
+func TestPipelineCommitsBatchesInOrder(t *testing.T) {
+	srv := s3test.NewServer(t)
+	srv.Put("bucket", "records.parquet", testParquet(t, 2_000, 500))
+
+	// The first batch is published last
+	release := make(chan struct{})
+	pub := &funcPublisher{publish: func(ctx context.Context, messages []envelope.Message) ([]publisher.Failure, error) {
+		if messages[0].RowStart == 0 {
+			<-release
+		}
+		return nil, nil
+	}}
+
+	// The store saves without blocking
+	store := newSlowStore()
+	close(store.release)
+
+	job := testFileJob(t, srv, &pub.recorder)
+	job.dest.Sender = testSender(pub, job.cfg)
+	job.store = store
+
+	done := make(chan fileReport, 1)
+	go func() { done <- runFile(t, job, "records.parquet") }()
+
+	deadline := time.After(10 * time.Second)
+	for len(pub.ids(t)) < 2_000 {
+		select {
+		case <-deadline:
+			t.Fatalf("published %d of 2000 rows while the first batch was held", len(pub.ids(t)))
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	// Nothing is committed past the first batch while it is being published
+	store.mu.Lock()
+	saves := len(store.saves)
+	store.mu.Unlock()
+	if saves != 0 {
+		t.Fatalf("saved %d checkpoints before the first batch was published", saves)
+	}
+
+	close(release)
+	if report := <-done; report.Status != filePublished {
+		t.Fatalf("report = %+v, want the file published", report)
+	}
+
+	// Every save covers a prefix of the file
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	rowGroupRows := []int64{500, 500, 500, 500}
+	for i, cp := range store.saves {
+		if cp.Published == 0 || cp.row(rowGroupRows) != cp.Published {
+			t.Fatalf("save %d = %+v, want the rows before its position published", i, cp)
+		}
+		if i > 0 && cp.Published < store.saves[i-1].Published {
+			t.Fatalf("save %d published %d rows after save %d published %d", i, cp.Published, i-1, store.saves[i-1].Published)
+		}
+	}
+	if last := store.saves[len(store.saves)-1]; !last.Complete || last.Published != 2_000 {
+		t.Fatalf("last save = %+v, want 2000 rows complete", last)
+	}
+}
+
+func TestPipelineBoundsPublishConcurrency(t *testing.T) {
+	srv := s3test.NewServer(t)
+	srv.Put("bucket", "a.parquet", testParquet(t, 1_000, 500))
+	srv.Put("bucket", "b.parquet", testParquet(t, 1_000, 500))
+
+	var inFlight, peak atomic.Int64
+	pub := &funcPublisher{publish: func(context.Context, []envelope.Message) ([]publisher.Failure, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+
+		time.Sleep(5 * time.Millisecond)
+		return nil, nil
+	}}
+
+	job := testFileJob(t, srv, &pub.recorder)
+	job.dest.Sender = testSender(pub, job.cfg)
+
+	// Both files share the publishers of the job
+	var wg sync.WaitGroup
+	for _, path := range []string{"a.parquet", "b.parquet"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if report := runFile(t, job, path); report.Status != filePublished {
+				t.Errorf("report = %+v, want the file published", report)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := len(pub.ids(t)); n != 2_000 {
+		t.Fatalf("published %d rows, want 2000", n)
+	}
+	if p := peak.Load(); p < 2 || p > int64(job.cfg.PublishConcurrency) {
+		t.Fatalf("published up to %d batches at once, want between 2 and %d", p, job.cfg.PublishConcurrency)
+	}
+}
+
+func TestPipelineStopsOnError(t *testing.T) {
+	srv := s3test.NewServer(t)
+	srv.Put("bucket", "records.parquet", testParquet(t, 2_000, 500))
+
+	// The first batch fails once the second is being published, every other
+	// one waits until it is canceled
+	var (
+		canceled atomic.Int64
+		started  = make(chan struct{}, 40)
+	)
+	pub := &funcPublisher{publish: func(ctx context.Context, messages []envelope.Message) ([]publisher.Failure, error) {
+		if messages[0].RowStart == 0 {
+			<-started
+			return []publisher.Failure{{ID: "unknown", Code: "InternalError"}}, nil
+		}
+
+		started <- struct{}{}
+		<-ctx.Done()
+		canceled.Add(1)
+		return nil, ctx.Err()
+	}}
+
+	job := testFileJob(t, srv, &pub.recorder)
+	job.dest.Sender = testSender(pub, job.cfg)
+
+	report := runFile(t, job, "records.parquet")
+	if report.Status != fileFailed || report.RowsPublished != 0 || !strings.Contains(report.Error, `"unknown"`) {
+		t.Fatalf("report = %+v, want the file failed by the first batch with no rows published", report)
+	}
+
+	// The reader and encoder stop rather than feeding the publishers the
+	// rest of the file
+	pub.mu.Lock()
+	sent := len(pub.messages)
+	pub.mu.Unlock()
+	if sent >= 40 {
+		t.Fatalf("sent %d of 40 messages after the first batch failed", sent)
+	}
+	if n := canceled.Load(); n == 0 {
+		t.Fatal("no batch being published was canceled")
+	}
+}
+
+func TestPipelineRedactsTransformedRecords(t *testing.T) {
+	ctx := context.Background()
+
+	srv := s3test.NewServer(t)
+	srv.Put("bucket", "records.parquet", testParquet(t, 100, 100))
+
+	policies, err := redact.ParsePolicies([]byte(`
+internal:
+  actions:
+    name: drop
+    email: hash
+external:
+  external: true
+  actions:
+    email: hash
+`), []byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		transforms string
+		policy     string
+		check      func(t *testing.T, record map[string]any)
+	}{
+		{
+			name:       "renamed field",
+			transforms: "records:\n  - {op: rename, field: email, to: contact}",
+			policy:     "internal",
+			check:      hashedContact,
+		},
+		{
+			name:       "renamed field to an external destination",
+			transforms: "records:\n  - {op: rename, field: email, to: contact}",
+			policy:     "external",
+			check:      hashedContact,
+		},
+		{
+			name:       "derived from a field the policy drops",
+			transforms: `records: [{op: derive, field: greeting, template: "Hello {{.first_name}}"}]`,
+			policy:     "internal",
+			check: func(t *testing.T, record map[string]any) {
+				if _, ok := record["first_name"]; ok {
+					t.Errorf("first_name = %v, want it dropped", record["first_name"])
+				}
+				if _, ok := record["greeting"]; ok {
+					t.Errorf("greeting = %v, want it dropped like the name it is derived from", record["greeting"])
+				}
+			},
+		},
+		{
+			name:       "derived from fields the policy drops and hashes",
+			transforms: `records: [{op: derive, field: greeting, template: "{{.email}} {{.account_type}}"}]`,
+			policy:     "internal",
+			check: func(t *testing.T, record map[string]any) {
+				if greeting, _ := record["greeting"].(string); greeting == "" || strings.Contains(greeting, "@") {
+					t.Errorf("greeting = %v, want it hashed like the email it is derived from", record["greeting"])
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chains, err := transform.Parse([]byte(tt.transforms), transform.Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			registry := dataset.NewRegistry()
+			dataset.Register[models.Record](registry, dataset.Records, dataset.Options{Schema: "record"})
+			if err := registry.AddTransforms(dataset.Records, chains[dataset.Records].Fingerprint(), chains[dataset.Records].Classify, chains[dataset.Records].Apply); err != nil {
+				t.Fatal(err)
+			}
+
+			rec := &recorder{}
+			job := testFileJob(t, srv, rec)
+			job.dest.Policy = policies[tt.policy]
+			if job.dataset, err = registry.Lookup(dataset.Records); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := job.publish(ctx, "records.parquet", nil, &fileReport{}); err != nil {
+				t.Fatal(err)
+			}
+
+			if n := len(rec.ids(t)); n != 100 {
+				t.Fatalf("published %d records, want 100", n)
+			}
+			for _, env := range rec.envelopes(t) {
+				for _, raw := range env.Records {
+					var record map[string]any
+					if err := json.Unmarshal(raw, &record); err != nil {
+						t.Fatal(err)
+					}
+					tt.check(t, record)
+				}
+			}
+		})
+	}
+}
+
+// hashedContact checks the email of a record was renamed to contact and is
+// still hashed like an email.
+func hashedContact(t *testing.T, record map[string]any) {
+	if _, ok := record["email"]; ok {
+		t.Errorf("email = %v, want it renamed", record["email"])
+	}
+	if contact, _ := record["contact"].(string); contact == "" || strings.Contains(contact, "@") {
+		t.Errorf("contact = %v, want it hashed", record["contact"])
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/publisher"
+)
+
+// poolJob is a batch of messages handed to the publish pool by a pipeline.
+type poolJob struct {
+	ctx    context.Context
+	sender *publisher.Sender
+	req    publisher.Request
+
+	// done is called with the result of the send once it has finished.
+	done func(error)
+}
+
+// publishPool is a fixed number of publishers shared by every file of an
+// invocation, so the number of batches being sent at once does not grow with
+// the number of files published at once. A single publisher sends jobs in the
+// order they were submitted.
+type publishPool struct {
+	jobs chan poolJob
+	wg   sync.WaitGroup
+}
+
+// newPublishPool starts a pool of n publishers.
+func newPublishPool(n int) *publishPool {
+	p := &publishPool{jobs: make(chan poolJob)}
+
+	for range max(n, 1) {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+
+			for job := range p.jobs {
+				err := job.ctx.Err()
+				if err == nil {
+					err = job.sender.Send(job.ctx, job.req)
+				}
+				job.done(err)
+			}
+		}()
+	}
+
+	return p
+}
+
+// submit hands a job to the next free publisher, waiting until one is free.
+// The job is not run if ctx is done first.
+func (p *publishPool) submit(ctx context.Context, job poolJob) error {
+	select {
+	case p.jobs <- job:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// close stops the publishers once the jobs already submitted have finished.
+func (p *publishPool) close() {
+	close(p.jobs)
+	p.wg.Wait()
+}
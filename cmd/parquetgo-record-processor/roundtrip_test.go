@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/models"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/recordgen"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/s3test"
+)
+
+// TestRoundTrip generates a file the way create-test-data does, publishes it
+// and checks every published record is byte for byte the JSON encoding of the
+// record generated.
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+
+	// Timestamps are read back in UTC, so they are generated in UTC
+	now := time.Date(2024, 10, 1, 12, 30, 15, 123456789, time.UTC)
+	gen := recordgen.New(rand.New(rand.NewSource(1)), func() time.Time { return now })
+
+	var (
+		buf     bytes.Buffer
+		records = make([]models.Record, 1_200)
+	)
+	w := recordgen.NewWriter(&buf)
+	for i := range records {
+		records[i] = gen.Record()
+		if err := w.Write(records[i]); err != nil {
+			t.Fatal(err)
+		}
+
+		// Flush row groups as create-test-data does
+		if (i+1)%500 == 0 {
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := s3test.NewServer(t)
+	srv.Put("bucket", "test_data.parquet", buf.Bytes())
+
+	rec := &recorder{}
+	job := testFileJob(t, srv, rec)
+
+	report, _ := job.run(ctx, "test_data.parquet", nil)
+	if report.Status != filePublished {
+		t.Fatalf("report = %+v", report)
+	}
+
+	var published []json.RawMessage
+	for _, env := range rec.envelopes(t) {
+		published = append(published, env.Records...)
+	}
+	if len(published) != len(records) {
+		t.Fatalf("published %d records, want %d", len(published), len(records))
+	}
+
+	for i, r := range records {
+		want, err := json.Marshal(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(published[i], want) {
+			t.Fatalf("record %d published as\n%s\nwant\n%s", i, published[i], want)
+		}
+	}
+}
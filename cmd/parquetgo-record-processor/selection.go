@@ -0,0 +1,540 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/bloom"
+	"github.com/parquet-go/parquet-go/bloom/xxhash"
+	"github.com/parquet-go/parquet-go/deprecated"
+	"github.com/parquet-go/parquet-go/encoding/thrift"
+	"github.com/parquet-go/parquet-go/format"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/fields"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/filter"
+)
+
+// selection is the columns and rows of a file that a request publishes. The
+// zero value selects every column of every row.
+type selection struct {
+	// columns are the paths of the fields to publish, or nil for all fields.
+	columns []string
+
+	// filter matches the rows to publish, or is nil for all rows.
+	filter filter.Expr
+
+	// expr is the filter expression as given in the request.
+	expr string
+}
+
+// newSelection creates the selection of a request.
+func newSelection(columns []string, expr string) (selection, error) {
+	var sel selection
+
+	for _, column := range columns {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if column == "" {
+			continue
+		}
+		if !slices.Contains(sel.columns, column) {
+			sel.columns = append(sel.columns, column)
+		}
+	}
+
+	if strings.TrimSpace(expr) != "" {
+		f, err := filter.Parse(expr)
+		if err != nil {
+			return selection{}, fmt.Errorf("invalid filter: %w", err)
+		}
+		sel.filter = f
+		sel.expr = expr
+	}
+
+	return sel, nil
+}
+
+// id returns a short identity of the selection, empty when it selects
+// everything. It keeps envelopes of different selections of the same rows
+// apart.
+func (s selection) id() string {
+	if s.columns == nil && s.filter == nil {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(s.columns, ",") + "\x00" + s.expr))
+	return hex.EncodeToString(sum[:8])
+}
+
+// paths returns the paths the selection needs to read, the published columns
+// and those the filter refers to, or nil if it needs every column.
+func (s selection) paths() []string {
+	if s.columns == nil {
+		return nil
+	}
+
+	paths := slices.Clone(s.columns)
+	if s.filter != nil {
+		for _, path := range filter.Columns(s.filter) {
+			if !slices.Contains(paths, path) {
+				paths = append(paths, path)
+			}
+		}
+	}
+
+	return paths
+}
+
+// covers reports whether a dotted leaf column path is selected by path, that
+// is it is the column itself or nested below it.
+func covers(path, leaf string) bool {
+	return leaf == path || strings.HasPrefix(leaf, path+".")
+}
+
+// column describes a leaf column of a file.
+type column struct {
+	// index is the index of the column among the leaf columns of the file,
+	// and of its chunk in every row group.
+	index int
+
+	// path is the dotted, lower case path of the column, such as
+	// address.city.
+	path string
+
+	// name is the dotted path of the column as written in the file, such as
+	// Address.City, which is how the fields of records read from it are
+	// named.
+	name string
+
+	kind parquet.Kind
+
+	// unit is the duration of one tick of a timestamp column, zero for other
+	// columns.
+	unit time.Duration
+
+	// date is true for a date column, stored as days since the epoch.
+	date bool
+
+	// repeated is true for a column within a list, which holds any number of
+	// values per row.
+	repeated bool
+
+	// opaque is true for a column of a logical type filters do not
+	// understand, such as a decimal or an unsigned integer. Its statistics
+	// are not ordered the way filter values are, so they and its bloom
+	// filter are never used to prune row groups.
+	opaque bool
+}
+
+// fileColumns returns the leaf columns of a file keyed by their dotted path.
+func fileColumns(f *parquet.File) map[string]column {
+	schema := f.Schema()
+	columns := make(map[string]column)
+
+	for _, path := range schema.Columns() {
+		leaf, _ := schema.Lookup(path...)
+		typ := leaf.Node.Type()
+
+		c := column{
+			index:    leaf.ColumnIndex,
+			path:     strings.ToLower(strings.Join(path, ".")),
+			name:     strings.Join(path, "."),
+			kind:     typ.Kind(),
+			repeated: leaf.MaxRepetitionLevel > 0,
+		}
+
+		lt, ct := typ.LogicalType(), typ.ConvertedType()
+		switch {
+		case lt != nil && lt.Timestamp != nil:
+			switch unit := lt.Timestamp.Unit; {
+			case unit.Millis != nil:
+				c.unit = time.Millisecond
+			case unit.Micros != nil:
+				c.unit = time.Microsecond
+			case unit.Nanos != nil:
+				c.unit = time.Nanosecond
+			default:
+				c.opaque = true
+			}
+		case lt != nil && lt.Date != nil:
+			c.date = true
+		case lt != nil && lt.Integer != nil:
+			c.opaque = !lt.Integer.IsSigned
+		case lt != nil && (lt.UTF8 != nil || lt.Enum != nil):
+			// Strings are ordered byte by byte like filter strings
+		case lt != nil:
+			c.opaque = true
+		case ct != nil:
+			switch *ct {
+			case deprecated.TimestampMillis:
+				c.unit = time.Millisecond
+			case deprecated.TimestampMicros:
+				c.unit = time.Microsecond
+			case deprecated.Date:
+				c.date = true
+			case deprecated.UTF8, deprecated.Enum, deprecated.Int8, deprecated.Int16, deprecated.Int32, deprecated.Int64:
+				// Ordered like their physical type
+			default:
+				c.opaque = true
+			}
+		}
+
+		columns[c.path] = c
+	}
+
+	return columns
+}
+
+// value converts a value read from the column to the form filters compare,
+// turning timestamp and date columns into times.
+func (c column) value(v any) any {
+	switch v := v.(type) {
+	case int64:
+		switch {
+		case c.unit > 0:
+			return time.Unix(0, 0).UTC().Add(time.Duration(v) * c.unit)
+		case c.date:
+			return time.Unix(v*86400, 0).UTC()
+		}
+	case int32:
+		if c.date {
+			return time.Unix(int64(v)*86400, 0).UTC()
+		}
+	}
+	return v
+}
+
+// encode plain encodes a filter value the way the column stores it, as
+// hashed by bloom filters. The boolean is false if the value cannot be
+// stored in the column.
+func (c column) encode(v any) ([]byte, bool) {
+	if t, ok := v.(time.Time); ok {
+		switch {
+		case c.unit > 0:
+			v = t.Sub(time.Unix(0, 0)) / c.unit
+		case c.date:
+			v = t.Unix() / 86400
+		default:
+			return nil, false
+		}
+	}
+
+	switch c.kind {
+	case parquet.ByteArray, parquet.FixedLenByteArray:
+		if s, ok := v.(string); ok {
+			return []byte(s), true
+		}
+	case parquet.Int32:
+		var i int64
+		switch v := v.(type) {
+		case int64:
+			i = v
+		case time.Duration:
+			i = int64(v)
+		default:
+			return nil, false
+		}
+		if i < math.MinInt32 || i > math.MaxInt32 {
+			return nil, false
+		}
+		return binary.LittleEndian.AppendUint32(nil, uint32(int32(i))), true
+	case parquet.Int64:
+		switch v := v.(type) {
+		case int64:
+			return binary.LittleEndian.AppendUint64(nil, uint64(v)), true
+		case time.Duration:
+			return binary.LittleEndian.AppendUint64(nil, uint64(v)), true
+		}
+	case parquet.Float:
+		switch v := v.(type) {
+		case float64:
+			return binary.LittleEndian.AppendUint32(nil, math.Float32bits(float32(v))), true
+		case int64:
+			return binary.LittleEndian.AppendUint32(nil, math.Float32bits(float32(v))), true
+		}
+	case parquet.Double:
+		switch v := v.(type) {
+		case float64:
+			return binary.LittleEndian.AppendUint64(nil, math.Float64bits(v)), true
+		case int64:
+			return binary.LittleEndian.AppendUint64(nil, math.Float64bits(float64(v))), true
+		}
+	}
+
+	return nil, false
+}
+
+// validate checks that every column the selection refers to exists in the
+// file, so a typo fails up front instead of silently publishing nothing.
+func (s selection) validate(columns map[string]column) error {
+	paths := slices.Clone(s.columns)
+	if s.filter != nil {
+		paths = append(paths, filter.Columns(s.filter)...)
+	}
+
+	for _, path := range paths {
+		found := false
+		for leaf := range columns {
+			if covers(path, leaf) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown column %q", path)
+		}
+	}
+
+	return nil
+}
+
+// resolve returns the paths the selection refers to as they are named in the
+// file, keyed by their lower case path. Paths are matched case insensitively,
+// but records read from the file keep the case of its columns. A path above
+// leaf columns, such as address, is named after the groups of its columns.
+func (s selection) resolve(columns map[string]column) map[string]string {
+	paths := slices.Clone(s.columns)
+	if s.filter != nil {
+		paths = append(paths, filter.Columns(s.filter)...)
+	}
+
+	names := make(map[string]string, len(paths))
+	for _, path := range paths {
+		if c, ok := columns[path]; ok {
+			names[path] = c.name
+			continue
+		}
+
+		depth := strings.Count(path, ".") + 1
+		for leaf, c := range columns {
+			if covers(path, leaf) {
+				names[path] = strings.Join(strings.SplitN(c.name, ".", depth+1)[:depth], ".")
+				break
+			}
+		}
+	}
+
+	return names
+}
+
+// mayMatch reports whether any row of a row group could match the filter,
+// judging from the min/max statistics and null counts of its column chunks,
+// and from their bloom filters for equality.
+func (s selection) mayMatch(f *parquet.File, columns map[string]column, rg *format.RowGroup) bool {
+	if s.filter == nil {
+		return true
+	}
+
+	stats := make(map[string]filter.Stats, len(columns))
+	for _, c := range columns {
+		if c.index < len(rg.Columns) {
+			stats[c.path] = columnStats(f, c, rg, &rg.Columns[c.index].MetaData)
+		}
+	}
+
+	return s.filter.MayMatch(func(path string) (filter.Stats, bool) {
+		st, ok := stats[path]
+		return st, ok
+	})
+}
+
+// match reports whether a row of the file read with plan matches the filter.
+func (s selection) match(plan readPlan, record any) bool {
+	if s.filter == nil {
+		return true
+	}
+
+	return s.filter.Eval(func(path string) (any, bool) {
+		v, _, ok := plan.field(record, path)
+		if !ok {
+			return nil, false
+		}
+		return plan.columns[path].value(v), true
+	})
+}
+
+// apply returns the selected columns of a row of the file read with plan,
+// nested by path and named as in the file, or the row itself if every column
+// is selected.
+func (s selection) apply(plan readPlan, record any) any {
+	if s.columns == nil {
+		return record
+	}
+
+	out := make(map[string]any, len(s.columns))
+	for _, path := range s.columns {
+		v, name, ok := plan.field(record, path)
+		if !ok {
+			continue
+		}
+
+		// Create the parents of nested paths
+		m := out
+		parts := strings.Split(name, ".")
+		for _, part := range parts[:len(parts)-1] {
+			child, ok := m[part].(map[string]any)
+			if !ok {
+				child = make(map[string]any)
+				m[part] = child
+			}
+			m = child
+		}
+		m[parts[len(parts)-1]] = v
+	}
+
+	return out
+}
+
+// field returns the value of the field of a record at a lower case path,
+// along with the path it was found at. Fields are looked up by the name of
+// the column in the file, then by the path itself for typed records tagged
+// differently than the file.
+func (p readPlan) field(record any, path string) (any, string, bool) {
+	if name, ok := p.names[path]; ok {
+		if v, ok := fields.Value(record, name); ok {
+			return v, name, true
+		}
+	}
+
+	v, ok := fields.Value(record, path)
+	return v, path, ok
+}
+
+// columnStats returns the statistics of a column chunk. Only the plain
+// encoded MinValue and MaxValue are used, the deprecated Min and Max are not
+// reliably ordered, and neither they nor bloom filters are used for opaque
+// columns. The bloom filter of the chunk, if it has one, is only read when the
+// filter asks for it.
+func columnStats(f *parquet.File, c column, rg *format.RowGroup, md *format.ColumnMetaData) filter.Stats {
+	st := filter.Stats{Rows: rg.NumRows, Repeated: c.repeated}
+
+	// Writers leave a zero null count out, and an absent null count decodes
+	// as zero, so only a positive count is known to have been written
+	s := md.Statistics
+	if s.NullCount > 0 {
+		st.NullCount, st.HasNullCount = s.NullCount, true
+	}
+
+	if c.opaque {
+		return st
+	}
+
+	if s.MinValue != nil && s.MaxValue != nil {
+		lo, okLo := decodeStat(c.kind, s.MinValue)
+		hi, okHi := decodeStat(c.kind, s.MaxValue)
+		if okLo && okHi {
+			st.Min, st.Max, st.HasMinMax = c.value(lo), c.value(hi), true
+		}
+	}
+
+	if md.BloomFilterOffset > 0 {
+		var (
+			once sync.Once
+			bf   bloom.SplitBlockFilter
+		)
+		st.MayContain = func(v any) bool {
+			b, ok := c.encode(v)
+			if !ok {
+				return true
+			}
+
+			once.Do(func() {
+				bf, _ = readBloomFilter(f, md.BloomFilterOffset, f.Size())
+			})
+
+			// A filter that could not be read rules nothing out
+			return bf == nil || bf.Check(xxhash.Sum64(b))
+		}
+	}
+
+	return st
+}
+
+// bloomFilterHeaderSize bounds the size of a bloom filter header, so it is
+// fetched along with the start of the bitset in a single read.
+const bloomFilterHeaderSize = 256
+
+// readBloomFilter reads the split block bloom filter at an offset of a file of
+// size bytes. A filter whose size is not a whole number of blocks or which
+// would extend past the end of the file is rejected.
+func readBloomFilter(r io.ReaderAt, offset, size int64) (bloom.SplitBlockFilter, error) {
+	if offset < 0 || offset >= size {
+		return nil, fmt.Errorf("bloom filter offset %d is outside the file", offset)
+	}
+
+	b := make([]byte, min(bloomFilterHeaderSize, size-offset))
+	n, err := r.ReadAt(b, offset)
+	if n == 0 {
+		return nil, fmt.Errorf("failed to read bloom filter header: %w", err)
+	}
+
+	var (
+		br     = bytes.NewReader(b[:n])
+		header format.BloomFilterHeader
+	)
+	if err := thrift.NewDecoder(new(thrift.CompactProtocol).NewReader(br)).Decode(&header); err != nil {
+		return nil, fmt.Errorf("failed to read bloom filter header: %w", err)
+	}
+
+	if header.Algorithm.Block == nil || header.Hash.XxHash == nil || header.Compression.Uncompressed == nil {
+		return nil, errors.New("unsupported bloom filter")
+	}
+
+	// The bitset follows the header
+	start := offset + int64(n-br.Len())
+	if header.NumBytes <= 0 || header.NumBytes%bloom.BlockSize != 0 || int64(header.NumBytes) > size-start {
+		return nil, fmt.Errorf("invalid bloom filter size %d", header.NumBytes)
+	}
+
+	data := make([]byte, header.NumBytes)
+	if _, err := r.ReadAt(data, start); err != nil {
+		return nil, fmt.Errorf("failed to read bloom filter: %w", err)
+	}
+
+	return bloom.MakeSplitBlockFilter(data), nil
+}
+
+// decodeStat decodes a plain encoded statistics value of a physical type.
+func decodeStat(kind parquet.Kind, b []byte) (any, bool) {
+	switch kind {
+	case parquet.Boolean:
+		if len(b) != 1 {
+			return nil, false
+		}
+		return b[0] != 0, true
+	case parquet.Int32:
+		if len(b) != 4 {
+			return nil, false
+		}
+		return int64(int32(binary.LittleEndian.Uint32(b))), true
+	case parquet.Int64:
+		if len(b) != 8 {
+			return nil, false
+		}
+		return int64(binary.LittleEndian.Uint64(b)), true
+	case parquet.Float:
+		if len(b) != 4 {
+			return nil, false
+		}
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))), true
+	case parquet.Double:
+		if len(b) != 8 {
+			return nil, false
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), true
+	case parquet.ByteArray, parquet.FixedLenByteArray:
+		return string(b), true
+	default:
+		return nil, false
+	}
+}
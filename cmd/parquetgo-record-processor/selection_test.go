@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/bloom"
+	"github.com/parquet-go/parquet-go/encoding/thrift"
+	"github.com/parquet-go/parquet-go/format"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/dataset"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/s3test"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/schema"
+)
+
+// mixedCaseAccount is a row of a file whose columns are not lower case.
+type mixedCaseAccount struct {
+	ID            string  `parquet:"ID"`
+	AccountStatus string  `parquet:"AccountStatus"`
+	Nickname      *string `parquet:"Nickname,optional"`
+	Address       struct {
+		City string `parquet:"City"`
+	} `parquet:"Address"`
+}
+
+// TestSelectionMatchesColumnsOfAnyCase selects and filters the columns of a
+// dynamic dataset by lower case paths and checks records keep the case of the
+// columns of the file.
+func TestSelectionMatchesColumnsOfAnyCase(t *testing.T) {
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	w := parquet.NewGenericWriter[mixedCaseAccount](&buf)
+	nickname := "ada"
+	for i, status := range []string{"active", "closed", "active", "closed"} {
+		row := mixedCaseAccount{ID: []string{"a", "b", "c", "d"}[i], AccountStatus: status}
+		row.Address.City = "Denver"
+		if i < 2 {
+			row.Nickname = &nickname
+		}
+		if _, err := w.Write([]mixedCaseAccount{row}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := s3test.NewServer(t)
+	srv.Put("bucket", "accounts.parquet", buf.Bytes())
+
+	pf, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry, err := schema.NewRegistry(schema.Version{Name: "accounts", Version: 1, Columns: schema.Describe(pf.Schema())})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	datasets := dataset.NewRegistry()
+	dataset.RegisterDynamic(datasets, "accounts", dataset.Options{})
+	ds, err := datasets.Lookup("accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		columns []string
+		filter  string
+		want    []string
+	}{
+		{
+			name:    "filtered by a mixed case column",
+			columns: []string{"id", "accountstatus", "address.city"},
+			filter:  "AccountStatus = 'active'",
+			want:    []string{`{"AccountStatus":"active","Address":{"City":"Denver"},"ID":"a"}`, `{"AccountStatus":"active","Address":{"City":"Denver"},"ID":"c"}`},
+		},
+		{
+			name:    "null mixed case column",
+			columns: []string{"ID"},
+			filter:  "nickname IS NULL",
+			want:    []string{`{"ID":"c"}`, `{"ID":"d"}`},
+		},
+		{
+			name:    "group of mixed case columns",
+			columns: []string{"address"},
+			filter:  "id IN ('b')",
+			want:    []string{`{"Address":{"City":"Denver"}}`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sel, err := newSelection(tt.columns, tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rec := &recorder{}
+			job := testFileJob(t, srv, rec)
+			job.registry = registry
+			job.dataset = ds
+			job.sel = sel
+
+			report, _ := job.run(ctx, "accounts.parquet", nil)
+			if report.Status != filePublished {
+				t.Fatalf("report = %+v", report)
+			}
+
+			var got []string
+			for _, env := range rec.envelopes(t) {
+				for _, raw := range env.Records {
+					// Compact through a map to order the keys
+					var record map[string]any
+					if err := json.Unmarshal(raw, &record); err != nil {
+						t.Fatal(err)
+					}
+					data, err := json.Marshal(record)
+					if err != nil {
+						t.Fatal(err)
+					}
+					got = append(got, string(data))
+				}
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("published %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("published %q, want %q", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// pruningRow is a row of a file whose row groups are pruned by their
+// statistics.
+type pruningRow struct {
+	ID       int64     `parquet:"id"`
+	Name     string    `parquet:"name"`
+	Nickname *string   `parquet:"nickname,optional"`
+	Day      int32     `parquet:"day,date"`
+	Millis   time.Time `parquet:"millis,timestamp(millisecond)"`
+	Micros   time.Time `parquet:"micros,timestamp(microsecond)"`
+	Nanos    time.Time `parquet:"nanos,timestamp(nanosecond)"`
+	Tags     []*string `parquet:"tags,list"`
+}
+
+// pruningFile writes three row groups of two rows each:
+//
+//	0: ids 1-2, names a and b, nicknames, days and times in January
+//	1: ids 3-4, names c and e, no nicknames, days and times in February
+//	2: ids 5-6, names f and g, a nickname, days and times in March
+//
+// with a bloom filter on the names. Every row group holds a null tag.
+func pruningFile(t *testing.T) *parquet.File {
+	t.Helper()
+
+	nickname, tag := "ada", "tag"
+	var rows []pruningRow
+	for i, name := range []string{"a", "b", "c", "e", "f", "g"} {
+		month := time.Month(i/2 + 1)
+		at := time.Date(2024, month, 1, i%2, 0, 0, 0, time.UTC)
+		row := pruningRow{
+			ID:     int64(i + 1),
+			Name:   name,
+			Day:    int32(time.Date(2024, month, 1+i%2, 0, 0, 0, 0, time.UTC).Unix() / 86400),
+			Millis: at,
+			Micros: at,
+			Nanos:  at,
+		}
+		if i < 2 || i == 5 {
+			row.Nickname = &nickname
+		}
+		// A row of nulls next to a row with a value, more nulls than rows
+		if i%2 == 0 {
+			row.Tags = []*string{nil, nil, nil}
+		} else {
+			row.Tags = []*string{&tag}
+		}
+		rows = append(rows, row)
+	}
+
+	var buf bytes.Buffer
+	w := parquet.NewGenericWriter[pruningRow](&buf, parquet.MaxRowsPerRowGroup(2), parquet.BloomFilters(parquet.SplitBlockFilter(10, "name")))
+	if _, err := w.Write(rows); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(f.Metadata().RowGroups); n != 3 {
+		t.Fatalf("wrote %d row groups, want 3", n)
+	}
+	return f
+}
+
+func TestSelectionPrunesRowGroups(t *testing.T) {
+	f := pruningFile(t)
+	columns := fileColumns(f)
+
+	tests := []struct {
+		filter string
+		read   []int
+	}{
+		// Min/max
+		{"id = 3", []int{1}},
+		{"id > 4", []int{2}},
+		{"id <= 2 OR id = 6", []int{0, 2}},
+		{"id IN (1, 6)", []int{0, 2}},
+		{"id = 7", nil},
+		{"name >= 'c' AND name < 'f'", []int{1}},
+
+		// The bloom filter rules out values within min/max
+		{"name = 'd'", nil},
+		{"name = 'e'", []int{1}},
+		{"name IN ('d', 'f')", []int{2}},
+
+		// Only positive null counts are known, every row group may hold a
+		// null nickname but the second one holds nothing else
+		{"nickname IS NULL", []int{0, 1, 2}},
+		{"nickname IS NOT NULL", []int{0, 2}},
+		{"nickname = 'ada'", []int{0, 2}},
+
+		// Null counts of lists count values, not rows
+		{"tags.list.element IS NOT NULL", []int{0, 1, 2}},
+
+		// Dates
+		{"day = DATE '2024-02-01'", []int{1}},
+		{"day < DATE '2024-01-02'", []int{0}},
+		{"day > '2024-02-02'", []int{2}},
+
+		// Timestamps of every unit
+		{"millis = TIMESTAMP '2024-02-01T01:00:00Z'", []int{1}},
+		{"micros >= TIMESTAMP '2024-02-01T00:30:00Z'", []int{1, 2}},
+		{"nanos < DATE '2024-01-01' + INTERVAL '1 second'", []int{0}},
+		{"millis = TIMESTAMP '2024-01-01T00:30:00Z'", []int{0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			sel, err := newSelection(nil, tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var read []int
+			for i := range f.Metadata().RowGroups {
+				if sel.mayMatch(f, columns, &f.Metadata().RowGroups[i]) {
+					read = append(read, i)
+				}
+			}
+
+			if !slices.Equal(read, tt.read) {
+				t.Fatalf("read row groups %v, want %v", read, tt.read)
+			}
+		})
+	}
+}
+
+// opaqueRow is a row of a file whose columns are of logical types that
+// filters do not understand.
+type opaqueRow struct {
+	ID     int64  `parquet:"id"`
+	Amount int32  `parquet:"amount,decimal(2:9)"`
+	Big    int64  `parquet:"big,decimal(2:18)"`
+	Count  uint64 `parquet:"count"`
+	Small  uint32 `parquet:"small"`
+}
+
+func TestSelectionNeverPrunesByOpaqueColumns(t *testing.T) {
+	// Unscaled decimals of 1.50 and 2.50, and unsigned values that read as
+	// negative when taken for signed
+	rows := []opaqueRow{
+		{ID: 1, Amount: 150, Big: 150, Count: 1<<63 + 5, Small: 3_000_000_000},
+		{ID: 2, Amount: 250, Big: 250, Count: 1<<63 + 6, Small: 3_000_000_001},
+	}
+
+	var buf bytes.Buffer
+	w := parquet.NewGenericWriter[opaqueRow](&buf, parquet.MaxRowsPerRowGroup(1), parquet.BloomFilters(parquet.SplitBlockFilter(10, "amount"), parquet.SplitBlockFilter(10, "count")))
+	if _, err := w.Write(rows); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	columns := fileColumns(f)
+	for path, opaque := range map[string]bool{"id": false, "amount": true, "big": true, "count": true, "small": true} {
+		if columns[path].opaque != opaque {
+			t.Fatalf("column %s opaque = %t, want %t", path, columns[path].opaque, opaque)
+		}
+	}
+
+	// Each of these would rule out every row group judging from raw
+	// statistics, though the rows match
+	for _, expr := range []string{"amount < 10", "big < 10", "amount = 1.5", "count > 0", "small > 0"} {
+		t.Run(expr, func(t *testing.T) {
+			sel, err := newSelection(nil, expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := range f.Metadata().RowGroups {
+				if !sel.mayMatch(f, columns, &f.Metadata().RowGroups[i]) {
+					t.Fatalf("pruned row group %d", i)
+				}
+			}
+		})
+	}
+}
+
+func TestReadBloomFilterRejectsInvalidSizes(t *testing.T) {
+	// file returns a file holding a bloom filter header claiming numBytes at
+	// offset 8, followed by bitset bytes of the bitset.
+	file := func(numBytes int32, bitset int) []byte {
+		var buf bytes.Buffer
+		buf.Write(make([]byte, 8))
+
+		header := format.BloomFilterHeader{
+			NumBytes:    numBytes,
+			Algorithm:   format.BloomFilterAlgorithm{Block: &format.SplitBlockAlgorithm{}},
+			Hash:        format.BloomFilterHash{XxHash: &format.XxHash{}},
+			Compression: format.BloomFilterCompression{Uncompressed: &format.BloomFilterUncompressed{}},
+		}
+		if err := thrift.NewEncoder(new(thrift.CompactProtocol).NewWriter(&buf)).Encode(&header); err != nil {
+			t.Fatal(err)
+		}
+
+		buf.Write(make([]byte, bitset))
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		offset  int64
+		wantErr bool
+	}{
+		{name: "valid", data: file(2*bloom.BlockSize, 2*bloom.BlockSize), offset: 8},
+		{name: "negative size", data: file(-bloom.BlockSize, 2*bloom.BlockSize), offset: 8, wantErr: true},
+		{name: "empty", data: file(0, 2*bloom.BlockSize), offset: 8, wantErr: true},
+		{name: "partial block", data: file(bloom.BlockSize+1, 2*bloom.BlockSize), offset: 8, wantErr: true},
+		{name: "past the end of the file", data: file(4*bloom.BlockSize, 2*bloom.BlockSize), offset: 8, wantErr: true},
+		{name: "larger than any file", data: file(1<<30, 2*bloom.BlockSize), offset: 8, wantErr: true},
+		{name: "offset past the end of the file", data: file(2*bloom.BlockSize, 2*bloom.BlockSize), offset: 1 << 20, wantErr: true},
+		{name: "negative offset", data: file(2*bloom.BlockSize, 2*bloom.BlockSize), offset: -1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bf, err := readBloomFilter(bytes.NewReader(tt.data), tt.offset, int64(len(tt.data)))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("read a bloom filter of %d bytes, want an error", len(bf))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(bf) != 2 {
+				t.Fatalf("read a bloom filter of %d blocks, want 2", len(bf))
+			}
+		})
+	}
+}
@@ -9,6 +9,12 @@ import (
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/claimcheck"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/envelope"
 )
 
 func main() {
@@ -20,13 +26,54 @@ func main() {
 
 func run(ctx context.Context, stdout io.Writer, getenv func(string) string) error {
 	logger := slog.New(slog.NewJSONHandler(stdout, nil))
-	lambda.Start(handleConsumeRecords(logger))
+
+	// Load aws config
+	awscfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	// Create the S3 client used to resolve claim checked envelopes
+	s3Client := s3.NewFromConfig(awscfg, func(o *s3.Options) {
+		if endpoint := getenv("S3_ENDPOINT_OVERRIDE"); endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	})
+
+	lambda.Start(handleConsumeRecords(logger, s3Client))
 	return nil
 }
 
-func handleConsumeRecords(logger *slog.Logger) func(context.Context, events.SQSEvent) error {
+func handleConsumeRecords(logger *slog.Logger, s3Client claimcheck.GetObjectAPI) func(context.Context, events.SQSEvent) error {
 	return func(ctx context.Context, event events.SQSEvent) error {
 		logger.InfoContext(ctx, "Received SQS event", "count", len(event.Records))
+
+		for _, message := range event.Records {
+			env, err := envelope.Decode([]byte(message.Body))
+			if err != nil {
+				logger.ErrorContext(ctx, "Failed to decode envelope", "message_id", message.MessageId, "error", err)
+				return fmt.Errorf("failed to decode envelope in message %s: %w", message.MessageId, err)
+			}
+
+			// Fetch the records of pointer envelopes from S3
+			claimed := env.Claim != nil
+			if env, err = claimcheck.Resolve(ctx, s3Client, env); err != nil {
+				logger.ErrorContext(ctx, "Failed to resolve claim check", "message_id", message.MessageId, "error", err)
+				return fmt.Errorf("failed to resolve claim check in message %s: %w", message.MessageId, err)
+			}
+
+			logger.InfoContext(ctx, "Received records",
+				"message_id", message.MessageId,
+				"envelope_id", env.ID,
+				"source", env.Source,
+				"schema", env.Schema,
+				"schema_version", env.SchemaVersion,
+				"row_start", env.RowStart,
+				"row_end", env.RowEnd,
+				"claim_checked", claimed,
+				"count", len(env.Records))
+		}
+
 		return nil
 	}
 }
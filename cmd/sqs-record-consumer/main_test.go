@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/claimcheck"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/envelope"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/s3test"
+)
+
+func TestConsumeResolvesPointers(t *testing.T) {
+	ctx := context.Background()
+
+	srv := s3test.NewServer(t)
+	store := claimcheck.New(srv.Client(), "claims", "claim-checks/", nil)
+
+	records, err := envelope.EncodeRecords([]any{
+		map[string]string{"id": "id-0", "body": strings.Repeat("b", 4_096)},
+		map[string]string{"id": "id-1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages, err := envelope.Pack(envelope.Header{Source: "s3://bucket/records.parquet"}, 0, records, envelope.PackOptions{MaxBytes: 1_024, Oversize: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("packed %d messages, want an oversized one and a regular one", len(messages))
+	}
+
+	pointer, err := store.Check(ctx, messages[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var logs bytes.Buffer
+	consume := handleConsumeRecords(slog.New(slog.NewJSONHandler(&logs, nil)), srv.Client())
+
+	event := events.SQSEvent{Records: []events.SQSMessage{
+		{MessageId: "pointer", Body: string(pointer.Body)},
+		{MessageId: "regular", Body: string(messages[1].Body)},
+	}}
+	if err := consume(ctx, event); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both messages are received with their records, only the first one
+	// through its pointer
+	type entry struct {
+		Msg          string `json:"msg"`
+		MessageID    string `json:"message_id"`
+		ClaimChecked bool   `json:"claim_checked"`
+		Count        int    `json:"count"`
+		RowStart     int64  `json:"row_start"`
+	}
+	received := make(map[string]entry)
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var e entry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Msg == "Received records" {
+			received[e.MessageID] = e
+		}
+	}
+
+	if got := received["pointer"]; !got.ClaimChecked || got.Count != 1 || got.RowStart != 0 {
+		t.Errorf("pointer received as %+v, want its claim checked record", got)
+	}
+	if got := received["regular"]; got.ClaimChecked || got.Count != 1 || got.RowStart != 1 {
+		t.Errorf("regular message received as %+v, want it unchanged", got)
+	}
+}
+
+func TestConsumeFailsOnMissingClaim(t *testing.T) {
+	srv := s3test.NewServer(t)
+
+	body, err := json.Marshal(envelope.Envelope{
+		Version: envelope.Version,
+		ID:      "id",
+		Records: []json.RawMessage{},
+		Claim:   &envelope.Claim{Bucket: "claims", Key: "claim-checks/id.json"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var logs bytes.Buffer
+	consume := handleConsumeRecords(slog.New(slog.NewJSONHandler(&logs, nil)), srv.Client())
+
+	event := events.SQSEvent{Records: []events.SQSMessage{{MessageId: "pointer", Body: string(body)}}}
+	if err := consume(context.Background(), event); err == nil {
+		t.Fatal("consumed a pointer to a missing object")
+	}
+}
@@ -0,0 +1,111 @@
+// Package claimcheck stores message bodies that are too large to publish in
+// S3 and replaces them with a small pointer envelope. Consumers resolve the
+// pointer back into the original envelope with Resolve.
+package claimcheck
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/envelope"
+)
+
+// PutObjectAPI is the subset of the S3 client used to store claim checked
+// bodies.
+type PutObjectAPI interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+// GetObjectAPI is the subset of the S3 client used to resolve claim checked
+// bodies.
+type GetObjectAPI interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+}
+
+// Store writes claim checked bodies under a prefix of an S3 bucket.
+type Store struct {
+	client  PutObjectAPI
+	bucket  string
+	prefix  string
+	tagging string
+}
+
+// New creates a store writing to bucket under prefix. Every object is tagged
+// with tags so a bucket lifecycle rule can expire bodies once consumers have
+// had time to read them.
+func New(client PutObjectAPI, bucket, prefix string, tags map[string]string) *Store {
+	values := make(url.Values, len(tags))
+	for k, v := range tags {
+		values.Set(k, v)
+	}
+
+	return &Store{client: client, bucket: bucket, prefix: prefix, tagging: values.Encode()}
+}
+
+// Check uploads the body of a message and returns the message with its body
+// replaced by a pointer envelope. Bodies are keyed by the message ID, so
+// publishing the same rows again overwrites the same object.
+func (s *Store) Check(ctx context.Context, msg envelope.Message) (envelope.Message, error) {
+	key := path.Join(s.prefix, msg.ID+".json")
+
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(msg.Body),
+		ContentType: aws.String("application/json"),
+	}
+	if s.tagging != "" {
+		input.Tagging = aws.String(s.tagging)
+	}
+
+	if _, err := s.client.PutObject(ctx, input); err != nil {
+		return envelope.Message{}, fmt.Errorf("failed to put claim check object %s: %w", key, err)
+	}
+
+	pointer, err := envelope.Pointer(msg, envelope.Claim{Bucket: s.bucket, Key: key, Size: len(msg.Body)})
+	if err != nil {
+		return envelope.Message{}, err
+	}
+
+	return pointer, nil
+}
+
+// Resolve returns the envelope a pointer envelope refers to. Envelopes that
+// are not pointers are returned as they are.
+func Resolve(ctx context.Context, client GetObjectAPI, env envelope.Envelope) (envelope.Envelope, error) {
+	if env.Claim == nil {
+		return env, nil
+	}
+
+	result, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(env.Claim.Bucket),
+		Key:    aws.String(env.Claim.Key),
+	})
+	if err != nil {
+		return envelope.Envelope{}, fmt.Errorf("failed to get claim check object s3://%s/%s: %w", env.Claim.Bucket, env.Claim.Key, err)
+	}
+	defer result.Body.Close()
+
+	body, err := io.ReadAll(result.Body)
+	if err != nil {
+		return envelope.Envelope{}, fmt.Errorf("failed to read claim check object s3://%s/%s: %w", env.Claim.Bucket, env.Claim.Key, err)
+	}
+
+	resolved, err := envelope.Decode(body)
+	if err != nil {
+		return envelope.Envelope{}, err
+	}
+
+	if resolved.ID != env.ID {
+		return envelope.Envelope{}, fmt.Errorf("claim check object s3://%s/%s holds envelope %s, expected %s", env.Claim.Bucket, env.Claim.Key, resolved.ID, env.ID)
+	}
+
+	return resolved, nil
+}
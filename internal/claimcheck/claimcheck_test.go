@@ -0,0 +1,115 @@
+package claimcheck
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/envelope"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/s3test"
+)
+
+// oversized packs a record too large for a 1KB message.
+func oversized(t *testing.T) (envelope.Message, []json.RawMessage) {
+	t.Helper()
+
+	records, err := envelope.EncodeRecords([]any{map[string]string{"id": "id-0", "body": strings.Repeat("b", 4_096)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := envelope.Pack(envelope.Header{Source: "s3://bucket/records.parquet", ETag: "etag"}, 0, records, envelope.PackOptions{MaxBytes: 1_024, Oversize: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || len(messages[0].Body) <= 1_024 {
+		t.Fatalf("packed %d messages, want a single oversized one", len(messages))
+	}
+
+	return messages[0], records
+}
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+
+	srv := s3test.NewServer(t)
+	store := New(srv.Client(), "claims", "claim-checks/", map[string]string{"lifecycle": "claim-check"})
+
+	msg, records := oversized(t)
+	pointer, err := store.Check(ctx, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pointer.Body) >= 1_024 || pointer.ID != msg.ID || pointer.RowStart != msg.RowStart || pointer.RowEnd != msg.RowEnd {
+		t.Fatalf("pointer = %+v, want a small message keeping the ID and rows", pointer)
+	}
+
+	env, err := envelope.Decode(pointer.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Claim == nil || env.Claim.Bucket != "claims" || env.Claim.Key != "claim-checks/"+msg.ID+".json" || len(env.Records) != 0 {
+		t.Fatalf("pointer envelope = %+v", env)
+	}
+	if _, ok := srv.Object("claims", env.Claim.Key); !ok {
+		t.Fatalf("claim checked body not stored at %s", env.Claim.Key)
+	}
+
+	resolved, err := Resolve(ctx, srv.Client(), env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved.ID != msg.ID || resolved.Claim != nil || !slices.EqualFunc(resolved.Records, records, func(a, b json.RawMessage) bool { return string(a) == string(b) }) {
+		t.Fatalf("resolved envelope = %+v, want the original records", resolved)
+	}
+}
+
+func TestResolveMissingObject(t *testing.T) {
+	srv := s3test.NewServer(t)
+
+	env := envelope.Envelope{ID: "id", Claim: &envelope.Claim{Bucket: "claims", Key: "claim-checks/missing.json"}}
+	if _, err := Resolve(context.Background(), srv.Client(), env); err == nil {
+		t.Fatal("resolved a pointer to a missing object")
+	}
+}
+
+func TestResolveOtherEnvelope(t *testing.T) {
+	ctx := context.Background()
+
+	srv := s3test.NewServer(t)
+	store := New(srv.Client(), "claims", "claim-checks/", nil)
+
+	msg, _ := oversized(t)
+	pointer, err := store.Check(ctx, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := envelope.Decode(pointer.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A pointer must resolve to the envelope it was made from
+	env.ID = "other"
+	if _, err := Resolve(ctx, srv.Client(), env); err == nil {
+		t.Fatal("resolved a pointer to the body of another envelope")
+	}
+}
+
+func TestResolvePassesEnvelopesThrough(t *testing.T) {
+	srv := s3test.NewServer(t)
+
+	env := envelope.Envelope{ID: "id", Source: "s3://bucket/records.parquet", Records: []json.RawMessage{json.RawMessage(`{"id":"id-0"}`)}}
+	resolved, err := Resolve(context.Background(), srv.Client(), env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved.ID != env.ID || len(resolved.Records) != 1 || string(resolved.Records[0]) != `{"id":"id-0"}` {
+		t.Fatalf("resolved envelope = %+v, want it unchanged", resolved)
+	}
+	if n := len(srv.Requests()); n != 0 {
+		t.Fatalf("made %d requests for an envelope that is not a pointer", n)
+	}
+}
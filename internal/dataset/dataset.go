@@ -0,0 +1,214 @@
+// Package dataset registers the datasets records are published from. A
+// dataset ties the files of one kind of record to the type their rows are read
+// into, the registered schema they are checked against, the field messages
+// are keyed by, the transforms applied before publishing and the personal
+// information its fields hold, so a new kind of record is published by
+// registering it rather than by a new processor.
+package dataset
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"sync"
+
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/redact"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/rowsource"
+)
+
+// Transform transforms a record before it is published.
+type Transform func(record any) (any, error)
+
+// Options configures a dataset.
+type Options struct {
+	// Schema is the name of the registered schema the files of the dataset
+	// are checked against. Defaults to the name of the dataset.
+	Schema string
+
+	// KeyField is the record field, such as account_type, whose value keys
+	// the messages of the dataset, used as the FIFO message group or Kinesis
+	// partition key. The configured default is used if it is empty.
+	KeyField string
+
+	// Transforms are applied in order to every record of the dataset before
+	// it is published.
+	Transforms []Transform
+
+	// Classes classify the personal information held by the fields of the
+	// dataset, on top of the pii tags of its type. Datasets without a type
+	// are only classified by them.
+	Classes redact.Classes
+}
+
+// Dataset is a registered dataset.
+type Dataset struct {
+	// Name is the name requests refer to the dataset by.
+	Name string
+
+	Options
+
+	// open opens a source reading the rows of a file of the dataset.
+	open func(f *parquet.File, opts rowsource.ParquetOptions) (rowsource.RowSource[any], error)
+
+	// decode converts a row held as a map into a record of the dataset.
+	decode func(row map[string]any) (any, error)
+
+	// fingerprints are those of the configured transforms, see
+	// Registry.AddTransforms.
+	fingerprints []string
+}
+
+// Open opens a source reading the selected rows and columns of a parquet file
+// of the dataset.
+func (d Dataset) Open(f *parquet.File, opts rowsource.ParquetOptions) (rowsource.RowSource[any], error) {
+	return d.open(f, opts)
+}
+
+// Decode converts a row held as a map keyed by column name, such as one
+// scanned from a SQL query, into a record of the dataset, so it is published
+// the same way as a row read from the file.
+func (d Dataset) Decode(row map[string]any) (any, error) {
+	return d.decode(row)
+}
+
+// Fingerprint returns a fingerprint of the configuration the records of the
+// dataset are published with to a destination redacted by policy, which may
+// be nil. It changes when the configured transforms or the policy change, so
+// the same rows published differently are not deduplicated against each
+// other. Transforms registered in code are not part of it.
+func (d Dataset) Fingerprint(policy *redact.Policy) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s", d.Name, d.KeyField)
+	for _, f := range d.fingerprints {
+		fmt.Fprintf(h, "\x00%s", f)
+	}
+	fmt.Fprintf(h, "\x00%s", policy.Fingerprint())
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Transform applies the transforms of the dataset to a record.
+func (d Dataset) Transform(record any) (any, error) {
+	for _, t := range d.Transforms {
+		var err error
+		if record, err = t(record); err != nil {
+			return nil, err
+		}
+	}
+	return record, nil
+}
+
+// Registry holds datasets by name.
+type Registry struct {
+	mu       sync.RWMutex
+	datasets map[string]Dataset
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{datasets: make(map[string]Dataset)}
+}
+
+// Register registers a dataset whose rows are read into values of type T. The
+// files of the dataset must be readable as T, and its fields are classified
+// by the pii tags of T. It panics if the name is already registered or a tag
+// is invalid, as registration happens at start up.
+func Register[T any](r *Registry, name string, opts Options) {
+	classes, err := redact.ClassesOf(reflect.TypeFor[T]())
+	if err != nil {
+		panic(fmt.Sprintf("dataset %s: %v", name, err))
+	}
+	maps.Copy(classes, opts.Classes)
+	opts.Classes = classes
+
+	r.add(name, opts, func(f *parquet.File, opts rowsource.ParquetOptions) (rowsource.RowSource[any], error) {
+		src, err := rowsource.NewParquet[T](f, opts)
+		if err != nil {
+			return nil, err
+		}
+		return rowsource.Any[T](src), nil
+	}, decodeAs[T])
+}
+
+// RegisterDynamic registers a dataset without a Go type. Its rows are read
+// into maps keyed by column name, so its files are only checked against the
+// registered schema.
+func RegisterDynamic(r *Registry, name string, opts Options) {
+	r.add(name, opts, func(f *parquet.File, opts rowsource.ParquetOptions) (rowsource.RowSource[any], error) {
+		return rowsource.NewDynamicParquet(f, opts)
+	}, func(row map[string]any) (any, error) {
+		return row, nil
+	})
+}
+
+// decodeAs converts a row into a value of type T through its JSON encoding,
+// matching columns to fields by their json tags.
+func decodeAs[T any](row map[string]any) (any, error) {
+	data, err := json.Marshal(row)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode row: %w", err)
+	}
+
+	var v T
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("failed to decode row as %T: %w", v, err)
+	}
+	return v, nil
+}
+
+// add adds a dataset to the registry.
+func (r *Registry) add(name string, opts Options, open func(*parquet.File, rowsource.ParquetOptions) (rowsource.RowSource[any], error), decode func(map[string]any) (any, error)) {
+	if opts.Schema == "" {
+		opts.Schema = name
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.datasets[name]; ok {
+		panic(fmt.Sprintf("dataset %s is registered more than once", name))
+	}
+	r.datasets[name] = Dataset{Name: name, Options: opts, open: open, decode: decode}
+}
+
+// Lookup returns the dataset registered under a name.
+func (r *Registry) Lookup(name string) (Dataset, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	d, ok := r.datasets[name]
+	if !ok {
+		return Dataset{}, fmt.Errorf("unknown dataset %q, expected one of %v", name, slices.Sorted(maps.Keys(r.datasets)))
+	}
+	return d, nil
+}
+
+// AddTransforms appends transforms to those of a registered dataset, such as
+// the configured transform chain of the dataset. The fingerprint identifies
+// their configuration and becomes part of that of the dataset. Records are
+// redacted after they are transformed, so classify returns the classes of the
+// fields of transformed records given those of the records transformed, see
+// transform.Chain.Classify. Classes are left as they are if it is nil.
+func (r *Registry) AddTransforms(name, fingerprint string, classify func(redact.Classes) redact.Classes, transforms ...Transform) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	d, ok := r.datasets[name]
+	if !ok {
+		return fmt.Errorf("unknown dataset %q, expected one of %v", name, slices.Sorted(maps.Keys(r.datasets)))
+	}
+
+	d.Transforms = append(slices.Clip(d.Transforms), transforms...)
+	if classify != nil {
+		d.Classes = classify(d.Classes)
+	}
+	d.fingerprints = append(slices.Clip(d.fingerprints), fingerprint)
+	r.datasets[name] = d
+	return nil
+}
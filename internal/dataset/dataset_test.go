@@ -0,0 +1,58 @@
+package dataset
+
+import (
+	"testing"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/models"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/redact"
+)
+
+func TestFingerprint(t *testing.T) {
+	policies, err := redact.ParsePolicies([]byte(`
+hashed:
+  actions:
+    email: hash
+dropped:
+  actions:
+    email: drop
+`), []byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRegistry()
+	Register[models.Record](r, Records, Options{})
+
+	plain, err := r.Lookup(Records)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.AddTransforms(Records, "chain-a", nil); err != nil {
+		t.Fatal(err)
+	}
+	transformed, err := r.Lookup(Records)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fingerprints := map[string]string{
+		"plain":              plain.Fingerprint(nil),
+		"hashed":             plain.Fingerprint(policies["hashed"]),
+		"dropped":            plain.Fingerprint(policies["dropped"]),
+		"transformed":        transformed.Fingerprint(nil),
+		"transformed hashed": transformed.Fingerprint(policies["hashed"]),
+	}
+
+	seen := make(map[string]string)
+	for name, fp := range fingerprints {
+		if other, ok := seen[fp]; ok {
+			t.Errorf("%s and %s have the same fingerprint", name, other)
+		}
+		seen[fp] = name
+	}
+
+	if plain.Fingerprint(policies["hashed"]) != fingerprints["hashed"] {
+		t.Error("the same configuration got different fingerprints")
+	}
+}
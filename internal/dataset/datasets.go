@@ -0,0 +1,15 @@
+package dataset
+
+import "github.com/jsmithdenverdev/poc-parquet-publisher/internal/models"
+
+// Records is the name of the dataset of models.Record, used by requests that
+// do not name a dataset.
+const Records = "records"
+
+// Default holds the datasets the processors publish. New datasets are added
+// here, along with their schema in the schema registry.
+var Default = NewRegistry()
+
+func init() {
+	Register[models.Record](Default, Records, Options{Schema: "record"})
+}
@@ -0,0 +1,67 @@
+package envelope
+
+import "strconv"
+
+// Attribute data types, as understood by SQS and SNS.
+const (
+	AttributeString = "String"
+	AttributeNumber = "Number"
+	AttributeBinary = "Binary"
+)
+
+// Names of the header attributes set on every message.
+const (
+	AttributeSource   = "source"
+	AttributeETag     = "etag"
+	AttributeSchema   = "schema"
+	AttributeRowStart = "row_start"
+	AttributeRowEnd   = "row_end"
+)
+
+// HeaderAttributes is the maximum number of header attributes set on a
+// message.
+const HeaderAttributes = 5
+
+// Attribute is a typed message attribute. Subscribers can filter on
+// attributes without decoding the message body.
+type Attribute struct {
+	// Type is the data type of the attribute, one of AttributeString,
+	// AttributeNumber or AttributeBinary.
+	Type string
+
+	// Value is the attribute value. Binary values hold the raw bytes.
+	Value string
+}
+
+// Attributes are the message attributes of a message keyed by name.
+type Attributes map[string]Attribute
+
+// Size returns the size of the attributes as counted toward the message size
+// by SQS and SNS, the sum of every name, data type and value.
+func (a Attributes) Size() int {
+	var size int
+	for name, attr := range a {
+		size += len(name) + len(attr.Type) + len(attr.Value)
+	}
+	return size
+}
+
+// headerAttributes returns the attributes describing where the records of an
+// envelope came from.
+func headerAttributes(header Header, rowStart, rowEnd int64) Attributes {
+	attrs := Attributes{
+		AttributeSource:   {Type: AttributeString, Value: header.Source},
+		AttributeRowStart: {Type: AttributeNumber, Value: strconv.FormatInt(rowStart, 10)},
+		AttributeRowEnd:   {Type: AttributeNumber, Value: strconv.FormatInt(rowEnd, 10)},
+	}
+
+	// Empty values are rejected by SQS and SNS
+	if header.ETag != "" {
+		attrs[AttributeETag] = Attribute{Type: AttributeString, Value: header.ETag}
+	}
+	if header.Schema != "" {
+		attrs[AttributeSchema] = Attribute{Type: AttributeString, Value: header.Schema}
+	}
+
+	return attrs
+}
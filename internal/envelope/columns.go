@@ -0,0 +1,169 @@
+package envelope
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/fields"
+)
+
+// attributeNamePattern matches the characters SQS and SNS allow in message
+// attribute names.
+var attributeNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,256}$`)
+
+// attributeColumn maps a record field to a message attribute.
+type attributeColumn struct {
+	// name is the name of the message attribute.
+	name string
+
+	// path is the dotted path of the record field, see fields.Value.
+	path string
+}
+
+// AttributeColumns are the record fields published as message attributes.
+// They are configured as a comma separated list of field paths, each
+// optionally prefixed with an attribute name, such as
+// "account_status,account_type,country=address.country". Without a name the
+// path is used as the attribute name.
+type AttributeColumns []attributeColumn
+
+// UnmarshalText parses and validates the attribute columns.
+func (c *AttributeColumns) UnmarshalText(text []byte) error {
+	var columns AttributeColumns
+	seen := make(map[string]bool)
+
+	for _, spec := range strings.Split(string(text), ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		name, path, ok := strings.Cut(spec, "=")
+		if !ok {
+			path = name
+		}
+		name, path = strings.TrimSpace(name), strings.TrimSpace(path)
+
+		if err := validateAttributeName(name); err != nil {
+			return err
+		}
+		if seen[name] {
+			return fmt.Errorf("duplicate message attribute %q", name)
+		}
+		seen[name] = true
+
+		columns = append(columns, attributeColumn{name: name, path: path})
+	}
+
+	*c = columns
+	return nil
+}
+
+// validateAttributeName checks that a message attribute name is accepted by
+// SQS and SNS and does not collide with the envelope header attributes.
+func validateAttributeName(name string) error {
+	lower := strings.ToLower(name)
+
+	switch {
+	case !attributeNamePattern.MatchString(name):
+		return fmt.Errorf("invalid message attribute name %q", name)
+	case strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".") || strings.Contains(name, ".."):
+		return fmt.Errorf("invalid message attribute name %q: misplaced period", name)
+	case strings.HasPrefix(lower, "aws.") || strings.HasPrefix(lower, "amazon."):
+		return fmt.Errorf("invalid message attribute name %q: reserved prefix", name)
+	}
+
+	switch name {
+	case AttributeSource, AttributeETag, AttributeSchema, AttributeRowStart, AttributeRowEnd:
+		return fmt.Errorf("message attribute %q is reserved for the envelope header", name)
+	}
+
+	return nil
+}
+
+// Attributes returns the message attributes of a record. Fields that are
+// missing, null or empty are left out, SQS and SNS reject empty values.
+func (c AttributeColumns) Attributes(record any) Attributes {
+	if len(c) == 0 {
+		return nil
+	}
+
+	attrs := make(Attributes, len(c))
+	for _, column := range c {
+		v, ok := fields.Value(record, column.path)
+		if !ok || v == nil {
+			continue
+		}
+
+		if attr, ok := attributeValue(v); ok {
+			attrs[column.name] = attr
+		}
+	}
+
+	return attrs
+}
+
+// AttributesOf returns the message attributes of every record, or nil when no
+// attribute columns are configured.
+func (c AttributeColumns) AttributesOf(records []any) []Attributes {
+	if len(c) == 0 {
+		return nil
+	}
+
+	attrs := make([]Attributes, len(records))
+	for i, record := range records {
+		attrs[i] = c.Attributes(record)
+	}
+
+	return attrs
+}
+
+// Number attributes of SQS and SNS hold magnitudes between 10^-128 and
+// 10^126, larger or smaller floats are published as String attributes.
+const (
+	minNumberAttribute = 1e-128
+	maxNumberAttribute = 1e126
+)
+
+// attributeValue converts a field value to a typed message attribute. Numbers
+// become Number attributes so subscribers can filter on ranges, byte slices
+// become Binary attributes and everything else that has a scalar form
+// becomes a String attribute. NaN and infinite floats have no attribute.
+func attributeValue(v any) (Attribute, bool) {
+	switch v := v.(type) {
+	case time.Time:
+		return Attribute{Type: AttributeString, Value: v.UTC().Format(time.RFC3339Nano)}, true
+	case []byte:
+		return Attribute{Type: AttributeBinary, Value: string(v)}, len(v) > 0
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String:
+		return Attribute{Type: AttributeString, Value: rv.String()}, rv.Len() > 0
+	case reflect.Bool:
+		return Attribute{Type: AttributeString, Value: strconv.FormatBool(rv.Bool())}, true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return Attribute{Type: AttributeNumber, Value: strconv.FormatInt(rv.Int(), 10)}, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Attribute{Type: AttributeNumber, Value: strconv.FormatUint(rv.Uint(), 10)}, true
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return Attribute{}, false
+		}
+
+		value := strconv.FormatFloat(f, 'g', -1, rv.Type().Bits())
+		if abs := math.Abs(f); abs != 0 && (abs < minNumberAttribute || abs > maxNumberAttribute) {
+			return Attribute{Type: AttributeString, Value: value}, true
+		}
+		return Attribute{Type: AttributeNumber, Value: value}, true
+	default:
+		return Attribute{}, false
+	}
+}
@@ -0,0 +1,97 @@
+package envelope
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestAttributeColumnsUnmarshalText(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    AttributeColumns
+		wantErr bool
+	}{
+		{text: "", want: nil},
+		{text: "account_type", want: AttributeColumns{{name: "account_type", path: "account_type"}}},
+		{text: " account_type , country = address.country ,", want: AttributeColumns{
+			{name: "account_type", path: "account_type"},
+			{name: "country", path: "address.country"},
+		}},
+		{text: "type=account_type,type=account_status", wantErr: true},
+		{text: "row_start=id", wantErr: true},
+		{text: "AWS.type=account_type", wantErr: true},
+		{text: "account type", wantErr: true},
+		{text: ".type=account_type", wantErr: true},
+		{text: "a..b=account_type", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			var got AttributeColumns
+			err := got.UnmarshalText([]byte(tt.text))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parsed %q as %v, want an error", tt.text, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("parsed %q as %v, want %v", tt.text, got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("parsed %q as %v, want %v", tt.text, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestAttributeValue(t *testing.T) {
+	type status string
+
+	tests := []struct {
+		name   string
+		value  any
+		want   Attribute
+		wantOK bool
+	}{
+		{name: "string", value: "premium", want: Attribute{Type: AttributeString, Value: "premium"}, wantOK: true},
+		{name: "named string", value: status("active"), want: Attribute{Type: AttributeString, Value: "active"}, wantOK: true},
+		{name: "empty string", value: ""},
+		{name: "bool", value: true, want: Attribute{Type: AttributeString, Value: "true"}, wantOK: true},
+		{name: "int", value: int32(-42), want: Attribute{Type: AttributeNumber, Value: "-42"}, wantOK: true},
+		{name: "largest uint", value: uint64(math.MaxUint64), want: Attribute{Type: AttributeNumber, Value: "18446744073709551615"}, wantOK: true},
+		{name: "float", value: 10.5, want: Attribute{Type: AttributeNumber, Value: "10.5"}, wantOK: true},
+		{name: "float32", value: float32(0.1), want: Attribute{Type: AttributeNumber, Value: "0.1"}, wantOK: true},
+		{name: "zero", value: 0.0, want: Attribute{Type: AttributeNumber, Value: "0"}, wantOK: true},
+		{name: "big float", value: 1e100, want: Attribute{Type: AttributeNumber, Value: "1e+100"}, wantOK: true},
+		{name: "too big for a number", value: -1e200, want: Attribute{Type: AttributeString, Value: "-1e+200"}, wantOK: true},
+		{name: "too small for a number", value: 1e-200, want: Attribute{Type: AttributeString, Value: "1e-200"}, wantOK: true},
+		{name: "largest float", value: math.MaxFloat64, want: Attribute{Type: AttributeString, Value: "1.7976931348623157e+308"}, wantOK: true},
+		{name: "nan", value: math.NaN()},
+		{name: "infinity", value: math.Inf(1)},
+		{name: "negative infinity", value: math.Inf(-1)},
+		{name: "time", value: time.Date(2024, 10, 1, 12, 0, 0, 0, time.FixedZone("MDT", -6*60*60)), want: Attribute{Type: AttributeString, Value: "2024-10-01T18:00:00Z"}, wantOK: true},
+		{name: "bytes", value: []byte{0, 1}, want: Attribute{Type: AttributeBinary, Value: "\x00\x01"}, wantOK: true},
+		{name: "empty bytes", value: []byte{}},
+		{name: "slice", value: []string{"email"}},
+		{name: "map", value: map[string]any{"city": "Denver"}},
+		{name: "struct", value: struct{ City string }{City: "Denver"}},
+		{name: "pointer", value: new(int)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := attributeValue(tt.value)
+			if ok != tt.wantOK || ok && got != tt.want {
+				t.Fatalf("attributeValue(%v) = %+v, %v, want %+v, %v", tt.value, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
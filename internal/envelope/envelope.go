@@ -0,0 +1,375 @@
+// Package envelope defines the message format used to publish records. A
+// single message carries many records along with a header describing where
+// they came from.
+package envelope
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+)
+
+// Version is the version of the envelope format written by this package.
+const Version = 1
+
+// ErrRecordTooLarge is returned when a single record cannot fit in a message.
+var ErrRecordTooLarge = errors.New("record too large for a single message")
+
+// Envelope is a message body carrying a contiguous range of records from a
+// source file.
+type Envelope struct {
+	// Version is the envelope format version.
+	Version int `json:"version"`
+
+	// ID is the stable identity of the envelope, see ID.
+	ID string `json:"id"`
+
+	// Source identifies the file the records were read from, such as
+	// s3://bucket/key.
+	Source string `json:"source"`
+
+	// ETag is the ETag of the source file version the records were read from.
+	ETag string `json:"etag,omitempty"`
+
+	// Schema is the fingerprint of the schema of the source file.
+	Schema string `json:"schema,omitempty"`
+
+	// SchemaVersion is the registered schema version the source file was
+	// written with, such as record/v1.
+	SchemaVersion string `json:"schema_version,omitempty"`
+
+	// RowStart is the row number of the first record in the source file.
+	RowStart int64 `json:"row_start"`
+
+	// RowEnd is the row number after the last record in the source file.
+	RowEnd int64 `json:"row_end"`
+
+	// Records are the JSON encoded records. They are empty on a pointer
+	// envelope.
+	Records []json.RawMessage `json:"records"`
+
+	// Claim is set on a pointer envelope, whose records were too large to
+	// publish and were stored elsewhere.
+	Claim *Claim `json:"claim,omitempty"`
+}
+
+// Claim points at where the full envelope of a pointer envelope is stored.
+type Claim struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+
+	// Size is the size of the stored envelope in bytes.
+	Size int `json:"size"`
+}
+
+// Decode decodes a message body into an envelope.
+func Decode(body []byte) (Envelope, error) {
+	var env Envelope
+	if err := json.Unmarshal(body, &env); err != nil {
+		return Envelope{}, fmt.Errorf("failed to decode envelope: %w", err)
+	}
+
+	if env.Version != Version {
+		return Envelope{}, fmt.Errorf("unsupported envelope version %d", env.Version)
+	}
+
+	return env, nil
+}
+
+// ID returns the stable identity of the envelope holding rows [rowStart,
+// rowEnd) of a version of a source file. Publishing the same rows of the same
+// file version again yields the same ID, so it can be used to deduplicate.
+// Rows published with a different selection of columns and rows, or with a
+// different configuration, get a different ID, so a FIFO queue does not drop
+// them as duplicates of the rows published before.
+func ID(header Header, rowStart, rowEnd int64) string {
+	key := fmt.Sprintf("%s\x00%s\x00%d\x00%d", header.Source, header.ETag, rowStart, rowEnd)
+	if header.Selection != "" {
+		key += "\x00" + header.Selection
+	}
+	if header.Config != "" {
+		key += "\x00config:" + header.Config
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Header describes the source file of the records being packed.
+type Header struct {
+	// Source identifies the file, such as s3://bucket/key.
+	Source string
+
+	// ETag is the ETag of the version of the file being read.
+	ETag string
+
+	// Schema is the fingerprint of the schema of the file.
+	Schema string
+
+	// SchemaVersion is the registered schema version the file was written
+	// with.
+	SchemaVersion string
+
+	// Selection identifies the columns and rows of the file being published,
+	// empty when every column of every row is.
+	Selection string
+
+	// Config is the fingerprint of the configuration the records are
+	// published with, such as the transforms of their dataset and the
+	// redaction policy of the destination, see dataset.Dataset.Fingerprint.
+	Config string
+}
+
+// PackOptions controls how records are packed into envelopes.
+type PackOptions struct {
+	// MaxRecords is the maximum number of records in an envelope, or no limit
+	// if it is not positive.
+	MaxRecords int
+
+	// MaxBytes is the maximum encoded size of an envelope.
+	MaxBytes int
+
+	// Rows optionally holds the row number of every record in the source file,
+	// for records that are not contiguous because rows were filtered out. An
+	// envelope then covers the rows from its first record to its last.
+	Rows []int64
+
+	// Keys optionally holds a key for every record. Records with different
+	// keys are never packed into the same envelope, so every envelope can be
+	// routed by the key of its records.
+	Keys []string
+
+	// Oversize allows records larger than MaxBytes. Each one is packed alone
+	// in an envelope exceeding MaxBytes, which must be claim checked before
+	// it is published. Without it such records fail with ErrRecordTooLarge.
+	Oversize bool
+
+	// Attributes optionally holds message attributes for every record. Like
+	// keys, records with different attributes are never packed into the same
+	// envelope. They count toward MaxBytes, as they do on SQS and SNS.
+	Attributes []Attributes
+
+	// NoAttributes leaves every message attribute out, header attributes
+	// included, for sinks that do not publish them. Records are then packed
+	// by the size of their bodies alone.
+	NoAttributes bool
+}
+
+// Message is an encoded envelope ready to be published.
+type Message struct {
+	ID       string
+	Key      string
+	Body     []byte
+	RowStart int64
+	RowEnd   int64
+
+	// Attributes are the message attributes of the envelope, both those of
+	// its records and the fixed header attributes.
+	Attributes Attributes
+}
+
+// Size returns the size of the message as counted by SQS and SNS, the body
+// plus its attributes.
+func (m Message) Size() int {
+	return len(m.Body) + m.Attributes.Size()
+}
+
+// Pack packs JSON encoded records into as few envelopes as possible. The
+// records must be contiguous rows of the source file starting at firstRow,
+// unless their row numbers are given in the options.
+func Pack(header Header, firstRow int64, records []json.RawMessage, opts PackOptions) ([]Message, error) {
+	if opts.Keys != nil && len(opts.Keys) != len(records) {
+		return nil, fmt.Errorf("got %d keys for %d records", len(opts.Keys), len(records))
+	}
+
+	if opts.Rows != nil && len(opts.Rows) != len(records) {
+		return nil, fmt.Errorf("got %d row numbers for %d records", len(opts.Rows), len(records))
+	}
+
+	if opts.Attributes != nil && len(opts.Attributes) != len(records) {
+		return nil, fmt.Errorf("got %d attribute sets for %d records", len(opts.Attributes), len(records))
+	}
+
+	overhead, err := headerSize(header)
+	if err != nil {
+		return nil, err
+	}
+
+	// Header attributes are sized with the widest possible row numbers
+	if opts.NoAttributes {
+		opts.Attributes = nil
+	} else {
+		overhead += headerAttributes(header, math.MinInt64, math.MinInt64).Size()
+	}
+
+	var (
+		messages []Message
+		start    int
+		size     = overhead
+	)
+
+	key := func(i int) string {
+		if opts.Keys == nil {
+			return ""
+		}
+		return opts.Keys[i]
+	}
+
+	row := func(i int) int64 {
+		if opts.Rows == nil {
+			return firstRow + int64(i)
+		}
+		return opts.Rows[i]
+	}
+
+	attributes := func(i int) Attributes {
+		if opts.Attributes == nil {
+			return nil
+		}
+		return opts.Attributes[i]
+	}
+
+	flush := func(end int) error {
+		rowStart, rowEnd := row(start), row(end-1)+1
+		id := ID(header, rowStart, rowEnd)
+
+		body, err := json.Marshal(Envelope{
+			Version:       Version,
+			ID:            id,
+			Source:        header.Source,
+			ETag:          header.ETag,
+			Schema:        header.Schema,
+			SchemaVersion: header.SchemaVersion,
+			RowStart:      rowStart,
+			RowEnd:        rowEnd,
+			Records:       records[start:end],
+		})
+		if err != nil {
+			return fmt.Errorf("failed to marshal envelope: %w", err)
+		}
+
+		var attrs Attributes
+		if !opts.NoAttributes {
+			attrs = headerAttributes(header, rowStart, rowEnd)
+			maps.Copy(attrs, attributes(start))
+		}
+
+		messages = append(messages, Message{
+			ID:         id,
+			Key:        key(start),
+			Body:       body,
+			RowStart:   rowStart,
+			RowEnd:     rowEnd,
+			Attributes: attrs,
+		})
+		start = end
+		size = overhead
+		if end < len(records) {
+			size += attributes(end).Size()
+		}
+		return nil
+	}
+
+	if len(records) > 0 {
+		size += attributes(0).Size()
+	}
+
+	for i, record := range records {
+		// Records are separated by a comma
+		recordSize := len(record) + 1
+		if overhead+attributes(i).Size()+recordSize > opts.MaxBytes {
+			if !opts.Oversize {
+				return nil, fmt.Errorf("row %d is %d bytes: %w", row(i), len(record), ErrRecordTooLarge)
+			}
+
+			// Pack the record alone, flushing the records before it
+			if i > start {
+				if err := flush(i); err != nil {
+					return nil, err
+				}
+			}
+			if err := flush(i + 1); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		full := opts.MaxRecords > 0 && i-start >= opts.MaxRecords
+		split := i > start && (key(i) != key(start) || !maps.Equal(attributes(i), attributes(start)))
+		if full || split || size+recordSize > opts.MaxBytes {
+			if err := flush(i); err != nil {
+				return nil, err
+			}
+		}
+
+		size += recordSize
+	}
+
+	if start < len(records) {
+		if err := flush(len(records)); err != nil {
+			return nil, err
+		}
+	}
+
+	return messages, nil
+}
+
+// Pointer returns a message whose body is a pointer envelope referring to the
+// stored body of msg. The pointer keeps the ID, rows and attributes of the
+// original message.
+func Pointer(msg Message, claim Claim) (Message, error) {
+	env, err := Decode(msg.Body)
+	if err != nil {
+		return Message{}, err
+	}
+
+	env.Records = []json.RawMessage{}
+	env.Claim = &claim
+
+	body, err := json.Marshal(env)
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to marshal pointer envelope: %w", err)
+	}
+
+	msg.Body = body
+	return msg, nil
+}
+
+// headerSize returns an upper bound on the encoded size of an envelope with no
+// records.
+func headerSize(header Header) (int, error) {
+	data, err := json.Marshal(Envelope{
+		Version:       Version,
+		ID:            ID(Header{}, 0, 0),
+		Source:        header.Source,
+		ETag:          header.ETag,
+		Schema:        header.Schema,
+		SchemaVersion: header.SchemaVersion,
+		RowStart:      math.MinInt64,
+		RowEnd:        math.MinInt64,
+		Records:       []json.RawMessage{},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal envelope header: %w", err)
+	}
+
+	return len(data), nil
+}
+
+// EncodeRecords encodes each record to JSON.
+func EncodeRecords(records []any) ([]json.RawMessage, error) {
+	encoded := make([]json.RawMessage, len(records))
+	for i, record := range records {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal record %d to JSON: %w", i, err)
+		}
+		encoded[i] = data
+	}
+
+	return encoded, nil
+}
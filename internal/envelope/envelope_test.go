@@ -0,0 +1,224 @@
+package envelope
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestID(t *testing.T) {
+	base := Header{Source: "s3://bucket/records.parquet", ETag: `"etag"`, Selection: "columns:id", Config: "config-a"}
+
+	if ID(base, 0, 100) != ID(base, 0, 100) {
+		t.Fatal("the same rows got different IDs")
+	}
+
+	tests := []struct {
+		name   string
+		header Header
+		start  int64
+		end    int64
+	}{
+		{name: "other rows", header: base, start: 100, end: 200},
+		{name: "other etag", header: Header{Source: base.Source, ETag: `"other"`, Selection: base.Selection, Config: base.Config}, end: 100},
+		{name: "other selection", header: Header{Source: base.Source, ETag: base.ETag, Config: base.Config}, end: 100},
+		{name: "other config", header: Header{Source: base.Source, ETag: base.ETag, Selection: base.Selection, Config: "config-b"}, end: 100},
+		{name: "no config", header: Header{Source: base.Source, ETag: base.ETag, Selection: base.Selection}, end: 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if ID(tt.header, tt.start, tt.end) == ID(base, 0, 100) {
+				t.Fatal("different rows or configuration got the same ID")
+			}
+		})
+	}
+}
+
+func TestPackNoAttributes(t *testing.T) {
+	header := Header{Source: "s3://bucket/records.parquet", ETag: `"etag"`}
+	records := []json.RawMessage{[]byte(`{"id":"a"}`), []byte(`{"id":"b"}`)}
+
+	messages, err := Pack(header, 0, records, PackOptions{
+		MaxBytes:     1024,
+		Attributes:   []Attributes{{"type": {Type: AttributeString, Value: "a"}}, {"type": {Type: AttributeString, Value: "b"}}},
+		NoAttributes: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Records are not split by attributes that are not published
+	if len(messages) != 1 {
+		t.Fatalf("packed %d messages, want 1", len(messages))
+	}
+	if msg := messages[0]; msg.Attributes != nil || msg.Size() != len(msg.Body) {
+		t.Fatalf("message has attributes %v and size %d, want none and %d", msg.Attributes, msg.Size(), len(msg.Body))
+	}
+}
+
+// testRecords returns n JSON encoded records of size bytes each.
+func testRecords(n, size int) []json.RawMessage {
+	records := make([]json.RawMessage, n)
+	for i := range records {
+		id := fmt.Sprintf("%d", i)
+		records[i] = fmt.Appendf(nil, `{"id":"%s%0*d"}`, id, size-9-len(id), 0)
+	}
+	return records
+}
+
+func TestPackBoundaries(t *testing.T) {
+	header := Header{Source: "s3://bucket/records.parquet", ETag: `"etag"`}
+	overhead, err := headerSize(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every record takes 101 bytes of the envelope, itself and a comma
+	const recordSize = 100
+
+	tests := []struct {
+		name       string
+		records    int
+		maxRecords int
+		maxBytes   int
+		want       []int
+	}{
+		{name: "no records", records: 0, maxBytes: 1024, want: nil},
+		{name: "exactly max records", records: 4, maxRecords: 2, maxBytes: 1 << 20, want: []int{2, 2}},
+		{name: "one past max records", records: 5, maxRecords: 2, maxBytes: 1 << 20, want: []int{2, 2, 1}},
+		{name: "one record per message", records: 3, maxRecords: 1, maxBytes: 1 << 20, want: []int{1, 1, 1}},
+		{name: "exactly max bytes", records: 6, maxBytes: overhead + 3*(recordSize+1), want: []int{3, 3}},
+		{name: "one byte short", records: 6, maxBytes: overhead + 3*(recordSize+1) - 1, want: []int{2, 2, 2}},
+		{name: "records before bytes", records: 6, maxRecords: 2, maxBytes: overhead + 3*(recordSize+1), want: []int{2, 2, 2}},
+		{name: "bytes before records", records: 6, maxRecords: 5, maxBytes: overhead + 3*(recordSize+1), want: []int{3, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records := testRecords(tt.records, recordSize)
+			messages, err := Pack(header, 10, records, PackOptions{
+				MaxRecords:   tt.maxRecords,
+				MaxBytes:     tt.maxBytes,
+				NoAttributes: true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(messages) != len(tt.want) {
+				t.Fatalf("packed %d messages, want %d", len(messages), len(tt.want))
+			}
+
+			row := int64(10)
+			for i, msg := range messages {
+				env, err := Decode(msg.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(env.Records) != tt.want[i] {
+					t.Fatalf("message %d has %d records, want %d", i, len(env.Records), tt.want[i])
+				}
+				if msg.RowStart != row || msg.RowEnd != row+int64(tt.want[i]) {
+					t.Fatalf("message %d has rows [%d, %d), want [%d, %d)", i, msg.RowStart, msg.RowEnd, row, row+int64(tt.want[i]))
+				}
+				if msg.Size() > tt.maxBytes {
+					t.Fatalf("message %d is %d bytes, want at most %d", i, msg.Size(), tt.maxBytes)
+				}
+				row = msg.RowEnd
+			}
+		})
+	}
+}
+
+func TestPackRecordLargerThanMaxBytes(t *testing.T) {
+	header := Header{Source: "s3://bucket/records.parquet", ETag: `"etag"`}
+	overhead, err := headerSize(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := testRecords(3, 100)
+	records[1] = testRecords(1, 1000)[0]
+	opts := PackOptions{MaxBytes: overhead + 500, NoAttributes: true}
+
+	if _, err := Pack(header, 0, records, opts); !errors.Is(err, ErrRecordTooLarge) {
+		t.Fatalf("got error %v, want ErrRecordTooLarge", err)
+	}
+
+	// With oversize records allowed the large record is packed alone
+	opts.Oversize = true
+	messages, err := Pack(header, 0, records, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(messages) != 3 {
+		t.Fatalf("packed %d messages, want 3", len(messages))
+	}
+	for i, msg := range messages {
+		if msg.RowStart != int64(i) || msg.RowEnd != int64(i+1) {
+			t.Fatalf("message %d has rows [%d, %d), want [%d, %d)", i, msg.RowStart, msg.RowEnd, i, i+1)
+		}
+	}
+	if messages[1].Size() <= opts.MaxBytes {
+		t.Fatalf("oversize message is %d bytes, want more than %d", messages[1].Size(), opts.MaxBytes)
+	}
+}
+
+func TestPackRejectsMismatchedOptions(t *testing.T) {
+	header := Header{Source: "s3://bucket/records.parquet"}
+	records := testRecords(3, 100)
+
+	tests := []struct {
+		name string
+		opts PackOptions
+	}{
+		{name: "rows", opts: PackOptions{Rows: []int64{0, 1}}},
+		{name: "keys", opts: PackOptions{Keys: []string{"a", "b", "c", "d"}}},
+		{name: "attributes", opts: PackOptions{Attributes: []Attributes{{}}}},
+		{name: "attributes left out", opts: PackOptions{Attributes: []Attributes{{}}, NoAttributes: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.MaxBytes = 1 << 20
+			if _, err := Pack(header, 0, records, tt.opts); err == nil {
+				t.Fatal("packed records with a mismatched number of options")
+			}
+		})
+	}
+}
+
+func TestPackCountsAttributes(t *testing.T) {
+	header := Header{Source: "s3://bucket/records.parquet", ETag: `"etag"`, Schema: "schema"}
+	records := testRecords(40, 100)
+
+	attrs := make([]Attributes, len(records))
+	for i := range attrs {
+		// Runs of records share their attributes
+		attrs[i] = Attributes{"type": {Type: AttributeString, Value: fmt.Sprintf("type-%d", i/20)}}
+	}
+
+	for maxBytes := 1024; maxBytes < 2048; maxBytes += 10 {
+		messages, err := Pack(header, 0, records, PackOptions{MaxBytes: maxBytes, Attributes: attrs})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var packed int
+		for i, msg := range messages {
+			if msg.Size() > maxBytes {
+				t.Fatalf("max bytes %d: message %d is %d bytes, body %d and attributes %d", maxBytes, i, msg.Size(), len(msg.Body), msg.Attributes.Size())
+			}
+			if msg.Attributes[AttributeSource].Value != header.Source || msg.Attributes["type"] != attrs[msg.RowStart]["type"] {
+				t.Fatalf("max bytes %d: message %d has attributes %v", maxBytes, i, msg.Attributes)
+			}
+			packed += int(msg.RowEnd - msg.RowStart)
+		}
+		if packed != len(records) {
+			t.Fatalf("max bytes %d: packed %d records, want %d", maxBytes, packed, len(records))
+		}
+	}
+}
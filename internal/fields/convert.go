@@ -0,0 +1,56 @@
+package fields
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Map converts a record to a map through its JSON encoding, so every nested
+// value is a map, a slice or a scalar named the way it is published, and
+// numbers keep their precision as json.Number. Maps are converted too, so the
+// map returned never shares values with the record.
+func Map(record any) (map[string]any, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode record: %w", err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var m map[string]any
+	if err := dec.Decode(&m); err != nil {
+		return nil, fmt.Errorf("failed to decode record %T as a map: %w", record, err)
+	}
+
+	return m, nil
+}
+
+// Text returns the text form of a value as it is published.
+func Text(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Hash returns the hex encoded HMAC-SHA256 of the text form of a value, so
+// records can still be joined on it without revealing it.
+func Hash(key []byte, v any) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(Text(v)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,74 @@
+package fields
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/models"
+)
+
+func TestMap(t *testing.T) {
+	r := models.Record{ID: "id-1", AccountBalance: 0.1, Tags: []string{"vip"}}
+	r.Address.City = "Denver"
+
+	m, err := Map(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m["id"] != "id-1" {
+		t.Errorf("id = %v", m["id"])
+	}
+	if n, ok := m["account_balance"].(json.Number); !ok || n.String() != "0.1" {
+		t.Errorf("account_balance = %#v, want json.Number 0.1", m["account_balance"])
+	}
+	if city := m["address"].(map[string]any)["city"]; city != "Denver" {
+		t.Errorf("address.city = %v", city)
+	}
+	if tags, ok := m["tags"].([]any); !ok || len(tags) != 1 || tags[0] != "vip" {
+		t.Errorf("tags = %#v", m["tags"])
+	}
+
+	// Maps are copied
+	again, err := Map(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again["address"].(map[string]any)["city"] = "Boulder"
+	if city := m["address"].(map[string]any)["city"]; city != "Denver" {
+		t.Errorf("address.city of the original = %v, want it left as it is", city)
+	}
+}
+
+func TestText(t *testing.T) {
+	tests := []struct {
+		v    any
+		want string
+	}{
+		{v: "text", want: "text"},
+		{v: json.Number("12.50"), want: "12.50"},
+		{v: time.Date(2024, 10, 1, 12, 0, 0, 5, time.UTC), want: "2024-10-01T12:00:00.000000005Z"},
+		{v: []byte("raw"), want: "raw"},
+		{v: true, want: "true"},
+		{v: 12.5, want: "12.5"},
+	}
+
+	for _, tt := range tests {
+		if got := Text(tt.v); got != tt.want {
+			t.Errorf("Text(%#v) = %q, want %q", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	if Hash([]byte("a"), "x") != Hash([]byte("a"), "x") {
+		t.Error("the same value hashed differently")
+	}
+	if Hash([]byte("a"), "x") == Hash([]byte("b"), "x") {
+		t.Error("different keys hashed the same")
+	}
+	if Hash([]byte("a"), json.Number("1")) != Hash([]byte("a"), "1") {
+		t.Error("a number and its text hashed differently")
+	}
+}
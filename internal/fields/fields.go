@@ -0,0 +1,71 @@
+// Package fields addresses the fields of records by dotted paths, the same
+// way for records decoded into structs and for records held as maps, and
+// converts records and values to the form they are published in.
+package fields
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Value returns the value of a field of a record addressed by a dotted
+// path such as address.country. Struct fields are matched by their json tag,
+// or case insensitively by name, and map entries by key, so the same path
+// works for decoded records and for the projected maps built from them.
+func Value(record any, path string) (any, bool) {
+	v := reflect.ValueOf(record)
+	for _, name := range strings.Split(path, ".") {
+		for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return nil, false
+			}
+			v = v.Elem()
+		}
+
+		switch v.Kind() {
+		case reflect.Struct:
+			field, ok := structField(v, name)
+			if !ok {
+				return nil, false
+			}
+			v = field
+		case reflect.Map:
+			if v.Type().Key().Kind() != reflect.String {
+				return nil, false
+			}
+			v = v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key()))
+			if !v.IsValid() {
+				return nil, false
+			}
+		default:
+			return nil, false
+		}
+	}
+
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, true
+		}
+		v = v.Elem()
+	}
+
+	return v.Interface(), true
+}
+
+// structField returns the field of a struct named name.
+func structField(v reflect.Value, name string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		tag, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if tag == name || (tag == "" && strings.EqualFold(f.Name, name)) {
+			return v.Field(i), true
+		}
+	}
+
+	return reflect.Value{}, false
+}
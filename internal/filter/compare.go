@@ -0,0 +1,117 @@
+package filter
+
+import (
+	"cmp"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// normalize converts a field value to one of the types literals are parsed
+// into: int64, float64, string, bool or time.Time. Pointers are followed and nil is
+// returned for null values. Other values are returned as they are and never
+// compare equal to a literal.
+func normalize(v any) any {
+	if t, ok := v.(time.Time); ok {
+		return t
+	}
+
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u := rv.Uint()
+		if u > 1<<63-1 {
+			return float64(u)
+		}
+		return int64(u)
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	case reflect.String:
+		return rv.String()
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return string(rv.Bytes())
+		}
+	case reflect.Invalid:
+		return nil
+	}
+
+	return rv.Interface()
+}
+
+// compare compares two normalized values. The boolean is false if the values
+// cannot be compared, such as a string with a number or anything with null.
+func compare(a, b any) (int, bool) {
+	switch a := a.(type) {
+	case int64:
+		switch b := b.(type) {
+		case int64:
+			return cmp.Compare(a, b), true
+		case float64:
+			return cmp.Compare(float64(a), b), true
+		}
+	case float64:
+		switch b := b.(type) {
+		case int64:
+			return cmp.Compare(a, float64(b)), true
+		case float64:
+			return cmp.Compare(a, b), true
+		}
+	case string:
+		switch b := b.(type) {
+		case string:
+			return strings.Compare(a, b), true
+		case time.Time:
+			if t, ok := parseTime(a); ok {
+				return t.Compare(b), true
+			}
+		}
+	case time.Time:
+		switch b := b.(type) {
+		case time.Time:
+			return a.Compare(b), true
+		case string:
+			if t, ok := parseTime(b); ok {
+				return a.Compare(t), true
+			}
+		}
+	case bool:
+		if b, ok := b.(bool); ok {
+			switch {
+			case a == b:
+				return 0, true
+			case !a:
+				return -1, true
+			default:
+				return 1, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// timeLayouts are the layouts accepted for time literals and for strings
+// compared with times.
+var timeLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"}
+
+// parseTime parses a time literal. Times without a zone are UTC.
+func parseTime(s string) (time.Time, bool) {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,298 @@
+// Package filter parses and evaluates row filter expressions such as
+//
+//	account_status = 'active' AND account_balance > 100
+//
+// Expressions compare record fields, addressed by dotted paths such as
+// address.country, with literal values. They support =, !=, <>, <, <=, >, >=,
+// IN (...), IS NULL and IS NOT NULL combined with AND, OR, NOT and
+// parentheses. Time literals are written TIMESTAMP '2024-10-01T00:00:00Z',
+// DATE '2024-10-01' or NOW() - INTERVAL '7 days'.
+//
+// Besides matching individual records, an expression can rule out whole row
+// groups from their column statistics: min/max values, null counts and bloom
+// filters.
+package filter
+
+import (
+	"slices"
+	"strings"
+)
+
+// Lookup returns the value of the field of a record at a dotted path. The
+// boolean is false if the record has no such field.
+type Lookup func(path string) (any, bool)
+
+// Stats are the statistics of a column over a set of rows, such as a row
+// group.
+type Stats struct {
+	// Rows is the number of rows the statistics describe.
+	Rows int64
+
+	// Min and Max are the smallest and largest values of the column. They are
+	// only meaningful if HasMinMax is true.
+	Min, Max any
+
+	HasMinMax bool
+
+	// NullCount is the number of null values of the column. It is only
+	// meaningful if HasNullCount is true.
+	NullCount int64
+
+	HasNullCount bool
+
+	// Repeated is true for a column holding any number of values per row,
+	// whose null count counts values rather than rows.
+	Repeated bool
+
+	// MayContain optionally reports whether a value may be present in the
+	// column, typically backed by a bloom filter. It is only consulted for
+	// equality once min/max statistics cannot rule a value out, as it may be
+	// expensive.
+	MayContain func(value any) bool
+}
+
+// allNull reports whether every value described by the statistics is null.
+// It cannot be told for repeated columns, whose null count may exceed the
+// number of rows while some rows hold values.
+func (s Stats) allNull() bool {
+	return s.HasNullCount && !s.Repeated && s.Rows > 0 && s.NullCount >= s.Rows
+}
+
+// StatsLookup returns the statistics of the column at a dotted path. The
+// boolean is false if no statistics are available.
+type StatsLookup func(path string) (Stats, bool)
+
+// Expr is a parsed filter expression.
+type Expr interface {
+	// Eval reports whether a record matches the expression. Comparisons with
+	// missing or null fields never match.
+	Eval(lookup Lookup) bool
+
+	// MayMatch reports whether any row described by the statistics could
+	// match the expression. It only returns false when no row can match.
+	MayMatch(stats StatsLookup) bool
+
+	// Columns returns the paths of the fields the expression refers to.
+	Columns() []string
+}
+
+// Columns returns the distinct paths referenced by an expression in the order
+// they first appear.
+func Columns(expr Expr) []string {
+	var columns []string
+	for _, c := range expr.Columns() {
+		if !slices.Contains(columns, c) {
+			columns = append(columns, c)
+		}
+	}
+	return columns
+}
+
+// andExpr matches when both sides match.
+type andExpr struct {
+	left, right Expr
+}
+
+func (e *andExpr) Eval(lookup Lookup) bool {
+	return e.left.Eval(lookup) && e.right.Eval(lookup)
+}
+
+func (e *andExpr) MayMatch(stats StatsLookup) bool {
+	return e.left.MayMatch(stats) && e.right.MayMatch(stats)
+}
+
+func (e *andExpr) Columns() []string {
+	return append(e.left.Columns(), e.right.Columns()...)
+}
+
+// orExpr matches when either side matches.
+type orExpr struct {
+	left, right Expr
+}
+
+func (e *orExpr) Eval(lookup Lookup) bool {
+	return e.left.Eval(lookup) || e.right.Eval(lookup)
+}
+
+func (e *orExpr) MayMatch(stats StatsLookup) bool {
+	return e.left.MayMatch(stats) || e.right.MayMatch(stats)
+}
+
+func (e *orExpr) Columns() []string {
+	return append(e.left.Columns(), e.right.Columns()...)
+}
+
+// notExpr matches when its operand does not.
+type notExpr struct {
+	expr Expr
+}
+
+func (e *notExpr) Eval(lookup Lookup) bool {
+	return !e.expr.Eval(lookup)
+}
+
+// MayMatch cannot be derived from the operand, statistics that rule out every
+// row matching the operand say nothing about rows not matching it.
+func (e *notExpr) MayMatch(StatsLookup) bool {
+	return true
+}
+
+func (e *notExpr) Columns() []string {
+	return e.expr.Columns()
+}
+
+// compareExpr compares a field with a literal.
+type compareExpr struct {
+	path  string
+	op    string
+	value any
+}
+
+func (e *compareExpr) Eval(lookup Lookup) bool {
+	v, ok := lookup(e.path)
+	if !ok {
+		return false
+	}
+
+	c, ok := compare(normalize(v), e.value)
+	if !ok {
+		return false
+	}
+
+	switch e.op {
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	default:
+		return false
+	}
+}
+
+func (e *compareExpr) MayMatch(stats StatsLookup) bool {
+	s, ok := stats(e.path)
+	if !ok {
+		return true
+	}
+
+	// Comparisons with null never match
+	if s.allNull() {
+		return false
+	}
+
+	// The bloom filter is only consulted once min/max cannot rule the value
+	// out
+	mayContain := func() bool {
+		return e.op != "=" || s.MayContain == nil || s.MayContain(e.value)
+	}
+
+	if !s.HasMinMax {
+		return mayContain()
+	}
+
+	lo, okLo := compare(normalize(s.Min), e.value)
+	hi, okHi := compare(normalize(s.Max), e.value)
+	if !okLo || !okHi {
+		return mayContain()
+	}
+
+	switch e.op {
+	case "=":
+		return lo <= 0 && hi >= 0 && mayContain()
+	case "!=":
+		return lo != 0 || hi != 0
+	case "<":
+		return lo < 0
+	case "<=":
+		return lo <= 0
+	case ">":
+		return hi > 0
+	case ">=":
+		return hi >= 0
+	default:
+		return true
+	}
+}
+
+func (e *compareExpr) Columns() []string {
+	return []string{e.path}
+}
+
+// inExpr matches when a field equals any of a list of literals.
+type inExpr struct {
+	path   string
+	values []any
+}
+
+func (e *inExpr) Eval(lookup Lookup) bool {
+	v, ok := lookup(e.path)
+	if !ok {
+		return false
+	}
+
+	v = normalize(v)
+	for _, value := range e.values {
+		if c, ok := compare(v, value); ok && c == 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (e *inExpr) MayMatch(stats StatsLookup) bool {
+	for _, value := range e.values {
+		eq := &compareExpr{path: e.path, op: "=", value: value}
+		if eq.MayMatch(stats) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (e *inExpr) Columns() []string {
+	return []string{e.path}
+}
+
+// nullExpr matches fields that are, or are not, null or missing.
+type nullExpr struct {
+	path string
+	not  bool
+}
+
+func (e *nullExpr) Eval(lookup Lookup) bool {
+	v, ok := lookup(e.path)
+	isNull := !ok || normalize(v) == nil
+	return isNull != e.not
+}
+
+func (e *nullExpr) MayMatch(stats StatsLookup) bool {
+	s, ok := stats(e.path)
+	if !ok || !s.HasNullCount {
+		return true
+	}
+
+	if e.not {
+		return !s.allNull()
+	}
+	return s.NullCount > 0
+}
+
+func (e *nullExpr) Columns() []string {
+	return []string{e.path}
+}
+
+// canonicalPath returns the canonical form of a path, paths are matched case
+// insensitively.
+func canonicalPath(path string) string {
+	return strings.ToLower(path)
+}
@@ -0,0 +1,202 @@
+package filter
+
+import (
+	"testing"
+	"time"
+)
+
+// record looks fields up in a map, as a record would be.
+func record(fields map[string]any) Lookup {
+	return func(path string) (any, bool) {
+		v, ok := fields[path]
+		return v, ok
+	}
+}
+
+func TestEval(t *testing.T) {
+	day := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+	name := "ada"
+	var nothing *string
+
+	fields := map[string]any{
+		"count":    int32(5),
+		"big":      uint64(1 << 63),
+		"ratio":    float32(0.5),
+		"name":     &name,
+		"bytes":    []byte("abc"),
+		"active":   true,
+		"at":       day,
+		"day":      "2024-10-01",
+		"nickname": nothing,
+		"missing":  nil,
+	}
+
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		// Integers compare with integers and floats
+		{"count = 5", true},
+		{"count = 5.0", true},
+		{"count <> 5", false},
+		{"count > 4 AND count < 6", true},
+		{"count >= 5.5", false},
+		{"count IN (1, 5)", true},
+		{"count IN (1, 2)", false},
+		{"big > 1", true},
+
+		// Floats
+		{"ratio = 0.5", true},
+		{"ratio < 1", true},
+		{"ratio > 0.5", false},
+
+		// Strings, through pointers and from bytes
+		{"name = 'ada'", true},
+		{"name > 'Ada'", true},
+		{"name IN ('bob', 'ada')", true},
+		{"bytes = 'abc'", true},
+		{"name = 1", false},
+		{"name != 1", false},
+
+		// Booleans
+		{"active = TRUE", true},
+		{"active > FALSE", true},
+		{"active = 1", false},
+
+		// Times compare with time literals and time strings
+		{"at = TIMESTAMP '2024-10-01T00:00:00Z'", true},
+		{"at = '2024-10-01'", true},
+		{"at < DATE '2024-10-01' + INTERVAL '1 second'", true},
+		{"at > NOW()", false},
+		{"day = DATE '2024-10-01'", true},
+		{"day < TIMESTAMP '2024-10-01T00:00:01Z'", true},
+		{"at = 'not a time'", false},
+
+		// Null and missing fields only match IS NULL
+		{"nickname IS NULL", true},
+		{"missing IS NULL", true},
+		{"absent IS NULL", true},
+		{"name IS NULL", false},
+		{"name IS NOT NULL", true},
+		{"nickname IS NOT NULL", false},
+		{"nickname = 'ada'", false},
+		{"nickname != 'ada'", false},
+		{"absent != 1", false},
+		{"absent IN (1)", false},
+
+		// Combinations
+		{"count = 1 OR name = 'ada'", true},
+		{"count = 1 OR name = 'bob'", false},
+		{"NOT count = 1", true},
+		{"count NOT IN (5)", false},
+		{"NOT (count = 5 AND active = TRUE)", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			expr, err := Parse(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := expr.Eval(record(fields)); got != tt.want {
+				t.Fatalf("Eval = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMayMatch(t *testing.T) {
+	day := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	stats := map[string]Stats{
+		"count": {Rows: 10, Min: int64(10), Max: int64(20), HasMinMax: true, NullCount: 0, HasNullCount: true},
+		"ratio": {Rows: 10, Min: 0.25, Max: 0.75, HasMinMax: true},
+		"name": {
+			Rows: 10, Min: "bob", Max: "dan", HasMinMax: true, NullCount: 2, HasNullCount: true,
+			MayContain: func(v any) bool { return v == "bob" || v == "dan" },
+		},
+		"at":       {Rows: 10, Min: day, Max: day.Add(24 * time.Hour), HasMinMax: true},
+		"nickname": {Rows: 10, NullCount: 10, HasNullCount: true},
+		"tags":     {Rows: 10, NullCount: 12, HasNullCount: true, Repeated: true},
+		"unknown":  {Rows: 10},
+	}
+	lookup := func(path string) (Stats, bool) {
+		s, ok := stats[path]
+		return s, ok
+	}
+
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		// Integers
+		{"count = 15", true},
+		{"count = 21", false},
+		{"count = 9", false},
+		{"count = 15.5", true},
+		{"count > 20", false},
+		{"count >= 20", true},
+		{"count < 10", false},
+		{"count <= 10", true},
+		{"count != 15", true},
+		{"count IN (1, 2)", false},
+		{"count IN (1, 12)", true},
+
+		// Floats
+		{"ratio = 0.5", true},
+		{"ratio > 0.75", false},
+		{"ratio < 0.25", false},
+		{"ratio >= 0", true},
+
+		// Strings, with the bloom filter consulted within min/max
+		{"name = 'bob'", true},
+		{"name = 'cat'", false},
+		{"name = 'eve'", false},
+		{"name > 'dan'", false},
+		{"name IN ('cat', 'dan')", true},
+		{"name != 'cat'", true},
+		{"name = 1", false},
+
+		// Times
+		{"at = DATE '2024-10-01'", true},
+		{"at > TIMESTAMP '2024-10-02T00:00:00Z'", false},
+		{"at < '2024-10-01'", false},
+
+		// Null counts
+		{"count IS NULL", false},
+		{"count IS NOT NULL", true},
+		{"name IS NULL", true},
+		{"nickname IS NULL", true},
+		{"nickname IS NOT NULL", false},
+		{"nickname = 'ada'", false},
+		{"nickname IN ('ada')", false},
+		{"ratio IS NULL", true},
+		{"tags IS NOT NULL", true},
+		{"tags = 'x'", true},
+
+		// Missing statistics rule nothing out
+		{"unknown = 1", true},
+		{"unknown IS NULL", true},
+		{"absent = 1", true},
+		{"absent IS NOT NULL", true},
+
+		// Combinations
+		{"count = 15 AND name = 'cat'", false},
+		{"count = 1 OR name = 'bob'", true},
+		{"count = 1 OR name = 'cat'", false},
+		{"NOT count = 15", true},
+		{"NOT count = 1", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			expr, err := Parse(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := expr.MayMatch(lookup); got != tt.want {
+				t.Fatalf("MayMatch = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
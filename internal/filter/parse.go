@@ -0,0 +1,425 @@
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Parse parses a filter expression. Keywords are case insensitive, strings
+// are single quoted with a doubled quote escaping a quote, and field paths
+// are matched case insensitively.
+func Parse(input string) (Expr, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %s at offset %d", tok, tok.pos)
+	}
+
+	return expr, nil
+}
+
+// tokenKind is the kind of a lexical token.
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOp
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+// token is a lexical token of a filter expression.
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "end of filter"
+	}
+	return strconv.Quote(t.text)
+}
+
+// keyword reports whether the token is the given keyword.
+func (t token) keyword(kw string) bool {
+	return t.kind == tokenIdent && strings.EqualFold(t.text, kw)
+}
+
+// lex splits a filter expression into tokens.
+func lex(input string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(input); {
+		c := rune(input[i])
+
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenLParen, text: "(", pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenRParen, text: ")", pos: i})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{kind: tokenComma, text: ",", pos: i})
+			i++
+		case c == '\'':
+			var sb strings.Builder
+			start := i
+			i++
+			for {
+				if i >= len(input) {
+					return nil, fmt.Errorf("unterminated string at offset %d", start)
+				}
+				if input[i] == '\'' {
+					if i+1 < len(input) && input[i+1] == '\'' {
+						sb.WriteByte('\'')
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				sb.WriteByte(input[i])
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenString, text: sb.String(), pos: start})
+		case strings.ContainsRune("=!<>", c):
+			start := i
+			i++
+			if i < len(input) && (input[i] == '=' || (c == '<' && input[i] == '>')) {
+				i++
+			}
+			op := input[start:i]
+			if op == "!" {
+				return nil, fmt.Errorf("unexpected \"!\" at offset %d", start)
+			}
+			tokens = append(tokens, token{kind: tokenOp, text: op, pos: start})
+		case c == '+' || (c == '-' && !(i+1 < len(input) && (input[i+1] == '.' || isDigit(input[i+1])))):
+			tokens = append(tokens, token{kind: tokenOp, text: string(c), pos: i})
+			i++
+		case c == '-' || c == '.' || unicode.IsDigit(c):
+			start := i
+			i++
+			for i < len(input) && (isNumberChar(rune(input[i])) || ((input[i] == '-' || input[i] == '+') && (input[i-1] == 'e' || input[i-1] == 'E'))) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: input[start:i], pos: start})
+		case c == '_' || unicode.IsLetter(c):
+			start := i
+			for i < len(input) && (input[i] == '_' || input[i] == '.' || unicode.IsLetter(rune(input[i])) || unicode.IsDigit(rune(input[i]))) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: input[start:i], pos: start})
+		default:
+			return nil, fmt.Errorf("unexpected %q at offset %d", c, i)
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF, pos: len(input)}), nil
+}
+
+// isDigit reports whether c is an ASCII digit.
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// isNumberChar reports whether c can appear in a number literal.
+func isNumberChar(c rune) bool {
+	return unicode.IsDigit(c) || c == '.' || c == 'e' || c == 'E'
+}
+
+// parser is a recursive descent parser over the tokens of an expression.
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// parseOr parses expr := and (OR and)*.
+func (p *parser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().keyword("OR") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orExpr{left: left, right: right}
+	}
+
+	return left, nil
+}
+
+// parseAnd parses and := not (AND not)*.
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().keyword("AND") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &andExpr{left: left, right: right}
+	}
+
+	return left, nil
+}
+
+// parseNot parses not := NOT not | primary.
+func (p *parser) parseNot() (Expr, error) {
+	if p.peek().keyword("NOT") {
+		p.next()
+		expr, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{expr: expr}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+// parsePrimary parses a parenthesized expression or a predicate on a field.
+func (p *parser) parsePrimary() (Expr, error) {
+	tok := p.next()
+
+	if tok.kind == tokenLParen {
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokenRParen {
+			return nil, fmt.Errorf("expected \")\" at offset %d, got %s", closing.pos, closing)
+		}
+		return expr, nil
+	}
+
+	if tok.kind != tokenIdent || isKeyword(tok.text) {
+		return nil, fmt.Errorf("expected field at offset %d, got %s", tok.pos, tok)
+	}
+	path := canonicalPath(tok.text)
+
+	switch op := p.next(); {
+	case op.kind == tokenOp && op.text != "+" && op.text != "-":
+		value, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			return nil, fmt.Errorf("comparison with NULL at offset %d never matches, use IS NULL", op.pos)
+		}
+		name := op.text
+		if name == "<>" {
+			name = "!="
+		}
+		return &compareExpr{path: path, op: name, value: value}, nil
+	case op.keyword("IS"):
+		not := false
+		if p.peek().keyword("NOT") {
+			p.next()
+			not = true
+		}
+		if null := p.next(); !null.keyword("NULL") {
+			return nil, fmt.Errorf("expected NULL at offset %d, got %s", null.pos, null)
+		}
+		return &nullExpr{path: path, not: not}, nil
+	case op.keyword("IN"):
+		return p.parseIn(path)
+	case op.keyword("NOT") && p.peek().keyword("IN"):
+		p.next()
+		in, err := p.parseIn(path)
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{expr: in}, nil
+	default:
+		return nil, fmt.Errorf("expected operator at offset %d, got %s", op.pos, op)
+	}
+}
+
+// parseIn parses the list of an IN predicate.
+func (p *parser) parseIn(path string) (Expr, error) {
+	if open := p.next(); open.kind != tokenLParen {
+		return nil, fmt.Errorf("expected \"(\" at offset %d, got %s", open.pos, open)
+	}
+
+	var values []any
+	for {
+		value, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		if value != nil {
+			values = append(values, value)
+		}
+
+		tok := p.next()
+		if tok.kind == tokenRParen {
+			break
+		}
+		if tok.kind != tokenComma {
+			return nil, fmt.Errorf("expected \",\" or \")\" at offset %d, got %s", tok.pos, tok)
+		}
+	}
+
+	return &inExpr{path: path, values: values}, nil
+}
+
+// parseLiteral parses a string, number, boolean, time or NULL literal. NULL
+// is returned as nil.
+func (p *parser) parseLiteral() (any, error) {
+	tok := p.next()
+
+	switch {
+	case tok.keyword("TIMESTAMP"), tok.keyword("DATE"):
+		s := p.next()
+		if s.kind != tokenString {
+			return nil, fmt.Errorf("expected quoted time after %s at offset %d, got %s", tok.text, s.pos, s)
+		}
+		t, ok := parseTime(s.text)
+		if !ok {
+			return nil, fmt.Errorf("invalid time %s at offset %d", s, s.pos)
+		}
+		return p.parseIntervals(t)
+	case tok.keyword("NOW"):
+		if open, closing := p.next(), p.next(); open.kind != tokenLParen || closing.kind != tokenRParen {
+			return nil, fmt.Errorf("expected \"()\" after NOW at offset %d", open.pos)
+		}
+		return p.parseIntervals(time.Now().UTC())
+	case tok.kind == tokenString:
+		return tok.text, nil
+	case tok.kind == tokenNumber:
+		if i, err := strconv.ParseInt(tok.text, 10, 64); err == nil {
+			return i, nil
+		}
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s at offset %d", tok, tok.pos)
+		}
+		return f, nil
+	case tok.keyword("TRUE"):
+		return true, nil
+	case tok.keyword("FALSE"):
+		return false, nil
+	case tok.keyword("NULL"):
+		return nil, nil
+	case tok.kind == tokenEOF:
+		return nil, errors.New("expected value at end of filter")
+	default:
+		return nil, fmt.Errorf("expected value at offset %d, got %s", tok.pos, tok)
+	}
+}
+
+// parseIntervals parses any number of "+ INTERVAL '...'" or
+// "- INTERVAL '...'" following a time literal and applies them to t.
+func (p *parser) parseIntervals(t time.Time) (time.Time, error) {
+	for {
+		op := p.peek()
+		if op.kind != tokenOp || (op.text != "+" && op.text != "-") {
+			return t, nil
+		}
+		p.next()
+
+		if kw := p.next(); !kw.keyword("INTERVAL") {
+			return time.Time{}, fmt.Errorf("expected INTERVAL at offset %d, got %s", kw.pos, kw)
+		}
+
+		s := p.next()
+		if s.kind != tokenString {
+			return time.Time{}, fmt.Errorf("expected quoted interval at offset %d, got %s", s.pos, s)
+		}
+
+		d, err := parseInterval(s.text)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid interval %s at offset %d: %w", s, s.pos, err)
+		}
+
+		if op.text == "-" {
+			d = -d
+		}
+		t = t.Add(d)
+	}
+}
+
+// intervalUnits are the units accepted in intervals.
+var intervalUnits = map[string]time.Duration{
+	"second": time.Second,
+	"minute": time.Minute,
+	"hour":   time.Hour,
+	"day":    24 * time.Hour,
+	"week":   7 * 24 * time.Hour,
+}
+
+// parseInterval parses an interval such as "7 days" or "90 minutes". Go
+// durations such as "36h" are accepted as well.
+func parseInterval(s string) (time.Duration, error) {
+	n, unit, ok := strings.Cut(strings.TrimSpace(s), " ")
+	if !ok {
+		return time.ParseDuration(s)
+	}
+
+	count, err := strconv.ParseInt(n, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	d, ok := intervalUnits[strings.TrimSuffix(strings.ToLower(strings.TrimSpace(unit)), "s")]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q", unit)
+	}
+
+	return time.Duration(count) * d, nil
+}
+
+// keywords are the reserved words of the expression language.
+var keywords = []string{"AND", "OR", "NOT", "IS", "NULL", "IN", "TRUE", "FALSE", "TIMESTAMP", "DATE", "NOW", "INTERVAL"}
+
+// isKeyword reports whether an identifier is a reserved word.
+func isKeyword(s string) bool {
+	for _, kw := range keywords {
+		if strings.EqualFold(s, kw) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// render writes an expression fully parenthesized so its structure can be
+// compared.
+func render(e Expr) string {
+	switch e := e.(type) {
+	case *andExpr:
+		return "(" + render(e.left) + " AND " + render(e.right) + ")"
+	case *orExpr:
+		return "(" + render(e.left) + " OR " + render(e.right) + ")"
+	case *notExpr:
+		return "NOT " + render(e.expr)
+	case *compareExpr:
+		return e.path + " " + e.op + " " + renderValue(e.value)
+	case *inExpr:
+		values := make([]string, len(e.values))
+		for i, v := range e.values {
+			values[i] = renderValue(v)
+		}
+		return e.path + " IN (" + strings.Join(values, ", ") + ")"
+	case *nullExpr:
+		if e.not {
+			return e.path + " IS NOT NULL"
+		}
+		return e.path + " IS NULL"
+	default:
+		return fmt.Sprintf("%T", e)
+	}
+}
+
+// renderValue writes a literal with its type.
+func renderValue(v any) string {
+	switch v := v.(type) {
+	case string:
+		return fmt.Sprintf("%q", v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprintf("%T(%v)", v, v)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		// Operators and literals
+		{"a = 1", "a = int64(1)"},
+		{"a <> 1", "a != int64(1)"},
+		{"a != -1.5", "a != float64(-1.5)"},
+		{"a >= 1e3", "a >= float64(1000)"},
+		{"a < .5", "a < float64(0.5)"},
+		{"a = true AND b = FALSE", "(a = bool(true) AND b = bool(false))"},
+		{"a = TIMESTAMP '2024-10-01T12:00:00Z'", "a = 2024-10-01T12:00:00Z"},
+		{"a > DATE '2024-10-01' - INTERVAL '7 days'", "a > 2024-09-24T00:00:00Z"},
+		{"a < date '2024-10-01' + interval '90 minutes' + INTERVAL '1h'", "a < 2024-10-01T02:30:00Z"},
+
+		// Paths are case insensitive
+		{"Address.Country = 'US'", `address.country = "US"`},
+
+		// Quoting
+		{"a = 'it''s'", `a = "it's"`},
+		{"a = ''", `a = ""`},
+		{"a = 'AND b = 1'", `a = "AND b = 1"`},
+
+		// Precedence: NOT binds tighter than AND, AND tighter than OR
+		{"a = 1 OR b = 2 AND c = 3", "(a = int64(1) OR (b = int64(2) AND c = int64(3)))"},
+		{"a = 1 AND b = 2 OR c = 3", "((a = int64(1) AND b = int64(2)) OR c = int64(3))"},
+		{"(a = 1 OR b = 2) AND c = 3", "((a = int64(1) OR b = int64(2)) AND c = int64(3))"},
+		{"NOT a = 1 AND b = 2", "(NOT a = int64(1) AND b = int64(2))"},
+		{"NOT (a = 1 AND b = 2)", "NOT (a = int64(1) AND b = int64(2))"},
+		{"a = 1 or b = 2 or c = 3", "((a = int64(1) OR b = int64(2)) OR c = int64(3))"},
+
+		// IN
+		{"a IN ('x', 'y')", `a IN ("x", "y")`},
+		{"a in (1, 2.5)", "a IN (int64(1), float64(2.5))"},
+		{"a NOT IN (1)", "NOT a IN (int64(1))"},
+		{"a IN (1, NULL)", "a IN (int64(1))"},
+
+		// IS [NOT] NULL
+		{"a IS NULL", "a IS NULL"},
+		{"a is not null", "a IS NOT NULL"},
+		{"a IS NULL OR a = 1", "(a IS NULL OR a = int64(1))"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			expr, err := Parse(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := render(expr); got != tt.want {
+				t.Fatalf("parsed %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"", "expected field at offset 0"},
+		{"a", "expected operator"},
+		{"a =", "expected value at end of filter"},
+		{"a = 'x", "unterminated string at offset 4"},
+		{"a ! 1", `unexpected "!" at offset 2`},
+		{"a = 1 b = 2", `unexpected "b" at offset 6`},
+		{"(a = 1", `expected ")"`},
+		{"a = 1)", `unexpected ")"`},
+		{"a = NULL", "use IS NULL"},
+		{"a IS 1", "expected NULL"},
+		{"a IN 1", `expected "("`},
+		{"a IN (1 2)", `expected "," or ")"`},
+		{"a IN ()", "expected value"},
+		{"AND = 1", "expected field"},
+		{"a = 1 AND", "expected field"},
+		{"a = 1.2.3", "invalid number"},
+		{"a = DATE 'yesterday'", "invalid time"},
+		{"a = DATE 1", "expected quoted time"},
+		{"a = NOW() - 1", "expected INTERVAL"},
+		{"a = NOW() - INTERVAL '7 fortnights'", "unknown unit"},
+		{"a = b", "expected value"},
+		{"a = 1 # b", "unexpected '#'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			expr, err := Parse(tt.input)
+			if err == nil {
+				t.Fatalf("parsed %s, want an error", render(expr))
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("error = %q, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseNow(t *testing.T) {
+	before := time.Now().UTC()
+	expr, err := Parse("a > NOW() - INTERVAL '7 days'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := time.Now().UTC()
+
+	got := expr.(*compareExpr).value.(time.Time)
+	if want := -7 * 24 * time.Hour; got.Before(before.Add(want)) || got.After(after.Add(want)) {
+		t.Fatalf("NOW() - INTERVAL '7 days' = %s, want 7 days before %s", got, before)
+	}
+}
+
+func TestColumns(t *testing.T) {
+	expr, err := Parse("b = 1 AND (A IS NULL OR b IN (2)) AND NOT c.d < 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.Join(Columns(expr), ","); got != "b,a,c.d" {
+		t.Fatalf("columns = %s, want b,a,c.d", got)
+	}
+}
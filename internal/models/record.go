@@ -2,39 +2,41 @@ package models
 
 import "time"
 
-// Record represents a data record with various fields
+// Record represents a data record with various fields. Every field carries a
+// pii tag classifying the personal information it holds, see package redact.
 type Record struct {
-	ID        string    `json:"id" parquet:"id"`
-	CreatedAt time.Time `json:"created_at" parquet:"created_at"`
-	UpdatedAt time.Time `json:"updated_at" parquet:"updated_at"`
+	ID        string    `json:"id" parquet:"id" pii:"none"`
+	CreatedAt time.Time `json:"created_at" parquet:"created_at" pii:"none"`
+	UpdatedAt time.Time `json:"updated_at" parquet:"updated_at" pii:"none"`
 
 	// Personal Information
-	FirstName   string `json:"first_name" parquet:"first_name"`
-	LastName    string `json:"last_name" parquet:"last_name"`
-	Email       string `json:"email" parquet:"email"`
-	PhoneNumber string `json:"phone_number" parquet:"phone_number"`
-	DateOfBirth string `json:"date_of_birth" parquet:"date_of_birth"`
+	FirstName   string `json:"first_name" parquet:"first_name" pii:"name"`
+	LastName    string `json:"last_name" parquet:"last_name" pii:"name"`
+	Email       string `json:"email" parquet:"email" pii:"email"`
+	PhoneNumber string `json:"phone_number" parquet:"phone_number" pii:"phone"`
+	DateOfBirth string `json:"date_of_birth" parquet:"date_of_birth" pii:"dob"`
 
 	// Address Information
-	Address Address `json:"address" parquet:"address"`
+	Address Address `json:"address" parquet:"address" pii:"address"`
 
 	// Account Information
-	AccountType    string    `json:"account_type" parquet:"account_type"`
-	AccountStatus  string    `json:"account_status" parquet:"account_status"`
-	LastLoginDate  time.Time `json:"last_login_date" parquet:"last_login_date"`
-	AccountBalance float64   `json:"account_balance" parquet:"account_balance"`
+	AccountType    string    `json:"account_type" parquet:"account_type" pii:"none"`
+	AccountStatus  string    `json:"account_status" parquet:"account_status" pii:"none"`
+	LastLoginDate  time.Time `json:"last_login_date" parquet:"last_login_date" pii:"none"`
+	AccountBalance float64   `json:"account_balance" parquet:"account_balance" pii:"none"`
 
 	// Preferences
-	Language                 string   `json:"language" parquet:"language"`
-	CommunicationPreferences []string `json:"communication_preferences" parquet:"communication_preferences,list"`
-	NewsletterSubscribed     bool     `json:"newsletter_subscribed" parquet:"newsletter_subscribed"`
+	Language                 string   `json:"language" parquet:"language" pii:"none"`
+	CommunicationPreferences []string `json:"communication_preferences" parquet:"communication_preferences,list" pii:"none"`
+	NewsletterSubscribed     bool     `json:"newsletter_subscribed" parquet:"newsletter_subscribed" pii:"none"`
 
 	// Metadata
-	Tags []string `json:"tags" parquet:"tags,list"`
-	Body string   `json:"body" parquet:"body"`
+	Tags []string `json:"tags" parquet:"tags,list" pii:"none"`
+	Body string   `json:"body" parquet:"body" pii:"none"`
 }
 
-// Address represents a physical address
+// Address represents a physical address. Its fields are classified by the
+// field holding it.
 type Address struct {
 	Street     string `json:"street" parquet:"street"`
 	City       string `json:"city" parquet:"city"`
@@ -0,0 +1,27 @@
+package publisher
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/envelope"
+)
+
+// messageAttributes converts envelope attributes to the message attributes of
+// a sink, built by attribute from the data type and either the string or the
+// binary value. SQS and SNS attributes only differ in their Go type.
+func messageAttributes[V any](attrs envelope.Attributes, attribute func(dataType string, stringValue *string, binaryValue []byte) V) map[string]V {
+	if len(attrs) == 0 {
+		return nil
+	}
+
+	values := make(map[string]V, len(attrs))
+	for name, attr := range attrs {
+		if attr.Type == envelope.AttributeBinary {
+			values[name] = attribute(attr.Type, nil, []byte(attr.Value))
+		} else {
+			values[name] = attribute(attr.Type, aws.String(attr.Value), nil)
+		}
+	}
+
+	return values
+}
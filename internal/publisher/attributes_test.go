@@ -0,0 +1,44 @@
+package publisher
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/envelope"
+)
+
+func TestMessageAttributes(t *testing.T) {
+	attrs := envelope.Attributes{
+		"kind":    {Type: envelope.AttributeString, Value: "click"},
+		"value":   {Type: envelope.AttributeNumber, Value: "42"},
+		"payload": {Type: envelope.AttributeBinary, Value: "\x00\x01"},
+	}
+
+	sqs := messageAttributes(attrs, sqsAttribute)
+	sns := messageAttributes(attrs, snsAttribute)
+	if len(sqs) != len(attrs) || len(sns) != len(attrs) {
+		t.Fatalf("converted %d SQS and %d SNS attributes, want %d", len(sqs), len(sns), len(attrs))
+	}
+
+	for name, attr := range attrs {
+		q, n := sqs[name], sns[name]
+		if aws.ToString(q.DataType) != attr.Type || aws.ToString(n.DataType) != attr.Type {
+			t.Fatalf("%s converted to types %s and %s, want %s", name, aws.ToString(q.DataType), aws.ToString(n.DataType), attr.Type)
+		}
+
+		if attr.Type == envelope.AttributeBinary {
+			if string(q.BinaryValue) != attr.Value || string(n.BinaryValue) != attr.Value || q.StringValue != nil || n.StringValue != nil {
+				t.Fatalf("%s converted to %+v and %+v, want a binary value", name, q, n)
+			}
+			continue
+		}
+		if aws.ToString(q.StringValue) != attr.Value || aws.ToString(n.StringValue) != attr.Value || q.BinaryValue != nil || n.BinaryValue != nil {
+			t.Fatalf("%s converted to %+v and %+v, want a string value", name, q, n)
+		}
+	}
+
+	if messageAttributes(nil, sqsAttribute) != nil {
+		t.Fatal("converted no attributes to a non nil map")
+	}
+}
@@ -0,0 +1,27 @@
+package publisher
+
+import "github.com/jsmithdenverdev/poc-parquet-publisher/internal/envelope"
+
+// Batch groups messages into batches that respect the entry and payload limits
+// of a sink.
+func Batch(messages []envelope.Message, limits Limits) [][]envelope.Message {
+	var (
+		batches [][]envelope.Message
+		start   int
+		size    int
+	)
+
+	for i, msg := range messages {
+		if i > start && (i-start >= limits.MaxEntries || size+limits.Size(msg) > limits.MaxBatchBytes) {
+			batches = append(batches, messages[start:i])
+			start, size = i, 0
+		}
+		size += limits.Size(msg)
+	}
+
+	if start < len(messages) {
+		batches = append(batches, messages[start:])
+	}
+
+	return batches
+}
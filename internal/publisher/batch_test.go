@@ -0,0 +1,50 @@
+package publisher
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/envelope"
+)
+
+func TestBatchEventBridgeOverhead(t *testing.T) {
+	p := NewEventBridge(nil, strings.Repeat("b", 64), strings.Repeat("s", 64), strings.Repeat("d", 64))
+	limits := p.Limits()
+
+	// Ten bodies that fill the batch exactly, leaving no room for the fields
+	// every event adds
+	messages := make([]envelope.Message, limits.MaxEntries)
+	for i := range messages {
+		messages[i] = envelope.Message{Body: make([]byte, limits.MaxBatchBytes/limits.MaxEntries)}
+	}
+
+	for _, batch := range Batch(messages, limits) {
+		var size int
+		for _, msg := range batch {
+			size += len(msg.Body) + len(p.busName) + len(p.source) + len(p.detailType)
+		}
+		if size > limits.MaxBatchBytes {
+			t.Fatalf("batch of %d events is %d bytes, over the %d byte limit", len(batch), size, limits.MaxBatchBytes)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{s: "short", n: 10, want: "short"},
+		{s: "abcdef", n: 3, want: "abc"},
+		{s: "aé", n: 2, want: "a"},
+		{s: "aéb", n: 3, want: "aé"},
+		{s: "日本", n: 5, want: "日"},
+	}
+
+	for _, tt := range tests {
+		if got := truncate(tt.s, tt.n); got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package publisher
+
+import (
+	"context"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/claimcheck"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/envelope"
+)
+
+// ClaimChecker stores the bodies of messages that are larger than a threshold
+// in S3 and publishes a pointer envelope in their place.
+type ClaimChecker struct {
+	store     *claimcheck.Store
+	threshold int
+}
+
+// NewClaimChecker creates the claim checker selected by the configuration. It
+// returns nil when claim checking is disabled. The threshold defaults to the
+// largest message the sink accepts.
+func NewClaimChecker(cfg Config, s3Client claimcheck.PutObjectAPI, limits Limits) *ClaimChecker {
+	if cfg.ClaimCheckBucket == "" {
+		return nil
+	}
+
+	threshold := cfg.ClaimCheckThreshold
+	if threshold <= 0 || threshold > limits.MaxEntryBytes {
+		threshold = limits.MaxEntryBytes
+	}
+
+	return &ClaimChecker{
+		store:     claimcheck.New(s3Client, cfg.ClaimCheckBucket, cfg.ClaimCheckPrefix, cfg.ClaimCheckTags),
+		threshold: threshold,
+	}
+}
+
+// Check returns the messages with every message larger than the threshold
+// replaced by its pointer. The number of messages replaced is also returned.
+func (c *ClaimChecker) Check(ctx context.Context, messages []envelope.Message) ([]envelope.Message, int, error) {
+	var (
+		checked []envelope.Message
+		count   int
+	)
+
+	for i, msg := range messages {
+		if msg.Size() <= c.threshold {
+			continue
+		}
+
+		// Copy on first write, the caller's slice is left untouched
+		if checked == nil {
+			checked = append([]envelope.Message(nil), messages...)
+		}
+
+		pointer, err := c.store.Check(ctx, msg)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		checked[i] = pointer
+		count++
+	}
+
+	if checked == nil {
+		return messages, 0, nil
+	}
+
+	return checked, count, nil
+}
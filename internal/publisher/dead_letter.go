@@ -0,0 +1,120 @@
+package publisher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"path"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// DeadLetter is a message that could not be published.
+type DeadLetter struct {
+	// ID is the ID of the envelope of the message.
+	ID          string          `json:"id"`
+	Source      string          `json:"source"`
+	RowStart    int64           `json:"row_start"`
+	RowEnd      int64           `json:"row_end"`
+	Code        string          `json:"code"`
+	Message     string          `json:"message"`
+	SenderFault bool            `json:"sender_fault"`
+	Attempts    int             `json:"attempts"`
+	Body        json.RawMessage `json:"body"`
+}
+
+// DeadLetterSink receives messages that could not be published so they can be
+// inspected and replayed.
+type DeadLetterSink interface {
+	Send(ctx context.Context, dl DeadLetter) error
+}
+
+// NewDeadLetterSink creates the dead letter sink selected by the
+// configuration.
+func NewDeadLetterSink(cfg Config, logger *slog.Logger, s3Client DeadLetterObjectAPI) (DeadLetterSink, error) {
+	switch cfg.DeadLetterSink {
+	case "", "log":
+		return &LogDeadLetterSink{logger: logger}, nil
+	case "s3":
+		if cfg.DeadLetterBucket == "" {
+			return nil, errors.New("DEAD_LETTER_BUCKET is required for the s3 dead letter sink")
+		}
+		return &S3DeadLetterSink{
+			client: s3Client,
+			bucket: cfg.DeadLetterBucket,
+			prefix: cfg.DeadLetterPrefix,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown dead letter sink %q", cfg.DeadLetterSink)
+	}
+}
+
+// LogDeadLetterSink writes dead letters to the log. The message body is not
+// logged as it may be large.
+type LogDeadLetterSink struct {
+	logger *slog.Logger
+}
+
+// NewLogDeadLetterSink creates a dead letter sink writing to logger.
+func NewLogDeadLetterSink(logger *slog.Logger) *LogDeadLetterSink {
+	return &LogDeadLetterSink{logger: logger}
+}
+
+// Send logs the dead letter.
+func (s *LogDeadLetterSink) Send(ctx context.Context, dl DeadLetter) error {
+	s.logger.ErrorContext(ctx, "Dead letter",
+		"id", dl.ID,
+		"source", dl.Source,
+		"row_start", dl.RowStart,
+		"row_end", dl.RowEnd,
+		"code", dl.Code,
+		"message", dl.Message,
+		"sender_fault", dl.SenderFault,
+		"attempts", dl.Attempts,
+		"size", len(dl.Body),
+	)
+	return nil
+}
+
+// DeadLetterObjectAPI is the subset of the S3 client used by the S3 dead
+// letter sink.
+type DeadLetterObjectAPI interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+// S3DeadLetterSink writes each dead letter as a JSON object under a prefix of
+// an S3 bucket. S3 is used rather than a queue because messages rejected for
+// being invalid would be rejected by a dead letter queue as well.
+type S3DeadLetterSink struct {
+	client DeadLetterObjectAPI
+	bucket string
+	prefix string
+}
+
+// Send writes the dead letter object.
+func (s *S3DeadLetterSink) Send(ctx context.Context, dl DeadLetter) error {
+	data, err := json.Marshal(dl)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead letter: %w", err)
+	}
+
+	// The same rows of a source are published under a different envelope ID
+	// for every object version, selection and configuration, which must not
+	// overwrite each other
+	key := path.Join(s.prefix, url.PathEscape(dl.Source), fmt.Sprintf("%d-%d-%s.json", dl.RowStart, dl.RowEnd, dl.ID))
+	if _, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	}); err != nil {
+		return fmt.Errorf("failed to put dead letter object %s: %w", key, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package publisher
+
+import (
+	"fmt"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/dataset"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/redact"
+)
+
+// Destination is where the records of a dataset are published.
+type Destination struct {
+	Sender *Sender
+
+	// Target is the queue, topic, bus, stream or file the sender publishes
+	// to, see Config.Target.
+	Target string
+
+	// Policy redacts the records published to the destination, nil if it has
+	// no redaction policy.
+	Policy *redact.Policy
+}
+
+// Destinations are the destinations records are published to. Datasets with
+// a target of their own get their own destination, every other dataset shares
+// the destination of the configured target.
+type Destinations struct {
+	fallback Destination
+	targets  map[string]Destination
+}
+
+// NewDestinations creates the destinations of the configured target and of
+// the targets of datasets, keyed by dataset name, each redacted by the policy
+// of its target.
+func NewDestinations(cfg Config, targets map[string]string, datasets *dataset.Registry, policies map[string]*redact.Policy, newSender func(Config) (*Sender, error)) (Destinations, error) {
+	fallback, err := newSender(cfg)
+	if err != nil {
+		return Destinations{}, err
+	}
+
+	dests := Destinations{
+		fallback: Destination{Sender: fallback, Target: cfg.Target(), Policy: redact.ForTarget(policies, cfg.Target())},
+		targets:  make(map[string]Destination, len(targets)),
+	}
+	for name, target := range targets {
+		if _, err := datasets.Lookup(name); err != nil {
+			return Destinations{}, fmt.Errorf("invalid dataset target: %w", err)
+		}
+
+		pcfg, err := cfg.WithTarget(target)
+		if err != nil {
+			return Destinations{}, fmt.Errorf("invalid target for dataset %s: %w", name, err)
+		}
+
+		sender, err := newSender(pcfg)
+		if err != nil {
+			return Destinations{}, fmt.Errorf("failed to create sender for dataset %s: %w", name, err)
+		}
+
+		dests.targets[name] = Destination{Sender: sender, Target: target, Policy: redact.ForTarget(policies, target)}
+	}
+
+	return dests, nil
+}
+
+// Destination returns the destination of a dataset.
+func (d Destinations) Destination(name string) Destination {
+	if dest, ok := d.targets[name]; ok {
+		return dest
+	}
+	return d.fallback
+}
@@ -0,0 +1,89 @@
+package publisher
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/envelope"
+)
+
+// eventBridgeRetryableCodes are the PutEvents entry error codes worth
+// retrying. Every other code means the event itself was rejected.
+var eventBridgeRetryableCodes = map[string]bool{
+	"ThrottlingException": true,
+	"InternalException":   true,
+	"InternalFailure":     true,
+}
+
+// EventBridgeAPI is the subset of the EventBridge client used to put events.
+type EventBridgeAPI interface {
+	PutEvents(ctx context.Context, params *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error)
+}
+
+// EventBridge puts messages on an event bus with PutEvents. Each message is
+// the detail of one event.
+type EventBridge struct {
+	client     EventBridgeAPI
+	busName    string
+	source     string
+	detailType string
+}
+
+// NewEventBridge creates a publisher for an EventBridge event bus.
+func NewEventBridge(client EventBridgeAPI, busName, source, detailType string) *EventBridge {
+	return &EventBridge{client: client, busName: busName, source: source, detailType: detailType}
+}
+
+// Limits returns the PutEvents limits. The size of every event includes its
+// source, detail type and event bus, so room is left for them in each event
+// and they count toward the size of the batch. Events have no attributes.
+func (p *EventBridge) Limits() Limits {
+	overhead := len(p.source) + len(p.detailType) + len(p.busName)
+	return Limits{
+		MaxEntries:    10,
+		MaxEntryBytes: 256*1024 - overhead,
+		MaxBatchBytes: 256 * 1024,
+		EntryOverhead: overhead,
+	}
+}
+
+// PublishBatch puts the messages with a single PutEvents call.
+func (p *EventBridge) PublishBatch(ctx context.Context, messages []envelope.Message) ([]Failure, error) {
+	entries := make([]types.PutEventsRequestEntry, len(messages))
+	for i, msg := range messages {
+		entries[i] = types.PutEventsRequestEntry{
+			EventBusName: aws.String(p.busName),
+			Source:       aws.String(p.source),
+			DetailType:   aws.String(p.detailType),
+			Detail:       aws.String(string(msg.Body)),
+		}
+	}
+
+	result, err := p.client.PutEvents(ctx, &eventbridge.PutEventsInput{Entries: entries})
+	if err != nil {
+		return nil, fmt.Errorf("failed to put events to EventBridge: %w", err)
+	}
+
+	// Results are returned in the same order as the entries
+	var failures []Failure
+	for i, entry := range result.Entries {
+		if entry.ErrorCode == nil || i >= len(messages) {
+			continue
+		}
+
+		code := aws.ToString(entry.ErrorCode)
+		failures = append(failures, Failure{
+			ID:          messages[i].ID,
+			Code:        code,
+			Message:     aws.ToString(entry.ErrorMessage),
+			SenderFault: !eventBridgeRetryableCodes[code],
+			Throttled:   isThrottle(code),
+		})
+	}
+
+	return failures, nil
+}
@@ -0,0 +1,74 @@
+package publisher
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/fields"
+)
+
+const (
+	// Maximum length of a FIFO message group ID (hard limit from AWS)
+	maxMessageGroupIDLength = 128
+)
+
+// GroupIDs returns the message group of every record read from source, or nil
+// when messages are not grouped. Groups are used on FIFO queues and topics,
+// and as partition keys on Kinesis when a group field is configured. Records
+// are grouped by keyField, the key field of their dataset, or by the
+// configured MessageGroupField, or all together by source if neither is set.
+func (c Config) GroupIDs(source string, records []any, keyField string) []string {
+	field := keyField
+	if field == "" {
+		field = c.MessageGroupField
+	}
+
+	grouped := c.FIFOQueue || (c.Sink == "kinesis" && field != "")
+	if !grouped {
+		return nil
+	}
+
+	keys := make([]string, len(records))
+	for i, record := range records {
+		keys[i] = GroupID(source, record, field)
+	}
+
+	return keys
+}
+
+// GroupID returns the FIFO message group of a record, the value of field if
+// one is set and the record has a value for it, or the source file otherwise.
+// Values that are not a valid group ID, because they are empty, too long or
+// contain characters other than printable ASCII, are replaced by their hash.
+func GroupID(source string, record any, field string) string {
+	group := source
+	if field != "" {
+		if value, ok := fields.Value(record, field); ok && value != nil {
+			group = fmt.Sprint(value)
+		}
+	}
+
+	if !validGroupID(group) {
+		sum := sha256.Sum256([]byte(group))
+		group = hex.EncodeToString(sum[:])
+	}
+
+	return group
+}
+
+// validGroupID reports whether s may be used as a FIFO message group ID as it
+// is. SQS and SNS only accept alphanumerics and punctuation.
+func validGroupID(s string) bool {
+	if s == "" || len(s) > maxMessageGroupIDLength {
+		return false
+	}
+
+	for i := 0; i < len(s); i++ {
+		if s[i] <= ' ' || s[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,85 @@
+package publisher
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestGroupID(t *testing.T) {
+	const source = "s3://bucket/records.parquet"
+
+	tests := []struct {
+		name   string
+		record map[string]any
+		field  string
+		want   string
+
+		// hashed is true when the group must be the hash of want
+		hashed bool
+	}{
+		{name: "no field", record: map[string]any{"kind": "click"}, want: source},
+		{name: "value", record: map[string]any{"kind": "click"}, field: "kind", want: "click"},
+		{name: "punctuation", record: map[string]any{"kind": `a!"#$%&'()*+,-./:;<=>?@[\]^_` + "`{|}~z"}, field: "kind", want: `a!"#$%&'()*+,-./:;<=>?@[\]^_` + "`{|}~z"},
+		{name: "number", record: map[string]any{"kind": 42}, field: "kind", want: "42"},
+		{name: "missing", record: map[string]any{}, field: "kind", want: source},
+		{name: "nil", record: map[string]any{"kind": nil}, field: "kind", want: source},
+		{name: "empty", record: map[string]any{"kind": ""}, field: "kind", want: "", hashed: true},
+		{name: "space", record: map[string]any{"kind": "page view"}, field: "kind", want: "page view", hashed: true},
+		{name: "newline", record: map[string]any{"kind": "page\nview"}, field: "kind", want: "page\nview", hashed: true},
+		{name: "non ascii", record: map[string]any{"kind": "café"}, field: "kind", want: "café", hashed: true},
+		{name: "too long", record: map[string]any{"kind": strings.Repeat("a", 129)}, field: "kind", want: strings.Repeat("a", 129), hashed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := GroupID(source, tt.record, tt.field)
+
+			want := tt.want
+			if tt.hashed {
+				sum := sha256.Sum256([]byte(tt.want))
+				want = hex.EncodeToString(sum[:])
+			}
+			if got != want {
+				t.Fatalf("GroupID = %q, want %q", got, want)
+			}
+			if !validGroupID(got) {
+				t.Fatalf("GroupID = %q is not a valid group ID", got)
+			}
+		})
+	}
+
+	// Records without a value do not share a group across sources
+	if GroupID("s3://bucket/a.parquet", map[string]any{"kind": nil}, "kind") == GroupID("s3://bucket/b.parquet", map[string]any{"kind": nil}, "kind") {
+		t.Fatal("records without a value share a group across sources")
+	}
+}
+
+func TestGroupIDs(t *testing.T) {
+	const source = "s3://bucket/records.parquet"
+	records := []any{map[string]any{"kind": "click", "type": "a"}, map[string]any{"kind": "view", "type": "b"}}
+
+	tests := []struct {
+		name     string
+		cfg      Config
+		keyField string
+		want     []string
+	}{
+		{name: "standard queue", cfg: Config{MessageGroupField: "kind"}},
+		{name: "fifo by source", cfg: Config{FIFOQueue: true}, want: []string{source, source}},
+		{name: "fifo by configured field", cfg: Config{FIFOQueue: true, MessageGroupField: "kind"}, want: []string{"click", "view"}},
+		{name: "fifo by key field", cfg: Config{FIFOQueue: true, MessageGroupField: "kind"}, keyField: "type", want: []string{"a", "b"}},
+		{name: "kinesis without a field", cfg: Config{Sink: "kinesis"}},
+		{name: "kinesis by key field", cfg: Config{Sink: "kinesis"}, keyField: "kind", want: []string{"click", "view"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.GroupIDs(source, records, tt.keyField); !slices.Equal(got, tt.want) {
+				t.Fatalf("GroupIDs = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
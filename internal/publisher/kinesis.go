@@ -0,0 +1,108 @@
+package publisher
+
+import (
+	"context"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/envelope"
+)
+
+// maxPartitionKeyLength is the longest partition key Kinesis accepts.
+const maxPartitionKeyLength = 256
+
+// kinesisRetryableCodes are the PutRecords record error codes worth retrying.
+// Every other code means the record itself was rejected.
+var kinesisRetryableCodes = map[string]bool{
+	"ProvisionedThroughputExceededException": true,
+	"InternalFailure":                        true,
+}
+
+// KinesisAPI is the subset of the Kinesis client used to put records.
+type KinesisAPI interface {
+	PutRecords(ctx context.Context, params *kinesis.PutRecordsInput, optFns ...func(*kinesis.Options)) (*kinesis.PutRecordsOutput, error)
+}
+
+// Kinesis puts messages on a Kinesis data stream with PutRecords.
+type Kinesis struct {
+	client     KinesisAPI
+	streamName string
+}
+
+// NewKinesis creates a publisher for a Kinesis data stream. The message key is
+// used as the partition key when set, otherwise the message ID is, spreading
+// messages evenly across shards.
+func NewKinesis(client KinesisAPI, streamName string) *Kinesis {
+	return &Kinesis{client: client, streamName: streamName}
+}
+
+// Limits returns the PutRecords limits. The size of every record includes its
+// partition key, so room is left for the longest one. Records have no
+// attributes.
+func (p *Kinesis) Limits() Limits {
+	return Limits{
+		MaxEntries:    500,
+		MaxEntryBytes: 1024*1024 - maxPartitionKeyLength,
+		MaxBatchBytes: 5 * 1024 * 1024,
+		EntryOverhead: maxPartitionKeyLength,
+	}
+}
+
+// PublishBatch puts the messages with a single PutRecords call.
+func (p *Kinesis) PublishBatch(ctx context.Context, messages []envelope.Message) ([]Failure, error) {
+	entries := make([]types.PutRecordsRequestEntry, len(messages))
+	for i, msg := range messages {
+		key := msg.Key
+		if key == "" {
+			key = msg.ID
+		}
+		key = truncate(key, maxPartitionKeyLength)
+
+		entries[i] = types.PutRecordsRequestEntry{
+			Data:         msg.Body,
+			PartitionKey: aws.String(key),
+		}
+	}
+
+	result, err := p.client.PutRecords(ctx, &kinesis.PutRecordsInput{
+		StreamName: aws.String(p.streamName),
+		Records:    entries,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to put records to Kinesis: %w", err)
+	}
+
+	// Results are returned in the same order as the records
+	var failures []Failure
+	for i, record := range result.Records {
+		if record.ErrorCode == nil || i >= len(messages) {
+			continue
+		}
+
+		code := aws.ToString(record.ErrorCode)
+		failures = append(failures, Failure{
+			ID:          messages[i].ID,
+			Code:        code,
+			Message:     aws.ToString(record.ErrorMessage),
+			SenderFault: !kinesisRetryableCodes[code],
+			Throttled:   isThrottle(code),
+		})
+	}
+
+	return failures, nil
+}
+
+// truncate cuts s to at most n bytes without splitting a UTF-8 character.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
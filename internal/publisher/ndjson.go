@@ -0,0 +1,48 @@
+package publisher
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/envelope"
+)
+
+// NDJSON writes messages as newline delimited JSON, one message per line. It
+// is intended for local development and tests where no AWS sink is available.
+// Only message bodies are written, attributes are dropped.
+type NDJSON struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewNDJSON creates a publisher writing to w.
+func NewNDJSON(w io.Writer) *NDJSON {
+	return &NDJSON{w: w}
+}
+
+// Limits returns limits matching SQS so output is batched the same way it
+// would be in production. Attributes are dropped, so none are allowed.
+func (p *NDJSON) Limits() Limits {
+	return Limits{
+		MaxEntries:    10,
+		MaxEntryBytes: 256 * 1024,
+		MaxBatchBytes: 256 * 1024,
+	}
+}
+
+// PublishBatch writes every message on its own line. Writes from concurrent
+// batches never interleave.
+func (p *NDJSON) PublishBatch(_ context.Context, messages []envelope.Message) ([]Failure, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, msg := range messages {
+		if _, err := fmt.Fprintf(p.w, "%s\n", msg.Body); err != nil {
+			return nil, fmt.Errorf("failed to write message: %w", err)
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,324 @@
+// Package publisher publishes encoded envelopes to a message sink. Every sink
+// implements Publisher with batch semantics, and a Sender adds the retries,
+// rate limiting and dead lettering shared by all of them.
+package publisher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/envelope"
+)
+
+// Config is the configuration shared by every processor that publishes
+// records.
+type Config struct {
+	// Sink is where messages are published, one of "sqs", "sns", "eventbridge",
+	// "kinesis", "stdout" or "file"
+	Sink string `env:"PUBLISH_SINK" envDefault:"sqs"`
+
+	// QueueURL is the URL of the SQS queue to publish messages to
+	QueueURL string `env:"QUEUE_URL"`
+
+	// FIFOQueue enables publishing to a FIFO queue or topic, setting a
+	// deduplication and message group ID on every message
+	FIFOQueue bool `env:"FIFO_QUEUE"`
+
+	// MessageGroupField is the record field, such as account_type, whose value
+	// is used as the FIFO message group or Kinesis partition key. Defaults to
+	// the source file
+	MessageGroupField string `env:"MESSAGE_GROUP_FIELD"`
+
+	// TopicARN is the ARN of the SNS topic to publish messages to
+	TopicARN string `env:"TOPIC_ARN"`
+
+	// EventBusName is the name of the EventBridge event bus to put events on
+	EventBusName string `env:"EVENT_BUS_NAME" envDefault:"default"`
+
+	// EventSource is the source of the events put on EventBridge
+	EventSource string `env:"EVENT_SOURCE" envDefault:"poc-parquet-publisher"`
+
+	// EventDetailType is the detail type of the events put on EventBridge
+	EventDetailType string `env:"EVENT_DETAIL_TYPE" envDefault:"ParquetRecords"`
+
+	// StreamName is the name of the Kinesis stream to put records on
+	StreamName string `env:"STREAM_NAME"`
+
+	// OutputPath is the file messages are appended to by the file sink
+	OutputPath string `env:"OUTPUT_PATH"`
+
+	// PublishConcurrency is the number of publishers sending message batches
+	// at the same time, shared by every file of an invocation
+	PublishConcurrency int `env:"PUBLISH_CONCURRENCY" envDefault:"8"`
+
+	// PublishMaxInFlight is the maximum number of message batches being sent at
+	// the same time to each target across the whole invocation. Defaults to
+	// PublishConcurrency
+	PublishMaxInFlight int `env:"PUBLISH_MAX_IN_FLIGHT"`
+
+	// PublishMessagesPerSecond is the maximum number of messages published per
+	// second to each target across the whole invocation, zero for no limit
+	PublishMessagesPerSecond float64 `env:"PUBLISH_MESSAGES_PER_SECOND"`
+
+	// PublishBytesPerSecond is the maximum number of message bytes published
+	// per second to each target across the whole invocation, zero for no limit
+	PublishBytesPerSecond int `env:"PUBLISH_BYTES_PER_SECOND"`
+
+	// PublishRampInterval is how long publishing must go without being
+	// throttled before the rate limits are raised back toward their
+	// configured values, and how long after backing off throttling is
+	// ignored
+	PublishRampInterval time.Duration `env:"PUBLISH_RAMP_INTERVAL" envDefault:"5s"`
+
+	// PublishMaxAttempts is the number of times a message is sent before it is
+	// routed to the dead letter sink
+	PublishMaxAttempts int `env:"PUBLISH_MAX_ATTEMPTS" envDefault:"5"`
+
+	// PublishBackoffBase is the delay before the first retry of failed messages,
+	// doubled on every following attempt
+	PublishBackoffBase time.Duration `env:"PUBLISH_BACKOFF_BASE" envDefault:"100ms"`
+
+	// PublishBackoffMax is the longest delay between retries of failed messages
+	PublishBackoffMax time.Duration `env:"PUBLISH_BACKOFF_MAX" envDefault:"10s"`
+
+	// ClaimCheckBucket is the bucket the bodies of oversized messages are
+	// stored in, replaced by a pointer envelope in the published message.
+	// Claim checking is disabled when it is empty and oversized records fail
+	ClaimCheckBucket string `env:"CLAIM_CHECK_BUCKET"`
+
+	// ClaimCheckPrefix is the key prefix claim checked bodies are written under
+	ClaimCheckPrefix string `env:"CLAIM_CHECK_PREFIX" envDefault:"claim-checks/"`
+
+	// ClaimCheckThreshold is the message size in bytes above which bodies are
+	// claim checked. Defaults to the largest message the sink accepts
+	ClaimCheckThreshold int `env:"CLAIM_CHECK_THRESHOLD"`
+
+	// ClaimCheckTags are the tags set on claim checked objects so a bucket
+	// lifecycle rule can expire them, formatted as key:value,key:value
+	ClaimCheckTags map[string]string `env:"CLAIM_CHECK_TAGS" envDefault:"lifecycle:claim-check"`
+
+	// DeadLetterSink is where messages that cannot be published are sent, one of
+	// "log" or "s3"
+	DeadLetterSink string `env:"DEAD_LETTER_SINK" envDefault:"log"`
+
+	// DeadLetterBucket is the bucket dead letters are written to by the s3 sink
+	DeadLetterBucket string `env:"DEAD_LETTER_BUCKET"`
+
+	// DeadLetterPrefix is the key prefix dead letters are written under by the
+	// s3 sink
+	DeadLetterPrefix string `env:"DEAD_LETTER_PREFIX" envDefault:"dead-letters/"`
+}
+
+// WithTarget returns the configuration publishing to another target of the
+// same sink, a queue URL, topic ARN, event bus, stream name or output path
+// depending on the sink.
+func (c Config) WithTarget(target string) (Config, error) {
+	switch c.Sink {
+	case "", "sqs":
+		c.QueueURL = target
+	case "sns":
+		c.TopicARN = target
+	case "eventbridge":
+		c.EventBusName = target
+	case "kinesis":
+		c.StreamName = target
+	case "file":
+		c.OutputPath = target
+	default:
+		return Config{}, fmt.Errorf("the %s sink has no target", c.Sink)
+	}
+	return c, nil
+}
+
+// Target returns the target of the sink, the queue URL, topic ARN, event bus,
+// stream name or output path depending on the sink, or empty if it has none.
+func (c Config) Target() string {
+	switch c.Sink {
+	case "", "sqs":
+		return c.QueueURL
+	case "sns":
+		return c.TopicARN
+	case "eventbridge":
+		return c.EventBusName
+	case "kinesis":
+		return c.StreamName
+	case "file":
+		return c.OutputPath
+	default:
+		return ""
+	}
+}
+
+// Limits are the batching limits of a sink.
+type Limits struct {
+	// MaxEntries is the maximum number of messages in a batch.
+	MaxEntries int
+
+	// MaxEntryBytes is the maximum size of a single message.
+	MaxEntryBytes int
+
+	// MaxBatchBytes is the maximum total size of the messages in a batch.
+	MaxBatchBytes int
+
+	// MaxAttributes is the maximum number of attributes on a message, zero if
+	// the sink does not support message attributes and drops them.
+	MaxAttributes int
+
+	// EntryOverhead is the size every message adds to a batch on top of its
+	// own, such as the source and detail type of an event.
+	EntryOverhead int
+}
+
+// Size returns the size a message counts toward MaxBatchBytes.
+func (l Limits) Size(msg envelope.Message) int {
+	return msg.Size() + l.EntryOverhead
+}
+
+// Failure describes a message of a batch that was not published.
+type Failure struct {
+	// ID is the ID of the message that failed.
+	ID string
+
+	Code    string
+	Message string
+
+	// SenderFault is true when the message itself was rejected and sending it
+	// again cannot succeed.
+	SenderFault bool
+
+	// Throttled is true when the message was rejected because the sink is
+	// receiving more than it can absorb.
+	Throttled bool
+}
+
+// Publisher publishes batches of messages to a sink.
+type Publisher interface {
+	// PublishBatch publishes a batch of messages that respects the limits of
+	// the sink. Messages that were not published are returned as failures, an
+	// error means the batch as a whole could not be sent.
+	PublishBatch(ctx context.Context, messages []envelope.Message) ([]Failure, error)
+
+	// Limits returns the batching limits of the sink.
+	Limits() Limits
+}
+
+// New creates the publisher for the sink selected by the configuration.
+func New(cfg Config, awscfg aws.Config) (Publisher, error) {
+	switch cfg.Sink {
+	case "", "sqs":
+		if cfg.QueueURL == "" {
+			return nil, errors.New("QUEUE_URL is required for the sqs sink")
+		}
+		return NewSQS(sqs.NewFromConfig(awscfg), cfg.QueueURL, cfg.FIFOQueue), nil
+	case "sns":
+		if cfg.TopicARN == "" {
+			return nil, errors.New("TOPIC_ARN is required for the sns sink")
+		}
+		return NewSNS(sns.NewFromConfig(awscfg), cfg.TopicARN, cfg.FIFOQueue), nil
+	case "eventbridge":
+		return NewEventBridge(eventbridge.NewFromConfig(awscfg), cfg.EventBusName, cfg.EventSource, cfg.EventDetailType), nil
+	case "kinesis":
+		if cfg.StreamName == "" {
+			return nil, errors.New("STREAM_NAME is required for the kinesis sink")
+		}
+		return NewKinesis(kinesis.NewFromConfig(awscfg), cfg.StreamName), nil
+	case "stdout":
+		return NewNDJSON(os.Stdout), nil
+	case "file":
+		if cfg.OutputPath == "" {
+			return nil, errors.New("OUTPUT_PATH is required for the file sink")
+		}
+		f, err := os.OpenFile(cfg.OutputPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open output file: %w", err)
+		}
+		return NewNDJSON(f), nil
+	default:
+		return nil, fmt.Errorf("unknown publish sink %q", cfg.Sink)
+	}
+}
+
+// throttleCodes are the error codes AWS services use when a request is
+// throttled.
+var throttleCodes = map[string]bool{
+	"ThrottlingException":                    true,
+	"Throttling":                             true,
+	"ThrottledException":                     true,
+	"RequestThrottled":                       true,
+	"KmsThrottled":                           true,
+	"KMSThrottling":                          true,
+	"KMSThrottlingException":                 true,
+	"ProvisionedThroughputExceededException": true,
+}
+
+// isThrottle reports whether an error code means the request was throttled.
+func isThrottle(code string) bool {
+	return throttleCodes[code]
+}
+
+// isThrottleError reports whether an error returned by an AWS client was
+// caused by throttling.
+func isThrottleError(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && isThrottle(apiErr.ErrorCode())
+}
+
+// isRetryableError reports whether a batch that failed as a whole may be
+// published by sending it again, because it was throttled, the service failed
+// rather than rejecting the request, or the request never made it there.
+func isRetryableError(err error) bool {
+	// A canceled or expired context is a net.Error too, but sending again
+	// cannot succeed
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if isThrottleError(err) {
+		return true
+	}
+
+	var sendErr *smithyhttp.RequestSendError
+	if errors.As(err, &sendErr) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorFault() == smithy.FaultServer {
+		return true
+	}
+
+	var respErr *smithyhttp.ResponseError
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() >= 500
+}
+
+// batchFailure describes the failure of every message of a batch that failed
+// as a whole with err.
+func batchFailure(err error) Failure {
+	failed := Failure{Code: "BatchFailed", Message: err.Error()}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		failed.Code = apiErr.ErrorCode()
+		failed.SenderFault = apiErr.ErrorFault() == smithy.FaultClient
+	}
+
+	return failed
+}
@@ -0,0 +1,138 @@
+package publisher
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// rateDecreaseFactor is how much the allowed rate is multiplied by when
+	// publishing is throttled.
+	rateDecreaseFactor = 0.5
+
+	// rateIncreaseStep is the fraction of the configured rate added back after
+	// every ramp interval without throttling.
+	rateIncreaseStep = 0.1
+
+	// rateMinScale is the smallest fraction of the configured rate the limiter
+	// backs off to.
+	rateMinScale = 0.05
+)
+
+// RateLimiter is a token bucket limiter on the messages and bytes published
+// per second. Every Sender has its own, so the configured rates apply to each
+// target separately, shared by every file published to it during an
+// invocation. It adapts to throttling: the allowed rate is halved when the sink
+// throttles a request and increased again in steps toward the configured rate
+// while it does not. Requests in flight together are usually throttled
+// together, so the rate is halved at most once per ramp interval.
+//
+// A zero configured rate leaves that dimension unlimited, and an unlimited
+// dimension does not adapt.
+type RateLimiter struct {
+	messages *rate.Limiter
+	bytes    *rate.Limiter
+
+	maxMessages  rate.Limit
+	maxBytes     rate.Limit
+	rampInterval time.Duration
+
+	// now returns the current time.
+	now func() time.Time
+
+	// mu guards the adaptive state below.
+	mu           sync.Mutex
+	scale        float64
+	lastChange   time.Time
+	lastDecrease time.Time
+}
+
+// NewRateLimiter creates a rate limiter configured by cfg for a sink with the
+// given limits.
+func NewRateLimiter(cfg Config, limits Limits) *RateLimiter {
+	l := &RateLimiter{
+		messages:     rate.NewLimiter(rate.Inf, 0),
+		bytes:        rate.NewLimiter(rate.Inf, 0),
+		maxMessages:  rate.Inf,
+		maxBytes:     rate.Inf,
+		rampInterval: cfg.PublishRampInterval,
+		now:          time.Now,
+		scale:        1,
+	}
+
+	// The bursts must allow a full batch through or WaitN would never succeed
+	if cfg.PublishMessagesPerSecond > 0 {
+		l.maxMessages = rate.Limit(cfg.PublishMessagesPerSecond)
+		l.messages = rate.NewLimiter(l.maxMessages, max(int(cfg.PublishMessagesPerSecond), limits.MaxEntries))
+	}
+
+	if cfg.PublishBytesPerSecond > 0 {
+		l.maxBytes = rate.Limit(cfg.PublishBytesPerSecond)
+		l.bytes = rate.NewLimiter(l.maxBytes, max(cfg.PublishBytesPerSecond, limits.MaxBatchBytes))
+	}
+
+	return l
+}
+
+// Wait blocks until a batch of the given number of messages and bytes may be
+// published.
+func (l *RateLimiter) Wait(ctx context.Context, messages, bytes int) error {
+	if err := l.messages.WaitN(ctx, messages); err != nil {
+		return err
+	}
+
+	return l.bytes.WaitN(ctx, bytes)
+}
+
+// Throttled backs the allowed rate off after the sink throttled a request,
+// unless it was already backed off within the last ramp interval.
+func (l *RateLimiter) Throttled() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.lastDecrease.IsZero() && l.now().Sub(l.lastDecrease) < l.rampInterval {
+		return
+	}
+
+	l.setScale(max(l.scale*rateDecreaseFactor, rateMinScale))
+	l.lastDecrease = l.lastChange
+}
+
+// Succeeded ramps the allowed rate back up toward the configured rate if
+// nothing has been throttled for a ramp interval.
+func (l *RateLimiter) Succeeded() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.scale >= 1 || l.now().Sub(l.lastChange) < l.rampInterval {
+		return
+	}
+
+	l.setScale(min(l.scale+rateIncreaseStep, 1))
+}
+
+// Scale returns the fraction of the configured rate currently allowed.
+func (l *RateLimiter) Scale() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.scale
+}
+
+// setScale applies a new fraction of the configured rate. It must be called
+// with mu held.
+func (l *RateLimiter) setScale(scale float64) {
+	l.scale = scale
+	l.lastChange = l.now()
+
+	if l.maxMessages != rate.Inf {
+		l.messages.SetLimitAt(l.lastChange, l.maxMessages*rate.Limit(scale))
+	}
+
+	if l.maxBytes != rate.Inf {
+		l.bytes.SetLimitAt(l.lastChange, l.maxBytes*rate.Limit(scale))
+	}
+}
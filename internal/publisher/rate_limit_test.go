@@ -0,0 +1,150 @@
+package publisher
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock that only moves when it is advanced.
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+// testRateLimiter returns a limiter of 10 messages per second, with bursts of
+// a single batch of 10 messages, running on a fake clock.
+func testRateLimiter() (*RateLimiter, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)}
+
+	l := NewRateLimiter(Config{PublishMessagesPerSecond: 10, PublishRampInterval: 5 * time.Second}, Limits{MaxEntries: 10})
+	l.now = clock.now
+
+	// Start with an empty bucket
+	l.messages.AllowN(clock.now(), 10)
+	return l, clock
+}
+
+func TestRateLimiterRefills(t *testing.T) {
+	l, clock := testRateLimiter()
+
+	if l.messages.AllowN(clock.now(), 1) {
+		t.Fatal("allowed a message from an empty bucket")
+	}
+
+	clock.advance(100 * time.Millisecond)
+	if !l.messages.AllowN(clock.now(), 1) {
+		t.Fatal("did not allow a message after refilling for 100ms at 10 per second")
+	}
+	if l.messages.AllowN(clock.now(), 1) {
+		t.Fatal("allowed a second message after refilling for 100ms at 10 per second")
+	}
+
+	// The bucket never holds more than a burst
+	clock.advance(time.Hour)
+	if !l.messages.AllowN(clock.now(), 10) || l.messages.AllowN(clock.now(), 1) {
+		t.Fatal("bucket did not refill to exactly a burst of 10")
+	}
+}
+
+func TestRateLimiterBacksOffWhenThrottled(t *testing.T) {
+	l, clock := testRateLimiter()
+
+	l.Throttled()
+	if l.Scale() != 0.5 {
+		t.Fatalf("scale = %v after throttling, want 0.5", l.Scale())
+	}
+
+	// At half the rate a message takes 200ms to refill
+	clock.advance(100 * time.Millisecond)
+	if l.messages.AllowN(clock.now(), 1) {
+		t.Fatal("allowed a message after 100ms at 5 per second")
+	}
+	clock.advance(100 * time.Millisecond)
+	if !l.messages.AllowN(clock.now(), 1) {
+		t.Fatal("did not allow a message after 200ms at 5 per second")
+	}
+
+	// Throttling within the same ramp interval does not back off further
+	l.Throttled()
+	if l.Scale() != 0.5 {
+		t.Fatalf("scale = %v after throttling twice in a ramp interval, want 0.5", l.Scale())
+	}
+
+	// Backing off stops at the minimum scale
+	for range 10 {
+		clock.advance(5 * time.Second)
+		l.Throttled()
+	}
+	if l.Scale() != rateMinScale {
+		t.Fatalf("scale = %v after throttling repeatedly, want %v", l.Scale(), rateMinScale)
+	}
+}
+
+func TestRateLimiterBacksOffOnceForConcurrentThrottles(t *testing.T) {
+	l, _ := testRateLimiter()
+
+	// Requests in flight together are throttled together
+	var wg sync.WaitGroup
+	for range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.Throttled()
+		}()
+	}
+	wg.Wait()
+
+	if l.Scale() != 0.5 {
+		t.Fatalf("scale = %v after 16 concurrent throttles, want 0.5", l.Scale())
+	}
+}
+
+func TestRateLimiterRecovers(t *testing.T) {
+	l, clock := testRateLimiter()
+
+	l.Throttled()
+
+	// Nothing is raised before a ramp interval passes without throttling
+	clock.advance(4 * time.Second)
+	l.Succeeded()
+	if l.Scale() != 0.5 {
+		t.Fatalf("scale = %v within the ramp interval, want 0.5", l.Scale())
+	}
+
+	// Then the rate is raised a step per interval, up to the configured rate
+	want := 0.5
+	for range 10 {
+		clock.advance(5 * time.Second)
+		l.Succeeded()
+		want = min(want+rateIncreaseStep, 1)
+
+		if diff := l.Scale() - want; diff > 1e-9 || diff < -1e-9 {
+			t.Fatalf("scale = %v, want %v", l.Scale(), want)
+		}
+	}
+
+	// Back at the configured rate the bucket refills at 10 per second again
+	l.messages.AllowN(clock.now(), 10)
+	clock.advance(100 * time.Millisecond)
+	if !l.messages.AllowN(clock.now(), 1) {
+		t.Fatal("did not allow a message after 100ms at the recovered rate")
+	}
+}
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	l := NewRateLimiter(Config{}, Limits{MaxEntries: 10, MaxBatchBytes: 1 << 20})
+
+	l.Throttled()
+	if err := l.Wait(context.Background(), 1_000, 1<<30); err != nil {
+		t.Fatalf("unlimited limiter refused a batch: %v", err)
+	}
+}
@@ -0,0 +1,315 @@
+package publisher
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/envelope"
+)
+
+// Stats counts the outcome of publishing across all batches of an invocation.
+// It is safe for concurrent use.
+type Stats struct {
+	// Retried is the number of messages that were sent again after failing.
+	Retried atomic.Int64
+
+	// Failed is the number of messages that could not be published and were
+	// routed to the dead letter sink.
+	Failed atomic.Int64
+
+	// ClaimChecked is the number of messages whose body was stored in S3 and
+	// published as a pointer.
+	ClaimChecked atomic.Int64
+}
+
+// Sender sends batches of messages through a Publisher, retrying the messages
+// of a batch that fail transiently. Messages rejected with a sender fault are
+// routed to the dead letter sink straight away, all other failed messages are
+// sent again with exponential backoff until they succeed or run out of
+// attempts. A batch that fails as a whole because it was throttled or the
+// service failed is sent again the same way, any other batch that fails as a
+// whole has every message dead lettered.
+//
+// A Sender is shared by every file of an invocation published to its target,
+// so its rate limiter and in flight limit apply to the target as a whole.
+type Sender struct {
+	pub         Publisher
+	deadLetters DeadLetterSink
+	claims      *ClaimChecker
+	limiter     *RateLimiter
+	logger      *slog.Logger
+	maxAttempts int
+	backoffBase time.Duration
+	backoffMax  time.Duration
+
+	// inFlight is a semaphore bounding the number of concurrent batch calls
+	// across every file being published.
+	inFlight chan struct{}
+}
+
+// NewSender creates a sender configured by cfg. Claim checking is disabled if
+// claims is nil.
+func NewSender(logger *slog.Logger, pub Publisher, deadLetters DeadLetterSink, claims *ClaimChecker, cfg Config) *Sender {
+	maxInFlight := cfg.PublishMaxInFlight
+	if maxInFlight <= 0 {
+		maxInFlight = max(cfg.PublishConcurrency, 1)
+	}
+
+	return &Sender{
+		pub:         pub,
+		deadLetters: deadLetters,
+		claims:      claims,
+		limiter:     NewRateLimiter(cfg, pub.Limits()),
+		logger:      logger,
+		maxAttempts: max(cfg.PublishMaxAttempts, 1),
+		backoffBase: cfg.PublishBackoffBase,
+		backoffMax:  cfg.PublishBackoffMax,
+		inFlight:    make(chan struct{}, maxInFlight),
+	}
+}
+
+// Limits returns the batching limits of the underlying publisher.
+func (s *Sender) Limits() Limits {
+	return s.pub.Limits()
+}
+
+// ClaimChecks reports whether messages larger than the sink accepts are
+// published by claim checking them. Records that do not fit in a message can
+// only be published when it is true.
+func (s *Sender) ClaimChecks() bool {
+	return s.claims != nil
+}
+
+// Request is a batch of messages to send.
+type Request struct {
+	// BatchIndex identifies the batch in logs.
+	BatchIndex int
+
+	// Source is the file the messages were read from.
+	Source string
+
+	Messages []envelope.Message
+
+	// Stats collects the outcome of sending the batch.
+	Stats *Stats
+}
+
+// Send sends a batch of messages. Messages of a batch that fails as a whole
+// for good are dead lettered like messages that fail on their own. It only
+// returns an error if ctx is done, claim checking fails, a failed message could
+// not be dead lettered or the publisher reports a failure for a message that
+// is not in the batch.
+func (s *Sender) Send(ctx context.Context, req Request) error {
+	messages := req.Messages
+
+	// Swap oversized messages for pointers once, so retries do not upload
+	// their bodies again
+	if s.claims != nil {
+		checked, count, err := s.claims.Check(ctx, messages)
+		if err != nil {
+			s.logger.ErrorContext(ctx, "Failed to claim check messages",
+				"file", req.Source,
+				"batch_index", req.BatchIndex,
+				"error", err,
+			)
+			return fmt.Errorf("failed to claim check messages: %w", err)
+		}
+
+		messages = checked
+		req.Stats.ClaimChecked.Add(int64(count))
+	}
+
+	// Keep messages by ID so failures can be matched up
+	pending := make(map[string]envelope.Message, len(messages))
+	for _, msg := range messages {
+		pending[msg.ID] = msg
+	}
+
+	for attempt := 1; ; attempt++ {
+		failures, err := s.publish(ctx, messages)
+		if err != nil {
+			// Give up on the invocation rather than the messages once its
+			// context is done, they are published again when it resumes
+			if ctx.Err() != nil {
+				return err
+			}
+
+			if !isRetryableError(err) || attempt >= s.maxAttempts {
+				s.logger.ErrorContext(ctx, "Failed to publish message batch, routing to dead letter sink",
+					"file", req.Source,
+					"batch_index", req.BatchIndex,
+					"batch_size", len(messages),
+					"attempt", attempt,
+					"error", err,
+				)
+
+				failed := batchFailure(err)
+				for _, msg := range messages {
+					if err := s.deadLetter(ctx, req, msg, failed, attempt); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+
+			// The batch as a whole failed transiently, send all of it again
+			delay := backoff(attempt, s.backoffBase, s.backoffMax)
+
+			s.logger.WarnContext(ctx, "Failed to publish message batch, retrying",
+				"file", req.Source,
+				"batch_index", req.BatchIndex,
+				"attempt", attempt,
+				"retry_count", len(messages),
+				"delay", delay,
+				"error", err,
+			)
+
+			req.Stats.Retried.Add(int64(len(messages)))
+			if err := sleep(ctx, delay); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if len(failures) == 0 {
+			return nil
+		}
+
+		// Split failures into those worth retrying and those that are not
+		var retry []envelope.Message
+		for _, failed := range failures {
+			msg, ok := pending[failed.ID]
+			if !ok {
+				return fmt.Errorf("publisher reported a failure for message %q, which is not in the batch", failed.ID)
+			}
+
+			if !failed.SenderFault && attempt < s.maxAttempts {
+				retry = append(retry, msg)
+				continue
+			}
+
+			s.logger.ErrorContext(ctx, "Message failed permanently, routing to dead letter sink",
+				"file", req.Source,
+				"batch_index", req.BatchIndex,
+				"attempt", attempt,
+				"row_start", msg.RowStart,
+				"row_end", msg.RowEnd,
+				"code", failed.Code,
+				"message", failed.Message,
+				"sender_fault", failed.SenderFault,
+			)
+
+			if err := s.deadLetter(ctx, req, msg, failed, attempt); err != nil {
+				return err
+			}
+		}
+
+		if len(retry) == 0 {
+			return nil
+		}
+
+		delay := backoff(attempt, s.backoffBase, s.backoffMax)
+
+		s.logger.WarnContext(ctx, "Some messages failed to send, retrying",
+			"file", req.Source,
+			"batch_index", req.BatchIndex,
+			"attempt", attempt,
+			"retry_count", len(retry),
+			"delay", delay,
+		)
+
+		req.Stats.Retried.Add(int64(len(retry)))
+		messages = retry
+
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// deadLetter routes a message that failed permanently to the dead letter
+// sink and counts it as failed.
+func (s *Sender) deadLetter(ctx context.Context, req Request, msg envelope.Message, failed Failure, attempts int) error {
+	if err := s.deadLetters.Send(ctx, DeadLetter{
+		ID:          msg.ID,
+		Source:      req.Source,
+		RowStart:    msg.RowStart,
+		RowEnd:      msg.RowEnd,
+		Code:        failed.Code,
+		Message:     failed.Message,
+		SenderFault: failed.SenderFault,
+		Attempts:    attempts,
+		Body:        msg.Body,
+	}); err != nil {
+		return fmt.Errorf("failed to send message to dead letter sink: %w", err)
+	}
+
+	req.Stats.Failed.Add(1)
+	return nil
+}
+
+// sleep waits for delay unless ctx is done first.
+func sleep(ctx context.Context, delay time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
+
+// publish publishes a batch once the rate limiter allows it and a slot is free
+// under the in flight limit. The outcome is fed back to the rate limiter so it
+// can adapt to throttling.
+func (s *Sender) publish(ctx context.Context, messages []envelope.Message) ([]Failure, error) {
+	var size int
+	for _, msg := range messages {
+		size += msg.Size()
+	}
+
+	if err := s.limiter.Wait(ctx, len(messages), size); err != nil {
+		return nil, err
+	}
+
+	select {
+	case s.inFlight <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-s.inFlight }()
+
+	failures, err := s.pub.PublishBatch(ctx, messages)
+
+	throttled := err != nil && isThrottleError(err)
+	for _, failed := range failures {
+		throttled = throttled || failed.Throttled
+	}
+
+	if throttled {
+		s.limiter.Throttled()
+		s.logger.WarnContext(ctx, "Publishing throttled, backing off rate", "rate_scale", s.limiter.Scale())
+	} else if err == nil {
+		s.limiter.Succeeded()
+	}
+
+	return failures, err
+}
+
+// backoff returns the delay before the given retry attempt using exponential
+// backoff with full jitter.
+func backoff(attempt int, base, limit time.Duration) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+
+	ceiling := max(limit, base)
+	if shift := attempt - 1; shift < 32 && base<<shift < ceiling {
+		ceiling = base << shift
+	}
+
+	return rand.N(ceiling) + 1
+}
@@ -0,0 +1,334 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/envelope"
+)
+
+func TestSendRetriesFailedMessages(t *testing.T) {
+	pub := &scriptedPublisher{calls: []func([]envelope.Message) ([]Failure, error){
+		failIDs("b", Failure{Code: "InternalError"}),
+		succeed,
+	}}
+	sender, deadLetters := testSender(pub, 3)
+
+	stats := &Stats{}
+	if err := sender.Send(context.Background(), Request{Messages: testMessages("a", "b", "c"), Stats: stats}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := pub.sent(); len(got) != 2 || len(got[1]) != 1 || got[1][0].ID != "b" {
+		t.Fatalf("sent batches %v, want the failed message sent again alone", ids(got))
+	}
+	if stats.Retried.Load() != 1 || stats.Failed.Load() != 0 || len(deadLetters.letters) != 0 {
+		t.Fatalf("retried %d, failed %d, dead lettered %d, want one retry", stats.Retried.Load(), stats.Failed.Load(), len(deadLetters.letters))
+	}
+}
+
+func TestSendDeadLettersSenderFaults(t *testing.T) {
+	pub := &scriptedPublisher{calls: []func([]envelope.Message) ([]Failure, error){
+		failIDs("b", Failure{Code: "InvalidMessageContents", SenderFault: true}),
+	}}
+	sender, deadLetters := testSender(pub, 3)
+
+	stats := &Stats{}
+	if err := sender.Send(context.Background(), Request{Source: "s3://bucket/records.parquet", Messages: testMessages("a", "b"), Stats: stats}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(pub.sent()); n != 1 {
+		t.Fatalf("sent %d batches, want a sender fault not to be retried", n)
+	}
+	if stats.Failed.Load() != 1 || len(deadLetters.letters) != 1 {
+		t.Fatalf("failed %d, dead lettered %d, want one", stats.Failed.Load(), len(deadLetters.letters))
+	}
+	dl := deadLetters.letters[0]
+	if dl.ID != "b" || dl.Source != "s3://bucket/records.parquet" || dl.Code != "InvalidMessageContents" || !dl.SenderFault || dl.Attempts != 1 {
+		t.Fatalf("dead letter = %+v", dl)
+	}
+}
+
+func TestSendGivesUpAfterMaxAttempts(t *testing.T) {
+	fail := failIDs("a", Failure{Code: "InternalError"})
+	pub := &scriptedPublisher{calls: []func([]envelope.Message) ([]Failure, error){fail, fail, fail}}
+	sender, deadLetters := testSender(pub, 3)
+
+	stats := &Stats{}
+	if err := sender.Send(context.Background(), Request{Messages: testMessages("a"), Stats: stats}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(pub.sent()); n != 3 {
+		t.Fatalf("sent %d batches, want 3 attempts", n)
+	}
+	if stats.Retried.Load() != 2 || stats.Failed.Load() != 1 {
+		t.Fatalf("retried %d and failed %d messages, want 2 and 1", stats.Retried.Load(), stats.Failed.Load())
+	}
+	if len(deadLetters.letters) != 1 || deadLetters.letters[0].Attempts != 3 || deadLetters.letters[0].SenderFault {
+		t.Fatalf("dead letters = %+v", deadLetters.letters)
+	}
+}
+
+func TestSendRetriesFailedBatches(t *testing.T) {
+	unavailable := &smithyhttp.ResponseError{
+		Response: &smithyhttp.Response{Response: &http.Response{StatusCode: http.StatusServiceUnavailable}},
+		Err:      errors.New("service unavailable"),
+	}
+
+	tests := []struct {
+		name      string
+		err       error
+		attempts  int
+		wantCalls int
+
+		// wantCode is the code the messages are dead lettered with, if the
+		// batch is given up on
+		wantCode        string
+		wantSenderFault bool
+	}{
+		{name: "throttled", err: &smithy.GenericAPIError{Code: "RequestThrottled"}, attempts: 3, wantCalls: 2},
+		{name: "kms throttled", err: &smithy.GenericAPIError{Code: "KMSThrottling"}, attempts: 3, wantCalls: 2},
+		{name: "server fault", err: &smithy.GenericAPIError{Code: "InternalError", Fault: smithy.FaultServer}, attempts: 3, wantCalls: 2},
+		{name: "server error", err: unavailable, attempts: 3, wantCalls: 2},
+		{name: "client error", err: &smithy.GenericAPIError{Code: "AccessDenied", Fault: smithy.FaultClient}, attempts: 3, wantCalls: 1, wantCode: "AccessDenied", wantSenderFault: true},
+		{name: "out of attempts", err: &smithy.GenericAPIError{Code: "RequestThrottled"}, attempts: 1, wantCalls: 1, wantCode: "RequestThrottled"},
+		{name: "server error out of attempts", err: unavailable, attempts: 1, wantCalls: 1, wantCode: "BatchFailed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pub := &scriptedPublisher{calls: []func([]envelope.Message) ([]Failure, error){
+				func([]envelope.Message) ([]Failure, error) { return nil, tt.err },
+				succeed,
+			}}
+			sender, deadLetters := testSender(pub, tt.attempts)
+
+			stats := &Stats{}
+			if err := sender.Send(context.Background(), Request{Messages: testMessages("a", "b"), Stats: stats}); err != nil {
+				t.Fatal(err)
+			}
+
+			sent := pub.sent()
+			if len(sent) != tt.wantCalls {
+				t.Fatalf("sent %d batches, want %d", len(sent), tt.wantCalls)
+			}
+
+			if tt.wantCode == "" {
+				if len(sent[1]) != 2 || stats.Retried.Load() != 2 {
+					t.Fatalf("sent batches %v and retried %d messages, want the whole batch sent again", ids(sent), stats.Retried.Load())
+				}
+				if len(deadLetters.letters) != 0 {
+					t.Fatalf("dead lettered %d messages of a batch that was sent again", len(deadLetters.letters))
+				}
+				return
+			}
+
+			// Every message of a batch given up on is dead lettered
+			if stats.Failed.Load() != 2 || len(deadLetters.letters) != 2 {
+				t.Fatalf("failed %d, dead lettered %d, want the whole batch", stats.Failed.Load(), len(deadLetters.letters))
+			}
+			for i, dl := range deadLetters.letters {
+				if dl.ID != []string{"a", "b"}[i] || dl.Code != tt.wantCode || dl.SenderFault != tt.wantSenderFault || dl.Attempts != tt.wantCalls {
+					t.Fatalf("dead letter = %+v", dl)
+				}
+			}
+		})
+	}
+}
+
+func TestIsRetryableError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "throttled", err: &smithy.GenericAPIError{Code: "ThrottlingException"}, want: true},
+		{name: "server fault", err: &smithy.GenericAPIError{Code: "InternalError", Fault: smithy.FaultServer}, want: true},
+		{name: "client fault", err: &smithy.GenericAPIError{Code: "AccessDenied", Fault: smithy.FaultClient}},
+		{name: "request not sent", err: &smithyhttp.RequestSendError{Err: errors.New("connection refused")}, want: true},
+		{name: "connection reset", err: fmt.Errorf("send: %w", &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}), want: true},
+		{name: "dns failure", err: &net.DNSError{Err: "no such host", Name: "sqs.us-east-1.amazonaws.com"}, want: true},
+		{name: "unexpected eof", err: fmt.Errorf("read response: %w", io.ErrUnexpectedEOF), want: true},
+		{name: "canceled", err: fmt.Errorf("send: %w", context.Canceled)},
+		{name: "canceled while sending", err: &smithyhttp.RequestSendError{Err: context.Canceled}},
+		{name: "deadline exceeded", err: context.DeadlineExceeded},
+		{name: "unknown", err: errors.New("invalid request")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryableError(tt.err); got != tt.want {
+				t.Fatalf("isRetryableError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendStopsWhenTheContextIsDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	pub := &scriptedPublisher{calls: []func([]envelope.Message) ([]Failure, error){
+		func([]envelope.Message) ([]Failure, error) {
+			cancel()
+			return nil, context.Canceled
+		},
+	}}
+	sender, deadLetters := testSender(pub, 3)
+
+	if err := sender.Send(ctx, Request{Messages: testMessages("a"), Stats: &Stats{}}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context canceled", err)
+	}
+	if len(deadLetters.letters) != 0 {
+		t.Fatalf("dead lettered %d messages of a canceled batch", len(deadLetters.letters))
+	}
+}
+
+func TestSendRejectsFailuresOfUnknownMessages(t *testing.T) {
+	pub := &scriptedPublisher{calls: []func([]envelope.Message) ([]Failure, error){
+		func([]envelope.Message) ([]Failure, error) {
+			return []Failure{{ID: "unknown", Code: "InternalError"}}, nil
+		},
+	}}
+	sender, deadLetters := testSender(pub, 3)
+
+	if err := sender.Send(context.Background(), Request{Messages: testMessages("a"), Stats: &Stats{}}); err == nil {
+		t.Fatal("expected an error for a failure of a message not in the batch")
+	}
+	if n := len(pub.sent()); n != 1 || len(deadLetters.letters) != 0 {
+		t.Fatalf("sent %d batches and dead lettered %d messages, want neither retried nor dead lettered", n, len(deadLetters.letters))
+	}
+}
+
+func TestS3DeadLetterKeys(t *testing.T) {
+	client := &putRecorder{}
+	sink := &S3DeadLetterSink{client: client, bucket: "dead-letters", prefix: "dead-letters/"}
+
+	// The same rows published under two configurations
+	for _, id := range []string{"first", "second"} {
+		if err := sink.Send(context.Background(), DeadLetter{ID: id, Source: "s3://bucket/records.parquet", RowStart: 0, RowEnd: 50}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(client.keys) != 2 || client.keys[0] == client.keys[1] {
+		t.Fatalf("dead letters written to %q, want distinct keys", client.keys)
+	}
+	for _, key := range client.keys {
+		if !strings.HasPrefix(key, "dead-letters/s3:") || !strings.HasSuffix(key, ".json") {
+			t.Fatalf("dead letter written to %s", key)
+		}
+	}
+}
+
+// testSender returns a sender of pub making up to attempts attempts without
+// any delay between them, and the dead letter sink it routes failures to.
+func testSender(pub Publisher, attempts int) (*Sender, *deadLetterRecorder) {
+	deadLetters := &deadLetterRecorder{}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	return NewSender(logger, pub, deadLetters, nil, Config{PublishConcurrency: 1, PublishMaxAttempts: attempts}), deadLetters
+}
+
+func testMessages(ids ...string) []envelope.Message {
+	messages := make([]envelope.Message, len(ids))
+	for i, id := range ids {
+		messages[i] = envelope.Message{ID: id, Body: []byte(`{"id":"` + id + `"}`), RowStart: int64(i), RowEnd: int64(i + 1)}
+	}
+	return messages
+}
+
+func ids(batches [][]envelope.Message) [][]string {
+	out := make([][]string, len(batches))
+	for i, batch := range batches {
+		for _, msg := range batch {
+			out[i] = append(out[i], msg.ID)
+		}
+	}
+	return out
+}
+
+func succeed([]envelope.Message) ([]Failure, error) {
+	return nil, nil
+}
+
+// failIDs returns a call failing the message with the given ID, if it is
+// part of the batch.
+func failIDs(id string, failure Failure) func([]envelope.Message) ([]Failure, error) {
+	return func(messages []envelope.Message) ([]Failure, error) {
+		for _, msg := range messages {
+			if msg.ID == id {
+				failure.ID = id
+				return []Failure{failure}, nil
+			}
+		}
+		return nil, nil
+	}
+}
+
+// scriptedPublisher answers its calls in order with calls, succeeding once
+// they run out, and records the batches it is sent.
+type scriptedPublisher struct {
+	calls []func([]envelope.Message) ([]Failure, error)
+
+	mu      sync.Mutex
+	batches [][]envelope.Message
+}
+
+func (p *scriptedPublisher) Limits() Limits {
+	return NewNDJSON(io.Discard).Limits()
+}
+
+func (p *scriptedPublisher) PublishBatch(_ context.Context, messages []envelope.Message) ([]Failure, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	call := succeed
+	if n := len(p.batches); n < len(p.calls) {
+		call = p.calls[n]
+	}
+	p.batches = append(p.batches, messages)
+
+	return call(messages)
+}
+
+func (p *scriptedPublisher) sent() [][]envelope.Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.batches
+}
+
+// deadLetterRecorder is a dead letter sink recording what it is sent.
+type deadLetterRecorder struct {
+	letters []DeadLetter
+}
+
+func (r *deadLetterRecorder) Send(_ context.Context, dl DeadLetter) error {
+	r.letters = append(r.letters, dl)
+	return nil
+}
+
+// putRecorder records the keys of the objects put.
+type putRecorder struct {
+	keys []string
+}
+
+func (r *putRecorder) PutObject(_ context.Context, in *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	r.keys = append(r.keys, aws.ToString(in.Key))
+	return &s3.PutObjectOutput{}, nil
+}
@@ -0,0 +1,173 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	eventbridgetypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	kinesistypes "github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+func TestSQSFailures(t *testing.T) {
+	client := &fakeSQS{failed: []sqstypes.BatchResultErrorEntry{
+		{Id: aws.String("b"), Code: aws.String("InvalidParameterValue"), Message: aws.String("bad body"), SenderFault: true},
+		{Id: aws.String("c"), Code: aws.String("ThrottlingException")},
+		{Id: aws.String("d"), Code: aws.String("InternalError")},
+	}}
+
+	failures, err := NewSQS(client, "queue", false).PublishBatch(context.Background(), testMessages("a", "b", "c", "d"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkFailures(t, failures, []Failure{
+		{ID: "b", Code: "InvalidParameterValue", Message: "bad body", SenderFault: true},
+		{ID: "c", Code: "ThrottlingException", Throttled: true},
+		{ID: "d", Code: "InternalError"},
+	})
+}
+
+func TestSNSFailures(t *testing.T) {
+	client := &fakeSNS{failed: []snstypes.BatchResultErrorEntry{
+		{Id: aws.String("b"), Code: aws.String("InvalidParameter"), Message: aws.String("bad attribute"), SenderFault: true},
+		{Id: aws.String("c"), Code: aws.String("KMSThrottling")},
+		{Id: aws.String("d"), Code: aws.String("InternalError")},
+	}}
+
+	failures, err := NewSNS(client, "topic", false).PublishBatch(context.Background(), testMessages("a", "b", "c", "d"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkFailures(t, failures, []Failure{
+		{ID: "b", Code: "InvalidParameter", Message: "bad attribute", SenderFault: true},
+		{ID: "c", Code: "KMSThrottling", Throttled: true},
+		{ID: "d", Code: "InternalError"},
+	})
+}
+
+func TestEventBridgeFailures(t *testing.T) {
+	// Results are matched to messages by position
+	client := &fakeEventBridge{entries: []eventbridgetypes.PutEventsResultEntry{
+		{EventId: aws.String("event-a")},
+		{ErrorCode: aws.String("MalformedDetail"), ErrorMessage: aws.String("detail is not JSON")},
+		{ErrorCode: aws.String("ThrottlingException")},
+		{ErrorCode: aws.String("InternalFailure")},
+	}}
+
+	failures, err := NewEventBridge(client, "bus", "source", "detail").PublishBatch(context.Background(), testMessages("a", "b", "c", "d"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkFailures(t, failures, []Failure{
+		{ID: "b", Code: "MalformedDetail", Message: "detail is not JSON", SenderFault: true},
+		{ID: "c", Code: "ThrottlingException", Throttled: true},
+		{ID: "d", Code: "InternalFailure"},
+	})
+}
+
+func TestKinesisFailures(t *testing.T) {
+	// Results are matched to messages by position
+	client := &fakeKinesis{records: []kinesistypes.PutRecordsResultEntry{
+		{SequenceNumber: aws.String("1"), ShardId: aws.String("shard-0")},
+		{ErrorCode: aws.String("KMSAccessDeniedException"), ErrorMessage: aws.String("access denied")},
+		{ErrorCode: aws.String("ProvisionedThroughputExceededException")},
+		{ErrorCode: aws.String("InternalFailure")},
+	}}
+
+	failures, err := NewKinesis(client, "stream").PublishBatch(context.Background(), testMessages("a", "b", "c", "d"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkFailures(t, failures, []Failure{
+		{ID: "b", Code: "KMSAccessDeniedException", Message: "access denied", SenderFault: true},
+		{ID: "c", Code: "ProvisionedThroughputExceededException", Throttled: true},
+		{ID: "d", Code: "InternalFailure"},
+	})
+}
+
+func TestNDJSONFailures(t *testing.T) {
+	// A failed write fails the batch as a whole, and is not retried
+	_, err := NewNDJSON(failingWriter{}).PublishBatch(context.Background(), testMessages("a", "b"))
+	if err == nil {
+		t.Fatal("expected an error for a failed write")
+	}
+	if isRetryableError(err) {
+		t.Fatalf("error %v is retryable, want a failed write dead lettered", err)
+	}
+}
+
+// checkFailures fails the test unless got matches want.
+func checkFailures(t *testing.T, got, want []Failure) {
+	t.Helper()
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("failures = %+v, want %+v", got, want)
+	}
+}
+
+// fakeSQS is an SQS client failing the entries in failed.
+type fakeSQS struct {
+	failed []sqstypes.BatchResultErrorEntry
+}
+
+func (c *fakeSQS) SendMessageBatch(_ context.Context, _ *sqs.SendMessageBatchInput, _ ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error) {
+	return &sqs.SendMessageBatchOutput{Failed: c.failed}, nil
+}
+
+// fakeSNS is an SNS client failing the entries in failed.
+type fakeSNS struct {
+	failed []snstypes.BatchResultErrorEntry
+}
+
+func (c *fakeSNS) PublishBatch(_ context.Context, _ *sns.PublishBatchInput, _ ...func(*sns.Options)) (*sns.PublishBatchOutput, error) {
+	return &sns.PublishBatchOutput{Failed: c.failed}, nil
+}
+
+// fakeEventBridge is an EventBridge client answering with entries.
+type fakeEventBridge struct {
+	entries []eventbridgetypes.PutEventsResultEntry
+}
+
+func (c *fakeEventBridge) PutEvents(_ context.Context, _ *eventbridge.PutEventsInput, _ ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error) {
+	var failed int32
+	for _, entry := range c.entries {
+		if entry.ErrorCode != nil {
+			failed++
+		}
+	}
+	return &eventbridge.PutEventsOutput{Entries: c.entries, FailedEntryCount: failed}, nil
+}
+
+// fakeKinesis is a Kinesis client answering with records.
+type fakeKinesis struct {
+	records []kinesistypes.PutRecordsResultEntry
+}
+
+func (c *fakeKinesis) PutRecords(_ context.Context, _ *kinesis.PutRecordsInput, _ ...func(*kinesis.Options)) (*kinesis.PutRecordsOutput, error) {
+	var failed int32
+	for _, record := range c.records {
+		if record.ErrorCode != nil {
+			failed++
+		}
+	}
+	return &kinesis.PutRecordsOutput{Records: c.records, FailedRecordCount: aws.Int32(failed)}, nil
+}
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
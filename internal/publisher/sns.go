@@ -0,0 +1,83 @@
+package publisher
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/envelope"
+)
+
+// SNSAPI is the subset of the SNS client used to publish messages.
+type SNSAPI interface {
+	PublishBatch(ctx context.Context, params *sns.PublishBatchInput, optFns ...func(*sns.Options)) (*sns.PublishBatchOutput, error)
+}
+
+// SNS publishes messages to an SNS topic with PublishBatch.
+type SNS struct {
+	client   SNSAPI
+	topicARN string
+	fifo     bool
+}
+
+// NewSNS creates a publisher for an SNS topic. On FIFO topics the message ID
+// is used as the deduplication ID and the message key as the message group.
+func NewSNS(client SNSAPI, topicARN string, fifo bool) *SNS {
+	return &SNS{client: client, topicARN: topicARN, fifo: fifo}
+}
+
+// Limits returns the PublishBatch limits.
+func (p *SNS) Limits() Limits {
+	return Limits{
+		MaxEntries:    10,
+		MaxEntryBytes: 256 * 1024,
+		MaxBatchBytes: 256 * 1024,
+		MaxAttributes: 10,
+	}
+}
+
+// PublishBatch publishes the messages with a single PublishBatch call.
+func (p *SNS) PublishBatch(ctx context.Context, messages []envelope.Message) ([]Failure, error) {
+	entries := make([]types.PublishBatchRequestEntry, len(messages))
+	for i, msg := range messages {
+		entries[i] = types.PublishBatchRequestEntry{
+			Id:                aws.String(msg.ID),
+			Message:           aws.String(string(msg.Body)),
+			MessageAttributes: messageAttributes(msg.Attributes, snsAttribute),
+		}
+
+		if p.fifo {
+			entries[i].MessageDeduplicationId = aws.String(msg.ID)
+			entries[i].MessageGroupId = aws.String(msg.Key)
+		}
+	}
+
+	result, err := p.client.PublishBatch(ctx, &sns.PublishBatchInput{
+		TopicArn:                   aws.String(p.topicARN),
+		PublishBatchRequestEntries: entries,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to publish batch to SNS: %w", err)
+	}
+
+	failures := make([]Failure, len(result.Failed))
+	for i, failed := range result.Failed {
+		failures[i] = Failure{
+			ID:          aws.ToString(failed.Id),
+			Code:        aws.ToString(failed.Code),
+			Message:     aws.ToString(failed.Message),
+			SenderFault: failed.SenderFault,
+			Throttled:   isThrottle(aws.ToString(failed.Code)),
+		}
+	}
+
+	return failures, nil
+}
+
+// snsAttribute builds an SNS message attribute.
+func snsAttribute(dataType string, stringValue *string, binaryValue []byte) types.MessageAttributeValue {
+	return types.MessageAttributeValue{DataType: aws.String(dataType), StringValue: stringValue, BinaryValue: binaryValue}
+}
@@ -0,0 +1,85 @@
+package publisher
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/envelope"
+)
+
+// SQSAPI is the subset of the SQS client used to publish messages.
+type SQSAPI interface {
+	SendMessageBatch(ctx context.Context, params *sqs.SendMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error)
+}
+
+// SQS publishes messages to an SQS queue with SendMessageBatch.
+type SQS struct {
+	client   SQSAPI
+	queueURL string
+	fifo     bool
+}
+
+// NewSQS creates a publisher for an SQS queue. On FIFO queues the message ID
+// doubles as the deduplication ID, so republishing a file within the
+// deduplication window does not produce duplicates, and the message key is
+// used as the message group.
+func NewSQS(client SQSAPI, queueURL string, fifo bool) *SQS {
+	return &SQS{client: client, queueURL: queueURL, fifo: fifo}
+}
+
+// Limits returns the SendMessageBatch limits.
+func (p *SQS) Limits() Limits {
+	return Limits{
+		MaxEntries:    10,
+		MaxEntryBytes: 256 * 1024,
+		MaxBatchBytes: 256 * 1024,
+		MaxAttributes: 10,
+	}
+}
+
+// PublishBatch sends the messages with a single SendMessageBatch call.
+func (p *SQS) PublishBatch(ctx context.Context, messages []envelope.Message) ([]Failure, error) {
+	entries := make([]types.SendMessageBatchRequestEntry, len(messages))
+	for i, msg := range messages {
+		entries[i] = types.SendMessageBatchRequestEntry{
+			Id:                aws.String(msg.ID),
+			MessageBody:       aws.String(string(msg.Body)),
+			MessageAttributes: messageAttributes(msg.Attributes, sqsAttribute),
+		}
+
+		if p.fifo {
+			entries[i].MessageDeduplicationId = aws.String(msg.ID)
+			entries[i].MessageGroupId = aws.String(msg.Key)
+		}
+	}
+
+	result, err := p.client.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{
+		QueueUrl: aws.String(p.queueURL),
+		Entries:  entries,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to send message batch to SQS: %w", err)
+	}
+
+	failures := make([]Failure, len(result.Failed))
+	for i, failed := range result.Failed {
+		failures[i] = Failure{
+			ID:          aws.ToString(failed.Id),
+			Code:        aws.ToString(failed.Code),
+			Message:     aws.ToString(failed.Message),
+			SenderFault: failed.SenderFault,
+			Throttled:   isThrottle(aws.ToString(failed.Code)),
+		}
+	}
+
+	return failures, nil
+}
+
+// sqsAttribute builds an SQS message attribute.
+func sqsAttribute(dataType string, stringValue *string, binaryValue []byte) types.MessageAttributeValue {
+	return types.MessageAttributeValue{DataType: aws.String(dataType), StringValue: stringValue, BinaryValue: binaryValue}
+}
@@ -0,0 +1,134 @@
+// Package recordgen generates random records. create-test-data writes them to
+// parquet files, and the processor tests read those files back.
+package recordgen
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/models"
+)
+
+// BodyLength is the length of the random text in the body of each record.
+const BodyLength = 1000
+
+var (
+	firstNames      = []string{"James", "Mary", "John", "Patricia", "Robert", "Jennifer", "Michael", "Linda", "William", "Elizabeth"}
+	lastNames       = []string{"Smith", "Johnson", "Williams", "Brown", "Jones", "Garcia", "Miller", "Davis", "Rodriguez", "Martinez"}
+	cities          = []string{"New York", "Los Angeles", "Chicago", "Houston", "Phoenix", "Philadelphia", "San Antonio", "San Diego"}
+	states          = []string{"NY", "CA", "IL", "TX", "AZ", "PA", "FL", "OH", "GA", "NC"}
+	streets         = []string{"Main St", "Oak Ave", "Maple Dr", "Cedar Ln", "Washington St", "Park Ave", "Lake Dr", "River Rd"}
+	countries       = []string{"USA", "Canada", "UK", "Australia", "Germany", "France", "Japan", "Brazil"}
+	languages       = []string{"en", "es", "fr", "de", "it", "pt", "ja", "zh"}
+	accountTypes    = []string{"free", "basic", "premium", "enterprise"}
+	accountStatuses = []string{"active", "suspended", "pending", "closed"}
+	commPrefs       = []string{"email", "sms", "phone", "mail"}
+	tags            = []string{"vip", "new", "returning", "priority", "special_offer", "seasonal", "promotional"}
+	domains         = []string{"gmail.com", "yahoo.com", "hotmail.com", "outlook.com"}
+)
+
+// Generator generates random records.
+type Generator struct {
+	rng *rand.Rand
+	now func() time.Time
+}
+
+// New creates a generator drawing from rng, with record times relative to
+// now. Tests pass a seeded rng and a fixed clock so records are repeatable.
+func New(rng *rand.Rand, now func() time.Time) *Generator {
+	return &Generator{rng: rng, now: now}
+}
+
+// NewWriter creates a writer of records to w with the schema of
+// models.Record, the way test data files are written.
+func NewWriter(w io.Writer) *parquet.Writer {
+	return parquet.NewWriter(w, parquet.SchemaOf(new(models.Record)))
+}
+
+// Record generates a record.
+func (g *Generator) Record() models.Record {
+	now := g.now()
+	r := models.Record{
+		ID:        uuid.Must(uuid.NewRandomFromReader(g.rng)).String(),
+		CreatedAt: now.Add(-time.Duration(g.rng.Intn(365)) * 24 * time.Hour),
+		UpdatedAt: now,
+
+		FirstName:   g.pick(firstNames),
+		LastName:    g.pick(lastNames),
+		Email:       g.email(),
+		PhoneNumber: g.phoneNumber(),
+		DateOfBirth: g.dateOfBirth(),
+
+		AccountType:    g.pick(accountTypes),
+		AccountStatus:  g.pick(accountStatuses),
+		LastLoginDate:  now.Add(-time.Duration(g.rng.Intn(30)) * 24 * time.Hour),
+		AccountBalance: float64(g.rng.Intn(10000)) + g.rng.Float64(),
+
+		Language:             g.pick(languages),
+		NewsletterSubscribed: g.rng.Float32() > 0.5,
+		Body:                 g.text(BodyLength),
+	}
+
+	// Set address
+	r.Address.Street = fmt.Sprintf("%d %s", g.rng.Intn(9999), g.pick(streets))
+	r.Address.City = g.pick(cities)
+	r.Address.State = g.pick(states)
+	r.Address.PostalCode = fmt.Sprintf("%05d", g.rng.Intn(99999))
+	r.Address.Country = g.pick(countries)
+
+	// Generate random communication preferences
+	numPrefs := g.rng.Intn(len(commPrefs)) + 1
+	r.CommunicationPreferences = make([]string, numPrefs)
+	for i := 0; i < numPrefs; i++ {
+		r.CommunicationPreferences[i] = g.pick(commPrefs)
+	}
+
+	// Generate random tags
+	numTags := g.rng.Intn(4)
+	r.Tags = make([]string, numTags)
+	for i := 0; i < numTags; i++ {
+		r.Tags[i] = g.pick(tags)
+	}
+
+	return r
+}
+
+func (g *Generator) pick(slice []string) string {
+	return slice[g.rng.Intn(len(slice))]
+}
+
+func (g *Generator) email() string {
+	return fmt.Sprintf("%s.%s@%s",
+		strings.ToLower(g.pick(firstNames)),
+		strings.ToLower(g.pick(lastNames)),
+		g.pick(domains))
+}
+
+func (g *Generator) phoneNumber() string {
+	return fmt.Sprintf("+1-%03d-%03d-%04d",
+		g.rng.Intn(800)+200,
+		g.rng.Intn(900)+100,
+		g.rng.Intn(9000)+1000)
+}
+
+func (g *Generator) dateOfBirth() string {
+	year := g.rng.Intn(50) + 1950
+	month := g.rng.Intn(12) + 1
+	day := g.rng.Intn(28) + 1 // Using 28 to avoid invalid dates
+	return fmt.Sprintf("%04d-%02d-%02d", year, month, day)
+}
+
+func (g *Generator) text(length int) string {
+	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789 "
+	b := make([]byte, length)
+	for i := range b {
+		b[i] = charset[g.rng.Intn(len(charset))]
+	}
+	return string(b)
+}
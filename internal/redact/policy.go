@@ -0,0 +1,334 @@
+package redact
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/fields"
+)
+
+// ErrUnclassified is returned when a record holds a field without a class
+// and is published to an external destination.
+var ErrUnclassified = errors.New("field is not classified")
+
+// Action is what a policy does with the fields of a class.
+type Action string
+
+// The actions of a policy.
+const (
+	// Allow publishes the field as it is.
+	Allow Action = "allow"
+
+	// Hash replaces the field with its HMAC, so records can still be joined
+	// on it but it cannot be recovered.
+	Hash Action = "hash"
+
+	// Tokenize replaces the field with a deterministic token that holders of
+	// the key can turn back into the value with Detokenize.
+	Tokenize Action = "tokenize"
+
+	// Drop leaves the field out.
+	Drop Action = "drop"
+)
+
+// tokenPrefix starts every token, so tokens are told apart from values.
+const tokenPrefix = "tok_"
+
+// Policy is how the records published to a destination are redacted.
+type Policy struct {
+	// Name names the policy in errors.
+	Name string `yaml:"-"`
+
+	// Target is the queue URL, topic ARN, event bus, stream name or output
+	// path of the destination.
+	Target string `yaml:"target"`
+
+	// External destinations are outside the account. Records holding fields
+	// that are not classified are refused, and classes without an action are
+	// dropped rather than allowed.
+	External bool `yaml:"external"`
+
+	// Actions are the actions taken on the fields of every class. Fields of
+	// class none are always allowed.
+	Actions map[Class]Action `yaml:"actions"`
+
+	// hashKey, tokenKey and nonceKey are derived from the configured key,
+	// see deriveKey.
+	hashKey  []byte
+	tokenKey []byte
+	nonceKey []byte
+}
+
+// ParsePolicies parses a YAML or JSON configuration of policies keyed by
+// name. The key is used by hash and tokenize actions and is required if any
+// policy has one.
+func ParsePolicies(data []byte, key []byte) (map[string]*Policy, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+
+	var policies map[string]*Policy
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&policies); err != nil {
+		return nil, fmt.Errorf("failed to decode redaction policies: %w", err)
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(policies)) {
+		p := policies[name]
+		if p == nil {
+			p = &Policy{}
+			policies[name] = p
+		}
+		p.Name = name
+
+		for _, class := range slices.Sorted(maps.Keys(p.Actions)) {
+			action := p.Actions[class]
+			if _, err := ParseClass(string(class)); err != nil {
+				return nil, fmt.Errorf("invalid policy %s: %w", name, err)
+			}
+
+			switch action {
+			case Allow, Drop:
+			case Hash, Tokenize:
+				if len(key) == 0 {
+					return nil, fmt.Errorf("invalid policy %s: a key is required to %s %s fields", name, action, class)
+				}
+			default:
+				return nil, fmt.Errorf("invalid policy %s: unknown action %q", name, action)
+			}
+		}
+
+		p.hashKey = deriveKey(key, hashLabel)
+		p.tokenKey = deriveKey(key, tokenLabel)
+		p.nonceKey = deriveKey(key, nonceLabel)
+	}
+
+	return policies, nil
+}
+
+// ForTarget returns the policy of the destination at a target, the policy
+// named default if no policy has the target, or nil if there is none.
+func ForTarget(policies map[string]*Policy, target string) *Policy {
+	for _, name := range slices.Sorted(maps.Keys(policies)) {
+		if p := policies[name]; p.Target == target {
+			return p
+		}
+	}
+	return policies["default"]
+}
+
+// Fingerprint returns a fingerprint of the policy, which changes whenever
+// records would be redacted differently. A nil policy has an empty one.
+func (p *Policy) Fingerprint() string {
+	if p == nil {
+		return ""
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%t", p.Name, p.External)
+	for _, class := range slices.Sorted(maps.Keys(p.Actions)) {
+		fmt.Fprintf(h, "\x00%s=%s", class, p.Actions[class])
+	}
+
+	// The keys are only written hashed, so the fingerprint reveals nothing
+	// about them
+	for _, key := range [][]byte{p.hashKey, p.tokenKey, p.nonceKey} {
+		sum := sha256.Sum256(key)
+		h.Write(sum[:])
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Redact redacts the fields of a record according to their classes. The
+// record is converted to a map keyed by the names it is published with, and
+// the redacted map is returned.
+func (p *Policy) Redact(record any, classes Classes) (any, error) {
+	m, err := fields.Map(record)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.redactMap(m, classes, ""); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// redactMap redacts the fields of a map nested at a path.
+func (p *Policy) redactMap(m map[string]any, classes Classes, prefix string) error {
+	for _, name := range slices.Sorted(maps.Keys(m)) {
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+
+		// Nested maps are redacted field by field, unless they are dropped as
+		// a whole
+		action, err := p.action(classes, path)
+		if err != nil {
+			return err
+		}
+
+		if action == Drop {
+			delete(m, name)
+			continue
+		}
+
+		v, err := p.redactValue(m[name], classes, path, action)
+		if err != nil {
+			return err
+		}
+		m[name] = v
+	}
+
+	return nil
+}
+
+// redactValue redacts the value of the field at a path.
+func (p *Policy) redactValue(v any, classes Classes, path string, action Action) (any, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case map[string]any:
+		return v, p.redactMap(v, classes, path)
+	case []any:
+		for i := range v {
+			var err error
+			if v[i], err = p.redactValue(v[i], classes, path, action); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	}
+
+	switch action {
+	case Hash:
+		return fields.Hash(p.hashKey, v), nil
+	case Tokenize:
+		return tokenize(p.tokenKey, p.nonceKey, fields.Text(v))
+	default:
+		return v, nil
+	}
+}
+
+// action returns the action taken on the field at a path.
+func (p *Policy) action(classes Classes, path string) (Action, error) {
+	class, ok := classes.Of(path)
+	if !ok {
+		if p.External {
+			return "", fmt.Errorf("cannot publish field %s to external destination %s: %w", path, p.Name, ErrUnclassified)
+		}
+		return Allow, nil
+	}
+
+	// The field is redacted as strictly as its strictest class requires
+	action := Allow
+	for _, part := range class.parts() {
+		if a := p.classAction(part); strictness[a] > strictness[action] {
+			action = a
+		}
+	}
+	return action, nil
+}
+
+// strictness orders actions by how little of a value they leave.
+var strictness = map[Action]int{Allow: 0, Tokenize: 1, Hash: 2, Drop: 3}
+
+// classAction returns the action taken on the fields of a class.
+func (p *Policy) classAction(class Class) Action {
+	if class == None {
+		return Allow
+	}
+	if action, ok := p.Actions[class]; ok {
+		return action
+	}
+	if p.External {
+		return Drop
+	}
+	return Allow
+}
+
+// Detokenize returns the value a token was made from with the key of the
+// policy that made it.
+func Detokenize(key []byte, token string) (string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(token, tokenPrefix))
+	if err != nil || !strings.HasPrefix(token, tokenPrefix) {
+		return "", errors.New("malformed token")
+	}
+
+	gcm, err := newGCM(deriveKey(key, tokenLabel))
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("malformed token")
+	}
+
+	value, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt token: %w", err)
+	}
+	return string(value), nil
+}
+
+// tokenize encrypts a value into a token with the token key. The nonce is the
+// HMAC of the value under the nonce key, so the same value always yields the
+// same token and tokens can be joined on like hashes.
+func tokenize(tokenKey, nonceKey []byte, value string) (string, error) {
+	gcm, err := newGCM(tokenKey)
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, nonceKey)
+	mac.Write([]byte(value))
+	nonce := mac.Sum(nil)[:gcm.NonceSize()]
+
+	return tokenPrefix + base64.RawURLEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(value), nil)), nil
+}
+
+// newGCM creates the AES-GCM cipher of a key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// The labels of the keys derived from the configured key, one per use.
+const (
+	hashLabel  = "redact hash"
+	tokenLabel = "redact token cipher"
+	nonceLabel = "redact token nonce"
+)
+
+// deriveKey derives the 256 bit key labelled by its use from the configured
+// key with HKDF-SHA256 (RFC 5869) without a salt, so hashing, encrypting
+// tokens and deriving their nonces never share a key.
+func deriveKey(key []byte, label string) []byte {
+	extract := hmac.New(sha256.New, nil)
+	extract.Write(key)
+
+	// A single block of output is a key
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write([]byte(label))
+	expand.Write([]byte{1})
+	return expand.Sum(nil)
+}
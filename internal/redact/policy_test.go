@@ -0,0 +1,303 @@
+package redact
+
+import (
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/fields"
+)
+
+var key = []byte("key")
+
+// testPolicies parses the policies of the tests.
+func testPolicies(t *testing.T) map[string]*Policy {
+	t.Helper()
+
+	policies, err := ParsePolicies([]byte(`
+internal:
+  target: internal-queue
+  actions:
+    name: allow
+    email: hash
+    phone: tokenize
+    address: drop
+external:
+  target: external-queue
+  external: true
+  actions:
+    name: allow
+    email: hash
+    phone: tokenize
+`), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return policies
+}
+
+// testCustomer returns a customer whose fields are all classified.
+func testCustomer() customer {
+	c := customer{ID: "id-0", Name: "Ada", Email: "ada@example.com", Phone: "555-0100"}
+	c.Address.City = "Denver"
+	c.Address.Country = "USA"
+	return c
+}
+
+func TestRedact(t *testing.T) {
+	policies := testPolicies(t)
+
+	classes, err := ClassesOf(reflect.TypeFor[customer]())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := testCustomer()
+	c.Contacts = append(c.Contacts, struct {
+		Email string `json:"email" pii:"email"`
+		Note  string `json:"note"`
+	}{Email: "grace@example.com", Note: "sister"})
+	c.Note = "vip"
+
+	// The internal policy publishes fields nobody classified
+	redacted, err := policies["internal"].Redact(c, classes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := redacted.(map[string]any)
+
+	if m["id"] != "id-0" || m["name"] != "Ada" || m["note"] != "vip" {
+		t.Fatalf("allowed fields = %v", m)
+	}
+	if want := fields.Hash(policies["internal"].hashKey, "ada@example.com"); m["email"] != want {
+		t.Fatalf("email = %v, want it hashed to %s", m["email"], want)
+	}
+	if _, ok := m["address"]; ok {
+		t.Fatalf("address = %v, want it dropped with the fields nested in it", m["address"])
+	}
+
+	token, ok := m["phone"].(string)
+	if !ok || !strings.HasPrefix(token, tokenPrefix) {
+		t.Fatalf("phone = %v, want a token", m["phone"])
+	}
+	if value, err := Detokenize(key, token); err != nil || value != "555-0100" {
+		t.Fatalf("detokenized %q, %v, want the phone number", value, err)
+	}
+
+	// Fields nested in lists are redacted by the class of their path
+	contact := m["contacts"].([]any)[0].(map[string]any)
+	if want := fields.Hash(policies["internal"].hashKey, "grace@example.com"); contact["email"] != want || contact["note"] != "sister" {
+		t.Fatalf("contact = %v, want its email hashed", contact)
+	}
+
+	// The record itself is left as it was
+	if c.Email != "ada@example.com" {
+		t.Fatal("redacted the record in place")
+	}
+}
+
+func TestRedactExternal(t *testing.T) {
+	policies := testPolicies(t)
+
+	classes, err := ClassesOf(reflect.TypeFor[customer]())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Unclassified fields are refused, even empty ones
+	_, err = policies["external"].Redact(testCustomer(), classes)
+	if !errors.Is(err, ErrUnclassified) {
+		t.Fatalf("err = %v, want %v", err, ErrUnclassified)
+	}
+
+	// Once classified, classes without an action are dropped rather than
+	// allowed
+	classes["contacts"] = None
+	classes["note"] = None
+	redacted, err := policies["external"].Redact(testCustomer(), classes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := redacted.(map[string]any)
+
+	if _, ok := m["address"]; ok {
+		t.Fatalf("address = %v, want it dropped without an action", m["address"])
+	}
+	if m["name"] != "Ada" || m["id"] != "id-0" {
+		t.Fatalf("allowed fields = %v", m)
+	}
+}
+
+func TestRedactDynamicRecords(t *testing.T) {
+	policies := testPolicies(t)
+
+	record := map[string]any{
+		"id":      "id-0",
+		"email":   "ada@example.com",
+		"address": map[string]any{"city": "Denver", "geo": map[string]any{"lat": 39.7}},
+	}
+	classes := Classes{"id": None, "email": Email, "address": Address}
+
+	redacted, err := policies["internal"].Redact(record, classes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := redacted.(map[string]any)
+	if _, ok := m["address"]; ok || m["email"] == "ada@example.com" {
+		t.Fatalf("redacted = %v", m)
+	}
+
+	// A map nested in a classified field is classified by it
+	classes["address"] = None
+	classes["address.geo"] = Phone
+	redacted, err = policies["external"].Redact(record, classes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	geo := redacted.(map[string]any)["address"].(map[string]any)["geo"].(map[string]any)
+	if lat, ok := geo["lat"].(string); !ok || !strings.HasPrefix(lat, tokenPrefix) {
+		t.Fatalf("address.geo.lat = %v, want it tokenized as a phone", geo["lat"])
+	}
+	if record["email"] != "ada@example.com" {
+		t.Fatal("redacted the record in place")
+	}
+}
+
+func TestRedactJoinedClasses(t *testing.T) {
+	policies := testPolicies(t)
+
+	record := map[string]any{"hashed": "Ada ada@example.com", "tokenized": "Ada 555-0100", "dropped": "ada@example.com Denver", "allowed": "Ada"}
+	classes := Classes{
+		"hashed":    Join(Name, Email),
+		"tokenized": Join(Name, Phone),
+		"dropped":   Join(Email, Address),
+		"allowed":   Join(Name, None),
+	}
+
+	// Fields are redacted by the strictest action of their classes
+	redacted, err := policies["internal"].Redact(record, classes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := redacted.(map[string]any)
+	if want := fields.Hash(policies["internal"].hashKey, record["hashed"]); m["hashed"] != want {
+		t.Fatalf("hashed = %v, want %s", m["hashed"], want)
+	}
+	if tok, _ := m["tokenized"].(string); !strings.HasPrefix(tok, tokenPrefix) {
+		t.Fatalf("tokenized = %v, want a token", m["tokenized"])
+	}
+	if _, ok := m["dropped"]; ok || m["allowed"] != "Ada" {
+		t.Fatalf("redacted = %v", m)
+	}
+
+	// External destinations drop classes they have no action for
+	redacted, err = policies["external"].Redact(map[string]any{"dob": "Ada 1990-01-01"}, Classes{"dob": Join(Name, DOB)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := redacted.(map[string]any)["dob"]; ok {
+		t.Fatalf("redacted = %v, want a name joined with a date of birth dropped", redacted)
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	p := testPolicies(t)["internal"]
+
+	first, err := tokenize(p.tokenKey, p.nonceKey, "555-0100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := tokenize(p.tokenKey, p.nonceKey, "555-0100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := tokenize(p.tokenKey, p.nonceKey, "555-0101")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tokens are deterministic, so they can be joined on
+	if first != again || first == other {
+		t.Fatalf("tokens %s, %s and %s, want the same value to yield the same token only", first, again, other)
+	}
+
+	if value, err := Detokenize(key, first); err != nil || value != "555-0100" {
+		t.Fatalf("detokenized %q, %v", value, err)
+	}
+	if _, err := Detokenize([]byte("other key"), first); err == nil {
+		t.Fatal("detokenized with another key")
+	}
+	for _, token := range []string{"555-0100", tokenPrefix + "!", tokenPrefix, first[:len(first)-4]} {
+		if _, err := Detokenize(key, token); err == nil {
+			t.Fatalf("detokenized malformed token %q", token)
+		}
+	}
+}
+
+func TestDeriveKey(t *testing.T) {
+	// The first block of RFC 5869 test case 3, without a salt or info
+	ikm := []byte(strings.Repeat("\x0b", 22))
+	if got, want := hex.EncodeToString(deriveKey(ikm, "")), "8da4e775a563c18f715f802a063c5a31b8a11f5c5ee1879ec3454e5f3c738d2d"; got != want {
+		t.Fatalf("derived %s, want %s", got, want)
+	}
+
+	// Every use gets a key of its own
+	p := testPolicies(t)["internal"]
+	keys := map[string][]byte{"configured": key, "hash": p.hashKey, "token": p.tokenKey, "nonce": p.nonceKey}
+	seen := make(map[string]string)
+	for use, k := range keys {
+		if other, ok := seen[string(k)]; ok {
+			t.Fatalf("%s and %s share a key", use, other)
+		}
+		seen[string(k)] = use
+	}
+}
+
+func TestParsePolicies(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		key     []byte
+		wantErr string
+	}{
+		{name: "no key to hash", config: "p:\n  actions:\n    email: hash", wantErr: "a key is required"},
+		{name: "no key to tokenize", config: "p:\n  actions:\n    email: tokenize", wantErr: "a key is required"},
+		{name: "no key to drop", config: "p:\n  actions:\n    email: drop"},
+		{name: "unknown class", config: "p:\n  actions:\n    secret: drop", key: key, wantErr: "unknown pii class"},
+		{name: "unknown action", config: "p:\n  actions:\n    email: encrypt", key: key, wantErr: "unknown action"},
+		{name: "unknown field", config: "p:\n  extrnal: true", key: key, wantErr: "field extrnal not found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParsePolicies([]byte(tt.config), tt.key)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestForTarget(t *testing.T) {
+	policies := testPolicies(t)
+
+	if p := ForTarget(policies, "external-queue"); p != policies["external"] {
+		t.Fatalf("policy of external-queue = %v", p)
+	}
+	if p := ForTarget(policies, "other-queue"); p != nil {
+		t.Fatalf("policy of other-queue = %v, want none", p)
+	}
+
+	policies["default"] = &Policy{Name: "default"}
+	if p := ForTarget(policies, "other-queue"); p != policies["default"] {
+		t.Fatalf("policy of other-queue = %v, want the default", p)
+	}
+}
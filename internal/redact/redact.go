@@ -0,0 +1,150 @@
+// Package redact classifies the personal information held by record fields
+// and redacts it according to the policy of the destination records are
+// published to.
+//
+// Fields are classified with a pii struct tag, such as pii:"email". Every
+// field of a struct tagged with a class is of that class unless it is tagged
+// itself. Fields that hold no personal information are tagged pii:"none", so
+// a field without a tag is one nobody has classified yet, and records holding
+// one are refused by external destinations.
+package redact
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Class is the kind of personal information a field holds. A field derived
+// from fields of several classes is of their joined class, see Join.
+type Class string
+
+// The classes of the pii tag vocabulary.
+const (
+	None    Class = "none"
+	Name    Class = "name"
+	Email   Class = "email"
+	Phone   Class = "phone"
+	DOB     Class = "dob"
+	Address Class = "address"
+)
+
+// classes are the known classes.
+var classes = map[Class]bool{None: true, Name: true, Email: true, Phone: true, DOB: true, Address: true}
+
+// ParseClass parses a class of the pii tag vocabulary.
+func ParseClass(s string) (Class, error) {
+	if c := Class(s); classes[c] {
+		return c, nil
+	}
+	return "", fmt.Errorf("unknown pii class %q", s)
+}
+
+// Join returns the class of a field holding the information of fields of
+// every given class, such as name+email for one derived from a name and an
+// email. Policies treat it as the strictest of them. It is None if every
+// class is None or there is none.
+func Join(classes ...Class) Class {
+	var parts []string
+	for _, class := range classes {
+		for _, part := range class.parts() {
+			if part != None && !slices.Contains(parts, string(part)) {
+				parts = append(parts, string(part))
+			}
+		}
+	}
+
+	if len(parts) == 0 {
+		return None
+	}
+
+	slices.Sort(parts)
+	return Class(strings.Join(parts, "+"))
+}
+
+// parts returns the classes joined into a class.
+func (c Class) parts() []Class {
+	var parts []Class
+	for _, part := range strings.Split(string(c), "+") {
+		parts = append(parts, Class(part))
+	}
+	return parts
+}
+
+// Classes are the classes of the fields of a record keyed by their dotted
+// path, such as address.city.
+type Classes map[string]Class
+
+// Of returns the class of the field at a dotted path, that of the field
+// itself or of the closest field it is nested in. The boolean is false if
+// the field is not classified.
+func (c Classes) Of(path string) (Class, bool) {
+	for {
+		if class, ok := c[path]; ok {
+			return class, true
+		}
+
+		i := strings.LastIndexByte(path, '.')
+		if i < 0 {
+			return "", false
+		}
+		path = path[:i]
+	}
+}
+
+// ClassesOf returns the classes of the fields of a struct type from their pii
+// tags. Fields are named by their json tags, as records are published. Fields
+// without a tag are left out.
+func ClassesOf(t reflect.Type) (Classes, error) {
+	c := make(Classes)
+	if err := classify(c, t, ""); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// classify adds the classes of the fields of a type nested at a path.
+func classify(c Classes, t reflect.Type, prefix string) error {
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == reflect.TypeFor[time.Time]() {
+		return nil
+	}
+
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+
+		if tag, ok := f.Tag.Lookup("pii"); ok {
+			class, err := ParseClass(tag)
+			if err != nil {
+				return fmt.Errorf("field %s: %w", path, err)
+			}
+			c[path] = class
+		}
+
+		if err := classify(c, f.Type, path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
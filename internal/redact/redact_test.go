@@ -0,0 +1,104 @@
+package redact
+
+import (
+	"maps"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// customer is a record with classified fields, a classified nested struct
+// and fields nobody has classified.
+type customer struct {
+	ID      string `json:"id" pii:"none"`
+	Name    string `json:"name" pii:"name"`
+	Email   string `json:"email" pii:"email"`
+	Phone   string `json:"phone" pii:"phone"`
+	Address struct {
+		City    string `json:"city"`
+		Country string `json:"country" pii:"none"`
+	} `json:"address" pii:"address"`
+	Contacts []struct {
+		Email string `json:"email" pii:"email"`
+		Note  string `json:"note"`
+	} `json:"contacts"`
+	CreatedAt time.Time `json:"created_at" pii:"none"`
+	Note      string    `json:"note"`
+	Skipped   string    `json:"-" pii:"email"`
+}
+
+func TestClassesOf(t *testing.T) {
+	classes, err := ClassesOf(reflect.TypeFor[customer]())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Classes{
+		"id":              None,
+		"name":            Name,
+		"email":           Email,
+		"phone":           Phone,
+		"address":         Address,
+		"address.country": None,
+		"contacts.email":  Email,
+		"created_at":      None,
+	}
+	if !maps.Equal(classes, want) {
+		t.Fatalf("classes = %v, want %v", classes, want)
+	}
+}
+
+func TestClassesOfInvalidTag(t *testing.T) {
+	type record struct {
+		Secret string `json:"secret" pii:"secret"`
+	}
+
+	if _, err := ClassesOf(reflect.TypeFor[record]()); err == nil {
+		t.Fatal("classified a field with an unknown class")
+	}
+}
+
+func TestOf(t *testing.T) {
+	classes := Classes{"address": Address, "address.country": None}
+
+	tests := []struct {
+		path  string
+		class Class
+		ok    bool
+	}{
+		{path: "address", class: Address, ok: true},
+		{path: "address.city", class: Address, ok: true},
+		{path: "address.geo.lat", class: Address, ok: true},
+		{path: "address.country", class: None, ok: true},
+		{path: "addresses", ok: false},
+		{path: "note", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			class, ok := classes.Of(tt.path)
+			if class != tt.class || ok != tt.ok {
+				t.Fatalf("Of(%s) = %q, %t, want %q, %t", tt.path, class, ok, tt.class, tt.ok)
+			}
+		})
+	}
+}
+
+func TestJoin(t *testing.T) {
+	tests := []struct {
+		classes []Class
+		want    Class
+	}{
+		{want: None},
+		{classes: []Class{None, None}, want: None},
+		{classes: []Class{Email}, want: Email},
+		{classes: []Class{Name, None, Email}, want: "email+name"},
+		{classes: []Class{Join(Phone, Name), Email, Name}, want: "email+name+phone"},
+	}
+
+	for _, tt := range tests {
+		if got := Join(tt.classes...); got != tt.want {
+			t.Errorf("Join(%v) = %s, want %s", tt.classes, got, tt.want)
+		}
+	}
+}
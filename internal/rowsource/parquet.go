@@ -0,0 +1,373 @@
+package rowsource
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/schema"
+)
+
+// ParquetOptions selects the rows and columns a Parquet source reads.
+type ParquetOptions struct {
+	// RowGroups are the indexes of the row groups to read, in order. Every row
+	// group is read if it is nil.
+	RowGroups []int
+
+	// Offset is the number of rows skipped at the start of the first row
+	// group read.
+	Offset int64
+
+	// Columns are the dotted paths of the columns to read, such as
+	// address.city. A path selects the column itself and every column nested
+	// below it. Every column is read if it is nil, otherwise the fields of
+	// the columns that are not read are left empty.
+	Columns []string
+}
+
+// Parquet is a RowSource reading a parquet file with the schema of T, the
+// schema records of type T are written with.
+type Parquet[T any] struct {
+	rowGroups []parquet.RowGroup
+	offset    int64
+
+	// reader reads the current row group, it is nil before the next one is
+	// opened.
+	reader *parquet.GenericReader[T]
+	next   int
+
+	// convert optionally converts every row read.
+	convert func(T) T
+}
+
+// NewParquet creates a source reading the rows of a parquet file into values
+// of type T. The columns of the file are checked against the schema of T up
+// front, so a file that cannot be read as T, such as one missing a column or
+// storing it as an incompatible type, is rejected before any row is read.
+func NewParquet[T any](f *parquet.File, opts ParquetOptions) (*Parquet[T], error) {
+	if err := schema.Compatible(schema.Describe(f.Schema()), schema.Describe(parquet.SchemaOf(new(T)))); err != nil {
+		return nil, fmt.Errorf("file cannot be read as %T: %w", *new(T), err)
+	}
+
+	return newParquet[T](f, opts, mask)
+}
+
+// NewDynamicParquet creates a source reading the rows of a parquet file of any
+// schema into maps keyed by column name. Lists become slices and timestamp and
+// date columns become times, so rows encode to JSON the way records do.
+func NewDynamicParquet(f *parquet.File, opts ParquetOptions) (*Parquet[any], error) {
+	p, err := newParquet[any](f, opts, project)
+	if err != nil {
+		return nil, err
+	}
+
+	p.convert = func(row any) any {
+		return dynamicValue(f.Schema(), row)
+	}
+
+	return p, nil
+}
+
+// newParquet creates a source reading the rows of a parquet file into values
+// of type T without checking its schema. Columns are selected by the
+// conversion returned by sel.
+func newParquet[T any](f *parquet.File, opts ParquetOptions, sel func(*parquet.Schema, []string) (parquet.Conversion, error)) (*Parquet[T], error) {
+	fileRowGroups := f.RowGroups()
+
+	indexes := opts.RowGroups
+	if indexes == nil {
+		indexes = make([]int, len(fileRowGroups))
+		for i := range indexes {
+			indexes[i] = i
+		}
+	}
+
+	// Columns that are not read are masked by converting row groups, so
+	// their pages are never fetched
+	var conv parquet.Conversion
+	if opts.Columns != nil {
+		var err error
+		if conv, err = sel(f.Schema(), opts.Columns); err != nil {
+			return nil, err
+		}
+	}
+
+	p := &Parquet[T]{
+		rowGroups: make([]parquet.RowGroup, 0, len(indexes)),
+		offset:    opts.Offset,
+	}
+	for _, i := range indexes {
+		if i < 0 || i >= len(fileRowGroups) {
+			return nil, fmt.Errorf("row group %d out of range, file has %d row groups", i, len(fileRowGroups))
+		}
+
+		rowGroup := fileRowGroups[i]
+		if conv != nil {
+			rowGroup = parquet.ConvertRowGroup(rowGroup, conv)
+		}
+		p.rowGroups = append(p.rowGroups, rowGroup)
+	}
+
+	return p, nil
+}
+
+// Read reads the next rows of the selected row groups. A single call never
+// returns rows of more than one row group.
+func (p *Parquet[T]) Read(rows []T) (int, error) {
+	for {
+		if p.reader == nil {
+			if p.next == len(p.rowGroups) {
+				return 0, io.EOF
+			}
+
+			p.reader = parquet.NewGenericRowGroupReader[T](p.rowGroups[p.next])
+			p.next++
+
+			if p.offset > 0 {
+				if err := p.reader.SeekToRow(p.offset); err != nil {
+					return 0, fmt.Errorf("failed to skip %d rows: %w", p.offset, err)
+				}
+				p.offset = 0
+			}
+		}
+
+		n, err := p.reader.Read(rows)
+		if p.convert != nil {
+			for i := range n {
+				rows[i] = p.convert(rows[i])
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			if err := p.closeReader(); err != nil {
+				return n, err
+			}
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+
+		return n, err
+	}
+}
+
+// Close closes the reader of the current row group.
+func (p *Parquet[T]) Close() error {
+	p.next = len(p.rowGroups)
+	return p.closeReader()
+}
+
+// closeReader closes the reader of the current row group, if there is one.
+func (p *Parquet[T]) closeReader() error {
+	if p.reader == nil {
+		return nil
+	}
+
+	err := p.reader.Close()
+	p.reader = nil
+	if err != nil {
+		return fmt.Errorf("failed to close row group reader: %w", err)
+	}
+
+	return nil
+}
+
+// selector returns whether the leaf column at a path is selected by paths.
+func selector(paths []string) func(path []string) bool {
+	return func(path []string) bool {
+		leaf := strings.ToLower(strings.Join(path, "."))
+		return slices.ContainsFunc(paths, func(p string) bool {
+			p = strings.ToLower(p)
+			return leaf == p || strings.HasPrefix(leaf, p+".")
+		})
+	}
+}
+
+// project returns the conversion of rows to the schema of the columns
+// selected by paths, so rows read into maps only hold the selected columns.
+func project(schema *parquet.Schema, paths []string) (parquet.Conversion, error) {
+	projected, err := projectSchema(schema, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	conv, err := parquet.Convert(projected, schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to project columns: %w", err)
+	}
+	return conv, nil
+}
+
+// projectSchema returns the schema of the columns selected by paths.
+func projectSchema(schema *parquet.Schema, paths []string) (*parquet.Schema, error) {
+	root, _, ok := projectNode(schema, nil, selector(paths))
+	if !ok {
+		return nil, errors.New("no columns selected")
+	}
+
+	return parquet.NewSchema(schema.Name(), root), nil
+}
+
+// mask returns the conversion of rows that keeps the schema of the file but
+// masks the columns not selected by paths, which are read as empty values.
+// Rows read into values of a Go type are converted this way rather than
+// projected, as parquet-go misplaces the values of a projected schema when
+// converting it back to the schema of the type if a list column is left out.
+func mask(schema *parquet.Schema, paths []string) (parquet.Conversion, error) {
+	selected := selector(paths)
+
+	m := maskColumns{schema: schema, keep: make([]bool, len(schema.Columns()))}
+	for i, path := range schema.Columns() {
+		m.keep[i] = selected(path)
+	}
+
+	if !slices.Contains(m.keep, true) {
+		return nil, errors.New("no columns selected")
+	}
+	return m, nil
+}
+
+// maskColumns is a conversion of rows to the same schema in which only the
+// kept columns are read.
+type maskColumns struct {
+	schema *parquet.Schema
+	keep   []bool
+}
+
+// Convert leaves rows as they are, the masked columns already hold empty
+// values.
+func (m maskColumns) Convert(rows []parquet.Row) (int, error) {
+	return len(rows), nil
+}
+
+// Column returns the index of a column if it is kept, -1 if it is masked.
+func (m maskColumns) Column(i int) int {
+	if m.keep[i] {
+		return i
+	}
+	return -1
+}
+
+// Schema returns the schema of the file.
+func (m maskColumns) Schema() *parquet.Schema {
+	return m.schema
+}
+
+// projectNode returns the part of a node at path made up of the selected
+// leaf columns, whether every leaf is selected so the node is returned as is,
+// and false if none of its leaves are selected. Groups with a logical type
+// such as lists and maps are kept whole if any of their leaves are selected,
+// as they are only valid with every child.
+func projectNode(node parquet.Node, path []string, selected func([]string) bool) (projected parquet.Node, whole, ok bool) {
+	if node.Leaf() {
+		ok = selected(path)
+		return node, ok, ok
+	}
+
+	group := make(parquet.Group)
+	whole = true
+	for _, field := range node.Fields() {
+		child, childWhole, ok := projectNode(field, append(slices.Clip(path), field.Name()), selected)
+		whole = whole && childWhole
+		if ok {
+			group[field.Name()] = child
+		}
+	}
+
+	switch {
+	case len(group) == 0:
+		return nil, false, false
+	case whole || node.Type().LogicalType() != nil:
+		return node, true, true
+	case node.Optional():
+		return parquet.Optional(group), false, true
+	case node.Repeated():
+		return parquet.Repeated(group), false, true
+	default:
+		return group, false, true
+	}
+}
+
+// dynamicValue converts the value of a node read into a map to the form
+// records are published in, turning timestamp and date columns into times.
+// Maps and slices are converted in place.
+func dynamicValue(node parquet.Node, v any) any {
+	if v == nil {
+		return nil
+	}
+
+	if node.Repeated() {
+		values, ok := v.([]any)
+		if !ok {
+			return v
+		}
+		for i := range values {
+			values[i] = dynamicValue(parquet.Required(node), values[i])
+		}
+		return values
+	}
+
+	lt := node.Type().LogicalType()
+	switch {
+	case node.Leaf():
+		return leafValue(node.Type(), v)
+	case lt != nil && lt.List != nil:
+		// Lists are read as a slice of their elements, list.element
+		values, ok := v.([]any)
+		if !ok || len(node.Fields()) != 1 || len(node.Fields()[0].Fields()) != 1 {
+			return v
+		}
+		elem := node.Fields()[0].Fields()[0]
+		for i := range values {
+			values[i] = dynamicValue(elem, values[i])
+		}
+		return values
+	case lt != nil && lt.Map != nil:
+		return v
+	}
+
+	m, ok := v.(map[string]any)
+	if !ok {
+		return v
+	}
+	for _, field := range node.Fields() {
+		if fv, ok := m[field.Name()]; ok {
+			m[field.Name()] = dynamicValue(field, fv)
+		}
+	}
+	return m
+}
+
+// leafValue converts the value of a timestamp or date column to a time.
+func leafValue(typ parquet.Type, v any) any {
+	lt := typ.LogicalType()
+	if lt == nil {
+		return v
+	}
+
+	switch v := v.(type) {
+	case int64:
+		if lt.Timestamp == nil {
+			return v
+		}
+		switch unit := lt.Timestamp.Unit; {
+		case unit.Millis != nil:
+			return time.UnixMilli(v).UTC()
+		case unit.Micros != nil:
+			return time.UnixMicro(v).UTC()
+		case unit.Nanos != nil:
+			return time.Unix(0, v).UTC()
+		}
+	case int32:
+		if lt.Date != nil {
+			return time.Unix(int64(v)*86400, 0).UTC()
+		}
+	}
+
+	return v
+}
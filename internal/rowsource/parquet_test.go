@@ -0,0 +1,210 @@
+package rowsource
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/models"
+)
+
+// testFile writes n records into a parquet file with row groups of
+// rowGroupRows rows.
+func testFile(t *testing.T, n, rowGroupRows int) (*parquet.File, []models.Record) {
+	t.Helper()
+
+	records := make([]models.Record, n)
+	for i := range records {
+		records[i] = models.Record{
+			ID:                       fmt.Sprintf("id-%d", i),
+			CreatedAt:                time.Unix(int64(i), 0).UTC(),
+			Email:                    fmt.Sprintf("ada%d@example.com", i),
+			AccountType:              []string{"free", "premium"}[i%2],
+			CommunicationPreferences: []string{"email", "sms"}[:1+i%2],
+			Tags:                     []string{fmt.Sprintf("tag-%d", i)},
+			Body:                     "body",
+		}
+		records[i].Address.City = fmt.Sprintf("city-%d", i%7)
+	}
+
+	var buf bytes.Buffer
+	w := parquet.NewGenericWriter[models.Record](&buf, parquet.MaxRowsPerRowGroup(int64(rowGroupRows)))
+	if _, err := w.Write(records); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, records
+}
+
+// readAll reads every row of a source.
+func readAll[T any](t *testing.T, src *Parquet[T]) []T {
+	t.Helper()
+	defer src.Close()
+
+	var rows []T
+	buf := make([]T, 64)
+	for {
+		n, err := src.Read(buf)
+		rows = append(rows, buf[:n]...)
+		if errors.Is(err, io.EOF) {
+			return rows
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestParquetColumns(t *testing.T) {
+	f, records := testFile(t, 300, 100)
+
+	// Timestamp columns that are not read hold the epoch rather than the zero
+	// time
+	epoch := time.Unix(0, 0).UTC()
+
+	tests := []struct {
+		name    string
+		columns []string
+		want    func(models.Record) models.Record
+	}{
+		{
+			name: "every column",
+			want: func(r models.Record) models.Record { return r },
+		},
+		{
+			name:    "scalars",
+			columns: []string{"id", "account_type"},
+			want: func(r models.Record) models.Record {
+				return models.Record{ID: r.ID, CreatedAt: epoch, AccountType: r.AccountType}
+			},
+		},
+		{
+			name:    "lists",
+			columns: []string{"tags", "ID", "created_at"},
+			want: func(r models.Record) models.Record {
+				return models.Record{ID: r.ID, CreatedAt: r.CreatedAt, Tags: r.Tags}
+			},
+		},
+		{
+			name:    "nested",
+			columns: []string{"address.city", "communication_preferences", "email"},
+			want: func(r models.Record) models.Record {
+				got := models.Record{CreatedAt: epoch, Email: r.Email, CommunicationPreferences: r.CommunicationPreferences}
+				got.Address.City = r.Address.City
+				return got
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := NewParquet[models.Record](f, ParquetOptions{Columns: tt.columns})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rows := readAll(t, src)
+			if len(rows) != len(records) {
+				t.Fatalf("read %d rows, want %d", len(rows), len(records))
+			}
+			for i, row := range rows {
+				if want := tt.want(records[i]); !equalRecords(row, want) {
+					t.Fatalf("row %d = %+v, want %+v", i, row, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParquetNoColumns(t *testing.T) {
+	f, _ := testFile(t, 10, 10)
+
+	if _, err := NewParquet[models.Record](f, ParquetOptions{Columns: []string{"missing"}}); err == nil {
+		t.Fatal("typed source selecting no columns was created")
+	}
+	if _, err := NewDynamicParquet(f, ParquetOptions{Columns: []string{"missing"}}); err == nil {
+		t.Fatal("dynamic source selecting no columns was created")
+	}
+}
+
+func TestParquetRowGroups(t *testing.T) {
+	f, records := testFile(t, 300, 100)
+
+	src, err := NewParquet[models.Record](f, ParquetOptions{RowGroups: []int{2, 1}, Offset: 40, Columns: []string{"id"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for _, row := range readAll(t, src) {
+		ids = append(ids, row.ID)
+	}
+
+	var want []string
+	for _, r := range slices.Concat(records[240:300], records[100:200]) {
+		want = append(want, r.ID)
+	}
+	if !slices.Equal(ids, want) {
+		t.Fatalf("read %d ids from %v, want %d from %v", len(ids), ids[:1], len(want), want[:1])
+	}
+}
+
+func TestDynamicParquetColumns(t *testing.T) {
+	f, records := testFile(t, 300, 100)
+
+	src, err := NewDynamicParquet(f, ParquetOptions{Columns: []string{"tags", "id", "created_at"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows := readAll(t, src)
+	if len(rows) != len(records) {
+		t.Fatalf("read %d rows, want %d", len(rows), len(records))
+	}
+
+	for i, row := range rows {
+		m, ok := row.(map[string]any)
+		if !ok {
+			t.Fatalf("row %d is a %T, want a map", i, row)
+		}
+		if len(m) != 3 {
+			t.Fatalf("row %d has columns %v, want only the selected ones", i, slices.Sorted(maps.Keys(m)))
+		}
+		if m["id"] != records[i].ID {
+			t.Fatalf("row %d id = %v, want %v", i, m["id"], records[i].ID)
+		}
+		if created, ok := m["created_at"].(time.Time); !ok || !created.Equal(records[i].CreatedAt) {
+			t.Fatalf("row %d created_at = %v, want %v", i, m["created_at"], records[i].CreatedAt)
+		}
+		if tags, ok := m["tags"].([]any); !ok || len(tags) != 1 || tags[0] != records[i].Tags[0] {
+			t.Fatalf("row %d tags = %v, want %v", i, m["tags"], records[i].Tags)
+		}
+	}
+}
+
+// equalRecords reports whether two records hold the same values, comparing
+// times by instant and treating nil and empty lists alike.
+func equalRecords(a, b models.Record) bool {
+	return a.ID == b.ID &&
+		a.CreatedAt.Equal(b.CreatedAt) &&
+		a.Email == b.Email &&
+		a.AccountType == b.AccountType &&
+		slices.Equal(a.CommunicationPreferences, b.CommunicationPreferences) &&
+		slices.Equal(a.Tags, b.Tags) &&
+		a.Body == b.Body &&
+		a.Address == b.Address
+}
@@ -0,0 +1,39 @@
+// Package rowsource reads the rows of a file as typed records, hiding the
+// library that decodes them so it can be swapped.
+package rowsource
+
+// RowSource reads the rows of a single file in order as values of type T.
+type RowSource[T any] interface {
+	// Read reads up to len(rows) rows into rows and returns the number of
+	// rows read. It returns io.EOF once every row has been read.
+	Read(rows []T) (int, error)
+
+	// Close releases the resources held by the source.
+	Close() error
+}
+
+// Any adapts a source of values of type T to a source of values of any type,
+// so sources of different types can be handled alike.
+func Any[T any](src RowSource[T]) RowSource[any] {
+	return anySource[T]{src: src}
+}
+
+// anySource is a RowSource of any type reading from a typed source.
+type anySource[T any] struct {
+	src RowSource[T]
+}
+
+// Read reads rows from the typed source.
+func (s anySource[T]) Read(rows []any) (int, error) {
+	typed := make([]T, len(rows))
+	n, err := s.src.Read(typed)
+	for i := range n {
+		rows[i] = typed[i]
+	}
+	return n, err
+}
+
+// Close closes the typed source.
+func (s anySource[T]) Close() error {
+	return s.src.Close()
+}
@@ -0,0 +1,345 @@
+// Package s3file reads S3 objects through io.ReaderAt with ranged GetObject
+// requests, so parquet files are read in place without downloading them.
+package s3file
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	// DefaultBlockSize is the minimum number of bytes fetched by a single
+	// ranged GetObject request when no block size is configured.
+	DefaultBlockSize = 1 << 20 // 1MB
+
+	// footerPrefetchSize is the number of bytes fetched from the end of the
+	// object when it is opened. Parquet keeps its metadata in the footer, so
+	// prefetching the tail lets the reader parse it without extra round trips.
+	footerPrefetchSize = 64 << 10 // 64KB
+
+	// cacheSize bounds the bytes of fetched blocks kept per file. Column
+	// chunks are read concurrently and interleaved, so the cache holds the
+	// blocks of every column being read rather than only the last one.
+	cacheSize = 64 << 20 // 64MB
+
+	// minCacheBlocks is the number of blocks kept per file however large the
+	// block size is.
+	minCacheBlocks = 4
+)
+
+// ObjectAPI is the subset of the S3 client used to read objects. It exists so
+// the file can be pointed at any S3 compatible endpoint, including an
+// in-process fake.
+type ObjectAPI interface {
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+}
+
+// File is a read only S3 object backed by ranged GetObject requests. Only the
+// bytes the parquet reader asks for are fetched, so files larger than the
+// Lambda ephemeral storage can be read and rows become available as soon as
+// their column chunks arrive.
+//
+// The object is fetched in blocks aligned to the block size. Fetched blocks
+// are cached, and blocks are fetched outside the lock, so concurrent column
+// reads proceed in parallel and never fetch the same block twice.
+type File struct {
+	client    ObjectAPI
+	bucket    string
+	key       string
+	etag      string
+	size      int64
+	blockSize int64
+	maxBlocks int
+
+	// tail holds the end of the object, where parquet keeps its metadata, so
+	// it stays buffered while column chunks are read.
+	tail       []byte
+	tailOffset int64
+
+	// mu guards the cache, as reads may be made concurrently.
+	mu sync.Mutex
+
+	// blocks are the cached blocks keyed by index, the block at index i
+	// starting at offset i * blockSize.
+	blocks map[int64]*block
+
+	// clock orders uses of blocks, so the least recently used is evicted.
+	clock uint64
+
+	// fetched is the number of bytes fetched from S3.
+	fetched int64
+}
+
+// block is a block of the object, fetched by the first read that needs it.
+type block struct {
+	// ready is closed once data or err is set.
+	ready chan struct{}
+	data  []byte
+	err   error
+
+	// used is the clock of the last read of the block.
+	used uint64
+}
+
+// Open opens an S3 object for ranged reads. The object size and ETag are
+// resolved up front and every subsequent range request is pinned to that ETag,
+// so an object replaced mid read fails instead of yielding a corrupt file.
+func Open(ctx context.Context, client ObjectAPI, bucket, key string, blockSize int64) (*File, error) {
+	head, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to head object %s/%s: %w", bucket, key, err)
+	}
+
+	if blockSize <= 0 {
+		blockSize = DefaultBlockSize
+	}
+
+	f := &File{
+		client:    client,
+		bucket:    bucket,
+		key:       key,
+		etag:      aws.ToString(head.ETag),
+		size:      aws.ToInt64(head.ContentLength),
+		blockSize: blockSize,
+		maxBlocks: max(int(cacheSize/blockSize), minCacheBlocks),
+		blocks:    make(map[int64]*block),
+	}
+
+	// Fetch the footer first so the parquet metadata is parsed from memory
+	prefetch := min(int64(footerPrefetchSize), f.size)
+	f.tailOffset = f.size - prefetch
+	if prefetch > 0 {
+		if f.tail, err = f.fetch(ctx, f.tailOffset, prefetch); err != nil {
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+// ETag returns the ETag of the object being read.
+func (f *File) ETag() string {
+	return f.etag
+}
+
+// Size returns the size of the object in bytes.
+func (f *File) Size() int64 {
+	return f.size
+}
+
+// BytesRead returns the number of bytes fetched from S3 so far, which is less
+// than the size of the object when only some of its column chunks are read.
+func (f *File) BytesRead() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.fetched
+}
+
+// Close releases the cached blocks.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.blocks, f.tail = make(map[int64]*block), nil
+	return nil
+}
+
+// Reader returns an io.ReaderAt of the file whose reads are made with ctx,
+// for readers such as parquet-go that only take an io.ReaderAt.
+func (f *File) Reader(ctx context.Context) io.ReaderAt {
+	return reader{f: f, ctx: ctx}
+}
+
+// reader reads a file with the context it was created with.
+type reader struct {
+	f   *File
+	ctx context.Context
+}
+
+// ReadAt implements io.ReaderAt.
+func (r reader) ReadAt(p []byte, off int64) (int, error) {
+	return r.f.ReadAtContext(r.ctx, p, off)
+}
+
+// ReadAtContext reads len(p) bytes starting at off, fetching the blocks that
+// are not already cached from S3.
+func (f *File) ReadAtContext(ctx context.Context, p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	if off >= f.size {
+		return 0, io.EOF
+	}
+
+	end := min(off+int64(len(p)), f.size)
+
+	// Column chunks are read from blocks, the footer from the tail
+	var read int
+	if off < f.tailOffset {
+		n, err := f.readBlocks(ctx, p[:min(end, f.tailOffset)-off], off)
+		read += n
+		if err != nil {
+			return read, err
+		}
+	}
+
+	if pos := off + int64(read); pos < end {
+		f.mu.Lock()
+		tail := f.tail
+		f.mu.Unlock()
+
+		if tail == nil {
+			return read, fmt.Errorf("object %s/%s is closed", f.bucket, f.key)
+		}
+		read += copy(p[read:end-off], tail[pos-f.tailOffset:])
+	}
+
+	if read < len(p) {
+		return read, io.EOF
+	}
+
+	return read, nil
+}
+
+// readBlocks reads p starting at off from the blocks covering it. Blocks that
+// are not cached are fetched outside the lock, consecutive missing blocks in
+// a single request. Blocks being fetched by another read are waited for.
+func (f *File) readBlocks(ctx context.Context, p []byte, off int64) (int, error) {
+	first := off / f.blockSize
+	last := (off + int64(len(p)) - 1) / f.blockSize
+
+	blocks := make([]*block, last-first+1)
+	missing := make([]bool, len(blocks))
+
+	f.mu.Lock()
+	f.clock++
+	for i := range blocks {
+		b, ok := f.blocks[first+int64(i)]
+		if !ok {
+			b = &block{ready: make(chan struct{})}
+			f.blocks[first+int64(i)] = b
+			missing[i] = true
+		}
+		b.used = f.clock
+		blocks[i] = b
+	}
+	f.evict()
+	f.mu.Unlock()
+
+	for i := 0; i < len(blocks); {
+		if !missing[i] {
+			i++
+			continue
+		}
+
+		j := i
+		for j < len(blocks) && missing[j] {
+			j++
+		}
+		f.fetchBlocks(ctx, first+int64(i), blocks[i:j])
+		i = j
+	}
+
+	var read int
+	for i, b := range blocks {
+		select {
+		case <-b.ready:
+		case <-ctx.Done():
+			return read, ctx.Err()
+		}
+		if b.err != nil {
+			return read, b.err
+		}
+
+		start := (first + int64(i)) * f.blockSize
+		n := copy(p[read:], b.data[off+int64(read)-start:])
+		read += n
+	}
+
+	return read, nil
+}
+
+// fetchBlocks fetches consecutive blocks starting at index first in a single
+// request. Blocks that fail are removed from the cache, so a later read
+// fetches them again.
+func (f *File) fetchBlocks(ctx context.Context, first int64, blocks []*block) {
+	// Blocks are only read up to the tail, which is already buffered
+	off := first * f.blockSize
+	end := min(off+int64(len(blocks))*f.blockSize, f.tailOffset)
+
+	data, err := f.fetch(ctx, off, end-off)
+
+	f.mu.Lock()
+	for i, b := range blocks {
+		if err != nil {
+			b.err = err
+			if f.blocks[first+int64(i)] == b {
+				delete(f.blocks, first+int64(i))
+			}
+		} else {
+			start := int64(i) * f.blockSize
+			b.data = data[start:min(start+f.blockSize, int64(len(data)))]
+		}
+		close(b.ready)
+	}
+	f.mu.Unlock()
+}
+
+// evict removes the least recently used blocks while more than maxBlocks are
+// cached. Reads in progress keep the blocks they use. It must be called with
+// mu held.
+func (f *File) evict() {
+	for len(f.blocks) > f.maxBlocks {
+		var (
+			oldest  int64
+			minUsed = f.clock + 1
+		)
+		for i, b := range f.blocks {
+			if b.used < minUsed {
+				oldest, minUsed = i, b.used
+			}
+		}
+		delete(f.blocks, oldest)
+	}
+}
+
+// fetch fetches length bytes of the object starting at off.
+func (f *File) fetch(ctx context.Context, off, length int64) ([]byte, error) {
+	end := min(off+length, f.size) - 1
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(f.bucket),
+		Key:    aws.String(f.key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", off, end)),
+	}
+	if f.etag != "" {
+		input.IfMatch = aws.String(f.etag)
+	}
+
+	result, err := f.client.GetObject(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get range %d-%d of object %s/%s: %w", off, end, f.bucket, f.key, err)
+	}
+	defer result.Body.Close()
+
+	buf := make([]byte, end-off+1)
+	if _, err := io.ReadFull(result.Body, buf); err != nil {
+		return nil, fmt.Errorf("failed to read range %d-%d of object %s/%s: %w", off, end, f.bucket, f.key, err)
+	}
+
+	f.mu.Lock()
+	f.fetched += int64(len(buf))
+	f.mu.Unlock()
+
+	return buf, nil
+}
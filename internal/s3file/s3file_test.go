@@ -0,0 +1,247 @@
+package s3file
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/models"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/s3test"
+)
+
+// testObject returns size bytes that differ at every offset, so misplaced
+// reads are caught.
+func testObject(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 7 / 3)
+	}
+	return data
+}
+
+// rangeGets returns the ranged GetObject requests made by a file, leaving out
+// the footer prefetch made when it was opened.
+func rangeGets(srv *s3test.Server, tailOffset int64) []string {
+	var gets []string
+	for _, r := range srv.Requests() {
+		if r.Method == "GET" && r.Range != "" && !strings.HasPrefix(r.Range, fmt.Sprintf("bytes=%d-", tailOffset)) {
+			gets = append(gets, r.Range)
+		}
+	}
+	return gets
+}
+
+func TestReadAt(t *testing.T) {
+	ctx := context.Background()
+	data := testObject(300_000)
+
+	srv := s3test.NewServer(t)
+	srv.Put("bucket", "key", data)
+
+	f, err := Open(ctx, srv.Client(), "bucket", "key", 16<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if f.Size() != int64(len(data)) {
+		t.Fatalf("size = %d, want %d", f.Size(), len(data))
+	}
+
+	tests := []struct {
+		name    string
+		off     int64
+		n       int
+		wantErr error
+	}{
+		{name: "start", off: 0, n: 100},
+		{name: "within a block", off: 20_000, n: 1_000},
+		{name: "across blocks", off: 16<<10 - 10, n: 100},
+		{name: "across many blocks", off: 5, n: 100_000},
+		{name: "across the tail", off: f.tailOffset - 500, n: 1_000},
+		{name: "within the tail", off: int64(len(data)) - 100, n: 100},
+		{name: "past the end", off: int64(len(data)) - 10, n: 100, wantErr: io.EOF},
+		{name: "at the end", off: int64(len(data)), n: 10, wantErr: io.EOF},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := make([]byte, tt.n)
+			n, err := f.Reader(ctx).ReadAt(p, tt.off)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+
+			want := data[min(tt.off, int64(len(data))):min(tt.off+int64(tt.n), int64(len(data)))]
+			if !bytes.Equal(p[:n], want) {
+				t.Fatalf("read %d bytes that differ from the object", n)
+			}
+		})
+	}
+}
+
+func TestReadAtConcurrent(t *testing.T) {
+	ctx := context.Background()
+	data := testObject(1 << 20)
+
+	srv := s3test.NewServer(t)
+	srv.Put("bucket", "key", data)
+
+	const blockSize = 32 << 10
+
+	f, err := Open(ctx, srv.Client(), "bucket", "key", blockSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// Interleave readers of separate regions, like the column readers of a
+	// row group, each reading its region in small steps
+	const readers = 8
+	region := f.tailOffset / readers
+
+	var wg sync.WaitGroup
+	for r := range readers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			rng := rand.New(rand.NewPCG(uint64(r), 0))
+			for off := int64(r) * region; off < int64(r+1)*region; {
+				p := make([]byte, min(1+rng.Int64N(4<<10), int64(r+1)*region-off))
+				if _, err := f.ReadAtContext(ctx, p, off); err != nil {
+					t.Error(err)
+					return
+				}
+				if !bytes.Equal(p, data[off:off+int64(len(p))]) {
+					t.Errorf("read at %d differs from the object", off)
+					return
+				}
+				off += int64(len(p))
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every block is fetched once, however the reads interleave
+	seen := make(map[string]bool)
+	for _, rng := range rangeGets(srv, f.tailOffset) {
+		if seen[rng] {
+			t.Errorf("range %s fetched more than once", rng)
+		}
+		seen[rng] = true
+	}
+
+	if got, want := f.BytesRead(), int64(len(data)); got > want {
+		t.Errorf("fetched %d bytes of a %d byte object", got, want)
+	}
+}
+
+func TestReadAtObjectReplaced(t *testing.T) {
+	ctx := context.Background()
+
+	srv := s3test.NewServer(t)
+	srv.Put("bucket", "key", testObject(200_000))
+
+	f, err := Open(ctx, srv.Client(), "bucket", "key", 16<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	srv.Put("bucket", "key", testObject(210_000)[10_000:])
+
+	if _, err := f.ReadAtContext(ctx, make([]byte, 100), 0); err == nil {
+		t.Fatal("read of a replaced object succeeded")
+	}
+}
+
+func TestReadAtCanceled(t *testing.T) {
+	srv := s3test.NewServer(t)
+	srv.Put("bucket", "key", testObject(200_000))
+
+	f, err := Open(context.Background(), srv.Client(), "bucket", "key", 16<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := f.ReadAtContext(ctx, make([]byte, 100), 0); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want %v", err, context.Canceled)
+	}
+
+	// The failed block is fetched again by the next read
+	if _, err := f.ReadAtContext(context.Background(), make([]byte, 100), 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadParquet(t *testing.T) {
+	ctx := context.Background()
+
+	records := make([]models.Record, 5_000)
+	for i := range records {
+		records[i] = models.Record{
+			ID:            fmt.Sprintf("id-%d", i),
+			CreatedAt:     time.Unix(int64(i), 0).UTC(),
+			FirstName:     "Ada",
+			AccountType:   []string{"free", "premium"}[i%2],
+			AccountStatus: "active",
+			Tags:          []string{"x"},
+			Body:          strings.Repeat("b", i%200),
+		}
+	}
+
+	var buf bytes.Buffer
+	w := parquet.NewGenericWriter[models.Record](&buf, parquet.MaxRowsPerRowGroup(1_000))
+	if _, err := w.Write(records); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := s3test.NewServer(t)
+	srv.Put("bucket", "records.parquet", buf.Bytes())
+
+	f, err := Open(ctx, srv.Client(), "bucket", "records.parquet", 32<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	pf, err := parquet.OpenFile(f.Reader(ctx), f.Size(), parquet.ReadBufferSize(32<<10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := parquet.NewGenericReader[models.Record](pf)
+	defer r.Close()
+
+	got := make([]models.Record, len(records))
+	n, err := r.Read(got)
+	if err != nil && !errors.Is(err, io.EOF) {
+		t.Fatal(err)
+	}
+	if n != len(records) {
+		t.Fatalf("read %d records, want %d", n, len(records))
+	}
+
+	for i := range records {
+		if got[i].ID != records[i].ID || got[i].Body != records[i].Body || !got[i].CreatedAt.Equal(records[i].CreatedAt) {
+			t.Fatalf("record %d = %+v, want %+v", i, got[i], records[i])
+		}
+	}
+}
@@ -0,0 +1,198 @@
+// Package s3paths expands the paths of a request into the keys of the S3
+// objects they refer to. A path is either an exact key, a glob pattern such
+// as exports/2024-10-*/part-*.parquet, or a prefix ending in a slash such as
+// exports/2024-10-01/, which refers to every object below it.
+//
+// Patterns follow path.Match, so * and ? never match a slash. A pattern
+// ending in a slash, such as exports/2024-10-*/, refers to every object below
+// the prefixes it matches. A pattern or prefix that matches no object is an
+// error, so a mistyped path is not mistaken for an empty one.
+//
+// A backslash escapes the character after it, so keys holding *, ?, [ or \,
+// or ending in a slash, are given as exact keys by escaping those characters,
+// such as reports/q\[1\].parquet for the key reports/q[1].parquet or
+// folder\/ for the key folder/.
+package s3paths
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// ErrNoMatch is returned when a pattern or prefix matches no object.
+var ErrNoMatch = errors.New("no objects match")
+
+// wildcards are the characters that make a path a pattern unless escaped.
+const wildcards = `*?[`
+
+// Filter limits the objects patterns and prefixes expand to. Exact keys are
+// kept as they are, without checking the filter.
+type Filter struct {
+	// ModifiedAfter keeps objects last modified at or after it.
+	ModifiedAfter *time.Time `json:"modified_after,omitempty"`
+
+	// ModifiedBefore keeps objects last modified before it.
+	ModifiedBefore *time.Time `json:"modified_before,omitempty"`
+
+	// MinSize keeps objects of at least this many bytes.
+	MinSize int64 `json:"min_size,omitempty"`
+
+	// MaxSize keeps objects of at most this many bytes, if it is set.
+	MaxSize int64 `json:"max_size,omitempty"`
+}
+
+// match reports whether an object passes the filter.
+func (f Filter) match(obj types.Object) bool {
+	modified := aws.ToTime(obj.LastModified)
+	if f.ModifiedAfter != nil && modified.Before(*f.ModifiedAfter) {
+		return false
+	}
+	if f.ModifiedBefore != nil && !modified.Before(*f.ModifiedBefore) {
+		return false
+	}
+
+	size := aws.ToInt64(obj.Size)
+	if size < f.MinSize {
+		return false
+	}
+	return f.MaxSize <= 0 || size <= f.MaxSize
+}
+
+// IsPattern reports whether a path is a pattern or a prefix rather than an
+// exact key, that is whether it holds a wildcard or ends in a slash that is
+// not escaped.
+func IsPattern(p string) bool {
+	_, pattern := parse(p)
+	return pattern
+}
+
+// parse splits a path into the literal part before its first wildcard, with
+// escapes removed, and reports whether it is a pattern or a prefix. The
+// literal part of an exact key is the key itself.
+func parse(p string) (literal string, pattern bool) {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		switch c := p[i]; {
+		case c == '\\' && i+1 < len(p):
+			i++
+			b.WriteByte(p[i])
+		case c == '\\' || strings.IndexByte(wildcards, c) >= 0:
+			// A trailing backslash is left for path.Match to reject
+			return b.String(), true
+		case c == '/' && i == len(p)-1:
+			b.WriteByte(c)
+			return b.String(), true
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), false
+}
+
+// Expand expands paths into the keys of the objects they refer to. Keys are
+// returned in the order of the paths, those of a single pattern or prefix
+// sorted, and every key only once. A pattern or prefix that matches no object
+// passing the filter fails with ErrNoMatch.
+func Expand(ctx context.Context, client s3.ListObjectsV2APIClient, bucket string, paths []string, filter Filter) ([]string, error) {
+	var (
+		keys []string
+		seen = make(map[string]bool)
+	)
+
+	add := func(key string) {
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+
+	for _, p := range paths {
+		literal, pattern := parse(p)
+		if !pattern {
+			add(literal)
+			continue
+		}
+
+		matched, err := list(ctx, client, bucket, p, literal, filter)
+		if err != nil {
+			return nil, err
+		}
+		if len(matched) == 0 {
+			return nil, fmt.Errorf("%w %q in bucket %s", ErrNoMatch, p, bucket)
+		}
+		for _, key := range matched {
+			add(key)
+		}
+	}
+
+	return keys, nil
+}
+
+// list returns the sorted keys of the objects a pattern or prefix refers to,
+// listing only the objects below prefix, the part of it before the first
+// wildcard.
+func list(ctx context.Context, client s3.ListObjectsV2APIClient, bucket, pattern, prefix string, filter Filter) ([]string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+
+	var keys []string
+
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects of s3://%s/%s: %w", bucket, prefix, err)
+		}
+
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+
+			// Skip the empty objects consoles create as folders
+			if strings.HasSuffix(key, "/") {
+				continue
+			}
+
+			if matchKey(pattern, key) && filter.match(obj) {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	slices.Sort(keys)
+	return keys, nil
+}
+
+// matchKey reports whether a key matches a pattern. A pattern ending in a
+// slash matches every key below a prefix it matches.
+func matchKey(pattern, key string) bool {
+	if strings.HasSuffix(pattern, "/") {
+		// Compare the pattern to the key up to the slash that ends the
+		// same number of segments
+		n := strings.Count(pattern, "/")
+		end := 0
+		for range n {
+			i := strings.IndexByte(key[end:], '/')
+			if i < 0 {
+				return false
+			}
+			end += i + 1
+		}
+		key = key[:end]
+	}
+
+	ok, _ := path.Match(pattern, key)
+	return ok
+}
@@ -0,0 +1,143 @@
+package s3paths
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/s3test"
+)
+
+func TestExpand(t *testing.T) {
+	ctx := context.Background()
+
+	srv := s3test.NewServer(t)
+	old := time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC)
+	for _, key := range []string{
+		"exports/2024-10-01/part-0.parquet",
+		"exports/2024-10-01/part-1.parquet",
+		"exports/2024-10-02/part-0.parquet",
+		"exports/2024-10-02/nested/part-0.parquet",
+		"exports/2024-10-02/",
+		"reports/q[1].parquet",
+		"reports/q1.parquet",
+	} {
+		srv.Put("bucket", key, []byte("data"))
+	}
+	srv.PutModified("bucket", "exports/2024-09-30/part-0.parquet", []byte("data"), old)
+
+	after := old.Add(time.Hour)
+
+	tests := []struct {
+		name    string
+		paths   []string
+		filter  Filter
+		want    []string
+		wantErr error
+	}{
+		{
+			name:  "exact keys",
+			paths: []string{"exports/2024-10-02/part-0.parquet", "missing.parquet"},
+			want:  []string{"exports/2024-10-02/part-0.parquet", "missing.parquet"},
+		},
+		{
+			name:  "pattern",
+			paths: []string{"exports/2024-10-*/part-0.parquet"},
+			want:  []string{"exports/2024-10-01/part-0.parquet", "exports/2024-10-02/part-0.parquet"},
+		},
+		{
+			name:  "prefix",
+			paths: []string{"exports/2024-10-02/"},
+			want:  []string{"exports/2024-10-02/nested/part-0.parquet", "exports/2024-10-02/part-0.parquet"},
+		},
+		{
+			name:  "pattern of prefixes",
+			paths: []string{"exports/2024-1?-0*/"},
+			want: []string{
+				"exports/2024-10-01/part-0.parquet",
+				"exports/2024-10-01/part-1.parquet",
+				"exports/2024-10-02/nested/part-0.parquet",
+				"exports/2024-10-02/part-0.parquet",
+			},
+		},
+		{
+			name:  "keys once in path order",
+			paths: []string{"exports/2024-10-01/part-1.parquet", "exports/2024-10-01/*"},
+			want:  []string{"exports/2024-10-01/part-1.parquet", "exports/2024-10-01/part-0.parquet"},
+		},
+		{
+			name:   "filtered",
+			paths:  []string{"exports/*/part-0.parquet"},
+			filter: Filter{ModifiedBefore: &after},
+			want:   []string{"exports/2024-09-30/part-0.parquet"},
+		},
+		{
+			name:  "escaped wildcards",
+			paths: []string{`reports/q\[1\].parquet`},
+			want:  []string{"reports/q[1].parquet"},
+		},
+		{
+			name:  "escaped slash",
+			paths: []string{`exports/2024-10-02\/`},
+			want:  []string{"exports/2024-10-02/"},
+		},
+		{
+			name:  "escaped prefix of a pattern",
+			paths: []string{`reports/q\[*`},
+			want:  []string{"reports/q[1].parquet"},
+		},
+		{
+			name:    "pattern matching nothing",
+			paths:   []string{"exports/2024-10-01/part-0.parquet", "exports/2025-*/"},
+			wantErr: ErrNoMatch,
+		},
+		{
+			name:    "filter matching nothing",
+			paths:   []string{"exports/2024-09-30/"},
+			filter:  Filter{ModifiedAfter: &after},
+			wantErr: ErrNoMatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Expand(ctx, srv.Client(), "bucket", tt.paths, tt.filter)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("expanded %q to %q, want %q", tt.paths, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandInvalidPattern(t *testing.T) {
+	srv := s3test.NewServer(t)
+
+	for _, p := range []string{"exports/[a-", `exports/part-0\`} {
+		if _, err := Expand(context.Background(), srv.Client(), "bucket", []string{p}, Filter{}); err == nil {
+			t.Errorf("invalid pattern %q was expanded", p)
+		}
+	}
+}
+
+func TestIsPattern(t *testing.T) {
+	tests := map[string]bool{
+		"exports/part-0.parquet":  false,
+		"exports/part-*.parquet":  true,
+		"exports/":                true,
+		`exports/part-\*.parquet`: false,
+		`exports\/`:               false,
+		`exports\\`:               false,
+		`exports\`:                true,
+	}
+
+	for p, want := range tests {
+		if got := IsPattern(p); got != want {
+			t.Errorf("IsPattern(%q) = %t, want %t", p, got, want)
+		}
+	}
+}
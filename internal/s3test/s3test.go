@@ -0,0 +1,284 @@
+// Package s3test runs an in-process fake S3 server for tests. It serves the
+// subset of the S3 API the processors use, HeadObject, ranged and conditional
+// GetObject, PutObject and ListObjectsV2, with path style addressing, and
+// records every request so tests can check what was fetched.
+package s3test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// object is a stored object.
+type object struct {
+	data     []byte
+	etag     string
+	modified time.Time
+}
+
+// Request is a request served by the server.
+type Request struct {
+	Method string
+	Bucket string
+	Key    string
+
+	// Range is the Range header of the request, if any.
+	Range string
+}
+
+// Server is a fake S3 server holding objects in memory.
+type Server struct {
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	objects  map[string]object
+	requests []Request
+}
+
+// NewServer starts a fake S3 server that is closed when the test ends.
+func NewServer(t testing.TB) *Server {
+	s := &Server{objects: make(map[string]object)}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.srv.Close)
+	return s
+}
+
+// URL returns the endpoint of the server.
+func (s *Server) URL() string {
+	return s.srv.URL
+}
+
+// Client returns an S3 client of the server.
+func (s *Server) Client() *s3.Client {
+	return s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(s.srv.URL),
+		UsePathStyle: true,
+		Credentials:  aws.AnonymousCredentials{},
+	})
+}
+
+// Put stores an object, last modified now.
+func (s *Server) Put(bucket, key string, data []byte) {
+	s.PutModified(bucket, key, data, time.Now())
+}
+
+// PutModified stores an object last modified at a time.
+func (s *Server) PutModified(bucket, key string, data []byte, modified time.Time) {
+	sum := md5.Sum(data)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.objects[bucket+"/"+key] = object{
+		data:     bytes.Clone(data),
+		etag:     `"` + hex.EncodeToString(sum[:]) + `"`,
+		modified: modified.UTC().Truncate(time.Second),
+	}
+}
+
+// Object returns the data of a stored object.
+func (s *Server) Object(bucket, key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	obj, ok := s.objects[bucket+"/"+key]
+	return obj.data, ok
+}
+
+// Requests returns the requests served so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.requests)
+}
+
+// serve serves a request.
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Method: r.Method, Bucket: bucket, Key: key, Range: r.Header.Get("Range")})
+	s.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && key == "" && r.URL.Query().Get("list-type") == "2":
+		s.list(w, r, bucket)
+	case r.Method == http.MethodHead, r.Method == http.MethodGet:
+		s.get(w, r, bucket, key)
+	case r.Method == http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.Put(bucket, key, data)
+		w.WriteHeader(http.StatusOK)
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented", r.Method+" is not supported")
+	}
+}
+
+// get serves HeadObject and GetObject, with an optional byte range and
+// If-Match condition.
+func (s *Server) get(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	s.mu.Lock()
+	obj, ok := s.objects[bucket+"/"+key]
+	s.mu.Unlock()
+
+	if !ok {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+
+	if match := r.Header.Get("If-Match"); match != "" && match != obj.etag {
+		writeError(w, http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold")
+		return
+	}
+
+	w.Header().Set("ETag", obj.etag)
+	w.Header().Set("Last-Modified", obj.modified.Format(http.TimeFormat))
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	data, status := obj.data, http.StatusOK
+	if rng := r.Header.Get("Range"); rng != "" && r.Method == http.MethodGet {
+		start, end, ok := parseRange(rng, int64(len(obj.data)))
+		if !ok {
+			writeError(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable")
+			return
+		}
+		data, status = obj.data[start:end+1], http.StatusPartialContent
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(obj.data)))
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	if r.Method == http.MethodGet {
+		w.Write(data)
+	}
+}
+
+// parseRange parses a Range header of the form bytes=start-end.
+func parseRange(rng string, size int64) (int64, int64, bool) {
+	spec, ok := strings.CutPrefix(rng, "bytes=")
+	if !ok {
+		return 0, 0, false
+	}
+
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, false
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start >= size {
+		return 0, 0, false
+	}
+
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false
+		}
+	}
+
+	return start, min(end, size-1), true
+}
+
+// listResult is the ListObjectsV2 response.
+type listResult struct {
+	XMLName               xml.Name      `xml:"ListBucketResult"`
+	Name                  string        `xml:"Name"`
+	Prefix                string        `xml:"Prefix"`
+	KeyCount              int           `xml:"KeyCount"`
+	MaxKeys               int           `xml:"MaxKeys"`
+	IsTruncated           bool          `xml:"IsTruncated"`
+	NextContinuationToken string        `xml:"NextContinuationToken,omitempty"`
+	Contents              []listContent `xml:"Contents"`
+}
+
+// listContent is an object listed by ListObjectsV2.
+type listContent struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+}
+
+// list serves ListObjectsV2, with the continuation token being the last key
+// of the previous page.
+func (s *Server) list(w http.ResponseWriter, r *http.Request, bucket string) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+
+	maxKeys := 1000
+	if v, err := strconv.Atoi(query.Get("max-keys")); err == nil && v > 0 {
+		maxKeys = v
+	}
+
+	after := query.Get("continuation-token")
+	if after == "" {
+		after = query.Get("start-after")
+	}
+
+	s.mu.Lock()
+	var keys []string
+	for name := range maps.Keys(s.objects) {
+		b, key, _ := strings.Cut(name, "/")
+		if b == bucket && strings.HasPrefix(key, prefix) && key > after {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	res := listResult{Name: bucket, Prefix: prefix, MaxKeys: maxKeys}
+	for i, key := range keys {
+		if i == maxKeys {
+			res.IsTruncated = true
+			res.NextContinuationToken = keys[i-1]
+			break
+		}
+
+		obj := s.objects[bucket+"/"+key]
+		res.Contents = append(res.Contents, listContent{
+			Key:          key,
+			LastModified: obj.modified.Format(time.RFC3339),
+			ETag:         obj.etag,
+			Size:         int64(len(obj.data)),
+		})
+	}
+	res.KeyCount = len(res.Contents)
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(res)
+}
+
+// writeError writes an S3 error response.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "%s<Error><Code>%s</Code><Message>%s</Message></Error>", xml.Header, code, message)
+}
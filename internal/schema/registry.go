@@ -0,0 +1,215 @@
+package schema
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// registry holds the versions registered in the repository, one JSON file per
+// version under a directory per schema name.
+//
+//go:embed registry
+var registry embed.FS
+
+// Version is a registered version of a named schema.
+type Version struct {
+	// Name is the name of the schema, such as record.
+	Name string `json:"name"`
+
+	// Version increases with every change to the schema.
+	Version int `json:"version"`
+
+	// Columns are the columns of the schema.
+	Columns []Column `json:"columns"`
+}
+
+// ID identifies the version, such as record/v1.
+func (v Version) ID() string {
+	return fmt.Sprintf("%s/v%d", v.Name, v.Version)
+}
+
+// Registry holds the registered versions of named schemas.
+type Registry struct {
+	// versions are the versions of every schema sorted by version.
+	versions map[string][]Version
+}
+
+// NewRegistry creates a registry of versions. Every version of a schema must
+// be unique.
+func NewRegistry(versions ...Version) (*Registry, error) {
+	r := &Registry{versions: make(map[string][]Version)}
+	for _, v := range versions {
+		if v.Name == "" {
+			return nil, errors.New("schema version has no name")
+		}
+		if len(v.Columns) == 0 {
+			return nil, fmt.Errorf("schema %s has no columns", v.ID())
+		}
+		if slices.ContainsFunc(r.versions[v.Name], func(o Version) bool { return o.Version == v.Version }) {
+			return nil, fmt.Errorf("schema %s is registered more than once", v.ID())
+		}
+		r.versions[v.Name] = append(r.versions[v.Name], v)
+	}
+
+	for _, versions := range r.versions {
+		slices.SortFunc(versions, func(a, b Version) int { return a.Version - b.Version })
+	}
+
+	return r, nil
+}
+
+// Embedded loads the versions registered in the repository.
+func Embedded() (*Registry, error) {
+	return Load(registry)
+}
+
+// Load loads a registry from every JSON file of a file system.
+func Load(fsys fs.FS) (*Registry, error) {
+	var versions []Version
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || path.Ext(name) != ".json" {
+			return err
+		}
+
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return fmt.Errorf("failed to read schema %s: %w", name, err)
+		}
+
+		v, err := decode(name, data)
+		if err != nil {
+			return err
+		}
+		versions = append(versions, v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return NewRegistry(versions...)
+}
+
+// ObjectAPI is the subset of the S3 client used to load a registry from S3.
+type ObjectAPI interface {
+	s3.ListObjectsV2APIClient
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+}
+
+// LoadS3 loads a registry from every JSON object under a prefix of an S3
+// bucket.
+func LoadS3(ctx context.Context, client ObjectAPI, bucket, prefix string) (*Registry, error) {
+	var versions []Version
+
+	pages := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list schemas: %w", err)
+		}
+
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+			if path.Ext(key) != ".json" {
+				continue
+			}
+
+			v, err := getVersion(ctx, client, bucket, key)
+			if err != nil {
+				return nil, err
+			}
+			versions = append(versions, v)
+		}
+	}
+
+	return NewRegistry(versions...)
+}
+
+// FromConfig loads a registry from the JSON objects under a prefix of an S3
+// bucket, or the versions registered in the repository if no bucket is
+// configured.
+func FromConfig(ctx context.Context, client ObjectAPI, bucket, prefix string) (*Registry, error) {
+	if bucket == "" {
+		return Embedded()
+	}
+	return LoadS3(ctx, client, bucket, prefix)
+}
+
+// getVersion reads a version from an S3 object.
+func getVersion(ctx context.Context, client ObjectAPI, bucket, key string) (Version, error) {
+	result, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return Version{}, fmt.Errorf("failed to get schema %s: %w", key, err)
+	}
+	defer result.Body.Close()
+
+	data, err := io.ReadAll(result.Body)
+	if err != nil {
+		return Version{}, fmt.Errorf("failed to read schema %s: %w", key, err)
+	}
+
+	return decode(key, data)
+}
+
+// decode decodes a version from a JSON file.
+func decode(name string, data []byte) (Version, error) {
+	var v Version
+	if err := json.Unmarshal(data, &v); err != nil {
+		return Version{}, fmt.Errorf("failed to decode schema %s: %w", name, err)
+	}
+
+	for i := range v.Columns {
+		v.Columns[i].Path = strings.ToLower(v.Columns[i].Path)
+	}
+
+	return v, nil
+}
+
+// Resolve returns the registered version of a schema that a file with the
+// given columns was written with. A file matching a version exactly resolves
+// to it, otherwise it resolves to the latest version it can be read as, and
+// exact is false. The file is the writer and the version the reader, as when
+// its rows are read, so a file may add optional columns or store a column as
+// a narrower type, but not make a required column optional. Files that
+// cannot be read as any version are rejected with the incompatibilities
+// against the latest one.
+func (r *Registry) Resolve(name string, columns []Column) (v Version, exact bool, err error) {
+	versions := r.versions[name]
+	if len(versions) == 0 {
+		return Version{}, false, fmt.Errorf("schema %s is not registered", name)
+	}
+
+	fingerprint := Fingerprint(columns)
+	for _, v := range versions {
+		if Fingerprint(v.Columns) == fingerprint {
+			return v, true, nil
+		}
+	}
+
+	for _, v := range slices.Backward(versions) {
+		if Compatible(columns, v.Columns) == nil {
+			return v, false, nil
+		}
+	}
+
+	latest := versions[len(versions)-1]
+	return Version{}, false, fmt.Errorf("schema is not compatible with any version of %s, against %s: %w",
+		name, latest.ID(), Compatible(columns, latest.Columns))
+}
@@ -0,0 +1,94 @@
+package schema
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/s3test"
+)
+
+func TestResolve(t *testing.T) {
+	v1 := Version{Name: "record", Version: 1, Columns: []Column{
+		{Path: "id", Type: "BYTE_ARRAY", LogicalType: "STRING"},
+		{Path: "balance", Type: "INT64"},
+		{Path: "count", Type: "INT32"},
+		{Path: "note", Type: "BYTE_ARRAY", LogicalType: "STRING", Optional: true},
+	}}
+
+	r, err := NewRegistry(v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// with returns the columns of v1 with one replaced or added
+	with := func(c Column) []Column {
+		columns := []Column{c}
+		for _, o := range v1.Columns {
+			if o.Path != c.Path {
+				columns = append(columns, o)
+			}
+		}
+		return columns
+	}
+
+	tests := []struct {
+		name    string
+		columns []Column
+		exact   bool
+		wantErr bool
+	}{
+		{name: "registered", columns: v1.Columns, exact: true},
+		{name: "added optional column", columns: with(Column{Path: "extra", Type: "INT32", Optional: true})},
+		{name: "required column made optional", columns: with(Column{Path: "id", Type: "BYTE_ARRAY", LogicalType: "STRING", Optional: true}), wantErr: true},
+		{name: "optional column made required", columns: with(Column{Path: "note", Type: "BYTE_ARRAY", LogicalType: "STRING"})},
+		{name: "narrower type", columns: with(Column{Path: "balance", Type: "INT32"})},
+		{name: "wider type", columns: with(Column{Path: "count", Type: "INT64"}), wantErr: true},
+		{name: "added required column", columns: with(Column{Path: "extra", Type: "INT32"}), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, exact, err := r.Resolve("record", tt.columns)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tt.wantErr)
+			}
+			if err == nil && (v.ID() != v1.ID() || exact != tt.exact) {
+				t.Fatalf("resolved %s exact %t, want %s exact %t", v.ID(), exact, v1.ID(), tt.exact)
+			}
+		})
+	}
+}
+
+func TestFromConfig(t *testing.T) {
+	ctx := context.Background()
+
+	srv := s3test.NewServer(t)
+	srv.Put("schemas", "registry/account/v2.json", []byte(`{"name": "account", "version": 2, "columns": [{"path": "id", "type": "BYTE_ARRAY"}]}`))
+	srv.Put("schemas", "registry/README.md", []byte("not a version"))
+	srv.Put("schemas", "other/account/v3.json", []byte("{}"))
+
+	// registered reports whether any version of a schema is registered
+	registered := func(r *Registry, name string) bool {
+		_, _, err := r.Resolve(name, nil)
+		return err == nil || !strings.Contains(err.Error(), "is not registered")
+	}
+
+	// The versions under the prefix are loaded from the bucket
+	r, err := FromConfig(ctx, srv.Client(), "schemas", "registry/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !registered(r, "account") || registered(r, "record") {
+		t.Fatal("want only the versions in the bucket")
+	}
+
+	// Without a bucket the embedded versions are loaded
+	r, err = FromConfig(ctx, srv.Client(), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if registered(r, "account") || !registered(r, "record") {
+		t.Fatal("want only the embedded versions")
+	}
+}
@@ -0,0 +1,146 @@
+// Package schema describes the columns of parquet schemas, fingerprints them
+// and decides whether one schema can be read as another, so files written as
+// a schema evolves can be checked against the versions a reader supports.
+package schema
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// Column describes a leaf column of a schema.
+type Column struct {
+	// Path is the lower case dotted path of the column, such as address.city.
+	Path string `json:"path"`
+
+	// Type is the physical type the column is stored as, such as INT64.
+	Type string `json:"type"`
+
+	// LogicalType is the logical type of the column, such as a timestamp, or
+	// empty if it has none.
+	LogicalType string `json:"logical_type,omitempty"`
+
+	// Optional is true if the column can hold nulls.
+	Optional bool `json:"optional,omitempty"`
+
+	// Repeated is the number of lists the column is nested in.
+	Repeated int `json:"repeated,omitempty"`
+}
+
+// widenings are the physical types a type can be read as besides itself.
+var widenings = map[string][]string{
+	parquet.Int32.String(): {parquet.Int64.String()},
+	parquet.Float.String(): {parquet.Double.String()},
+}
+
+// Describe returns the leaf columns of a schema sorted by path.
+func Describe(s *parquet.Schema) []Column {
+	var columns []Column
+	for _, path := range s.Columns() {
+		leaf, _ := s.Lookup(path...)
+		typ := leaf.Node.Type()
+
+		column := Column{
+			Path:     strings.ToLower(strings.Join(path, ".")),
+			Type:     typ.Kind().String(),
+			Optional: leaf.MaxDefinitionLevel > leaf.MaxRepetitionLevel,
+			Repeated: leaf.MaxRepetitionLevel,
+		}
+		if lt := typ.LogicalType(); lt != nil {
+			column.LogicalType = lt.String()
+		}
+
+		columns = append(columns, column)
+	}
+
+	slices.SortFunc(columns, func(a, b Column) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+
+	return columns
+}
+
+// Fingerprint returns a short fingerprint of a set of columns. It does not
+// depend on the order of the columns, as they are read by path, so schemas
+// with the same fingerprint decode to the same records.
+func Fingerprint(columns []Column) string {
+	columns = slices.SortedFunc(slices.Values(columns), func(a, b Column) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+
+	h := sha256.New()
+	for _, c := range columns {
+		fmt.Fprintf(h, "%s|%s|%s|%t|%d\n", c.Path, c.Type, c.LogicalType, c.Optional, c.Repeated)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// Compatible checks that data written with the writer columns can be read as
+// the reader columns. Columns only one side has must be optional, types may
+// be widened, such as INT32 to INT64, and required columns may become
+// optional, but not the other way around. Logical types must match, except for
+// their parameters such as the unit of a timestamp, which are converted. Every
+// incompatibility is reported.
+func Compatible(writer, reader []Column) error {
+	var (
+		have = byPath(writer)
+		want = byPath(reader)
+		errs []error
+	)
+
+	for _, w := range writer {
+		r, ok := want[w.Path]
+		switch {
+		case !ok && !w.Optional:
+			errs = append(errs, fmt.Errorf("required column %q was removed", w.Path))
+		case !ok:
+		case w.Type != r.Type && !slices.Contains(widenings[w.Type], r.Type):
+			errs = append(errs, fmt.Errorf("column %q is %s, cannot be read as %s", w.Path, w.Type, r.Type))
+		case w.Type == r.Type && logicalKind(w) != logicalKind(r):
+			errs = append(errs, fmt.Errorf("column %q is %s, cannot be read as %s", w.Path, logicalType(w), logicalType(r)))
+		case w.Repeated != r.Repeated:
+			errs = append(errs, fmt.Errorf("column %q is nested in %d lists, expected %d", w.Path, w.Repeated, r.Repeated))
+		case w.Optional && !r.Optional:
+			errs = append(errs, fmt.Errorf("column %q is optional, expected required", w.Path))
+		}
+	}
+
+	for _, r := range reader {
+		if _, ok := have[r.Path]; !ok && !r.Optional {
+			errs = append(errs, fmt.Errorf("required column %q is missing", r.Path))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// byPath indexes columns by path.
+func byPath(columns []Column) map[string]Column {
+	m := make(map[string]Column, len(columns))
+	for _, c := range columns {
+		m[c.Path] = c
+	}
+	return m
+}
+
+// logicalKind returns the logical type of a column without its parameters,
+// such as TIMESTAMP.
+func logicalKind(c Column) string {
+	kind, _, _ := strings.Cut(c.LogicalType, "(")
+	return kind
+}
+
+// logicalType returns the logical type of a column for error messages, or its
+// physical type if it has none.
+func logicalType(c Column) string {
+	if c.LogicalType == "" {
+		return c.Type
+	}
+	return c.LogicalType
+}
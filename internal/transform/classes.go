@@ -0,0 +1,113 @@
+package transform
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/redact"
+)
+
+// Classify returns the classes of the fields of records transformed by the
+// chain, given those of the records it is applied to. Classes follow renamed
+// fields, derived fields are of the joined class of the fields their template
+// refers to, and constants hold no personal information. A derived field is
+// not classified if one of the fields it refers to is not.
+func (c *Chain) Classify(classes redact.Classes) redact.Classes {
+	out := maps.Clone(classes)
+	if out == nil {
+		out = make(redact.Classes)
+	}
+
+	for _, classify := range c.classifiers {
+		classify(out)
+	}
+
+	return out
+}
+
+// newClassifier returns how a configured step changes the classes of the
+// fields of a record. The step must be valid.
+func newClassifier(s Step) func(redact.Classes) {
+	switch s.Op {
+	case "rename":
+		return func(classes redact.Classes) {
+			moved := subtree(classes, s.Field, s.To)
+			unclassify(classes, s.Field)
+			unclassify(classes, s.To)
+			maps.Copy(classes, moved)
+		}
+	case "drop":
+		return func(classes redact.Classes) {
+			unclassify(classes, s.Field)
+		}
+	case "constant":
+		return func(classes redact.Classes) {
+			unclassify(classes, s.Field)
+			classes[s.Field] = redact.None
+		}
+	case "derive":
+		tmpl := template.Must(template.New(s.Field).Parse(s.Template))
+		inputs, whole := templateFields(tmpl.Tree)
+
+		return func(classes redact.Classes) {
+			var joined []redact.Class
+			if whole {
+				joined = slices.AppendSeq(joined, maps.Values(classes))
+			}
+
+			classified := true
+			for _, input := range inputs {
+				class, ok := classes.Of(input)
+				classified = classified && ok
+				joined = append(joined, class)
+
+				// Fields nested in the input are part of it
+				prefix := input + "."
+				for path, class := range classes {
+					if strings.HasPrefix(path, prefix) {
+						joined = append(joined, class)
+					}
+				}
+			}
+
+			unclassify(classes, s.Field)
+			if classified {
+				classes[s.Field] = redact.Join(joined...)
+			}
+		}
+	default:
+		// Hashing, masking and casting a field keep what it holds
+		return func(redact.Classes) {}
+	}
+}
+
+// subtree returns the classes of the field at a path and the fields nested in
+// it, moved to another path.
+func subtree(classes redact.Classes, path, to string) redact.Classes {
+	moved := make(redact.Classes)
+	if class, ok := classes.Of(path); ok {
+		moved[to] = class
+	}
+
+	prefix := path + "."
+	for p, class := range classes {
+		if rest, ok := strings.CutPrefix(p, prefix); ok {
+			moved[to+"."+rest] = class
+		}
+	}
+
+	return moved
+}
+
+// unclassify removes the classes of the field at a path and the fields nested
+// in it.
+func unclassify(classes redact.Classes, path string) {
+	prefix := path + "."
+	for p := range classes {
+		if p == path || strings.HasPrefix(p, prefix) {
+			delete(classes, p)
+		}
+	}
+}
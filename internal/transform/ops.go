@@ -0,0 +1,247 @@
+package transform
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/fields"
+)
+
+// newStep creates the transform of a configured step.
+func newStep(s Step, opts Options) (func(map[string]any) error, error) {
+	if s.Field == "" {
+		return nil, errors.New("field is required")
+	}
+
+	switch s.Op {
+	case "rename":
+		return rename(s.Field, s.To)
+	case "drop":
+		return drop(s.Field), nil
+	case "constant":
+		return constant(s.Field, s.Value), nil
+	case "hash":
+		return hash(s.Field, opts.HashKey)
+	case "mask":
+		return mask(s.Field, s.Pattern, s.Replacement)
+	case "cast":
+		return cast(s.Field, s.Type)
+	case "derive":
+		return derive(s.Field, s.Template)
+	default:
+		return nil, fmt.Errorf("unknown transform %q", s.Op)
+	}
+}
+
+// rename moves a field to another path.
+func rename(field, to string) (func(map[string]any) error, error) {
+	if to == "" {
+		return nil, errors.New("to is required")
+	}
+
+	return func(record map[string]any) error {
+		v, ok := get(record, field)
+		if !ok {
+			return nil
+		}
+		remove(record, field)
+		set(record, to, v)
+		return nil
+	}, nil
+}
+
+// drop removes a field.
+func drop(field string) func(map[string]any) error {
+	return func(record map[string]any) error {
+		remove(record, field)
+		return nil
+	}
+}
+
+// constant sets a field to the same value on every record.
+func constant(field string, value any) func(map[string]any) error {
+	return func(record map[string]any) error {
+		set(record, field, value)
+		return nil
+	}
+}
+
+// hash replaces a field with its hash, see fields.Hash. Null values are left
+// as they are.
+func hash(field string, key []byte) (func(map[string]any) error, error) {
+	if len(key) == 0 {
+		return nil, errors.New("a hash key is required")
+	}
+
+	return func(record map[string]any) error {
+		v, ok := get(record, field)
+		if !ok || v == nil {
+			return nil
+		}
+
+		set(record, field, fields.Hash(key, v))
+		return nil
+	}, nil
+}
+
+// mask replaces every match of a pattern in a field with a replacement.
+func mask(field, pattern, replacement string) (func(map[string]any) error, error) {
+	if pattern == "" {
+		return nil, errors.New("pattern is required")
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	return func(record map[string]any) error {
+		v, ok := get(record, field)
+		if !ok || v == nil {
+			return nil
+		}
+
+		set(record, field, re.ReplaceAllString(fields.Text(v), replacement))
+		return nil
+	}, nil
+}
+
+// cast converts a field to another type.
+func cast(field, typ string) (func(map[string]any) error, error) {
+	var conv func(any) (any, error)
+	switch typ {
+	case "string":
+		conv = func(v any) (any, error) { return fields.Text(v), nil }
+	case "int":
+		conv = func(v any) (any, error) { return strconv.ParseInt(strings.TrimSpace(fields.Text(v)), 10, 64) }
+	case "float":
+		conv = func(v any) (any, error) { return strconv.ParseFloat(strings.TrimSpace(fields.Text(v)), 64) }
+	case "bool":
+		conv = func(v any) (any, error) { return strconv.ParseBool(strings.TrimSpace(fields.Text(v))) }
+	default:
+		return nil, fmt.Errorf("unknown type %q", typ)
+	}
+
+	return func(record map[string]any) error {
+		v, ok := get(record, field)
+		if !ok || v == nil {
+			return nil
+		}
+
+		cv, err := conv(v)
+		if err != nil {
+			return fmt.Errorf("failed to cast field %s to %s: %w", field, typ, err)
+		}
+		set(record, field, cv)
+		return nil
+	}, nil
+}
+
+// derive sets a field to a template rendered with the record. The step is
+// skipped for records missing a field the template refers to, like any other
+// step on a missing field.
+func derive(field, text string) (func(map[string]any) error, error) {
+	if text == "" {
+		return nil, errors.New("template is required")
+	}
+
+	tmpl, err := template.New(field).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+	inputs, _ := templateFields(tmpl.Tree)
+
+	return func(record map[string]any) error {
+		for _, input := range inputs {
+			if _, ok := get(record, input); !ok {
+				return nil
+			}
+		}
+
+		var b strings.Builder
+		if err := tmpl.Execute(&b, record); err != nil {
+			return fmt.Errorf("failed to derive field %s: %w", field, err)
+		}
+		set(record, field, b.String())
+		return nil
+	}, nil
+}
+
+// templateFields returns the dotted paths of the fields of the record a
+// template refers to, such as address.city for {{.address.city}} or
+// {{$.address.city}}. Fields within range and with blocks are relative to
+// another value and are left out. The boolean is true if the template refers
+// to the record as a whole, such as with {{index . "email"}}.
+func templateFields(tree *parse.Tree) ([]string, bool) {
+	var (
+		paths []string
+		whole bool
+		walk  func(node parse.Node, root bool)
+	)
+	add := func(ident []string) {
+		if path := strings.Join(ident, "."); !slices.Contains(paths, path) {
+			paths = append(paths, path)
+		}
+	}
+
+	walk = func(node parse.Node, root bool) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child, root)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe, root)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, cmd := range n.Cmds {
+				walk(cmd, root)
+			}
+		case *parse.CommandNode:
+			for _, arg := range n.Args {
+				walk(arg, root)
+			}
+		case *parse.FieldNode:
+			if root {
+				add(n.Ident)
+			}
+		case *parse.DotNode:
+			whole = whole || root
+		case *parse.VariableNode:
+			switch {
+			case len(n.Ident) > 1 && n.Ident[0] == "$":
+				add(n.Ident[1:])
+			case n.Ident[0] == "$":
+				whole = true
+			}
+		case *parse.IfNode:
+			walk(n.Pipe, root)
+			walk(n.List, root)
+			walk(n.ElseList, root)
+		case *parse.RangeNode:
+			walk(n.Pipe, root)
+			walk(n.List, false)
+			walk(n.ElseList, root)
+		case *parse.WithNode:
+			walk(n.Pipe, root)
+			walk(n.List, false)
+			walk(n.ElseList, root)
+		case *parse.TemplateNode:
+			walk(n.Pipe, root)
+		}
+	}
+	walk(tree.Root, true)
+
+	return paths, whole
+}
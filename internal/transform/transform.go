@@ -0,0 +1,203 @@
+// Package transform applies declarative chains of transforms to records
+// between reading and publishing them, such as dropping, hashing or masking
+// fields that must not leave the account.
+//
+// Chains are configured per dataset in YAML or JSON, for example
+//
+//	records:
+//	  - op: drop
+//	    field: date_of_birth
+//	  - op: hash
+//	    field: email
+//	  - op: mask
+//	    field: phone_number
+//	    pattern: '^.*(\d{4})$'
+//	    replacement: '***-***-$1'
+//
+// Fields are addressed by dotted paths such as address.city. Steps on a field
+// a record does not have, and derive steps whose template refers to one, are
+// skipped, so a chain also applies to records with only some columns
+// selected.
+package transform
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/fields"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/redact"
+)
+
+// Step is a single configured transform.
+type Step struct {
+	// Op is the transform, one of "rename", "drop", "constant", "hash",
+	// "mask", "cast" or "derive".
+	Op string `yaml:"op"`
+
+	// Field is the dotted path of the field transformed, or set by constant
+	// and derive.
+	Field string `yaml:"field"`
+
+	// To is the dotted path a field is renamed to.
+	To string `yaml:"to,omitempty"`
+
+	// Value is the value a constant sets.
+	Value any `yaml:"value,omitempty"`
+
+	// Pattern is the regular expression whose matches a mask replaces.
+	Pattern string `yaml:"pattern,omitempty"`
+
+	// Replacement replaces every match of a mask, and can refer to submatches
+	// such as $1. Matches are removed if it is empty.
+	Replacement string `yaml:"replacement,omitempty"`
+
+	// Type is the type a cast converts to, one of "string", "int", "float"
+	// or "bool".
+	Type string `yaml:"type,omitempty"`
+
+	// Template is the text/template a derived field is rendered from, with
+	// the record as its data, such as "{{.first_name}} {{.last_name}}".
+	Template string `yaml:"template,omitempty"`
+}
+
+// Options holds what chains need besides their configuration.
+type Options struct {
+	// HashKey is the key of the HMAC hash steps. It is kept out of the chain
+	// configuration as it is a secret.
+	HashKey []byte
+}
+
+// Chain is a sequence of transforms applied to a record in order.
+type Chain struct {
+	steps       []func(record map[string]any) error
+	classifiers []func(classes redact.Classes)
+	fingerprint string
+}
+
+// New creates a chain from its steps.
+func New(steps []Step, opts Options) (*Chain, error) {
+	c := &Chain{steps: make([]func(map[string]any) error, 0, len(steps))}
+	for i, s := range steps {
+		fn, err := newStep(s, opts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid transform %d (%s): %w", i, s.Op, err)
+		}
+		c.steps = append(c.steps, fn)
+		c.classifiers = append(c.classifiers, newClassifier(s))
+	}
+
+	// The hash key changes the output as much as the steps do, so it is
+	// part of the fingerprint, hashed rather than as it is
+	h := sha256.New()
+	if err := json.NewEncoder(h).Encode(steps); err != nil {
+		return nil, fmt.Errorf("failed to encode transforms: %w", err)
+	}
+	key := sha256.Sum256(opts.HashKey)
+	h.Write(key[:])
+	c.fingerprint = hex.EncodeToString(h.Sum(nil))
+
+	return c, nil
+}
+
+// Fingerprint returns a fingerprint of the steps and hash key of the chain,
+// which changes whenever records would be transformed differently.
+func (c *Chain) Fingerprint() string {
+	return c.fingerprint
+}
+
+// Parse parses a YAML or JSON configuration of the chains of every dataset,
+// keyed by dataset name. An empty configuration has no chains.
+func Parse(data []byte, opts Options) (map[string]*Chain, error) {
+	var config map[string][]Step
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&config); err != nil {
+		return nil, fmt.Errorf("failed to decode transforms: %w", err)
+	}
+
+	chains := make(map[string]*Chain, len(config))
+	for _, name := range slices.Sorted(maps.Keys(config)) {
+		chain, err := New(config[name], opts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid transforms of dataset %s: %w", name, err)
+		}
+		chains[name] = chain
+	}
+
+	return chains, nil
+}
+
+// Apply applies the chain to a record. The record is converted to a map
+// keyed by the names it is published with, see fields.Map, and the
+// transformed map is returned.
+func (c *Chain) Apply(record any) (any, error) {
+	m, err := fields.Map(record)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, step := range c.steps {
+		if err := step(m); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// lookup returns the map holding the field at a dotted path and the name of
+// the field within it. Missing parents are created if create is true,
+// otherwise the boolean is false if a parent is missing.
+func lookup(record map[string]any, path string, create bool) (map[string]any, string, bool) {
+	parts := strings.Split(path, ".")
+
+	m := record
+	for _, part := range parts[:len(parts)-1] {
+		child, ok := m[part].(map[string]any)
+		if !ok {
+			if !create {
+				return nil, "", false
+			}
+			child = make(map[string]any)
+			m[part] = child
+		}
+		m = child
+	}
+
+	return m, parts[len(parts)-1], true
+}
+
+// get returns the value of the field at a dotted path.
+func get(record map[string]any, path string) (any, bool) {
+	m, name, ok := lookup(record, path, false)
+	if !ok {
+		return nil, false
+	}
+	v, ok := m[name]
+	return v, ok
+}
+
+// set sets the field at a dotted path, creating its parents.
+func set(record map[string]any, path string, v any) {
+	m, name, _ := lookup(record, path, true)
+	m[name] = v
+}
+
+// remove removes the field at a dotted path.
+func remove(record map[string]any, path string) {
+	if m, name, ok := lookup(record, path, false); ok {
+		delete(m, name)
+	}
+}
@@ -0,0 +1,333 @@
+package transform
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/fields"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/models"
+	"github.com/jsmithdenverdev/poc-parquet-publisher/internal/redact"
+)
+
+var hashKey = []byte("key")
+
+// testRecord returns a record with every field set.
+func testRecord() models.Record {
+	r := models.Record{
+		ID:                       "id-1",
+		CreatedAt:                time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC),
+		FirstName:                "Ada",
+		LastName:                 "Lovelace",
+		Email:                    "ada@example.com",
+		PhoneNumber:              "555-010-1234",
+		DateOfBirth:              "1990-01-01",
+		AccountType:              "premium",
+		AccountStatus:            "active",
+		AccountBalance:           12.5,
+		Language:                 "en",
+		CommunicationPreferences: []string{"email"},
+		NewsletterSubscribed:     true,
+		Tags:                     []string{"vip"},
+		Body:                     "body",
+	}
+	r.Address.City = "Denver"
+	r.Address.Country = "USA"
+	return r
+}
+
+// apply applies a chain parsed from YAML to the test record and returns the
+// transformed map.
+func apply(t *testing.T, config string) map[string]any {
+	t.Helper()
+
+	chains, err := Parse([]byte("records:\n"+config), Options{HashKey: hashKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := chains["records"].Apply(testRecord())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out.(map[string]any)
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		check  func(t *testing.T, m map[string]any)
+	}{
+		{
+			name:   "rename",
+			config: "  - {op: rename, field: address.city, to: city}",
+			check: func(t *testing.T, m map[string]any) {
+				if _, ok := m["address"].(map[string]any)["city"]; ok || m["city"] != "Denver" {
+					t.Errorf("city = %v, address = %v", m["city"], m["address"])
+				}
+			},
+		},
+		{
+			name:   "drop",
+			config: "  - {op: drop, field: date_of_birth}",
+			check: func(t *testing.T, m map[string]any) {
+				if _, ok := m["date_of_birth"]; ok {
+					t.Errorf("date_of_birth = %v, want it dropped", m["date_of_birth"])
+				}
+			},
+		},
+		{
+			name:   "constant",
+			config: "  - {op: constant, field: meta.origin, value: parquet}",
+			check: func(t *testing.T, m map[string]any) {
+				if got := m["meta"].(map[string]any)["origin"]; got != "parquet" {
+					t.Errorf("meta.origin = %v", got)
+				}
+			},
+		},
+		{
+			name:   "hash",
+			config: "  - {op: hash, field: email}",
+			check: func(t *testing.T, m map[string]any) {
+				if want := fields.Hash(hashKey, "ada@example.com"); m["email"] != want {
+					t.Errorf("email = %v, want %v", m["email"], want)
+				}
+			},
+		},
+		{
+			name:   "mask",
+			config: `  - {op: mask, field: phone_number, pattern: '^.*(\d{4})$', replacement: '***-***-$1'}`,
+			check: func(t *testing.T, m map[string]any) {
+				if m["phone_number"] != "***-***-1234" {
+					t.Errorf("phone_number = %v", m["phone_number"])
+				}
+			},
+		},
+		{
+			name:   "cast",
+			config: "  - {op: cast, field: account_balance, type: string}\n  - {op: cast, field: newsletter_subscribed, type: string}",
+			check: func(t *testing.T, m map[string]any) {
+				if m["account_balance"] != "12.5" || m["newsletter_subscribed"] != "true" {
+					t.Errorf("account_balance = %#v, newsletter_subscribed = %#v", m["account_balance"], m["newsletter_subscribed"])
+				}
+			},
+		},
+		{
+			name:   "derive",
+			config: `  - {op: derive, field: name, template: "{{.first_name}} {{.last_name}}"}`,
+			check: func(t *testing.T, m map[string]any) {
+				if m["name"] != "Ada Lovelace" {
+					t.Errorf("name = %v", m["name"])
+				}
+			},
+		},
+		{
+			name:   "missing field",
+			config: "  - {op: hash, field: address.missing}\n  - {op: drop, field: nope.nothing}",
+			check: func(t *testing.T, m map[string]any) {
+				if _, ok := m["nope"]; ok {
+					t.Errorf("nope = %v, want no field created", m["nope"])
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.check(t, apply(t, tt.config))
+		})
+	}
+}
+
+func TestApplyKeepsRecord(t *testing.T) {
+	chains, err := Parse([]byte("records:\n  - {op: drop, field: email}"), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Maps are converted too, so the record applied to is left as it is
+	record, err := fields.Map(testRecord())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chains["records"].Apply(record); err != nil {
+		t.Fatal(err)
+	}
+	if record["email"] != "ada@example.com" {
+		t.Fatalf("email = %v, want the record left as it is", record["email"])
+	}
+
+	// Numbers keep their precision
+	if n, ok := record["account_balance"].(json.Number); !ok || n.String() != "12.5" {
+		t.Fatalf("account_balance = %#v, want a json.Number", record["account_balance"])
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]string{
+		"unknown op":        "records:\n  - {op: explode, field: id}",
+		"unknown key":       "records:\n  - {op: drop, field: id, bogus: 1}",
+		"no field":          "records:\n  - {op: drop}",
+		"rename without to": "records:\n  - {op: rename, field: id}",
+		"hash without key":  "records:\n  - {op: hash, field: id}",
+		"bad pattern":       "records:\n  - {op: mask, field: id, pattern: '('}",
+		"bad template":      "records:\n  - {op: derive, field: id, template: '{{'}",
+		"unknown cast":      "records:\n  - {op: cast, field: id, type: date}",
+	}
+
+	for name, config := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Parse([]byte(config), Options{}); err == nil {
+				t.Fatal("invalid transforms were parsed")
+			}
+		})
+	}
+}
+
+func TestDeriveSkipsMissingFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     any
+	}{
+		{name: "missing", template: "{{.first_name}}"},
+		{name: "missing among others", template: "{{.last_name}}, {{.first_name}}"},
+		{name: "missing nested", template: "{{.address.city}} {{.address.missing}}"},
+		{name: "missing from the root", template: "{{with .address}}{{.city}}{{end}} {{$.first_name}}"},
+		{name: "present", template: "{{.last_name}} {{.address.city}}", want: "Lovelace Denver"},
+		{name: "relative to another value", template: "{{with .address}}{{.city}}{{end}}", want: "Denver"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain, err := New([]Step{
+				{Op: "drop", Field: "first_name"},
+				{Op: "derive", Field: "name", Template: tt.template},
+			}, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			out, err := chain.Apply(testRecord())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, ok := out.(map[string]any)["name"]; got != tt.want || ok != (tt.want != nil) {
+				t.Fatalf("name = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	steps := []Step{{Op: "hash", Field: "email"}}
+
+	a, err := New(steps, Options{HashKey: []byte("a")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := New(steps, Options{HashKey: []byte("b")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := New(steps, Options{HashKey: []byte("a")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Fingerprint() != again.Fingerprint() || a.Fingerprint() == b.Fingerprint() {
+		t.Fatal("fingerprints do not follow the hash key")
+	}
+}
+
+func TestClassify(t *testing.T) {
+	classes := redact.Classes{
+		"id":            redact.None,
+		"first_name":    redact.Name,
+		"email":         redact.Email,
+		"address":       redact.Address,
+		"address.city":  redact.Address,
+		"account_type":  redact.None,
+		"date_of_birth": redact.DOB,
+	}
+
+	tests := []struct {
+		name  string
+		steps []Step
+		want  redact.Classes
+	}{
+		{
+			name:  "rename",
+			steps: []Step{{Op: "rename", Field: "email", To: "contact"}},
+			want:  redact.Classes{"contact": redact.Email, "email": ""},
+		},
+		{
+			name:  "rename a group",
+			steps: []Step{{Op: "rename", Field: "address", To: "location"}},
+			want:  redact.Classes{"location": redact.Address, "location.city": redact.Address},
+		},
+		{
+			name:  "rename over a classified field",
+			steps: []Step{{Op: "rename", Field: "account_type", To: "email"}},
+			want:  redact.Classes{"email": redact.None},
+		},
+		{
+			name:  "constant",
+			steps: []Step{{Op: "constant", Field: "email", Value: "redacted"}},
+			want:  redact.Classes{"email": redact.None},
+		},
+		{
+			name:  "derive",
+			steps: []Step{{Op: "derive", Field: "greeting", Template: "{{.first_name}} {{.id}}"}},
+			want:  redact.Classes{"greeting": redact.Name},
+		},
+		{
+			name:  "derive from several classes",
+			steps: []Step{{Op: "derive", Field: "contact", Template: "{{.first_name}} <{{.email}}> {{.address.city}}"}},
+			want:  redact.Classes{"contact": redact.Join(redact.Name, redact.Email, redact.Address)},
+		},
+		{
+			name:  "derive from a renamed field",
+			steps: []Step{{Op: "rename", Field: "email", To: "contact"}, {Op: "derive", Field: "greeting", Template: "{{.contact}}"}},
+			want:  redact.Classes{"greeting": redact.Email},
+		},
+		{
+			name:  "derive from the record",
+			steps: []Step{{Op: "derive", Field: "dump", Template: `{{index . "id"}}`}},
+			want:  redact.Classes{"dump": redact.Join(redact.Name, redact.Email, redact.Address, redact.DOB)},
+		},
+		{
+			name:  "derive from an unclassified field",
+			steps: []Step{{Op: "derive", Field: "email", Template: "{{.id}} {{.note}}"}},
+			want:  redact.Classes{"email": ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain, err := New(tt.steps, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := chain.Classify(classes)
+			for path, want := range tt.want {
+				class, ok := got.Of(path)
+				if want == "" {
+					if ok {
+						t.Errorf("%s is of class %s, want it unclassified", path, class)
+					}
+					continue
+				}
+				if class != want {
+					t.Errorf("%s is of class %q, want %q", path, class, want)
+				}
+			}
+		})
+	}
+
+	if classes["email"] != redact.Email {
+		t.Fatal("classified the classes in place")
+	}
+}